@@ -0,0 +1,127 @@
+package cdc_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/eyeKill/KV/cdc"
+	"github.com/eyeKill/KV/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cdc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeRecords(t *testing.T, w *cdc.Writer, id common.WorkerId, epoch uint32, from, to uint64) {
+	for v := from; v <= to; v++ {
+		err := w.Append(cdc.Record{
+			Worker: id, Epoch: epoch, Version: v, Op: cdc.OP_PUT,
+			Key: fmt.Sprintf("key%d", v), Value: fmt.Sprint(v),
+		})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Sync())
+}
+
+func readAll(t *testing.T, r *cdc.Reader) []uint64 {
+	var ret []uint64
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return ret
+		}
+		assert.Nil(t, err)
+		ret = append(ret, rec.Version)
+	}
+}
+
+func TestWriter_Rotate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	w, err := cdc.NewWriter(dir, 200, 3)
+	assert.Nil(t, err)
+	writeRecords(t, w, 1, 0, 1, 50)
+	assert.Nil(t, w.Close())
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(files))
+
+	// continues after the last record
+	w, err = cdc.NewWriter(dir, 200, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(50), w.LastVersion())
+	writeRecords(t, w, 1, 0, 51, 52)
+	assert.Nil(t, w.Close())
+	r, err := cdc.NewReader(dir, "")
+	assert.Nil(t, err)
+	defer r.Close()
+	versions := readAll(t, r)
+	assert.Equal(t, uint64(52), versions[len(versions)-1])
+	for i := 1; i < len(versions); i++ {
+		assert.Equal(t, versions[i-1]+1, versions[i])
+	}
+}
+
+func TestReader_Checkpoint(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	checkpoint := path.Join(dir, "offset")
+	w, err := cdc.NewWriter(path.Join(dir, "feed"), 300, 0)
+	assert.Nil(t, err)
+	defer w.Close()
+	writeRecords(t, w, 1, 0, 1, 10)
+
+	r, err := cdc.NewReader(path.Join(dir, "feed"), checkpoint)
+	assert.Nil(t, err)
+	for i := 0; i < 4; i++ {
+		_, err := r.Next()
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, r.Commit())
+	r.Close()
+
+	// reopened reader starts after the committed offset, and picks up new records
+	r, err = cdc.NewReader(path.Join(dir, "feed"), checkpoint)
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Equal(t, []uint64{5, 6, 7, 8, 9, 10}, readAll(t, r))
+	writeRecords(t, w, 1, 0, 11, 12)
+	assert.Equal(t, []uint64{11, 12}, readAll(t, r))
+}
+
+func TestMerge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	var readers []*cdc.Reader
+	for id := 1; id <= 2; id++ {
+		feed := path.Join(dir, fmt.Sprint(id))
+		w, err := cdc.NewWriter(feed, cdc.DEFAULT_MAX_FILE_SIZE, 0)
+		assert.Nil(t, err)
+		writeRecords(t, w, common.WorkerId(id), 0, 1, 3)
+		writeRecords(t, w, common.WorkerId(id), 1, 4, 5)
+		assert.Nil(t, w.Close())
+		r, err := cdc.NewReader(feed, "")
+		assert.Nil(t, err)
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	var merged []string
+	err := cdc.Merge(readers, func(r cdc.Record) error {
+		merged = append(merged, fmt.Sprintf("%d/%d/%d", r.Epoch, r.Worker, r.Version))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"0/1/1", "0/1/2", "0/1/3", "0/2/1", "0/2/2", "0/2/3",
+		"1/1/4", "1/1/5", "1/2/4", "1/2/5",
+	}, merged)
+}
@@ -0,0 +1,47 @@
+package cdc
+
+import "io"
+
+// Merge feeds of several workers into one stream ordered by epoch, calling `out` for each record.
+// Records of the same epoch are ordered by worker, and records of the same worker keep their order in its feed.
+// Returns once every reader reaches the end of its feed.
+func Merge(readers []*Reader, out func(Record) error) error {
+	heads := make([]*Record, len(readers))
+	advance := func(i int) error {
+		rec, err := readers[i].Next()
+		if err == io.EOF {
+			heads[i] = nil
+			return nil
+		} else if err != nil {
+			return err
+		}
+		heads[i] = &rec
+		return nil
+	}
+	for i := range readers {
+		if err := advance(i); err != nil {
+			return err
+		}
+	}
+	for {
+		min := -1
+		for i, h := range heads {
+			if h == nil {
+				continue
+			}
+			if min == -1 || h.Epoch < heads[min].Epoch ||
+				(h.Epoch == heads[min].Epoch && h.Worker < heads[min].Worker) {
+				min = i
+			}
+		}
+		if min == -1 {
+			return nil
+		}
+		if err := out(*heads[min]); err != nil {
+			return err
+		}
+		if err := advance(min); err != nil {
+			return err
+		}
+	}
+}
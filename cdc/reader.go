@@ -0,0 +1,167 @@
+package cdc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+var ELOST = errors.New("checkpointed file has been rotated away")
+
+// Position in a feed.
+type Offset struct {
+	// file name, empty for the beginning of the feed
+	File     string `json:"file"`
+	Position int64  `json:"position"`
+}
+
+// Reads records of one feed in order, remembering where it stopped in a checkpoint file.
+type Reader struct {
+	dir        string
+	checkpoint string
+	offset     Offset
+	file       *os.File
+	r          *bufio.Reader
+}
+
+// Open a reader on dir, starting from the offset in checkpoint file if it exists.
+// Pass an empty checkpoint path to always start from the beginning.
+func NewReader(dir string, checkpoint string) (*Reader, error) {
+	r := &Reader{dir: dir, checkpoint: checkpoint}
+	if checkpoint == "" {
+		return r, nil
+	}
+	bin, err := ioutil.ReadFile(checkpoint)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bin, &r.offset); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reader) closeFile() {
+	if r.file != nil {
+		_ = r.file.Close()
+		r.file, r.r = nil, nil
+	}
+}
+
+// open file of current offset, moving on to the first file if there is none
+func (r *Reader) openFile() error {
+	if r.offset.File == "" {
+		names, err := listFiles(r.dir)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return io.EOF
+		}
+		r.offset = Offset{File: names[0]}
+	}
+	f, err := os.Open(path.Join(r.dir, r.offset.File))
+	if os.IsNotExist(err) {
+		return ELOST
+	} else if err != nil {
+		return err
+	}
+	if _, err := f.Seek(r.offset.Position, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.file, r.r = f, bufio.NewReader(f)
+	return nil
+}
+
+// move on to the file after the current one, returns false if there is none
+func (r *Reader) nextFile() (bool, error) {
+	names, err := listFiles(r.dir)
+	if err != nil {
+		return false, err
+	}
+	for _, n := range names {
+		if n > r.offset.File {
+			r.closeFile()
+			r.offset = Offset{File: n}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Read the next record. Returns io.EOF if there is none for now, Next could be called again later.
+func (r *Reader) Next() (Record, error) {
+	for {
+		if r.file == nil {
+			if err := r.openFile(); err != nil {
+				return Record{}, err
+			}
+		}
+		line, err := r.r.ReadBytes('\n')
+		if err == io.EOF {
+			// a partially written line is read again next time
+			r.closeFile()
+			if len(line) > 0 {
+				return Record{}, io.EOF
+			}
+			ok, err := r.nextFile()
+			if err != nil {
+				return Record{}, err
+			} else if !ok {
+				return Record{}, io.EOF
+			}
+			continue
+		} else if err != nil {
+			return Record{}, err
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return Record{}, err
+		}
+		r.offset.Position += int64(len(line))
+		return rec, nil
+	}
+}
+
+// Offset after the last record read.
+func (r *Reader) Offset() Offset {
+	return r.offset
+}
+
+// Save current offset to the checkpoint file.
+func (r *Reader) Commit() error {
+	if r.checkpoint == "" {
+		return nil
+	}
+	bin, err := json.Marshal(r.offset)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(path.Dir(r.checkpoint), path.Base(r.checkpoint)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bin); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.checkpoint)
+}
+
+func (r *Reader) Close() {
+	r.closeFile()
+}
@@ -0,0 +1,186 @@
+// Change data capture feed of the KV store.
+// Each worker primary appends committed mutations to rotating JSON Lines files in a local directory,
+// consumers read them with a checkpointed offset, and feeds of all workers can be merged into one stream.
+package cdc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/eyeKill/KV/common"
+)
+
+const (
+	FILE_PREFIX           = "cdc-"
+	FILE_SUFFIX           = ".jsonl"
+	DEFAULT_MAX_FILE_SIZE = 64 * 1024 * 1024
+)
+
+const (
+	OP_PUT    = "put"
+	OP_DELETE = "delete"
+)
+
+// A committed mutation.
+type Record struct {
+	Worker common.WorkerId `json:"worker"`
+	// slot table version the mutation is committed under
+	Epoch uint32 `json:"epoch"`
+	// version on Worker, increasing in each feed
+	Version uint64        `json:"version"`
+	Slot    common.SlotId `json:"slot"`
+	Op      string        `json:"op"`
+	Key     string        `json:"key"`
+	Value   string        `json:"value,omitempty"`
+}
+
+func fileName(seq int) string {
+	return fmt.Sprintf("%s%08d%s", FILE_PREFIX, seq, FILE_SUFFIX)
+}
+
+// List feed files in dir in order.
+func listFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		n := info.Name()
+		if strings.HasPrefix(n, FILE_PREFIX) && strings.HasSuffix(n, FILE_SUFFIX) {
+			names = append(names, n)
+		}
+	}
+	// sequence numbers are zero padded
+	sort.Strings(names)
+	return names, nil
+}
+
+func fileSeq(name string) int {
+	var seq int
+	_, _ = fmt.Sscanf(strings.TrimPrefix(name, FILE_PREFIX), "%d", &seq)
+	return seq
+}
+
+// Appends records to rotating files. A Writer is not safe for concurrent use.
+type Writer struct {
+	dir         string
+	maxFileSize int64
+	// number of files kept, 0 to keep all of them
+	maxFiles int
+	file     *os.File
+	seq      int
+	size     int64
+	last     uint64
+}
+
+// Open a writer on dir, continuing the last file in it if there is one.
+func NewWriter(dir string, maxFileSize int64, maxFiles int) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &Writer{dir: dir, maxFileSize: maxFileSize, maxFiles: maxFiles}
+	names, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return w, w.open(0)
+	}
+	w.seq = fileSeq(names[len(names)-1])
+	// recover version of the last record
+	for i := len(names) - 1; i >= 0 && w.last == 0; i-- {
+		f, err := os.Open(path.Join(dir, names[i]))
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var r Record
+			// the last line could be incomplete after a crash
+			if err := json.Unmarshal(scanner.Bytes(), &r); err == nil {
+				w.last = r.Version
+			}
+		}
+		_ = f.Close()
+	}
+	return w, w.open(w.seq)
+}
+
+func (w *Writer) open(seq int) error {
+	f, err := os.OpenFile(path.Join(w.dir, fileName(seq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	w.file, w.seq, w.size = f, seq, info.Size()
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if err := w.open(w.seq + 1); err != nil {
+		return err
+	}
+	if w.maxFiles <= 0 {
+		return nil
+	}
+	names, err := listFiles(w.dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > w.maxFiles {
+		if err := os.Remove(path.Join(w.dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// Version of the last record written.
+func (w *Writer) LastVersion() uint64 {
+	return w.last
+}
+
+func (w *Writer) Append(r Record) error {
+	bin, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	bin = append(bin, '\n')
+	if w.size > 0 && w.size+int64(len(bin)) > w.maxFileSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(bin)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	w.last = r.Version
+	return nil
+}
+
+// Flush written records to disk.
+func (w *Writer) Sync() error {
+	return w.file.Sync()
+}
+
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
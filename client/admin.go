@@ -0,0 +1,89 @@
+package client
+
+// operations on workers themselves rather than keys
+
+import (
+	"context"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+)
+
+// Connect to the primary of worker `id`.
+func (c *Client) workerConn(ctx context.Context, id common.WorkerId) (*grpc.ClientConn, error) {
+	addr, err := c.resolver.WorkerAddr(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.pool.Get(ctx, addr)
+	if err != nil {
+		c.invalidateWorker(id, addr)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Get the replication mode of worker `id`, and latency histograms of waiting for its backups in each mode.
+func (c *Client) ReplicationStats(ctx context.Context, id common.WorkerId) (*pb.ReplicationStatsResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.RPCTimeout)
+	defer cancel()
+	conn, err := c.workerConn(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pb.NewKVWorkerInternalClient(conn).ReplicationStats(ctx, &empty.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	if err := StatusToError(resp.Status); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Let worker `id` accept writes again after a promotion, without waiting for enough backups to be in sync.
+// Returns the number of backups in sync, and how many are required.
+func (c *Client) LeaveReadOnly(ctx context.Context, id common.WorkerId) (*pb.ReadOnlyResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.RPCTimeout)
+	defer cancel()
+	conn, err := c.workerConn(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pb.NewKVWorkerInternalClient(conn).LeaveReadOnly(ctx, &empty.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	if err := StatusToError(resp.Status); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Hand the primary role of worker `id` over to its backup `target`, by node name or address,
+// giving it `timeout` to catch up while writes are paused. Returns the new primary, the version
+// it took over at and how long writes were unavailable.
+func (c *Client) Switchover(ctx context.Context, id common.WorkerId, target string, timeout time.Duration) (*pb.SwitchoverResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.RPCTimeout+timeout)
+	defer cancel()
+	conn, err := c.workerConn(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pb.NewKVWorkerInternalClient(conn).Switchover(ctx, &pb.SwitchoverRequest{
+		Target:    target,
+		TimeoutMs: uint32(timeout / time.Millisecond),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := StatusToError(resp.Status); err != nil {
+		return nil, err
+	}
+	// the primary is somewhere else now
+	c.resolver.InvalidateWorker(id)
+	return resp, nil
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+)
+
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// Outcome of one operation in a batch.
+type BatchResult struct {
+	Key   string
+	Value string
+	Err   error
+}
+
+// Run `f` on each of the `n` items concurrently. Concurrency is bounded by `parallelism`.
+func parallel(n int, parallelism int, f func(i int)) {
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, parallelism)
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			f(i)
+			<-sem
+		}()
+	}
+	wg.Wait()
+}
+
+const BATCH_PARALLELISM = 16
+
+// Get multiple keys. Results are in the same order as `keys`.
+func (c *Client) BatchGet(ctx context.Context, keys []string) []BatchResult {
+	ret := make([]BatchResult, len(keys))
+	parallel(len(keys), BATCH_PARALLELISM, func(i int) {
+		value, err := c.Get(ctx, keys[i])
+		ret[i] = BatchResult{Key: keys[i], Value: value, Err: err}
+	})
+	return ret
+}
+
+// Put multiple pairs. Results are in the same order as `pairs`. Each pair succeeds or fails on its own.
+func (c *Client) BatchPut(ctx context.Context, pairs []KeyValue) []BatchResult {
+	ret := make([]BatchResult, len(pairs))
+	parallel(len(pairs), BATCH_PARALLELISM, func(i int) {
+		err := c.Put(ctx, pairs[i].Key, pairs[i].Value)
+		ret[i] = BatchResult{Key: pairs[i].Key, Err: err}
+	})
+	return ret
+}
+
+// Delete multiple keys. Results are in the same order as `keys`.
+func (c *Client) BatchDelete(ctx context.Context, keys []string) []BatchResult {
+	ret := make([]BatchResult, len(keys))
+	parallel(len(keys), BATCH_PARALLELISM, func(i int) {
+		err := c.Delete(ctx, keys[i])
+		ret[i] = BatchResult{Key: keys[i], Err: err}
+	})
+	return ret
+}
+
+// Scan at most `limit` keys with `prefix` in ascending order, starting after `start`.
+// Returns the pairs and the cursor to pass as `start` for the next page, which is empty when there is no more key.
+// Pages could hold fewer than `limit` pairs, or none, before the last one.
+func (c *Client) Scan(ctx context.Context, prefix string, start string, limit int) ([]KeyValue, string, error) {
+	slots, _, err := c.Slots(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	ids := make(map[common.WorkerId]bool)
+	for _, id := range slots {
+		ids[id] = true
+	}
+	// get the first `limit` keys from every worker, then merge them
+	var lock sync.Mutex
+	var merged []KeyValue
+	var lastErr error
+	// workers that returned `limit` keys have only been scanned up to the last of them,
+	// so the page ends at the smallest such key, whether the worker still owns it or not
+	truncated := false
+	frontier := ""
+	wg := sync.WaitGroup{}
+	for id := range ids {
+		id := id
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var pairs []*pb.KVPair
+			var owner common.HashSlotRing
+			err := c.doRoute(ctx, func(_ common.HashSlotRing) common.WorkerId {
+				return id
+			}, func(ctx context.Context, client pb.KVWorkerClient, slotVersion uint32) (pb.Status, error) {
+				resp, err := client.Scan(ctx, &pb.ScanRequest{
+					Prefix:      prefix,
+					Start:       start,
+					Limit:       uint32(limit),
+					SlotVersion: slotVersion,
+				})
+				if err != nil {
+					return pb.Status_OK, err
+				}
+				pairs = resp.Pairs
+				return resp.Status, nil
+			})
+			if err == nil {
+				// workers keep keys of slots migrated away, skip those
+				owner, _, err = c.Slots(ctx)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				lastErr = err
+				return
+			}
+			if n := len(pairs); n == limit && n > 0 {
+				if last := pairs[n-1].Key; !truncated || last < frontier {
+					frontier = last
+				}
+				truncated = true
+			}
+			for _, p := range pairs {
+				if owner.GetWorkerIdByKey(p.Key) == id {
+					merged = append(merged, KeyValue{Key: p.Key, Value: p.Value})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if lastErr != nil {
+		return nil, "", lastErr
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Key < merged[j].Key })
+	next := ""
+	if truncated {
+		// keys past the frontier could have others before them on the workers truncated there
+		n := sort.Search(len(merged), func(i int) bool { return merged[i].Key > frontier })
+		merged, next = merged[:n], frontier
+	}
+	if len(merged) > limit {
+		merged = merged[:limit]
+		next = merged[limit-1].Key
+	}
+	return merged, next, nil
+}
@@ -0,0 +1,236 @@
+// Client library for the distributed KV store.
+// A Client caches the slot table, routes every request to the worker owning the key
+// and transparently retries on slot table changes & primary failovers.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Options struct {
+	// Addresses of the masters, with format `hostname:port`. They are tried in order.
+	MasterAddrs []string
+	// Maximum number of retries for a single request, 0 means no retry at all.
+	MaxRetries int
+	// Backoff before the first retry, doubled after each retry until MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Timeout of a single RPC, bounded by the caller's context.
+	RPCTimeout time.Duration
+	// Where to look up the slot table & workers. Masters in MasterAddrs are asked if not set.
+	Resolver Resolver
+	// Consistency of Get, STRONG by default.
+	ReadConsistency Consistency
+}
+
+func DefaultOptions(masterAddrs ...string) Options {
+	return Options{
+		MasterAddrs: masterAddrs,
+		MaxRetries:  8,
+		BaseBackoff: 50 * time.Millisecond,
+		MaxBackoff:  2 * time.Second,
+		RPCTimeout:  5 * time.Second,
+	}
+}
+
+// Client for the KV store. A Client is safe for concurrent use.
+type Client struct {
+	opts     Options
+	pool     *connPool
+	resolver Resolver
+	// round-robin counter of follower reads
+	next atomic.Uint32
+}
+
+func New(opts Options) (*Client, error) {
+	pool := newConnPool()
+	resolver := opts.Resolver
+	if resolver == nil {
+		if len(opts.MasterAddrs) == 0 {
+			return nil, errors.New("no master address given")
+		}
+		resolver = newMasterResolver(opts, pool)
+	}
+	return &Client{
+		opts:     opts,
+		pool:     pool,
+		resolver: resolver,
+	}, nil
+}
+
+func (c *Client) Close() error {
+	return c.pool.Close()
+}
+
+// Fetch the latest slot table.
+func (c *Client) RefreshSlots(ctx context.Context) error {
+	return c.resolver.RefreshSlots(ctx)
+}
+
+// Get the cached slot table & its version, fetching it if there is none.
+func (c *Client) Slots(ctx context.Context) (common.HashSlotRing, uint32, error) {
+	return c.resolver.Slots(ctx)
+}
+
+// Forget the cached address of worker `id`, e.g. after its primary went down.
+func (c *Client) invalidateWorker(id common.WorkerId, addr string) {
+	c.resolver.InvalidateWorker(id)
+	c.pool.Drop(addr)
+}
+
+func (c *Client) backoff(ctx context.Context, attempt int) error {
+	d := c.opts.BaseBackoff << uint(attempt)
+	if d > c.opts.MaxBackoff || d <= 0 {
+		d = c.opts.MaxBackoff
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A single request to a worker, returning the status in its response.
+type workerCall func(ctx context.Context, client pb.KVWorkerClient, slotVersion uint32) (pb.Status, error)
+
+// Route a request by `key` and call it with bounded retries.
+func (c *Client) do(ctx context.Context, key string, call workerCall) error {
+	return c.doRoute(ctx, func(slots common.HashSlotRing) common.WorkerId {
+		return slots.GetWorkerIdByKey(key)
+	}, call)
+}
+
+// Call a request on the worker chosen by `route` with bounded retries.
+// `route` is evaluated against the latest slot table before every attempt.
+func (c *Client) doRoute(ctx context.Context, route func(slots common.HashSlotRing) common.WorkerId, call workerCall) error {
+	log := common.Log()
+	var lastErr error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := c.backoff(ctx, attempt-1); err != nil {
+				return err
+			}
+		}
+		slots, version, err := c.Slots(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		id := route(slots)
+		addr, err := c.resolver.WorkerAddr(ctx, id)
+		if err != nil {
+			lastErr = err
+			if errors.Is(err, EINVWID) {
+				// the slot table is older than the worker set
+				if err := c.RefreshSlots(ctx); err != nil {
+					lastErr = err
+				}
+			}
+			continue
+		}
+		rctx, cancel := context.WithTimeout(ctx, c.opts.RPCTimeout)
+		conn, err := c.pool.Get(rctx, addr)
+		if err != nil {
+			cancel()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Info("Failed to connect to worker, retrying...", zap.Int("worker", int(id)), zap.Error(err))
+			c.invalidateWorker(id, addr)
+			lastErr = err
+			continue
+		}
+		st, err := call(rctx, pb.NewKVWorkerClient(conn), version)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			code := status.Code(err)
+			if code != codes.Unavailable && code != codes.DeadlineExceeded {
+				return err
+			}
+			log.Info("Worker unavailable, retrying...", zap.Int("worker", int(id)), zap.Error(err))
+			c.invalidateWorker(id, addr)
+			lastErr = err
+			continue
+		}
+		switch st {
+		case pb.Status_EINVVERSION:
+			// have got to update slot table
+			if err := c.RefreshSlots(ctx); err != nil {
+				lastErr = err
+			} else {
+				lastErr = EINVVERSION
+			}
+		case pb.Status_EINVSERVER, pb.Status_ENOSERVER:
+			// primary changed
+			c.invalidateWorker(id, addr)
+			lastErr = StatusToError(st)
+		default:
+			return StatusToError(st)
+		}
+	}
+	return fmt.Errorf("giving up after %d retries: %w", c.opts.MaxRetries, lastErr)
+}
+
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	return c.GetWith(ctx, key, c.opts.ReadConsistency)
+}
+
+// Get a key with the given consistency. Reads that are not STRONG are spread among the primary
+// and backups of the owner, or its learners if asked to, and go to the primary if the replica chosen could not serve them.
+func (c *Client) GetWith(ctx context.Context, key string, consistency Consistency) (string, error) {
+	if consistency.Level != pb.Consistency_STRONG && consistency.Learners {
+		if value, ok, err := c.learnerGet(ctx, key, consistency); ok {
+			return value, err
+		}
+	} else if consistency.Level != pb.Consistency_STRONG {
+		if value, ok, err := c.followerGet(ctx, key, consistency); ok {
+			return value, err
+		}
+	}
+	var value string
+	err := c.do(ctx, key, func(ctx context.Context, client pb.KVWorkerClient, slotVersion uint32) (pb.Status, error) {
+		resp, err := client.Get(ctx, &pb.Key{Key: key, SlotVersion: slotVersion})
+		if err != nil {
+			return pb.Status_OK, err
+		}
+		value = resp.Value
+		return resp.Status, nil
+	})
+	return value, err
+}
+
+func (c *Client) Put(ctx context.Context, key string, value string) error {
+	return c.do(ctx, key, func(ctx context.Context, client pb.KVWorkerClient, slotVersion uint32) (pb.Status, error) {
+		resp, err := client.Put(ctx, &pb.KVPair{Key: key, Value: value, SlotVersion: slotVersion})
+		if err != nil {
+			return pb.Status_OK, err
+		}
+		return resp.Status, nil
+	})
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, key, func(ctx context.Context, client pb.KVWorkerClient, slotVersion uint32) (pb.Status, error) {
+		resp, err := client.Delete(ctx, &pb.Key{Key: key, SlotVersion: slotVersion})
+		if err != nil {
+			return pb.Status_OK, err
+		}
+		return resp.Status, nil
+	})
+}
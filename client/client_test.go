@@ -0,0 +1,607 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	"github.com/eyeKill/KV/localcluster"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/raft"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func startCluster(t *testing.T, n int) *localcluster.Cluster {
+	c, err := localcluster.Start(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newClient(t *testing.T, masters ...string) *client.Client {
+	opts := client.DefaultOptions(masters...)
+	opts.BaseBackoff = time.Millisecond
+	opts.RPCTimeout = 500 * time.Millisecond
+	kv, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kv
+}
+
+func TestClient_Correctness(t *testing.T) {
+	c := startCluster(t, 2)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+
+	assert.Nil(t, kv.Put(ctx, "a", "b"))
+	v, err := kv.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", v)
+	assert.Nil(t, kv.Delete(ctx, "a"))
+	_, err = kv.Get(ctx, "a")
+	assert.True(t, errors.Is(err, client.ENOENT))
+	assert.Equal(t, pb.Status_ENOENT, client.StatusOf(err))
+	assert.True(t, errors.Is(kv.Delete(ctx, "a"), client.ENOENT))
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	c := startCluster(t, 3)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				k := strconv.Itoa(g*1000 + i)
+				assert.Nil(t, kv.Put(ctx, k, k))
+			}
+		}()
+	}
+	wg.Wait()
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 50; i++ {
+			k := strconv.Itoa(g*1000 + i)
+			v, err := kv.Get(ctx, k)
+			assert.Nil(t, err)
+			assert.Equal(t, k, v)
+		}
+	}
+}
+
+func TestClient_MasterFailover(t *testing.T) {
+	c := startCluster(t, 1)
+	defer c.Stop()
+	// nobody listens on the first address
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	deadAddr := dead.Addr().String()
+	_ = dead.Close()
+	kv := newClient(t, deadAddr, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+	assert.Nil(t, kv.Put(ctx, "a", "b"))
+	v, err := kv.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", v)
+}
+
+func TestClient_SlotVersionChange(t *testing.T) {
+	c := startCluster(t, 1)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+	assert.Nil(t, kv.Put(ctx, "a", "b"))
+	// bump the slot table version, client should pick it up transparently
+	c.BumpSlotVersion()
+	v, err := kv.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", v)
+	_, version, err := kv.Slots(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), version)
+}
+
+func TestClient_RetriesExhausted(t *testing.T) {
+	c := startCluster(t, 1)
+	defer c.Stop()
+	opts := client.DefaultOptions(c.MasterAddr)
+	opts.MaxRetries = 2
+	opts.BaseBackoff = time.Millisecond
+	kv, err := client.New(opts)
+	assert.Nil(t, err)
+	defer kv.Close()
+	// worker is always one version ahead of master
+	c.Workers[0].SlotTableVersion.Inc()
+	err = kv.Put(context.Background(), "a", "b")
+	assert.True(t, errors.Is(err, client.EINVVERSION))
+}
+
+func TestClient_Batch(t *testing.T) {
+	c := startCluster(t, 2)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+
+	var pairs []client.KeyValue
+	var keys []string
+	for i := 0; i < 20; i++ {
+		k := fmt.Sprintf("k%02d", i)
+		pairs = append(pairs, client.KeyValue{Key: k, Value: strconv.Itoa(i)})
+		keys = append(keys, k)
+	}
+	for _, r := range kv.BatchPut(ctx, pairs) {
+		assert.Nil(t, r.Err)
+	}
+	ret := kv.BatchGet(ctx, append(keys, "missing"))
+	for i, r := range ret[:20] {
+		assert.Nil(t, r.Err)
+		assert.Equal(t, keys[i], r.Key)
+		assert.Equal(t, strconv.Itoa(i), r.Value)
+	}
+	assert.True(t, errors.Is(ret[20].Err, client.ENOENT))
+	for _, r := range kv.BatchDelete(ctx, keys[:10]) {
+		assert.Nil(t, r.Err)
+	}
+	ret = kv.BatchGet(ctx, keys[:10])
+	for _, r := range ret {
+		assert.True(t, errors.Is(r.Err, client.ENOENT))
+	}
+}
+
+func TestClient_Scan(t *testing.T) {
+	c := startCluster(t, 3)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, kv.Put(ctx, fmt.Sprintf("user/%02d", i), strconv.Itoa(i)))
+	}
+	assert.Nil(t, kv.Put(ctx, "other", "x"))
+
+	// page through all of them
+	var got []string
+	start := ""
+	for {
+		pairs, next, err := kv.Scan(ctx, "user/", start, 10)
+		assert.Nil(t, err)
+		for _, p := range pairs {
+			got = append(got, p.Key)
+		}
+		if next == "" {
+			break
+		}
+		start = next
+	}
+	assert.Equal(t, 25, len(got))
+	for i, k := range got {
+		assert.Equal(t, fmt.Sprintf("user/%02d", i), k)
+	}
+}
+
+func TestClient_ScanStaleKeys(t *testing.T) {
+	c := startCluster(t, 2)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+	slots := *common.NewHashSlotRing()
+	for i := range slots {
+		slots[i] = 2
+	}
+	c.SetSlotTable(slots)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, kv.Put(ctx, fmt.Sprintf("k/a%02d", i), "a"))
+	}
+	// worker 2 keeps the keys of slots handed back to worker 1 in among its own
+	for i := range slots {
+		slots[i] = common.WorkerId(i%2 + 1)
+	}
+	c.SetSlotTable(slots)
+	var want []string
+	for i := 0; i < 10; i++ {
+		if k := fmt.Sprintf("k/a%02d", i); slots.GetWorkerIdByKey(k) == 2 {
+			want = append(want, k)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		k := fmt.Sprintf("k/b%02d", i)
+		assert.Nil(t, kv.Put(ctx, k, "b"))
+		want = append(want, k)
+	}
+
+	var got []string
+	start := ""
+	for {
+		pairs, next, err := kv.Scan(ctx, "k/", start, 5)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.True(t, len(pairs) <= 5)
+		for _, p := range pairs {
+			got = append(got, p.Key)
+		}
+		if next == "" {
+			break
+		}
+		start = next
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestClient_Watch(t *testing.T) {
+	c := startCluster(t, 2)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+
+	events := make(chan client.Event, 100)
+	cursor := make(client.WatchCursor)
+	wctx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- kv.Watch(wctx, "w/", true, cursor, func(e client.Event) error {
+			events <- e
+			return nil
+		})
+	}()
+	next := func() client.Event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("no event received")
+			return client.Event{}
+		}
+	}
+	// wait for all streams to be set up
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, kv.Put(ctx, "w/a", "1"))
+	assert.Nil(t, kv.Put(ctx, "other", "1"))
+	assert.Nil(t, kv.Delete(ctx, "w/a"))
+	e := next()
+	assert.Equal(t, pb.Operation_PUT, e.Op)
+	assert.Equal(t, "w/a", e.Key)
+	assert.Equal(t, "1", e.Value)
+	e = next()
+	assert.Equal(t, pb.Operation_DELETE, e.Op)
+	assert.Equal(t, "w/a", e.Key)
+
+	// carries on across slot table changes
+	c.BumpSlotVersion()
+	assert.Nil(t, kv.Put(ctx, "w/b", "2"))
+	e = next()
+	assert.Equal(t, "w/b", e.Key)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	// resume from where we stopped
+	assert.Nil(t, kv.Put(ctx, "w/c", "3"))
+	wctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		done <- kv.Watch(wctx, "w/c", false, cursor, func(e client.Event) error {
+			events <- e
+			return nil
+		})
+	}()
+	e = next()
+	assert.Equal(t, "w/c", e.Key)
+	assert.Equal(t, "3", e.Value)
+}
+
+func TestClient_PubSub(t *testing.T) {
+	c := startCluster(t, 2)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		id, n, err := kv.Publish(ctx, "news", fmt.Sprint(i), 2)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(i), id)
+		assert.Equal(t, 0, n)
+	}
+	messages := make(chan client.Message, 100)
+	sctx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- kv.Subscribe(sctx, "news", true, func(m client.Message) error {
+			messages <- m
+			return nil
+		})
+	}()
+	next := func() client.Message {
+		select {
+		case m := <-messages:
+			return m
+		case <-time.After(2 * time.Second):
+			t.Fatal("no message received")
+			return client.Message{}
+		}
+	}
+	// only the last 2 are retained
+	assert.Equal(t, "2", next().Payload)
+	assert.Equal(t, "3", next().Payload)
+	_, n, err := kv.Publish(ctx, "news", "4", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "4", next().Payload)
+
+	// carries on across slot table changes, catching up with retained messages
+	c.BumpSlotVersion()
+	_, _, err = kv.Publish(ctx, "news", "5", 2)
+	assert.Nil(t, err)
+	m := next()
+	assert.Equal(t, "5", m.Payload)
+	assert.Equal(t, uint64(5), m.Id)
+	select {
+	case m := <-messages:
+		t.Fatalf("unexpected message %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestClient_Lock(t *testing.T) {
+	c := startCluster(t, 2)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+
+	lease, err := kv.TryLock(ctx, "l", time.Minute)
+	assert.Nil(t, err)
+	_, err = kv.TryLock(ctx, "l", time.Minute)
+	assert.True(t, errors.Is(err, client.ELOCKED))
+	assert.Nil(t, kv.RenewLease(ctx, lease, time.Minute))
+	assert.Nil(t, kv.ReleaseLock(ctx, lease))
+	assert.True(t, errors.Is(kv.ReleaseLock(ctx, lease), client.ENOENT))
+
+	// fencing tokens increase, and expired leases are taken over
+	next, err := kv.Lock(ctx, "l", 100*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, next.Fence > lease.Fence)
+	third, err := kv.Lock(ctx, "l", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, third.Fence > next.Fence)
+	assert.True(t, errors.Is(kv.RenewLease(ctx, next, time.Minute), client.ENOENT))
+	assert.True(t, errors.Is(kv.ReleaseLock(ctx, next), client.ENOENT))
+
+	// lock keys are hidden from scans
+	pairs, _, err := kv.Scan(ctx, "", "", 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pairs))
+}
+
+func TestClient_ReplicationStats(t *testing.T) {
+	c := startCluster(t, 1)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+
+	assert.Nil(t, kv.Put(ctx, "a", "b"))
+	stats, err := kv.ReplicationStats(ctx, 1)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "semi-sync", stats.Mode)
+	assert.Equal(t, 3, len(stats.Histograms))
+	for _, h := range stats.Histograms {
+		assert.Equal(t, len(h.Bounds)+1, len(h.Counts))
+	}
+	_, err = kv.ReplicationStats(ctx, 2)
+	assert.True(t, errors.Is(err, client.EINVWID))
+}
+
+func TestClient_Verify(t *testing.T) {
+	c, err := localcluster.StartWithBackups(1, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, kv.Put(ctx, strconv.Itoa(i), "a"))
+	}
+	report, err := kv.Verify(ctx, 1, time.Second)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, uint64(10), report.Version)
+	assert.Equal(t, 2, len(report.Replicas))
+	assert.Equal(t, uint64(10), report.Replicas[1].Version)
+	assert.Equal(t, 10, report.Keys)
+	assert.Empty(t, report.Diffs)
+
+	// a stray write takes the version of the next one, which the backup then skips
+	b := c.Backups[0][0]
+	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", b.Hostname, b.Port), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	sctx := metadata.AppendToOutgoingContext(ctx, worker.HEADER_CLIENT_WORKER_ID, "1")
+	stream, err := pb.NewKVBackupClient(conn).Sync(sctx)
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "stray", Value: "x", Version: 11}))
+	_, err = stream.Recv()
+	assert.Nil(t, err)
+	assert.Nil(t, kv.Put(ctx, "0", "b"))
+
+	report, err = kv.Verify(ctx, 1, time.Second)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, uint64(11), report.Version)
+	assert.Equal(t, 11, report.Keys)
+	assert.Equal(t, 2, len(report.Diffs))
+	kinds := make(map[string]client.KeyDiff)
+	for _, d := range report.Diffs {
+		kinds[d.Key] = d
+	}
+	assert.Equal(t, client.DIFF_VALUE, kinds["0"].Kind)
+	assert.Equal(t, "b", kinds["0"].Expected)
+	assert.Equal(t, "a", kinds["0"].Actual)
+	assert.Equal(t, client.DIFF_EXTRA, kinds["stray"].Kind)
+	assert.Equal(t, "x", kinds["stray"].Actual)
+}
+
+func TestClient_ReadOnly(t *testing.T) {
+	c := startCluster(t, 1)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+	w := c.Workers[0]
+
+	// as if promoted with one backup missing, which is in sync once it is back
+	assert.Nil(t, kv.Put(ctx, "a", "1"))
+	w.EnterReadOnly(1)
+	assert.True(t, errors.Is(kv.Put(ctx, "a", "2"), client.EREADONLY))
+	assert.True(t, errors.Is(kv.Delete(ctx, "a"), client.EREADONLY))
+	value, err := kv.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", value)
+	stats, err := kv.ReplicationStats(ctx, 1)
+	assert.Nil(t, err)
+	assert.True(t, stats.ReadOnly)
+	_, err = c.AddBackup(1)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return kv.Put(ctx, "a", "2") == nil
+	}, 2*time.Second, 50*time.Millisecond)
+
+	// or once an operator says so
+	w.EnterReadOnly(2)
+	assert.True(t, errors.Is(kv.Put(ctx, "a", "3"), client.EREADONLY))
+	resp, err := kv.LeaveReadOnly(ctx, 1)
+	if assert.Nil(t, err) {
+		assert.Equal(t, uint32(1), resp.SyncedBackups)
+		assert.Equal(t, uint32(2), resp.RequiredBackups)
+	}
+	assert.Nil(t, kv.Put(ctx, "a", "3"))
+}
+
+func TestClient_Switchover(t *testing.T) {
+	c := startCluster(t, 1)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+	_, err := c.AddBackup(1)
+	assert.Nil(t, err)
+	assert.Nil(t, kv.Put(ctx, "a", "1"))
+
+	_, err = kv.Switchover(ctx, 1, "nowhere", time.Second)
+	assert.True(t, errors.Is(err, client.ENOENT))
+	// the backup catches up, but could not take over without zookeeper, so the primary stays
+	_, err = kv.Switchover(ctx, 1, "backup-1-0", time.Second)
+	assert.True(t, errors.Is(err, client.EFAILED))
+	assert.Nil(t, kv.Put(ctx, "a", "2"))
+	value, err := kv.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", value)
+}
+
+func TestClient_Raft(t *testing.T) {
+	c, err := localcluster.StartRaft(3, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+
+	assert.Nil(t, kv.Put(ctx, "a", "1"))
+	assert.Nil(t, kv.Put(ctx, "b", "1"))
+	assert.Nil(t, kv.Delete(ctx, "b"))
+	// locks go through compare-and-puts
+	lease, err := kv.TryLock(ctx, "l", time.Minute)
+	assert.Nil(t, err)
+	_, err = kv.TryLock(ctx, "l", time.Minute)
+	assert.True(t, errors.Is(err, client.ELOCKED))
+
+	// the leader crashes, and the rest elect another one holding every write committed
+	leader := -1
+	for i, w := range c.Replicas {
+		if w.RaftNode().Status().State == raft.STATE_LEADER {
+			leader = i
+		}
+	}
+	if !assert.NotEqual(t, -1, leader) {
+		return
+	}
+	c.StopReplica(leader)
+	assert.Nil(t, kv.Put(ctx, "c", "1"))
+	value, err := kv.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", value)
+	_, err = kv.Get(ctx, "b")
+	assert.True(t, errors.Is(err, client.ENOENT))
+	assert.Nil(t, kv.ReleaseLock(ctx, lease))
+}
+
+func TestClient_Learner(t *testing.T) {
+	c := startCluster(t, 1)
+	defer c.Stop()
+	kv := newClient(t, c.MasterAddr)
+	defer kv.Close()
+	ctx := context.Background()
+	_, err := c.AddLearner(1)
+	assert.Nil(t, err)
+	assert.Nil(t, kv.Put(ctx, "a", "1"))
+
+	// synced like a backup, and serving reads asked to go to learners
+	assert.Eventually(t, func() bool {
+		value, err := kv.GetWith(ctx, "a", client.ANY.OnLearners())
+		return err == nil && value == "1"
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Nil(t, kv.Put(ctx, "a", "2"))
+	report, err := kv.Verify(ctx, 1, time.Second)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 2, len(report.Replicas))
+		assert.Empty(t, report.Diffs)
+	}
+	stats, err := kv.ReplicationStats(ctx, 1)
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(stats.Backups)) {
+		assert.True(t, stats.Backups[0].Learner)
+	}
+
+	// but never counted as a backup, nor taking over
+	c.Workers[0].EnterReadOnly(1)
+	resp, err := kv.LeaveReadOnly(ctx, 1)
+	if assert.Nil(t, err) {
+		assert.Equal(t, uint32(0), resp.SyncedBackups)
+	}
+	_, err = kv.Switchover(ctx, 1, "learner-1-0", time.Second)
+	assert.True(t, errors.Is(err, client.ENOENT))
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	pb "github.com/eyeKill/KV/proto"
+)
+
+// An error carrying a non-OK status returned by the KV cluster.
+type StatusError struct {
+	Status pb.Status
+	msg    string
+}
+
+func (e *StatusError) Error() string {
+	return e.msg
+}
+
+// Typed errors, one for each non-OK pb.Status. Compare them with errors.Is.
+var (
+	ENOENT      error = &StatusError{Status: pb.Status_ENOENT, msg: "key does not exist"}
+	ENOSERVER   error = &StatusError{Status: pb.Status_ENOSERVER, msg: "no server available for this worker"}
+	EFAILED     error = &StatusError{Status: pb.Status_EFAILED, msg: "operation failed on server"}
+	EINVSERVER  error = &StatusError{Status: pb.Status_EINVSERVER, msg: "server cannot serve this request"}
+	EINVWID     error = &StatusError{Status: pb.Status_EINVWID, msg: "invalid worker id"}
+	EINVVERSION error = &StatusError{Status: pb.Status_EINVVERSION, msg: "slot table version mismatch"}
+	ETRUNCATED  error = &StatusError{Status: pb.Status_ETRUNCATED, msg: "history is no longer retained"}
+	ELOCKED     error = &StatusError{Status: pb.Status_ELOCKED, msg: "lock is held by someone else"}
+	EINVEPOCH   error = &StatusError{Status: pb.Status_EINVEPOCH, msg: "primary of a stale epoch"}
+	EREADONLY   error = &StatusError{Status: pb.Status_EREADONLY, msg: "worker is read-only until enough backups are in sync"}
+)
+
+var statusErrors = map[pb.Status]error{
+	pb.Status_ENOENT:      ENOENT,
+	pb.Status_ENOSERVER:   ENOSERVER,
+	pb.Status_EFAILED:     EFAILED,
+	pb.Status_EINVSERVER:  EINVSERVER,
+	pb.Status_EINVWID:     EINVWID,
+	pb.Status_EINVVERSION: EINVVERSION,
+	pb.Status_ETRUNCATED:  ETRUNCATED,
+	pb.Status_ELOCKED:     ELOCKED,
+	pb.Status_EINVEPOCH:   EINVEPOCH,
+	pb.Status_EREADONLY:   EREADONLY,
+}
+
+// Map a status to its typed error, nil for OK.
+func StatusToError(status pb.Status) error {
+	if status == pb.Status_OK {
+		return nil
+	}
+	if err, ok := statusErrors[status]; ok {
+		return err
+	}
+	return &StatusError{Status: status, msg: fmt.Sprintf("remote responded %s", status.String())}
+}
+
+// Get the status an error was mapped from. Errors not coming from the cluster
+// (connection failures, cancelled contexts...) are reported as EFAILED.
+func StatusOf(err error) pb.Status {
+	if err == nil {
+		return pb.Status_OK
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Status
+	}
+	return pb.Status_EFAILED
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+)
+
+// Consistency of a read.
+type Consistency struct {
+	Level pb.Consistency
+	// for BOUNDED reads, how far behind the primary a backup serving it may be
+	MaxStaleness time.Duration
+	// served by learners of the owner, instead of backups taking turns with the primary
+	Learners bool
+}
+
+var (
+	// served by the primary only
+	STRONG = Consistency{Level: pb.Consistency_STRONG}
+	// may be served by any backup, however far behind
+	ANY = Consistency{Level: pb.Consistency_ANY}
+)
+
+// May be served by a backup in sync with the primary within `staleness`.
+func Bounded(staleness time.Duration) Consistency {
+	return Consistency{Level: pb.Consistency_BOUNDED, MaxStaleness: staleness}
+}
+
+// The same consistency, served by learners, e.g. for analytics reads kept off the primary & backups.
+// Reads go to the primary if there is no learner, or the one chosen could not serve them.
+func (c Consistency) OnLearners() Consistency {
+	c.Learners = true
+	return c
+}
+
+// Try reading `key` from a backup of its owner, taking turns with the primary.
+// Returns false if it is the primary's turn, or the backup chosen could not serve it.
+func (c *Client) followerGet(ctx context.Context, key string, consistency Consistency) (string, bool, error) {
+	slots, version, err := c.Slots(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	id := slots.GetWorkerIdByKey(key)
+	backups, err := c.resolver.BackupAddrs(ctx, id)
+	if err != nil || len(backups) == 0 {
+		return "", false, err
+	}
+	i := int(c.next.Inc() % uint32(len(backups)+1))
+	if i == len(backups) {
+		return "", false, nil
+	}
+	return c.replicaGet(ctx, id, backups[i], version, key, consistency)
+}
+
+// Try reading `key` from a learner of its owner, returning false if there is none that could serve it.
+func (c *Client) learnerGet(ctx context.Context, key string, consistency Consistency) (string, bool, error) {
+	slots, version, err := c.Slots(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	id := slots.GetWorkerIdByKey(key)
+	learners, err := c.resolver.LearnerAddrs(ctx, id)
+	if err != nil || len(learners) == 0 {
+		return "", false, err
+	}
+	addr := learners[int(c.next.Inc()%uint32(len(learners)))]
+	return c.replicaGet(ctx, id, addr, version, key, consistency)
+}
+
+// Read `key` from backup or learner `addr` of worker `id`, returning false if it could not serve it.
+func (c *Client) replicaGet(ctx context.Context, id common.WorkerId, addr string, version uint32, key string,
+	consistency Consistency) (string, bool, error) {
+	rctx, cancel := context.WithTimeout(ctx, c.opts.RPCTimeout)
+	defer cancel()
+	conn, err := c.pool.Get(rctx, addr)
+	if err != nil {
+		// the replica could have gone, look it up again next time
+		c.resolver.InvalidateWorker(id)
+		c.pool.Drop(addr)
+		return "", false, err
+	}
+	resp, err := pb.NewKVWorkerClient(conn).Get(rctx, &pb.Key{
+		Key:          key,
+		SlotVersion:  version,
+		Consistency:  consistency.Level,
+		MaxStaleness: uint32(consistency.MaxStaleness / time.Millisecond),
+	})
+	if err != nil {
+		c.resolver.InvalidateWorker(id)
+		c.pool.Drop(addr)
+		return "", false, err
+	}
+	switch resp.Status {
+	case pb.Status_OK:
+		return resp.Value, true, nil
+	case pb.Status_ENOENT:
+		return "", true, ENOENT
+	default:
+		// too stale, or slot table changed
+		return "", false, nil
+	}
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	pb "github.com/eyeKill/KV/proto"
+	"google.golang.org/grpc"
+)
+
+// A lease on a lock.
+type Lease struct {
+	Name  string
+	Token string
+	// Increases each time the lock is acquired. Pass it along to whatever the lock guards,
+	// so that writes from holders whose lease has expired could be rejected.
+	Fence uint64
+}
+
+type lockRPC func(pb.KVWorkerClient, context.Context, *pb.LockRequest, ...grpc.CallOption) (*pb.LockResponse, error)
+
+func (c *Client) lockCall(ctx context.Context, rpc lockRPC, req *pb.LockRequest) (*pb.LockResponse, error) {
+	var resp *pb.LockResponse
+	err := c.do(ctx, req.Name, func(ctx context.Context, client pb.KVWorkerClient, slotVersion uint32) (pb.Status, error) {
+		req.SlotVersion = slotVersion
+		var err error
+		resp, err = rpc(client, ctx, req)
+		if err != nil {
+			return pb.Status_OK, err
+		}
+		return resp.Status, nil
+	})
+	return resp, err
+}
+
+// Token for a new lease, chosen by the client so that retrying a request whose reply was lost
+// gets the lease it has been given, instead of finding the lock held.
+func newLockToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Acquire lock `name` for `ttl` under `token`, returns ELOCKED if someone else holds it.
+// If it is already held under `token`, the lease given then is returned, e.g. when retrying an acquisition.
+func (c *Client) TryLockAs(ctx context.Context, name string, token string, ttl time.Duration) (*Lease, error) {
+	resp, err := c.lockCall(ctx, pb.KVWorkerClient.AcquireLock, &pb.LockRequest{Name: name, Token: token, Ttl: uint32(ttl / time.Millisecond)})
+	if err != nil {
+		return nil, err
+	}
+	return &Lease{Name: name, Token: resp.Token, Fence: resp.Fence}, nil
+}
+
+// Acquire lock `name` for `ttl`, returns ELOCKED if someone else holds it.
+func (c *Client) TryLock(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	return c.TryLockAs(ctx, name, token, ttl)
+}
+
+// Acquire lock `name` for `ttl`, waiting until it is released or expires, or ctx is done.
+func (c *Client) Lock(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		lease, err := c.TryLockAs(ctx, name, token, ttl)
+		if !errors.Is(err, ELOCKED) {
+			return lease, err
+		}
+		if err := c.backoff(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Extend a lease to `ttl` from now, returns ENOENT if it has been lost.
+func (c *Client) RenewLease(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	_, err := c.lockCall(ctx, pb.KVWorkerClient.RenewLease, &pb.LockRequest{Name: lease.Name, Token: lease.Token, Ttl: uint32(ttl / time.Millisecond)})
+	return err
+}
+
+// Release a lock, returns ENOENT if the lease has been lost.
+func (c *Client) ReleaseLock(ctx context.Context, lease *Lease) error {
+	_, err := c.lockCall(ctx, pb.KVWorkerClient.ReleaseLock, &pb.LockRequest{Name: lease.Name, Token: lease.Token})
+	return err
+}
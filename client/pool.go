@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/eyeKill/KV/common"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// A set of gRPC connections indexed by address, safe for concurrent use.
+// gRPC connections multiplex requests, so one connection per server is enough.
+type connPool struct {
+	lock  sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newConnPool() *connPool {
+	return &connPool{conns: make(map[string]*grpc.ClientConn)}
+}
+
+// Get a connection to `addr`, dialing it if there is none.
+func (p *connPool) Get(ctx context.Context, addr string) (*grpc.ClientConn, error) {
+	p.lock.Lock()
+	conn, ok := p.conns[addr]
+	p.lock.Unlock()
+	if ok {
+		return conn, nil
+	}
+	common.Log().Debug("Dialing...", zap.String("server", addr))
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if existing, ok := p.conns[addr]; ok {
+		// someone else dialed it in the meantime
+		_ = conn.Close()
+		return existing, nil
+	}
+	p.conns[addr] = conn
+	return conn, nil
+}
+
+// Close and forget the connection to `addr`, if any.
+func (p *connPool) Drop(addr string) {
+	p.lock.Lock()
+	conn, ok := p.conns[addr]
+	delete(p.conns, addr)
+	p.lock.Unlock()
+	if ok {
+		_ = conn.Close()
+	}
+}
+
+func (p *connPool) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var ret error
+	for addr, conn := range p.conns {
+		if err := conn.Close(); err != nil && ret == nil {
+			ret = err
+		}
+		delete(p.conns, addr)
+	}
+	return ret
+}
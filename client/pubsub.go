@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/zap"
+)
+
+// A message published to a channel.
+type Message struct {
+	Channel string
+	// increasing in each channel, continues across migrations & failovers only while messages are retained
+	Id      uint64
+	Payload string
+}
+
+// Publish `payload` to `channel`, keeping the last `retain` messages of the channel for new subscribers.
+// Returns id of the message and the number of subscribers it is delivered to.
+func (c *Client) Publish(ctx context.Context, channel string, payload string, retain int) (uint64, int, error) {
+	var resp *pb.PublishResponse
+	err := c.do(ctx, channel, func(ctx context.Context, client pb.KVWorkerClient, slotVersion uint32) (pb.Status, error) {
+		var err error
+		resp, err = client.Publish(ctx, &pb.PublishRequest{
+			Channel:     channel,
+			Payload:     payload,
+			Retain:      uint32(retain),
+			SlotVersion: slotVersion,
+		})
+		if err != nil {
+			return pb.Status_OK, err
+		}
+		return resp.Status, nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return resp.Id, int(resp.Receivers), nil
+}
+
+// Subscribe to `channel`, calling `handler` for each message until ctx is done or `handler` returns an error.
+// Retained messages are delivered first if `replay` is set.
+// The subscription follows the channel across migrations & failovers, and after reconnecting,
+// retained messages missed in between are delivered.
+func (c *Client) Subscribe(ctx context.Context, channel string, replay bool, handler func(Message) error) error {
+	log := common.Log()
+	req := &pb.SubscribeRequest{Channel: channel, Replay: replay}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := c.backoff(ctx, attempt-1); err != nil {
+				return err
+			}
+		}
+		slots, version, err := c.Slots(ctx)
+		if err != nil {
+			continue
+		}
+		id := slots.GetWorkerIdByKey(channel)
+		addr, err := c.resolver.WorkerAddr(ctx, id)
+		if errors.Is(err, EINVWID) {
+			_ = c.RefreshSlots(ctx)
+			continue
+		} else if err != nil {
+			continue
+		}
+		dctx, cancel := context.WithTimeout(ctx, c.opts.RPCTimeout)
+		conn, err := c.pool.Get(dctx, addr)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.invalidateWorker(id, addr)
+			continue
+		}
+		req.SlotVersion = version
+		stream, err := pb.NewKVWorkerClient(conn).Subscribe(ctx, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.invalidateWorker(id, addr)
+			continue
+		}
+		for {
+			m, err := stream.Recv()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Info("Subscription broken, retrying...", zap.String("channel", channel), zap.Error(err))
+				c.invalidateWorker(id, addr)
+				break
+			}
+			if m.Status == pb.Status_OK {
+				if err := handler(Message{Channel: m.Channel, Id: m.Id, Payload: m.Payload}); err != nil {
+					return err
+				}
+				// catch up from here after reconnecting
+				req.Replay, req.AfterId = true, m.Id
+				attempt = 0
+				continue
+			}
+			if m.Status == pb.Status_EINVVERSION {
+				if err := c.RefreshSlots(ctx); err != nil {
+					log.Info("Failed to refresh slot table.", zap.Error(err))
+				}
+				attempt = 0
+			} else if m.Status == pb.Status_EINVSERVER || m.Status == pb.Status_ENOSERVER {
+				c.invalidateWorker(id, addr)
+			} else if m.Status != pb.Status_EFAILED {
+				// EFAILED means we fell behind, just reconnect
+				return StatusToError(m.Status)
+			}
+			break
+		}
+	}
+}
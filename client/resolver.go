@@ -0,0 +1,193 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"go.uber.org/zap"
+)
+
+var ENOMASTER = errors.New("no master available")
+
+// Resolves slots to workers and workers to the addresses of their primaries.
+// Implementations are expected to cache their results and be safe for concurrent use.
+type Resolver interface {
+	// Get the slot table & its version, fetching it if there is none.
+	Slots(ctx context.Context) (common.HashSlotRing, uint32, error)
+	// Fetch the latest slot table, discarding cached worker addresses as well.
+	RefreshSlots(ctx context.Context) error
+	// Get address of the primary of worker `id`, with format `hostname:port`.
+	WorkerAddr(ctx context.Context, id common.WorkerId) (string, error)
+	// Get addresses of the backups of worker `id`, for follower reads.
+	BackupAddrs(ctx context.Context, id common.WorkerId) ([]string, error)
+	// Get addresses of the learners of worker `id`, for reads kept off its primary & backups.
+	LearnerAddrs(ctx context.Context, id common.WorkerId) ([]string, error)
+	// Forget the cached address of worker `id`.
+	InvalidateWorker(id common.WorkerId)
+}
+
+// Addresses of the nodes of a worker.
+type workerAddrs struct {
+	primary  string
+	backups  []string
+	learners []string
+}
+
+// Resolver asking masters.
+type masterResolver struct {
+	opts Options
+	pool *connPool
+
+	// index of the master we are currently talking to
+	masterLock sync.Mutex
+	masterIdx  int
+
+	slotLock    sync.RWMutex
+	slots       common.HashSlotRing
+	slotVersion uint32
+
+	// addresses of the nodes of each worker
+	workerLock sync.RWMutex
+	workers    map[common.WorkerId]workerAddrs
+}
+
+func newMasterResolver(opts Options, pool *connPool) *masterResolver {
+	return &masterResolver{
+		opts:    opts,
+		pool:    pool,
+		workers: make(map[common.WorkerId]workerAddrs),
+	}
+}
+
+// Call `f` on the current master, failing over to the next master on connection errors.
+func (r *masterResolver) withMaster(ctx context.Context, f func(ctx context.Context, client pb.KVMasterClient) error) error {
+	log := common.Log()
+	r.masterLock.Lock()
+	start := r.masterIdx
+	r.masterLock.Unlock()
+	var lastErr error = ENOMASTER
+	for i := 0; i < len(r.opts.MasterAddrs); i++ {
+		idx := (start + i) % len(r.opts.MasterAddrs)
+		addr := r.opts.MasterAddrs[idx]
+		err := func() error {
+			rctx, cancel := context.WithTimeout(ctx, r.opts.RPCTimeout)
+			defer cancel()
+			conn, err := r.pool.Get(rctx, addr)
+			if err != nil {
+				return err
+			}
+			return f(rctx, pb.NewKVMasterClient(conn))
+		}()
+		if err == nil {
+			r.masterLock.Lock()
+			r.masterIdx = idx
+			r.masterLock.Unlock()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Warn("Master unavailable, trying the next one.", zap.String("master", addr), zap.Error(err))
+		r.pool.Drop(addr)
+		lastErr = err
+	}
+	return lastErr
+}
+
+func (r *masterResolver) RefreshSlots(ctx context.Context) error {
+	var resp *pb.GetSlotsResponse
+	err := r.withMaster(ctx, func(ctx context.Context, client pb.KVMasterClient) error {
+		var err error
+		resp, err = client.GetSlots(ctx, &empty.Empty{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	slots := make(common.HashSlotRing, len(resp.SlotTable))
+	for i, v := range resp.SlotTable {
+		slots[i] = common.WorkerId(v.Id)
+	}
+	r.slotLock.Lock()
+	r.slots = slots
+	r.slotVersion = resp.Version
+	r.slotLock.Unlock()
+	// worker addresses might have changed as well
+	r.workerLock.Lock()
+	r.workers = make(map[common.WorkerId]workerAddrs)
+	r.workerLock.Unlock()
+	return nil
+}
+
+func (r *masterResolver) Slots(ctx context.Context) (common.HashSlotRing, uint32, error) {
+	r.slotLock.RLock()
+	slots, version := r.slots, r.slotVersion
+	r.slotLock.RUnlock()
+	if slots != nil {
+		return slots, version, nil
+	}
+	if err := r.RefreshSlots(ctx); err != nil {
+		return nil, 0, err
+	}
+	r.slotLock.RLock()
+	defer r.slotLock.RUnlock()
+	return r.slots, r.slotVersion, nil
+}
+
+func (r *masterResolver) lookup(ctx context.Context, id common.WorkerId) (workerAddrs, error) {
+	r.workerLock.RLock()
+	addrs, ok := r.workers[id]
+	r.workerLock.RUnlock()
+	if ok {
+		return addrs, nil
+	}
+	var resp *pb.GetWorkerResponse
+	err := r.withMaster(ctx, func(ctx context.Context, client pb.KVMasterClient) error {
+		var err error
+		resp, err = client.GetWorkerById(ctx, &pb.WorkerId{Id: uint32(id)})
+		return err
+	})
+	if err != nil {
+		return addrs, err
+	}
+	if resp.Status != pb.Status_OK {
+		return addrs, StatusToError(resp.Status)
+	}
+	addrs.primary = fmt.Sprintf("%s:%d", resp.Worker.Hostname, resp.Worker.Port)
+	for _, b := range resp.Backups {
+		addrs.backups = append(addrs.backups, fmt.Sprintf("%s:%d", b.Hostname, b.Port))
+	}
+	for _, l := range resp.Learners {
+		addrs.learners = append(addrs.learners, fmt.Sprintf("%s:%d", l.Hostname, l.Port))
+	}
+	r.workerLock.Lock()
+	r.workers[id] = addrs
+	r.workerLock.Unlock()
+	return addrs, nil
+}
+
+func (r *masterResolver) WorkerAddr(ctx context.Context, id common.WorkerId) (string, error) {
+	addrs, err := r.lookup(ctx, id)
+	return addrs.primary, err
+}
+
+func (r *masterResolver) BackupAddrs(ctx context.Context, id common.WorkerId) ([]string, error) {
+	addrs, err := r.lookup(ctx, id)
+	return addrs.backups, err
+}
+
+func (r *masterResolver) LearnerAddrs(ctx context.Context, id common.WorkerId) ([]string, error) {
+	addrs, err := r.lookup(ctx, id)
+	return addrs.learners, err
+}
+
+func (r *masterResolver) InvalidateWorker(id common.WorkerId) {
+	r.workerLock.Lock()
+	delete(r.workers, id)
+	r.workerLock.Unlock()
+}
@@ -0,0 +1,207 @@
+package client
+
+// checking consistency of the replicas of a worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+)
+
+// Kinds of differences between a backup and its primary.
+const (
+	// present on the primary only
+	DIFF_MISSING = "missing"
+	// present on the backup only
+	DIFF_EXTRA = "extra"
+	// values differ
+	DIFF_VALUE = "value"
+	// values are the same, but written at different versions
+	DIFF_VERSION = "version"
+)
+
+// A key of a backup that differs from its primary.
+type KeyDiff struct {
+	Slot common.SlotId
+	Key  string
+	// address of the backup
+	Replica string
+	Kind    string
+	// value & version on the primary and on the backup, empty for missing ones
+	Expected        string
+	ExpectedVersion uint64
+	Actual          string
+	ActualVersion   uint64
+}
+
+// A replica of the worker verified.
+type ReplicaVersion struct {
+	Addr    string
+	Primary bool
+	// version applied when its keys are taken, backups behind the cut are compared anyway
+	Version uint64
+}
+
+// Result of comparing the replicas of a worker at a version cut.
+type VerifyReport struct {
+	Worker common.WorkerId
+	// version of the primary when its keys are taken
+	Version  uint64
+	Replicas []ReplicaVersion
+	// number of keys compared, and those written after the cut on some replica, which are left out
+	Keys    int
+	Skipped int
+	// in order of slot, key and replica
+	Diffs []KeyDiff
+}
+
+// Take every key of the replica at `addr` once it has applied `version`, waiting for `wait` at most.
+// Returns them with the version actually applied.
+func (c *Client) dump(ctx context.Context, addr string, version uint64, wait time.Duration) (map[string]*pb.DumpEntry, uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.RPCTimeout+wait)
+	defer cancel()
+	conn, err := c.pool.Get(ctx, addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	stream, err := pb.NewKVBackupClient(conn).Dump(ctx, &pb.DumpRequest{
+		Version:   version,
+		TimeoutMs: uint32(wait / time.Millisecond),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := make(map[string]*pb.DumpEntry)
+	var applied uint64
+	received := false
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		if err := StatusToError(resp.Status); err != nil {
+			return nil, 0, err
+		}
+		received = true
+		applied = resp.Version
+		for _, ent := range resp.Entries {
+			entries[ent.Key] = ent
+		}
+	}
+	if !received {
+		return nil, 0, errors.New("replica sent nothing")
+	}
+	return entries, applied, nil
+}
+
+// Compare every backup & learner of worker `id` with its primary, key by key, at the version of the primary
+// when its keys are taken. They are given `wait` to catch up with that version.
+// Keys written after it on any replica are left out, since replicas only keep their latest values.
+func (c *Client) Verify(ctx context.Context, id common.WorkerId, wait time.Duration) (*VerifyReport, error) {
+	primary, err := c.resolver.WorkerAddr(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := c.resolver.BackupAddrs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	learners, err := c.resolver.LearnerAddrs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// not appended in place, the resolver keeps them
+	backups = append(append([]string(nil), backups...), learners...)
+	slots, _, err := c.resolver.Slots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	expected, version, err := c.dump(ctx, primary, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	report := VerifyReport{
+		Worker:   id,
+		Version:  version,
+		Replicas: []ReplicaVersion{{Addr: primary, Primary: true, Version: version}},
+	}
+	dumps := make([]map[string]*pb.DumpEntry, len(backups))
+	for i, addr := range backups {
+		entries, applied, err := c.dump(ctx, addr, version, wait)
+		if err != nil {
+			return nil, err
+		}
+		dumps[i] = entries
+		report.Replicas = append(report.Replicas, ReplicaVersion{Addr: addr, Version: applied})
+	}
+	// every key present somewhere, unless written after the cut somewhere
+	keys := make(map[string]bool)
+	skipped := make(map[string]bool)
+	for _, entries := range append([]map[string]*pb.DumpEntry{expected}, dumps...) {
+		for k, ent := range entries {
+			if ent.Version > version {
+				skipped[k] = true
+			} else if !ent.Deleted {
+				keys[k] = true
+			}
+		}
+	}
+	for k := range keys {
+		if skipped[k] {
+			continue
+		}
+		report.Keys++
+		slot := common.GetSlotId(k, len(slots))
+		want, ok := expected[k]
+		if ok && want.Deleted {
+			want, ok = nil, false
+		}
+		for i, entries := range dumps {
+			got, found := entries[k]
+			if found && got.Deleted {
+				got, found = nil, false
+			}
+			diff := KeyDiff{Slot: slot, Key: k, Replica: backups[i]}
+			if ok {
+				diff.Expected, diff.ExpectedVersion = want.Value, want.Version
+			}
+			if found {
+				diff.Actual, diff.ActualVersion = got.Value, got.Version
+			}
+			switch {
+			case !ok && !found:
+				continue
+			case !found:
+				diff.Kind = DIFF_MISSING
+			case !ok:
+				diff.Kind = DIFF_EXTRA
+			case want.Value != got.Value:
+				diff.Kind = DIFF_VALUE
+			case want.Version != got.Version:
+				diff.Kind = DIFF_VERSION
+			default:
+				continue
+			}
+			report.Diffs = append(report.Diffs, diff)
+		}
+	}
+	report.Skipped = len(skipped)
+	sort.Slice(report.Diffs, func(i, j int) bool {
+		a, b := report.Diffs[i], report.Diffs[j]
+		if a.Slot != b.Slot {
+			return a.Slot < b.Slot
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Replica < b.Replica
+	})
+	return &report, nil
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/zap"
+)
+
+// A change of a key.
+type Event struct {
+	// worker the change happened on, versions are only comparable on the same worker
+	Worker common.WorkerId
+	// PUT or DELETE
+	Op      pb.Operation
+	Key     string
+	Value   string
+	Version uint64
+}
+
+// Position reached in the change feed of each worker, used to resume watching.
+type WatchCursor map[common.WorkerId]uint64
+
+// returned by a single worker stream when the slot table has to be refreshed
+var errSlotsChanged = errors.New("slot table changed")
+
+// Watch changes of `key`, or of every key starting with `key` if `prefix` is set, calling `handler` for each of them.
+// Changes after `cursor` are replayed as long as workers still retain them, pass nil to get new changes only.
+// `cursor` is updated as changes are delivered, so it could be used to resume later.
+// Watching carries on across failovers & migrations, until ctx is done, `handler` returns an error
+// or the history needed is no longer retained (ETRUNCATED).
+// Changes of the same worker are delivered in order, and `handler` is never called concurrently.
+func (c *Client) Watch(ctx context.Context, key string, prefix bool, cursor WatchCursor, handler func(Event) error) error {
+	log := common.Log()
+	if cursor == nil {
+		cursor = make(WatchCursor)
+	}
+	// guards cursor & calls to handler
+	var lock sync.Mutex
+	var since uint32 = 0
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := c.backoff(ctx, attempt-1); err != nil {
+				return err
+			}
+		}
+		slots, version, err := c.Slots(ctx)
+		if err != nil {
+			log.Info("Failed to get slot table, retrying...", zap.Error(err))
+			continue
+		}
+		// only owner of the key is watched, or every worker for a prefix
+		ids := make(map[common.WorkerId]bool)
+		if prefix {
+			for _, id := range slots {
+				ids[id] = true
+			}
+		} else {
+			ids[slots.GetWorkerIdByKey(key)] = true
+		}
+		wctx, cancel := context.WithCancel(ctx)
+		errCh := make(chan error, len(ids))
+		for id := range ids {
+			id := id
+			go func() {
+				errCh <- c.watchWorker(wctx, id, &pb.WatchRequest{
+					Key:              key,
+					Prefix:           prefix,
+					SinceSlotVersion: since,
+					SlotVersion:      version,
+				}, &lock, cursor, handler)
+			}()
+		}
+		// once any of them stops, restart all of them
+		err = <-errCh
+		cancel()
+		for i := 1; i < len(ids); i++ {
+			<-errCh
+		}
+		if err != errSlotsChanged {
+			return err
+		}
+		if err := c.RefreshSlots(ctx); err != nil {
+			log.Info("Failed to refresh slot table.", zap.Error(err))
+		}
+		// workers new to us should replay from the point they took over
+		since = version + 1
+		attempt = 0
+	}
+}
+
+// Stream changes from worker `id`, following its primary across failovers.
+func (c *Client) watchWorker(ctx context.Context, id common.WorkerId, req *pb.WatchRequest,
+	lock *sync.Mutex, cursor WatchCursor, handler func(Event) error) error {
+	log := common.Log()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := c.backoff(ctx, attempt-1); err != nil {
+				return err
+			}
+		}
+		addr, err := c.resolver.WorkerAddr(ctx, id)
+		if errors.Is(err, EINVWID) {
+			return errSlotsChanged
+		} else if err != nil {
+			continue
+		}
+		dctx, cancel := context.WithTimeout(ctx, c.opts.RPCTimeout)
+		conn, err := c.pool.Get(dctx, addr)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.invalidateWorker(id, addr)
+			continue
+		}
+		lock.Lock()
+		if v, ok := cursor[id]; ok {
+			req.FromVersion = v
+		}
+		lock.Unlock()
+		stream, err := pb.NewKVWorkerClient(conn).WatchKeys(ctx, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.invalidateWorker(id, addr)
+			continue
+		}
+		for {
+			ev, err := stream.Recv()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Info("Watch stream broken, retrying...", zap.Int("worker", int(id)), zap.Error(err))
+				c.invalidateWorker(id, addr)
+				break
+			}
+			if ev.Status == pb.Status_OK {
+				lock.Lock()
+				if ev.Op != pb.Operation_GET {
+					err = handler(Event{Worker: id, Op: ev.Op, Key: ev.Key, Value: ev.Value, Version: ev.Version})
+				}
+				if err == nil {
+					cursor[id] = ev.Version
+				}
+				lock.Unlock()
+				if err != nil {
+					return err
+				}
+				attempt = 0
+				continue
+			}
+			if ev.Status == pb.Status_EINVVERSION {
+				return errSlotsChanged
+			} else if ev.Status == pb.Status_EINVSERVER || ev.Status == pb.Status_ENOSERVER {
+				// primary changed
+				c.invalidateWorker(id, addr)
+				break
+			}
+			return StatusToError(ev.Status)
+		}
+	}
+}
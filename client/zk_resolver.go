@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	"github.com/samuel/go-zookeeper/zk"
+	"go.uber.org/zap"
+)
+
+// Resolver reading the slot table & workers from zookeeper directly.
+// The slot table is reloaded whenever its version changes, and cached worker addresses
+// are dropped whenever their worker nodes change, so masters are never involved.
+type ZkResolver struct {
+	conn *zk.Conn
+
+	slotLock    sync.RWMutex
+	slots       common.HashSlotRing
+	slotVersion uint32
+
+	workerLock sync.RWMutex
+	workers    map[common.WorkerId]workerAddrs
+
+	stopCh chan struct{}
+}
+
+// Create a resolver and start watching the slot table.
+func NewZkResolver(conn *zk.Conn) (*ZkResolver, error) {
+	r := &ZkResolver{
+		conn:    conn,
+		workers: make(map[common.WorkerId]workerAddrs),
+		stopCh:  make(chan struct{}),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	go r.watchSlots()
+	return r, nil
+}
+
+// Stop watching zookeeper.
+func (r *ZkResolver) Close() {
+	close(r.stopCh)
+}
+
+// Load slot table & its version. Both are updated in one transaction by master,
+// read the version twice to make sure that they match.
+func (r *ZkResolver) load() error {
+	for {
+		var before, after uint32
+		var slots common.HashSlotRing
+		if err := common.ZkGet(r.conn, common.ZK_TABLE_VERSION, &before); err != nil {
+			return err
+		}
+		if err := common.ZkGet(r.conn, common.ZK_TABLE, &slots); err != nil {
+			return err
+		}
+		if err := common.ZkGet(r.conn, common.ZK_TABLE_VERSION, &after); err != nil {
+			return err
+		}
+		if before != after {
+			continue
+		}
+		r.slotLock.Lock()
+		changed := r.slots == nil || r.slotVersion != after
+		r.slots = slots
+		r.slotVersion = after
+		r.slotLock.Unlock()
+		if changed {
+			r.workerLock.Lock()
+			r.workers = make(map[common.WorkerId]workerAddrs)
+			r.workerLock.Unlock()
+		}
+		return nil
+	}
+}
+
+func (r *ZkResolver) watchSlots() {
+	log := common.Log()
+	for {
+		_, _, eventChan, err := r.conn.GetW(common.ZK_TABLE_VERSION)
+		if err == nil {
+			err = r.load()
+		}
+		if err != nil {
+			log.Error("Failed to watch slot table.", zap.Error(err))
+			select {
+			case <-time.After(2 * time.Second):
+				continue
+			case <-r.stopCh:
+				return
+			}
+		}
+		select {
+		case <-eventChan:
+			continue
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+func (r *ZkResolver) RefreshSlots(_ context.Context) error {
+	return r.load()
+}
+
+func (r *ZkResolver) Slots(_ context.Context) (common.HashSlotRing, uint32, error) {
+	r.slotLock.RLock()
+	defer r.slotLock.RUnlock()
+	return r.slots, r.slotVersion, nil
+}
+
+func (r *ZkResolver) lookup(id common.WorkerId) (workerAddrs, error) {
+	r.workerLock.RLock()
+	addrs, ok := r.workers[id]
+	r.workerLock.RUnlock()
+	if ok {
+		return addrs, nil
+	}
+	worker, err := common.GetAndWatchWorker(r.conn, id)
+	if err == zk.ErrNoNode {
+		return addrs, EINVWID
+	} else if err != nil {
+		return addrs, err
+	}
+	if len(worker.Primaries) == 0 {
+		return addrs, ENOSERVER
+	}
+	// use the first primary, like master does
+	var name string
+	for k := range worker.Primaries {
+		if name == "" || k < name {
+			name = k
+		}
+	}
+	node := worker.Primaries[name]
+	addrs.primary = fmt.Sprintf("%s:%d", node.Host.Hostname, node.Host.Port)
+	addrs.backups = addrsByName(worker.Backups)
+	addrs.learners = addrsByName(worker.Learners)
+	r.workerLock.Lock()
+	r.workers[id] = addrs
+	r.workerLock.Unlock()
+	// forget it once worker nodes change
+	go func() {
+		select {
+		case <-worker.Watcher:
+			r.InvalidateWorker(id)
+		case <-r.stopCh:
+		}
+	}()
+	return addrs, nil
+}
+
+func (r *ZkResolver) WorkerAddr(_ context.Context, id common.WorkerId) (string, error) {
+	addrs, err := r.lookup(id)
+	return addrs.primary, err
+}
+
+// Get addresses of worker nodes in the order of their names.
+func addrsByName(nodes map[string]*common.WorkerNode) []string {
+	names := make([]string, 0, len(nodes))
+	for k := range nodes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	ret := make([]string, 0, len(names))
+	for _, k := range names {
+		node := nodes[k]
+		ret = append(ret, fmt.Sprintf("%s:%d", node.Host.Hostname, node.Host.Port))
+	}
+	return ret
+}
+
+func (r *ZkResolver) BackupAddrs(_ context.Context, id common.WorkerId) ([]string, error) {
+	addrs, err := r.lookup(id)
+	return addrs.backups, err
+}
+
+func (r *ZkResolver) LearnerAddrs(_ context.Context, id common.WorkerId) ([]string, error) {
+	addrs, err := r.lookup(id)
+	return addrs.learners, err
+}
+
+func (r *ZkResolver) InvalidateWorker(id common.WorkerId) {
+	r.workerLock.Lock()
+	delete(r.workers, id)
+	r.workerLock.Unlock()
+}
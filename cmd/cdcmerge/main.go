@@ -0,0 +1,89 @@
+// Merge change data capture feeds of several workers into one cluster-wide stream.
+// Records are written to stdout as JSON Lines, ordered by slot table version (epoch),
+// then by worker, keeping the order of each worker's feed.
+// When following, records are only ordered against those already written by the time they are read.
+//
+// Usage: cdcmerge [-checkpoint-dir dir] [-follow] feed-dir...
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/eyeKill/KV/cdc"
+	"github.com/eyeKill/KV/common"
+	"go.uber.org/zap"
+)
+
+var (
+	checkpointDir = flag.String("checkpoint-dir", "",
+		"Directory for consumer offsets of each feed, start from the beginning every time if empty")
+	follow   = flag.Bool("follow", false, "Keep waiting for new records")
+	interval = flag.Duration("interval", time.Second, "Polling interval when following")
+)
+
+func main() {
+	log := common.Log()
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: cdcmerge [-checkpoint-dir dir] [-follow] feed-dir...")
+		os.Exit(64)
+	}
+	var readers []*cdc.Reader
+	for _, dir := range flag.Args() {
+		checkpoint := ""
+		if *checkpointDir != "" {
+			name, err := checkpointName(dir)
+			if err != nil {
+				log.Fatal("Failed to resolve feed.", zap.String("dir", dir), zap.Error(err))
+			}
+			checkpoint = path.Join(*checkpointDir, name)
+		}
+		r, err := cdc.NewReader(dir, checkpoint)
+		if err != nil {
+			log.Fatal("Failed to open feed.", zap.String("dir", dir), zap.Error(err))
+		}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	encoder := json.NewEncoder(out)
+	for {
+		err := cdc.Merge(readers, func(r cdc.Record) error {
+			return encoder.Encode(r)
+		})
+		if err != nil {
+			log.Fatal("Failed to merge feeds.", zap.Error(err))
+		}
+		if err := out.Flush(); err != nil {
+			log.Fatal("Failed to write records.", zap.Error(err))
+		}
+		// offsets are committed only after records are written out
+		for _, r := range readers {
+			if err := r.Commit(); err != nil {
+				log.Fatal("Failed to save offset.", zap.Error(err))
+			}
+		}
+		if !*follow {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// Name of the checkpoint of the feed in `dir`, the same whatever the order feeds are given in
+// or however the directory is written.
+func checkpointName(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%08x.offset", filepath.Base(abs), crc32.ChecksumIEEE([]byte(abs))), nil
+}
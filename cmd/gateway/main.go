@@ -0,0 +1,36 @@
+// HTTP/JSON gateway for the distributed KV store
+// It exposes the KV API over HTTP for clients that do not speak gRPC.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	"github.com/eyeKill/KV/gateway"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+var (
+	masterAddrs = flag.String("addr", "localhost:7899", "Addresses of the masters, separated by comma")
+	port        = flag.Int("port", 8080, "The HTTP port")
+)
+
+func main() {
+	log := common.Log()
+	flag.Parse()
+
+	kv, err := client.New(client.DefaultOptions(strings.Split(*masterAddrs, ",")...))
+	if err != nil {
+		log.Panic("Failed to create client.", zap.Error(err))
+	}
+	defer kv.Close()
+
+	addr := fmt.Sprintf("0.0.0.0:%d", *port)
+	log.Info("Serving HTTP gateway.", zap.String("addr", addr))
+	if err := http.ListenAndServe(addr, gateway.NewServer(kv)); err != nil {
+		log.Error("HTTP server raised error.", zap.Error(err))
+	}
+}
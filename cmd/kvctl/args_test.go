@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitArgs(t *testing.T) {
+	args, err := SplitArgs(`put greeting "hello world"`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"put", "greeting", "hello world"}, args)
+	args, err = SplitArgs(`put 'a b' it\'s\ fine "say \"hi\""`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"put", "a b", "it's fine", `say "hi"`}, args)
+	args, err = SplitArgs(`get ""`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"get", ""}, args)
+	_, err = SplitArgs(`get "a`)
+	assert.NotNil(t, err)
+}
+
+func TestParseInterleaved(t *testing.T) {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	f := fs.String("value-file", "", "")
+	args, err := parseInterleaved(fs, []string{"k", "--value-file", "v.txt"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"k"}, args)
+	assert.Equal(t, "v.txt", *f)
+	args, err = parseInterleaved(fs, []string{"--", "-k"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-k"}, args)
+}
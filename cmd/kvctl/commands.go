@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Exit code for malformed command lines. Other failures exit with their pb.Status value.
+const EXIT_USAGE = 64
+
+type usageError struct {
+	usage string
+}
+
+func (e usageError) Error() string {
+	return "usage: " + e.usage
+}
+
+// Shared state of all commands.
+type Env struct {
+	Masters []string
+	// Workers are looked up in zookeeper directly if set, instead of asking masters.
+	ZkServers []string
+	Printer   Printer
+	kv        *client.Client
+	zkConn    *zk.Conn
+	resolver  *client.ZkResolver
+}
+
+// Get the client, creating it on first use.
+func (e *Env) Client() (*client.Client, error) {
+	if e.kv == nil {
+		opts := client.DefaultOptions(e.Masters...)
+		if len(e.ZkServers) > 0 {
+			if e.resolver == nil {
+				conn, err := common.ConnectToZk(e.ZkServers)
+				if err != nil {
+					return nil, err
+				}
+				resolver, err := client.NewZkResolver(conn)
+				if err != nil {
+					conn.Close()
+					return nil, err
+				}
+				e.zkConn, e.resolver = conn, resolver
+			}
+			opts.Resolver = e.resolver
+		}
+		kv, err := client.New(opts)
+		if err != nil {
+			return nil, err
+		}
+		e.kv = kv
+	}
+	return e.kv, nil
+}
+
+func (e *Env) Close() {
+	if e.kv != nil {
+		_ = e.kv.Close()
+	}
+	if e.resolver != nil {
+		e.resolver.Close()
+		e.zkConn.Close()
+	}
+}
+
+type Command struct {
+	Name  string
+	Usage string
+	Help  string
+	Run   func(ctx context.Context, env *Env, args []string) error
+}
+
+var commands = map[string]*Command{}
+
+func register(c *Command) {
+	commands[c.Name] = c
+}
+
+func init() {
+	register(&Command{
+		Name:  "get",
+		Usage: "get <key>",
+		Help:  "Print the value of a key.",
+		Run:   runGet,
+	})
+	register(&Command{
+		Name:  "put",
+		Usage: "put <key> (<value> | --value-file <file>)",
+		Help:  "Set the value of a key. Use `--value-file -` to read the value from stdin.",
+		Run:   runPut,
+	})
+	register(&Command{
+		Name:  "delete",
+		Usage: "delete <key>",
+		Help:  "Delete a key.",
+		Run:   runDelete,
+	})
+	register(&Command{
+		Name:  "import",
+		Usage: "import <file.jsonl>",
+		Help:  `Apply one {"key": ..., "value": ...} object per line, {"key": ..., "op": "delete"} deletes. "-" reads stdin.`,
+		Run:   runImport,
+	})
+	register(&Command{
+		Name:  "repl-stats",
+		Usage: "repl-stats <worker-id>",
+		Help:  "Print latency of waiting for backups in each replication mode, the mode in use is marked with *, divergence found by anti-entropy, and how far each backup is behind.",
+		Run:   runReplStats,
+	})
+	register(&Command{
+		Name:  "leave-read-only",
+		Usage: "leave-read-only <worker-id>",
+		Help:  "Let a primary promoted with too few backups in sync accept writes again without waiting for them.",
+		Run:   runLeaveReadOnly,
+	})
+	register(&Command{
+		Name:  "verify",
+		Usage: "verify --worker <worker-id> [--wait <duration>]",
+		Help:  "Compare every backup of a worker with its primary at the current version of the primary, and print keys missing, extra or mismatched. Backups are given --wait (5s by default) to catch up. Fails if any differs.",
+		Run:   runVerify,
+	})
+	register(&Command{
+		Name:  "switchover",
+		Usage: "switchover --worker <worker-id> --to <node> [--timeout <duration>]",
+		Help:  "Hand the primary role of a worker over to one of its backups, by node name or address. Writes are paused until it catches up, for --timeout (5s by default) at most, and the old primary becomes a backup. Prints how long writes were unavailable.",
+		Run:   runSwitchover,
+	})
+}
+
+// Usage of all commands, sorted by name.
+func commandsHelp() string {
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, n := range names {
+		c := commands[n]
+		b.WriteString(fmt.Sprintf("* %s\n    %s\n", c.Usage, c.Help))
+	}
+	return b.String()
+}
+
+// Run command line `args`, whose first element is the command name.
+func Dispatch(ctx context.Context, env *Env, args []string) error {
+	if len(args) == 0 {
+		return usageError{"<command> [args...]"}
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		return usageError{fmt.Sprintf("unknown command %q, available commands:\n%s", args[0], commandsHelp())}
+	}
+	return c.Run(ctx, env, args[1:])
+}
+
+// Map the error of a command to the exit code of the process.
+func ExitCode(err error) int {
+	var ue usageError
+	if errors.As(err, &ue) {
+		return EXIT_USAGE
+	}
+	return int(client.StatusOf(err))
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs
+}
+
+func runGet(ctx context.Context, env *Env, args []string) error {
+	if len(args) != 1 {
+		return usageError{commands["get"].Usage}
+	}
+	kv, err := env.Client()
+	if err != nil {
+		return err
+	}
+	value, err := kv.Get(ctx, args[0])
+	env.Printer.Print(NewResult("get", args[0], value, err))
+	return err
+}
+
+func runPut(ctx context.Context, env *Env, args []string) error {
+	usage := usageError{commands["put"].Usage}
+	fs := newFlagSet("put")
+	valueFile := fs.String("value-file", "", "read value from file")
+	args, err := parseInterleaved(fs, args)
+	if err != nil {
+		return usage
+	}
+	var key, value string
+	if *valueFile != "" {
+		if len(args) != 1 {
+			return usage
+		}
+		var bin []byte
+		if *valueFile == "-" {
+			bin, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			bin, err = ioutil.ReadFile(*valueFile)
+		}
+		if err != nil {
+			env.Printer.Print(NewResult("put", args[0], "", err))
+			return err
+		}
+		key, value = args[0], string(bin)
+	} else {
+		if len(args) != 2 {
+			return usage
+		}
+		key, value = args[0], args[1]
+	}
+	kv, err := env.Client()
+	if err != nil {
+		return err
+	}
+	err = kv.Put(ctx, key, value)
+	env.Printer.Print(NewResult("put", key, "", err))
+	return err
+}
+
+func runDelete(ctx context.Context, env *Env, args []string) error {
+	if len(args) != 1 {
+		return usageError{commands["delete"].Usage}
+	}
+	kv, err := env.Client()
+	if err != nil {
+		return err
+	}
+	err = kv.Delete(ctx, args[0])
+	env.Printer.Print(NewResult("delete", args[0], "", err))
+	return err
+}
+
+// One line of an import file
+type importEntry struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+	Op    string  `json:"op"`
+}
+
+func runImport(ctx context.Context, env *Env, args []string) error {
+	if len(args) != 1 {
+		return usageError{commands["import"].Usage}
+	}
+	var in io.Reader
+	if args[0] == "-" {
+		in = os.Stdin
+	} else {
+		f, err := os.Open(args[0])
+		if err != nil {
+			env.Printer.Print(NewResult("import", args[0], "", err))
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	kv, err := env.Client()
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(in)
+	// values can be large
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var lastErr error
+	line, succeeded, failed := 0, 0, 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var ent importEntry
+		if err := json.Unmarshal(scanner.Bytes(), &ent); err != nil {
+			err = fmt.Errorf("line %d: %w", line, err)
+			env.Printer.Print(NewResult("import", args[0], "", err))
+			return err
+		}
+		switch {
+		case ent.Op == "delete":
+			err = kv.Delete(ctx, ent.Key)
+		case (ent.Op == "" || ent.Op == "put") && ent.Value != nil:
+			err = kv.Put(ctx, ent.Key, *ent.Value)
+		default:
+			err = fmt.Errorf("line %d: invalid entry", line)
+			env.Printer.Print(NewResult("import", args[0], "", err))
+			return err
+		}
+		if err != nil {
+			// report failures one by one, but keep going
+			env.Printer.Print(NewResult("import", ent.Key, "", fmt.Errorf("line %d: %w", line, err)))
+			lastErr = err
+			failed++
+		} else {
+			succeeded++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		env.Printer.Print(NewResult("import", args[0], "", err))
+		return err
+	}
+	summary := fmt.Sprintf("%d succeeded, %d failed", succeeded, failed)
+	env.Printer.Print(NewResult("import", args[0], summary, lastErr))
+	return lastErr
+}
+
+func runReplStats(ctx context.Context, env *Env, args []string) error {
+	if len(args) != 1 {
+		return usageError{commands["repl-stats"].Usage}
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return usageError{commands["repl-stats"].Usage}
+	}
+	kv, err := env.Client()
+	if err != nil {
+		return err
+	}
+	resp, err := kv.ReplicationStats(ctx, common.WorkerId(id))
+	if err != nil {
+		env.Printer.Print(NewResult("repl-stats", args[0], "", err))
+		return err
+	}
+	var results []Result
+	for _, h := range resp.Histograms {
+		mode := h.Mode
+		if mode == resp.Mode {
+			mode += "*"
+		}
+		results = append(results, NewResult("repl-stats", mode, summarizeHistogram(h), nil))
+	}
+	results = append(results, NewResult("repl-stats", "anti-entropy", fmt.Sprintf("rounds=%d diverged=%d repaired=%d",
+		resp.AntiEntropyRounds, resp.DivergedRanges, resp.RepairedKeys), nil))
+	if resp.ReadOnly {
+		results = append(results, NewResult("repl-stats", "read-only", "true", nil))
+	}
+	for _, b := range resp.Backups {
+		summary := fmt.Sprintf("version=%d lag=%d/%v ejected=%t ejections=%d",
+			b.Version, b.LagVersions, time.Duration(b.LagMicros)*time.Microsecond, b.Ejected, b.Ejections)
+		if b.Learner {
+			summary += " learner"
+		}
+		results = append(results, NewResult("repl-stats", b.Name, summary, nil))
+	}
+	env.Printer.Print(results...)
+	return nil
+}
+
+// Summarize a latency histogram as count, mean and upper bounds of p50 & p99.
+func summarizeHistogram(h *pb.LatencyHistogram) string {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total == 0 {
+		return "count=0"
+	}
+	quantile := func(q float64) string {
+		var seen uint64
+		for i, c := range h.Counts {
+			seen += c
+			if float64(seen) >= q*float64(total) {
+				if i < len(h.Bounds) {
+					return "<=" + (time.Duration(h.Bounds[i]) * time.Microsecond).String()
+				}
+				break
+			}
+		}
+		return ">" + (time.Duration(h.Bounds[len(h.Bounds)-1]) * time.Microsecond).String()
+	}
+	mean := time.Duration(h.SumMicros/total) * time.Microsecond
+	return fmt.Sprintf("count=%d mean=%s p50%s p99%s", total, mean, quantile(0.5), quantile(0.99))
+}
+
+func runLeaveReadOnly(ctx context.Context, env *Env, args []string) error {
+	if len(args) != 1 {
+		return usageError{commands["leave-read-only"].Usage}
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return usageError{commands["leave-read-only"].Usage}
+	}
+	kv, err := env.Client()
+	if err != nil {
+		return err
+	}
+	resp, err := kv.LeaveReadOnly(ctx, common.WorkerId(id))
+	if err != nil {
+		env.Printer.Print(NewResult("leave-read-only", args[0], "", err))
+		return err
+	}
+	env.Printer.Print(NewResult("leave-read-only", args[0],
+		fmt.Sprintf("synced=%d required=%d", resp.SyncedBackups, resp.RequiredBackups), nil))
+	return nil
+}
+
+func runVerify(ctx context.Context, env *Env, args []string) error {
+	fs := newFlagSet("verify")
+	id := fs.Int("worker", -1, "")
+	wait := fs.Duration("wait", 5*time.Second, "")
+	rest, err := parseInterleaved(fs, args)
+	if err != nil || len(rest) != 0 || *id < 0 {
+		return usageError{commands["verify"].Usage}
+	}
+	kv, err := env.Client()
+	if err != nil {
+		return err
+	}
+	report, err := kv.Verify(ctx, common.WorkerId(*id), *wait)
+	if err != nil {
+		env.Printer.Print(NewResult("verify", strconv.Itoa(*id), "", err))
+		return err
+	}
+	results := []Result{NewResult("verify", "version", strconv.FormatUint(report.Version, 10), nil)}
+	for _, r := range report.Replicas {
+		role := "backup"
+		if r.Primary {
+			role = "primary"
+		}
+		results = append(results, NewResult("verify", r.Addr, fmt.Sprintf("%s version=%d skew=%d",
+			role, r.Version, int64(r.Version)-int64(report.Version)), nil))
+	}
+	counts := make(map[string]int)
+	for _, d := range report.Diffs {
+		counts[d.Kind]++
+		results = append(results, NewResult("verify", d.Key, fmt.Sprintf("slot=%d replica=%s %s expected=%q@%d actual=%q@%d",
+			d.Slot, d.Replica, d.Kind, d.Expected, d.ExpectedVersion, d.Actual, d.ActualVersion), nil))
+	}
+	results = append(results, NewResult("verify", "summary", fmt.Sprintf("keys=%d skipped=%d missing=%d extra=%d value=%d version=%d",
+		report.Keys, report.Skipped, counts[client.DIFF_MISSING], counts[client.DIFF_EXTRA],
+		counts[client.DIFF_VALUE], counts[client.DIFF_VERSION]), nil))
+	env.Printer.Print(results...)
+	if len(report.Diffs) > 0 {
+		return fmt.Errorf("%d differences found among replicas of worker %d", len(report.Diffs), *id)
+	}
+	return nil
+}
+
+func runSwitchover(ctx context.Context, env *Env, args []string) error {
+	fs := newFlagSet("switchover")
+	id := fs.Int("worker", -1, "")
+	to := fs.String("to", "", "")
+	timeout := fs.Duration("timeout", 5*time.Second, "")
+	rest, err := parseInterleaved(fs, args)
+	if err != nil || len(rest) != 0 || *id < 0 || *to == "" {
+		return usageError{commands["switchover"].Usage}
+	}
+	kv, err := env.Client()
+	if err != nil {
+		return err
+	}
+	resp, err := kv.Switchover(ctx, common.WorkerId(*id), *to, *timeout)
+	if err != nil {
+		env.Printer.Print(NewResult("switchover", strconv.Itoa(*id), "", err))
+		return err
+	}
+	env.Printer.Print(NewResult("switchover", strconv.Itoa(*id), fmt.Sprintf("primary=%s version=%d paused=%v",
+		resp.Primary, resp.Version, time.Duration(resp.PausedMicros)*time.Microsecond), nil))
+	return nil
+}
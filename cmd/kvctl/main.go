@@ -0,0 +1,55 @@
+// Command line client for the distributed KV store
+// Run `kvctl <command> [args...]` for a single command, or `kvctl` alone for a REPL.
+// The exit code is 0 on success, the pb.Status value of the failure otherwise
+// (1 for ENOENT, 2 for ENOSERVER...), and 64 for malformed command lines.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// configurations
+var (
+	serverAddrs = flag.String("addr", "localhost:7899", "Addresses of the masters, separated by comma")
+	output      = flag.String("output", OUTPUT_TABLE, "Output format, json or table")
+	zkServers   = flag.String("zk-servers", "", "Zookeeper servers separated by whitespace, workers are looked up there instead of asking masters if set")
+)
+
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(out, "Usage: %s [flags] [<command> [args...]]\nStarts a REPL if no command is given.\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	_, _ = fmt.Fprintf(out, "\nCommands:\n%s", commandsHelp())
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *output != OUTPUT_TABLE && *output != OUTPUT_JSON {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid output format %q\n", *output)
+		os.Exit(EXIT_USAGE)
+	}
+	env := &Env{
+		Masters:   strings.Split(*serverAddrs, ","),
+		ZkServers: strings.Fields(*zkServers),
+		Printer:   Printer{Format: *output, Out: os.Stdout},
+	}
+	ctx := context.Background()
+	if flag.NArg() == 0 {
+		RunREPL(ctx, env)
+		env.Close()
+		return
+	}
+	err := Dispatch(ctx, env, flag.Args())
+	env.Close()
+	if err != nil {
+		if _, ok := err.(usageError); ok {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(ExitCode(err))
+	}
+}
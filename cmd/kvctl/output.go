@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/eyeKill/KV/client"
+)
+
+const (
+	OUTPUT_TABLE = "table"
+	OUTPUT_JSON  = "json"
+)
+
+// One row of command output.
+type Result struct {
+	Op     string `json:"op"`
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func NewResult(op string, key string, value string, err error) Result {
+	r := Result{
+		Op:     op,
+		Key:    key,
+		Value:  value,
+		Status: client.StatusOf(err).String(),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Print results in the chosen format.
+type Printer struct {
+	Format string
+	Out    io.Writer
+}
+
+func (p Printer) Print(results ...Result) {
+	switch p.Format {
+	case OUTPUT_JSON:
+		enc := json.NewEncoder(p.Out)
+		for _, r := range results {
+			_ = enc.Encode(r)
+		}
+	default:
+		w := tabwriter.NewWriter(p.Out, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "OP\tKEY\tVALUE\tSTATUS")
+		for _, r := range results {
+			status := r.Status
+			if r.Error != "" {
+				status = r.Error
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Op, r.Key, r.Value, status)
+		}
+		_ = w.Flush()
+	}
+}
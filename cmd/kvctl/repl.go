@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const HISTORY_FILENAME = ".kvctl_history"
+
+const REPL_HELP_STRING = `Welcome to NaiveKV.
+Arguments can be quoted, e.g. put greeting "hello world".
+Usages:
+%s* history
+    Print command history, !<n> runs the n-th command again.
+* exit
+* quit
+`
+
+// Command history, persisted to the user's home directory when possible.
+type History struct {
+	entries []string
+	file    *os.File
+}
+
+func OpenHistory() *History {
+	h := &History{}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return h
+	}
+	p := filepath.Join(home, HISTORY_FILENAME)
+	if f, err := os.Open(p); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			h.entries = append(h.entries, scanner.Text())
+		}
+		_ = f.Close()
+	}
+	if f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err == nil {
+		h.file = f
+	}
+	return h
+}
+
+func (h *History) Add(line string) {
+	h.entries = append(h.entries, line)
+	if h.file != nil {
+		_, _ = h.file.WriteString(line + "\n")
+	}
+}
+
+// Get the n-th entry, starting from 1.
+func (h *History) Get(n int) (string, bool) {
+	if n < 1 || n > len(h.entries) {
+		return "", false
+	}
+	return h.entries[n-1], true
+}
+
+func (h *History) Print(out io.Writer) {
+	for i, e := range h.entries {
+		_, _ = fmt.Fprintf(out, "%5d  %s\n", i+1, e)
+	}
+}
+
+func (h *History) Close() {
+	if h.file != nil {
+		_ = h.file.Close()
+	}
+}
+
+// Read-eval-print loop on stdin.
+func RunREPL(ctx context.Context, env *Env) {
+	history := OpenHistory()
+	defer history.Close()
+	// bufio.Scanner split tokens by '\n' by default
+	scanner := bufio.NewScanner(os.Stdin)
+	fmt.Print(">>> ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "!") {
+			n, err := strconv.Atoi(line[1:])
+			prev, ok := history.Get(n)
+			if err != nil || !ok {
+				fmt.Printf("No such history entry %q\n", line)
+				fmt.Print(">>> ")
+				continue
+			}
+			fmt.Println(prev)
+			line = prev
+		}
+		args, err := SplitArgs(line)
+		if err != nil {
+			fmt.Printf("Invalid input: %v\n", err)
+			fmt.Print(">>> ")
+			continue
+		}
+		if len(args) == 0 {
+			fmt.Print(">>> ")
+			continue
+		}
+		history.Add(line)
+		switch args[0] {
+		case "help":
+			fmt.Printf(REPL_HELP_STRING, commandsHelp())
+		case "history":
+			history.Print(os.Stdout)
+		case "exit", "quit":
+			fmt.Println("Goodbye")
+			return
+		default:
+			if err := Dispatch(ctx, env, args); err != nil {
+				if _, ok := err.(usageError); ok {
+					fmt.Println(err)
+				}
+			}
+		}
+		fmt.Print(">>> ")
+	}
+}
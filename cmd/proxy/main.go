@@ -0,0 +1,57 @@
+// Routing proxy for the distributed KV store
+// It serves the KVWorker API and forwards every request to the worker owning the key,
+// so that clients do not have to track the slot table themselves.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/proxy"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+)
+
+var (
+	port      = flag.Int("port", 7890, "The proxy port")
+	zkServers = flag.String("zk-servers", "localhost:2181", "Zookeeper server cluster, separated by space")
+)
+
+func main() {
+	log := common.Log()
+	flag.Parse()
+
+	conn, err := common.ConnectToZk(strings.Fields(*zkServers))
+	if err != nil {
+		log.Panic("Failed to connect too zookeeper.", zap.Error(err))
+	}
+	defer conn.Close()
+	log.Info("Connected to zookeeper.", zap.String("server", conn.Server()))
+
+	resolver, err := client.NewZkResolver(conn)
+	if err != nil {
+		log.Panic("Failed to read slot table.", zap.Error(err))
+	}
+	defer resolver.Close()
+	opts := client.DefaultOptions()
+	opts.Resolver = resolver
+	kv, err := client.New(opts)
+	if err != nil {
+		log.Panic("Failed to create client.", zap.Error(err))
+	}
+	defer kv.Close()
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", *port))
+	if err != nil {
+		log.Panic("failed to listen to port.", zap.Int("port", *port), zap.Error(err))
+	}
+	server := common.NewGrpcServer()
+	pb.RegisterKVWorkerServer(server, proxy.NewServer(kv))
+	log.Info("Serving proxy.", zap.Int("port", *port))
+	if err := server.Serve(listener); err != nil {
+		log.Error("gRPC server raised error.", zap.Error(err))
+	}
+}
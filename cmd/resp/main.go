@@ -0,0 +1,41 @@
+// Redis protocol front-end for the distributed KV store
+// It lets applications speaking RESP2 use the KV store without being rewritten.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	"github.com/eyeKill/KV/resp"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+)
+
+var (
+	masterAddrs = flag.String("addr", "localhost:7899", "Addresses of the masters, separated by comma")
+	hostname    = flag.String("hostname", "localhost", "Hostname announced to cluster-aware clients")
+	port        = flag.Int("port", 6379, "The RESP port")
+)
+
+func main() {
+	log := common.Log()
+	flag.Parse()
+
+	kv, err := client.New(client.DefaultOptions(strings.Split(*masterAddrs, ",")...))
+	if err != nil {
+		log.Panic("Failed to create client.", zap.Error(err))
+	}
+	defer kv.Close()
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", *port))
+	if err != nil {
+		log.Panic("failed to listen to port.", zap.Int("port", *port), zap.Error(err))
+	}
+	server := resp.NewServer(kv, common.Node{Hostname: *hostname, Port: uint16(*port)})
+	log.Info("Serving RESP.", zap.Int("port", *port))
+	if err := server.Serve(listener); err != nil {
+		log.Error("RESP server raised error.", zap.Error(err))
+	}
+}
@@ -6,8 +6,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/eyeKill/KV/cdc"
 	"github.com/eyeKill/KV/common"
 	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/raft"
 	"github.com/eyeKill/KV/worker"
 	"github.com/samuel/go-zookeeper/zk"
 	"go.uber.org/zap"
@@ -15,19 +17,34 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 var (
 	hostname  = flag.String("hostname", "localhost", "The server's hostname")
 	port      = flag.Int("port", 7900, "The server port")
-	mode      = flag.String("mode", worker.MODE_PRIMARY, "The server's mode, primary or backup")
+	mode      = flag.String("mode", worker.MODE_PRIMARY, "The server's mode, primary, backup or learner")
 	filePath  = flag.String("path", ".", "Path for persistent log and slot file.")
 	id        = flag.Int("id", -1, "Worker id, new worker if not set.")
 	weight    = flag.Float64("weight", 10.0, "Weight for new worker.")
+	mcPort    = flag.Int("memcache-port", 0, "Port serving memcached text protocol, disabled if 0")
+	cdcDir    = flag.String("cdc-dir", "", "Directory for change data capture files, disabled if empty")
+	cdcSize   = flag.Int64("cdc-file-size", cdc.DEFAULT_MAX_FILE_SIZE, "Size limit of a single change data capture file")
+	cdcFiles  = flag.Int("cdc-files", 0, "Number of change data capture files kept, all of them if 0")
+	syncBatch = flag.Int("sync-batch", worker.DEFAULT_SYNC_BATCH_SIZE, "Max number of entries replicated in one batch")
+	syncWin   = flag.Int("sync-window", worker.DEFAULT_SYNC_WINDOW, "Max number of batches waiting for ack of each backup")
+	aeEvery   = flag.Duration("anti-entropy-interval", worker.DEFAULT_ANTI_ENTROPY_INTERVAL,
+		"Interval of comparing Merkle trees with backups, disabled if 0")
 	zkServers = strings.Fields(*flag.String("zk-servers", "localhost:2181",
 		"Zookeeper server cluster, separated by space"))
+	raftId    = flag.Uint64("raft-id", 0, "Id of this replica in the raft group of the worker, primary/backup replication if 0")
+	raftPeers = flag.String("raft-peers", "",
+		"Members of a new raft group as id=host:port, separated by space, empty when joining an existing one")
+	raftTick = flag.Duration("raft-tick", 100*time.Millisecond, "Interval of raft ticks")
 )
 
 var (
@@ -50,6 +67,10 @@ func setupCloseHandler() {
 			close(wk.WatchWorkerStopChan)
 			close(wk.WatchMigrationStopChan)
 			close(wk.SyncStopChan)
+			close(wk.WatchTableStopChan)
+			close(wk.CDCStopChan)
+			close(wk.LeaseStopChan)
+			close(wk.WatchConfigStopChan)
 		}
 		if server != nil {
 			log.Info("Gracefully stopping gRPC server...")
@@ -78,8 +99,8 @@ func main() {
 
 	// initialize workerServer server
 	if *id == -1 {
-		if *mode == worker.MODE_BACKUP {
-			log.Panic("You have to specify an ID when using backup mode!")
+		if *mode == worker.MODE_BACKUP || *mode == worker.MODE_LEARNER {
+			log.Panic("You have to specify an ID when using backup or learner mode!")
 		}
 		log.Info("Getting new id from zookeeper...")
 		// get new id
@@ -103,15 +124,57 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+	} else if *mode == worker.MODE_LEARNER {
+		workerServer, err = worker.NewLearnerServer(*hostname, uint16(*port), *filePath, common.WorkerId(*id))
+		if err != nil {
+			panic(err)
+		}
 	}
+	workerServer.SyncBatchSize = *syncBatch
+	workerServer.SyncWindow = *syncWin
+	workerServer.AntiEntropyInterval = *aeEvery
 	if err := workerServer.RegisterToZk(conn, float32(*weight)); err != nil {
 		log.Panic("Failed to register to zookeeper.", zap.Error(err))
 	}
+	// the mode registered is followed by the group's leadership from now on
+	var raftNode *raft.Node
+	if *raftId != 0 {
+		raftNode, err = startRaft(workerServer)
+		if err != nil {
+			log.Panic("Failed to start raft group member.", zap.Error(err))
+		}
+		defer raftNode.Stop()
+	}
 
 	// start watching worker metadata changes, and do backup broadcasting
 	go workerServer.Watch()
 	go workerServer.WatchMigration()
 	go workerServer.DoSync()
+	go workerServer.WatchSlotTable()
+	go workerServer.KeepLease()
+	go workerServer.WatchConfig()
+	go workerServer.WatchEpoch()
+
+	if *cdcDir != "" {
+		w, err := cdc.NewWriter(*cdcDir, *cdcSize, *cdcFiles)
+		if err != nil {
+			log.Panic("Failed to open change data capture files.", zap.String("dir", *cdcDir), zap.Error(err))
+		}
+		defer w.Close()
+		go workerServer.ExportCDC(w)
+	}
+
+	if *mcPort != 0 {
+		mcListener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", *mcPort))
+		if err != nil {
+			log.Panic("failed to listen to port.", zap.Int("port", *mcPort), zap.Error(err))
+		}
+		go func() {
+			if err := workerServer.ServeMemcache(mcListener); err != nil {
+				log.Error("Memcache server raised error.", zap.Error(err))
+			}
+		}()
+	}
 
 	// open tcp socket
 	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", *port))
@@ -123,8 +186,38 @@ func main() {
 	pb.RegisterKVWorkerServer(server, workerServer)
 	pb.RegisterKVBackupServer(server, workerServer)
 	pb.RegisterKVWorkerInternalServer(server, workerServer)
+	if raftNode != nil {
+		pb.RegisterKVRaftServer(server, raft.NewService(raftNode))
+		go raftNode.Run(*raftTick)
+	}
 	defer server.GracefulStop()
 	if err := server.Serve(listener); err != nil {
 		log.Error("gRPC server raised error.", zap.Error(err))
 	}
 }
+
+// Join the raft group of the worker, persisting its log next to the store.
+func startRaft(workerServer *worker.WorkerServer) (*raft.Node, error) {
+	peers := make(map[raft.NodeId]string)
+	for _, p := range strings.Fields(*raftPeers) {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid raft peer %s", p)
+		}
+		id, err := strconv.ParseUint(kv[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid raft peer %s", p)
+		}
+		peers[raft.NodeId(id)] = kv[1]
+	}
+	storage, err := raft.NewFileStorage(path.Join(*filePath, "raft"))
+	if err != nil {
+		return nil, err
+	}
+	return workerServer.StartRaft(raft.Config{
+		Id:      raft.NodeId(*raftId),
+		Peers:   peers,
+		Storage: storage,
+		Seed:    time.Now().UnixNano(),
+	}, raft.NewGrpcTransport())
+}
@@ -21,7 +21,9 @@ const (
 	ZK_MASTER_NAME         = "master"
 	ZK_PRIMARY_WORKER_NAME = "primary"
 	ZK_BACKUP_WORKER_NAME  = "backup"
+	ZK_LEARNER_WORKER_NAME = "learner"
 	ZK_WORKER_CONFIG_NAME  = "config"
+	ZK_WORKER_EPOCH_NAME   = "epoch"
 	ZK_COMPLETE_SEM_NAME   = "completeSem"
 )
 
@@ -71,14 +73,40 @@ type Worker struct {
 	Weight  float32
 	Watcher <-chan zk.Event
 	// here worker & backup nodes are represented by corresponding znode names.
-	Primaries  map[string]*WorkerNode
-	Backups    map[string]*WorkerNode
+	Primaries map[string]*WorkerNode
+	Backups   map[string]*WorkerNode
+	// replicas synced like backups, but never acknowledging writes or taking part in elections
+	Learners   map[string]*WorkerNode
 	NumBackups int
 }
 
+// How primaries replicate writes to backups before acknowledging them.
+const (
+	// don't wait for backups, unless they fall too far behind
+	REPLICATION_ASYNC = "async"
+	// wait for a number of backups
+	REPLICATION_SEMI_SYNC = "semi-sync"
+	// wait for every backup
+	REPLICATION_SYNC = "sync"
+)
+
 type WorkerConfig struct {
 	Weight     float32
 	NumBackups int
+	// one of REPLICATION_*, semi-sync if empty
+	Replication string `json:",omitempty"`
+	// number of backups to wait for in semi-sync mode, at least one
+	Acks int `json:",omitempty"`
+	// number of versions backups may fall behind in async mode
+	MaxLag uint64 `json:",omitempty"`
+	// backups more than EjectLag versions or EjectLagMs milliseconds behind are not waited for in semi-sync mode,
+	// until they are no more than RejoinLag versions behind again. Never ejected if both are 0.
+	EjectLag   uint64 `json:",omitempty"`
+	EjectLagMs uint64 `json:",omitempty"`
+	RejoinLag  uint64 `json:",omitempty"`
+	// backups that have to be in sync before a primary promoted with fewer backups than the worker had
+	// accepts writes, if unset as many as it had besides the one promoted
+	ReadOnlyQuorum int `json:",omitempty"`
 }
 
 // get a worker instance from zookeeper
@@ -89,13 +117,15 @@ func GetAndWatchWorker(conn *zk.Conn, id WorkerId) (Worker, error) {
 		return Worker{}, err
 	}
 	worker := Worker{
-		Id:         id,
-		Watcher:    eventChan,
-		Primaries:  make(map[string]*WorkerNode),
-		Backups:	make(map[string]*WorkerNode),
+		Id:        id,
+		Watcher:   eventChan,
+		Primaries: make(map[string]*WorkerNode),
+		Backups:   make(map[string]*WorkerNode),
+		Learners:  make(map[string]*WorkerNode),
 	}
 	for _, c := range children {
-		if strings.Contains(c, ZK_PRIMARY_WORKER_NAME) || strings.Contains(c, ZK_BACKUP_WORKER_NAME) {
+		if strings.Contains(c, ZK_PRIMARY_WORKER_NAME) || strings.Contains(c, ZK_BACKUP_WORKER_NAME) ||
+			strings.Contains(c, ZK_LEARNER_WORKER_NAME) {
 			// worker node
 			var node WorkerNode
 			if err := ZkGet(conn, path.Join(p, c), &node); err != nil {
@@ -103,8 +133,10 @@ func GetAndWatchWorker(conn *zk.Conn, id WorkerId) (Worker, error) {
 			}
 			if strings.Contains(c, ZK_PRIMARY_WORKER_NAME) {
 				worker.Primaries[c] = &node
-			} else {
+			} else if strings.Contains(c, ZK_BACKUP_WORKER_NAME) {
 				worker.Backups[c] = &node
+			} else {
+				worker.Learners[c] = &node
 			}
 		} else if c == ZK_WORKER_CONFIG_NAME {
 			var config WorkerConfig
@@ -113,6 +145,9 @@ func GetAndWatchWorker(conn *zk.Conn, id WorkerId) (Worker, error) {
 			}
 			worker.Weight = config.Weight
 			worker.NumBackups = config.NumBackups
+		} else if c == ZK_WORKER_EPOCH_NAME {
+			// fencing epoch of primaries, only workers care
+			continue
 		} else {
 			Log().Warn("Cannot determine node, skipping", zap.String("name", c))
 		}
@@ -1,23 +1,76 @@
 package common
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"github.com/samuel/go-zookeeper/zk"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"net"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Session timeout requested from zookeeper. Ephemeral nodes of a session live at least this long
+// after zookeeper last hears from it.
+const ZK_SESSION_TIMEOUT = 2000 * time.Millisecond
+
+// session timeouts negotiated by connections made with ConnectToZk, in nanoseconds
+var sessionTimeouts sync.Map
+
 func ConnectToZk(servers []string) (*zk.Conn, error) {
-	conn, _, err := zk.Connect(servers, 2000*time.Millisecond)
+	timeout := atomic.NewInt64(int64(ZK_SESSION_TIMEOUT))
+	dialer := func(network, address string, t time.Duration) (net.Conn, error) {
+		conn, err := net.DialTimeout(network, address, t)
+		if err != nil {
+			return nil, err
+		}
+		return &sessionConn{Conn: conn, timeout: timeout}, nil
+	}
+	conn, _, err := zk.Connect(servers, ZK_SESSION_TIMEOUT, zk.WithDialer(dialer))
 	if err == nil {
 		conn.SetLogger(&ZkLoggerAdapter{})
+		sessionTimeouts.Store(conn, timeout)
 	}
 	return conn, err
 }
 
+// Get the session timeout zookeeper has negotiated for `conn`, which could differ from the one requested
+// as it is bound by the server's min & max session timeout. ZK_SESSION_TIMEOUT until it is known.
+func SessionTimeout(conn *zk.Conn) time.Duration {
+	if t, ok := sessionTimeouts.Load(conn); ok {
+		return time.Duration(t.(*atomic.Int64).Load())
+	}
+	return ZK_SESSION_TIMEOUT
+}
+
+// Connection to a zookeeper server, picking up the session timeout from the connect response,
+// the first thing read from it: frame length, protocol version, then the timeout in milliseconds.
+type sessionConn struct {
+	net.Conn
+	head    []byte
+	timeout *atomic.Int64
+}
+
+func (c *sessionConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if len(c.head) < 12 {
+		rest := 12 - len(c.head)
+		if rest > n {
+			rest = n
+		}
+		c.head = append(c.head, b[:rest]...)
+		if len(c.head) == 12 {
+			ms := binary.BigEndian.Uint32(c.head[8:])
+			c.timeout.Store(int64(time.Duration(ms) * time.Millisecond))
+		}
+	}
+	return n, err
+}
+
 func EnsurePathRecursive(conn *zk.Conn, p string) error {
 	// ensure p layer by layer
 	dirs := strings.Split(p, "/")
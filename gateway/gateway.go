@@ -0,0 +1,258 @@
+// HTTP/JSON gateway for the KV store.
+// The gateway is stateless, it forwards every request through the client library,
+// so it routes by slot and retries just like any other client.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/zap"
+)
+
+const (
+	KEYS_PREFIX        = "/v1/keys/"
+	BATCH_PATH         = "/v1/batch"
+	SCAN_PATH          = "/v1/scan"
+	HEALTH_PATH        = "/healthz"
+	DEFAULT_SCAN_LIMIT = 100
+	MAX_SCAN_LIMIT     = 1000
+	MAX_VALUE_SIZE     = 64 * 1024 * 1024
+	HEALTH_TIMEOUT     = 2 * time.Second
+)
+
+// HTTP status code for each KV status
+var httpStatus = map[pb.Status]int{
+	pb.Status_OK:          http.StatusOK,
+	pb.Status_ENOENT:      http.StatusNotFound,
+	pb.Status_ENOSERVER:   http.StatusServiceUnavailable,
+	pb.Status_EFAILED:     http.StatusInternalServerError,
+	pb.Status_EINVSERVER:  http.StatusServiceUnavailable,
+	pb.Status_EINVWID:     http.StatusInternalServerError,
+	pb.Status_EINVVERSION: http.StatusServiceUnavailable,
+	pb.Status_EREADONLY:   http.StatusServiceUnavailable,
+}
+
+func HttpStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if code, ok := httpStatus[client.StatusOf(err)]; ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+type Server struct {
+	kv  *client.Client
+	mux *http.ServeMux
+}
+
+func NewServer(kv *client.Client) *Server {
+	s := &Server{kv: kv, mux: http.NewServeMux()}
+	s.mux.HandleFunc(KEYS_PREFIX, s.handleKey)
+	s.mux.HandleFunc(BATCH_PATH, s.handleBatch)
+	s.mux.HandleFunc(SCAN_PATH, s.handleScan)
+	s.mux.HandleFunc(HEALTH_PATH, s.handleHealth)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+type KeyResponse struct {
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newKeyResponse(key string, value string, err error) KeyResponse {
+	r := KeyResponse{Key: key, Value: value, Status: client.StatusOf(err).String()}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		common.Log().Warn("Failed to write response.", zap.Error(err))
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, KeyResponse{Status: pb.Status_EFAILED.String(), Error: msg})
+}
+
+// GET, PUT & DELETE /v1/keys/{key}, the request body of PUT is the raw value.
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), KEYS_PREFIX))
+	if err != nil || key == "" {
+		writeError(w, http.StatusBadRequest, "invalid key")
+		return
+	}
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		value, err := s.kv.Get(ctx, key)
+		writeJSON(w, HttpStatus(err), newKeyResponse(key, value, err))
+	case http.MethodPut:
+		bin, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_VALUE_SIZE))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		err = s.kv.Put(ctx, key, string(bin))
+		writeJSON(w, HttpStatus(err), newKeyResponse(key, "", err))
+	case http.MethodDelete:
+		err := s.kv.Delete(ctx, key)
+		writeJSON(w, HttpStatus(err), newKeyResponse(key, "", err))
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+type BatchOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+type BatchRequest struct {
+	Ops []BatchOp `json:"ops"`
+}
+
+type BatchResponse struct {
+	Results []KeyResponse `json:"results"`
+}
+
+// Run the ops of a batch, those on the same key one after another in the order given,
+// and those on different keys concurrently.
+func (s *Server) runBatch(ctx context.Context, ops []BatchOp) []KeyResponse {
+	var keys []string
+	byKey := make(map[string][]int)
+	for i, op := range ops {
+		if _, ok := byKey[op.Key]; !ok {
+			keys = append(keys, op.Key)
+		}
+		byKey[op.Key] = append(byKey[op.Key], i)
+	}
+	ret := make([]KeyResponse, len(ops))
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, client.BATCH_PARALLELISM)
+	for _, key := range keys {
+		idx := byKey[key]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			for _, i := range idx {
+				var value string
+				var err error
+				switch ops[i].Op {
+				case "get":
+					value, err = s.kv.Get(ctx, ops[i].Key)
+				case "put":
+					err = s.kv.Put(ctx, ops[i].Key, ops[i].Value)
+				case "delete":
+					err = s.kv.Delete(ctx, ops[i].Key)
+				}
+				ret[i] = newKeyResponse(ops[i].Key, value, err)
+			}
+			<-sem
+		}()
+	}
+	wg.Wait()
+	return ret
+}
+
+// POST /v1/batch, ops are "get", "put" or "delete" and each of them succeeds or fails on its own.
+// Ops on the same key are run in the order given, so a get after a put sees the value put,
+// while those on different keys are run concurrently, in no particular order. Nothing is atomic.
+// Results are in the same order as ops.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req BatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_VALUE_SIZE)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, op := range req.Ops {
+		if op.Op != "get" && op.Op != "put" && op.Op != "delete" {
+			writeError(w, http.StatusBadRequest, "invalid op "+strconv.Quote(op.Op))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, BatchResponse{Results: s.runBatch(r.Context(), req.Ops)})
+}
+
+type Pair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type ScanResponse struct {
+	Pairs []Pair `json:"pairs"`
+	// pass it as `start` to get the next page, empty if there is no more
+	Next string `json:"next"`
+}
+
+// GET /v1/scan?prefix=...&start=...&limit=...
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	limit := DEFAULT_SCAN_LIMIT
+	if l := q.Get("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 || v > MAX_SCAN_LIMIT {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = v
+	}
+	pairs, next, err := s.kv.Scan(r.Context(), q.Get("prefix"), q.Get("start"), limit)
+	if err != nil {
+		writeJSON(w, HttpStatus(err), newKeyResponse("", "", err))
+		return
+	}
+	resp := ScanResponse{Pairs: make([]Pair, len(pairs)), Next: next}
+	for i, p := range pairs {
+		resp.Pairs[i] = Pair{Key: p.Key, Value: p.Value}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GET /healthz, healthy when a master answers with the slot table.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), HEALTH_TIMEOUT)
+	defer cancel()
+	if err := s.kv.RefreshSlots(ctx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, KeyResponse{Status: pb.Status_ENOSERVER.String(), Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, KeyResponse{Status: pb.Status_OK.String()})
+}
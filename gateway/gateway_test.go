@@ -0,0 +1,113 @@
+package gateway_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/gateway"
+	"github.com/eyeKill/KV/localcluster"
+	"github.com/stretchr/testify/assert"
+)
+
+func setUp(t *testing.T) (*localcluster.Cluster, *httptest.Server) {
+	c, err := localcluster.Start(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := client.DefaultOptions(c.MasterAddr)
+	opts.BaseBackoff = time.Millisecond
+	kv, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, httptest.NewServer(gateway.NewServer(kv))
+}
+
+func do(t *testing.T, method string, url string, body string, dest interface{}) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	if dest != nil {
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(dest))
+	}
+	return resp.StatusCode
+}
+
+func TestGateway_Keys(t *testing.T) {
+	c, s := setUp(t)
+	defer c.Stop()
+	defer s.Close()
+	u := s.URL + "/v1/keys/" + url.PathEscape("a b/c")
+
+	var r gateway.KeyResponse
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodGet, u, "", &r))
+	assert.Equal(t, "ENOENT", r.Status)
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPut, u, "hello world", &r))
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, u, "", &r))
+	assert.Equal(t, "a b/c", r.Key)
+	assert.Equal(t, "hello world", r.Value)
+	assert.Equal(t, http.StatusOK, do(t, http.MethodDelete, u, "", &r))
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodDelete, u, "", &r))
+	assert.Equal(t, http.StatusMethodNotAllowed, do(t, http.MethodPost, u, "", &r))
+}
+
+func TestGateway_BatchAndScan(t *testing.T) {
+	c, s := setUp(t)
+	defer c.Stop()
+	defer s.Close()
+
+	var br gateway.BatchResponse
+	body := `{"ops": [
+		{"op": "put", "key": "p/1", "value": "1"},
+		{"op": "put", "key": "p/2", "value": "2"},
+		{"op": "put", "key": "p/3", "value": "3"},
+		{"op": "get", "key": "missing"}
+	]}`
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPost, s.URL+"/v1/batch", body, &br))
+	assert.Equal(t, 4, len(br.Results))
+	assert.Equal(t, "OK", br.Results[0].Status)
+	assert.Equal(t, "ENOENT", br.Results[3].Status)
+
+	// ops on the same key are run in order
+	body = `{"ops": [
+		{"op": "put", "key": "q", "value": "1"},
+		{"op": "get", "key": "q"},
+		{"op": "delete", "key": "q"},
+		{"op": "get", "key": "q"},
+		{"op": "put", "key": "q", "value": "2"},
+		{"op": "put", "key": "q", "value": "3"},
+		{"op": "get", "key": "q"}
+	]}`
+	br = gateway.BatchResponse{}
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPost, s.URL+"/v1/batch", body, &br))
+	assert.Equal(t, 7, len(br.Results))
+	assert.Equal(t, "1", br.Results[1].Value)
+	assert.Equal(t, "OK", br.Results[2].Status)
+	assert.Equal(t, "ENOENT", br.Results[3].Status)
+	assert.Equal(t, "3", br.Results[6].Value)
+
+	var sr gateway.ScanResponse
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, s.URL+"/v1/scan?prefix=p/&limit=2", "", &sr))
+	assert.Equal(t, []gateway.Pair{{Key: "p/1", Value: "1"}, {Key: "p/2", Value: "2"}}, sr.Pairs)
+	assert.Equal(t, "p/2", sr.Next)
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, s.URL+"/v1/scan?prefix=p/&limit=2&start=p/2", "", &sr))
+	assert.Equal(t, []gateway.Pair{{Key: "p/3", Value: "3"}}, sr.Pairs)
+	assert.Equal(t, "", sr.Next)
+	assert.Equal(t, http.StatusBadRequest, do(t, http.MethodGet, s.URL+"/v1/scan?limit=0", "", nil))
+}
+
+func TestGateway_Health(t *testing.T) {
+	c, s := setUp(t)
+	defer s.Close()
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, s.URL+"/healthz", "", nil))
+	c.Stop()
+	assert.Equal(t, http.StatusServiceUnavailable, do(t, http.MethodGet, s.URL+"/healthz", "", nil))
+}
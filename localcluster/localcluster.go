@@ -0,0 +1,380 @@
+// In-process cluster for tests and local experiments.
+// It runs a master serving a static slot table and a set of primary workers inside the current process,
+// without zookeeper. Everything listens on random loopback ports.
+package localcluster
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/raft"
+	"github.com/eyeKill/KV/worker"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+)
+
+// A master serving a static slot table.
+type Master struct {
+	pb.UnimplementedKVMasterServer
+	lock     sync.RWMutex
+	version  uint32
+	slots    common.HashSlotRing
+	addrs    map[common.WorkerId]*net.TCPAddr
+	backups  map[common.WorkerId][]*net.TCPAddr
+	learners map[common.WorkerId][]*net.TCPAddr
+	// replicas of workers replicating through Raft groups, whose leaders serve as primaries
+	groups map[common.WorkerId][]*worker.WorkerServer
+}
+
+func (m *Master) GetSlots(_ context.Context, _ *empty.Empty) (*pb.GetSlotsResponse, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	resp := pb.GetSlotsResponse{Version: m.version}
+	resp.SlotTable = make([]*pb.WorkerId, len(m.slots))
+	for i, id := range m.slots {
+		resp.SlotTable[i] = &pb.WorkerId{Id: uint32(id)}
+	}
+	return &resp, nil
+}
+
+func (m *Master) GetWorkerById(_ context.Context, in *pb.WorkerId) (*pb.GetWorkerResponse, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if replicas, ok := m.groups[common.WorkerId(in.Id)]; ok {
+		return groupResponse(replicas), nil
+	}
+	addr, ok := m.addrs[common.WorkerId(in.Id)]
+	if !ok {
+		return &pb.GetWorkerResponse{Status: pb.Status_EINVWID}, nil
+	}
+	resp := pb.GetWorkerResponse{
+		Status: pb.Status_OK,
+		Worker: &pb.Worker{Hostname: addr.IP.String(), Port: int32(addr.Port)},
+	}
+	for _, b := range m.backups[common.WorkerId(in.Id)] {
+		resp.Backups = append(resp.Backups, &pb.Worker{Hostname: b.IP.String(), Port: int32(b.Port)})
+	}
+	for _, l := range m.learners[common.WorkerId(in.Id)] {
+		resp.Learners = append(resp.Learners, &pb.Worker{Hostname: l.IP.String(), Port: int32(l.Port)})
+	}
+	return &resp, nil
+}
+
+// Point clients to the leader of a Raft group, and to the rest of its running replicas as backups.
+func groupResponse(replicas []*worker.WorkerServer) *pb.GetWorkerResponse {
+	resp := pb.GetWorkerResponse{Status: pb.Status_ENOSERVER}
+	for _, r := range replicas {
+		node := r.RaftNode()
+		select {
+		case <-node.Stopped():
+			continue
+		default:
+		}
+		w := &pb.Worker{Hostname: r.Hostname, Port: int32(r.Port)}
+		if node.Status().State == raft.STATE_LEADER && resp.Worker == nil {
+			resp.Status, resp.Worker = pb.Status_OK, w
+		} else {
+			resp.Backups = append(resp.Backups, w)
+		}
+	}
+	return &resp
+}
+
+type Cluster struct {
+	Master     *Master
+	MasterAddr string
+	Workers    []*worker.WorkerServer
+	// backups of each worker, attached to their primaries directly
+	Backups [][]*worker.WorkerServer
+	// learners of each worker, attached the same way
+	Learners [][]*worker.WorkerServer
+	// replicas of worker 1 in a Raft group, exchanging messages through Network
+	Replicas []*worker.WorkerServer
+	Network  *raft.Network
+	servers  []*grpc.Server
+	// servers of Replicas, in the same order
+	replicaServers []*grpc.Server
+	stopCh         chan struct{}
+	dir            string
+}
+
+func listen() (net.Listener, *net.TCPAddr, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, l.Addr().(*net.TCPAddr), nil
+}
+
+// Start a master and `n` primary workers with id 1 to n. Slots are assigned round-robin.
+func Start(n int) (*Cluster, error) {
+	return StartWithBackups(n, 0, nil)
+}
+
+func backupName(id int, i int) string {
+	return fmt.Sprintf("backup-%d-%d", id, i)
+}
+
+func learnerName(id int, i int) string {
+	return fmt.Sprintf("learner-%d-%d", id, i)
+}
+
+// Start a master and `n` primary workers, each of them with `backups` backups.
+// `setup` is called on every primary before anything else if not nil.
+func StartWithBackups(n int, backups int, setup func(w *worker.WorkerServer)) (*Cluster, error) {
+	dir, err := ioutil.TempDir("", "localcluster")
+	if err != nil {
+		return nil, err
+	}
+	c := newCluster(dir)
+	for i := 1; i <= n; i++ {
+		id := common.WorkerId(i)
+		l, addr, err := listen()
+		if err != nil {
+			c.Stop()
+			return nil, err
+		}
+		w, err := worker.NewPrimaryServer(addr.IP.String(), uint16(addr.Port), path.Join(dir, fmt.Sprint(i)), id)
+		if err != nil {
+			_ = l.Close()
+			c.Stop()
+			return nil, err
+		}
+		w.HoldLease()
+		if setup != nil {
+			setup(w)
+		}
+		go w.DoSync()
+		s := common.NewGrpcServer()
+		pb.RegisterKVWorkerServer(s, w)
+		pb.RegisterKVBackupServer(s, w)
+		pb.RegisterKVWorkerInternalServer(s, w)
+		go s.Serve(l)
+		c.Workers = append(c.Workers, w)
+		c.Backups = append(c.Backups, nil)
+		c.Learners = append(c.Learners, nil)
+		c.servers = append(c.servers, s)
+		c.Master.addrs[id] = addr
+		for j := 0; j < backups; j++ {
+			if _, err := c.AddBackup(i); err != nil {
+				c.Stop()
+				return nil, err
+			}
+		}
+	}
+	for i := range c.Master.slots {
+		c.Master.slots[i] = common.WorkerId(i%n + 1)
+	}
+	for _, w := range c.Workers {
+		w.SetSlotTable(c.Master.slots)
+	}
+	if err := c.serveMaster(); err != nil {
+		c.Stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newCluster(dir string) *Cluster {
+	return &Cluster{
+		Master: &Master{
+			slots:    *common.NewHashSlotRing(),
+			addrs:    make(map[common.WorkerId]*net.TCPAddr),
+			backups:  make(map[common.WorkerId][]*net.TCPAddr),
+			learners: make(map[common.WorkerId][]*net.TCPAddr),
+			groups:   make(map[common.WorkerId][]*worker.WorkerServer),
+		},
+		stopCh: make(chan struct{}),
+		dir:    dir,
+	}
+}
+
+func (c *Cluster) serveMaster() error {
+	l, _, err := listen()
+	if err != nil {
+		return err
+	}
+	s := common.NewGrpcServer()
+	pb.RegisterKVMasterServer(s, c.Master)
+	go s.Serve(l)
+	c.servers = append(c.servers, s)
+	c.MasterAddr = l.Addr().String()
+	return nil
+}
+
+// Start a master and worker 1 as a Raft group of `replicas` replicas in place of a primary & backups,
+// with members ticking every `tick`. Every slot is assigned to it, and the master points clients to its leader.
+func StartRaft(replicas int, tick time.Duration) (*Cluster, error) {
+	dir, err := ioutil.TempDir("", "localcluster")
+	if err != nil {
+		return nil, err
+	}
+	c := newCluster(dir)
+	c.Network = raft.NewNetwork()
+	go c.Network.Run(c.stopCh)
+	listeners := make([]net.Listener, replicas)
+	peers := make(map[raft.NodeId]string)
+	for i := range listeners {
+		l, addr, err := listen()
+		if err != nil {
+			c.Stop()
+			return nil, err
+		}
+		listeners[i] = l
+		peers[raft.NodeId(i+1)] = addr.String()
+	}
+	for i, l := range listeners {
+		addr := l.Addr().(*net.TCPAddr)
+		w, err := worker.NewBackupServer(addr.IP.String(), uint16(addr.Port), path.Join(dir, fmt.Sprintf("replica-%d", i+1)), 1)
+		if err != nil {
+			c.Stop()
+			return nil, err
+		}
+		node, err := w.StartRaft(raft.Config{
+			Id:      raft.NodeId(i + 1),
+			Peers:   peers,
+			Storage: raft.NewMemoryStorage(),
+			Seed:    int64(i + 1),
+		}, c.Network)
+		if err != nil {
+			c.Stop()
+			return nil, err
+		}
+		c.Network.Join(node)
+		go node.Run(tick)
+		s := common.NewGrpcServer()
+		pb.RegisterKVWorkerServer(s, w)
+		pb.RegisterKVBackupServer(s, w)
+		go s.Serve(l)
+		c.Replicas = append(c.Replicas, w)
+		c.replicaServers = append(c.replicaServers, s)
+	}
+	for i := range c.Master.slots {
+		c.Master.slots[i] = 1
+	}
+	for _, w := range c.Replicas {
+		w.SetSlotTable(c.Master.slots)
+	}
+	c.Master.groups[1] = c.Replicas
+	if err := c.serveMaster(); err != nil {
+		c.Stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Crash replica `i` of the Raft group: it stops serving, and taking messages from the rest.
+func (c *Cluster) StopReplica(i int) {
+	node := c.Replicas[i].RaftNode()
+	node.Stop()
+	c.Network.Leave(node.Id())
+	c.replicaServers[i].Stop()
+}
+
+// Start a new backup of worker `id`, attached to its primary.
+func (c *Cluster) AddBackup(id int) (*worker.WorkerServer, error) {
+	l, addr, err := listen()
+	if err != nil {
+		return nil, err
+	}
+	name := backupName(id, len(c.Backups[id-1]))
+	b, err := worker.NewBackupServer(addr.IP.String(), uint16(addr.Port), path.Join(c.dir, name), common.WorkerId(id))
+	if err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	// could be promoted by a switchover
+	b.HoldLease()
+	s := common.NewGrpcServer()
+	pb.RegisterKVWorkerServer(s, b)
+	pb.RegisterKVBackupServer(s, b)
+	go s.Serve(l)
+	c.Backups[id-1] = append(c.Backups[id-1], b)
+	c.servers = append(c.servers, s)
+	if err := c.Workers[id-1].AttachBackup(name, addr.String()); err != nil {
+		return nil, err
+	}
+	c.Master.lock.Lock()
+	c.Master.backups[common.WorkerId(id)] = append(c.Master.backups[common.WorkerId(id)], addr)
+	c.Master.lock.Unlock()
+	return b, nil
+}
+
+// Start a new learner of worker `id`, attached to its primary.
+func (c *Cluster) AddLearner(id int) (*worker.WorkerServer, error) {
+	l, addr, err := listen()
+	if err != nil {
+		return nil, err
+	}
+	name := learnerName(id, len(c.Learners[id-1]))
+	learner, err := worker.NewLearnerServer(addr.IP.String(), uint16(addr.Port), path.Join(c.dir, name), common.WorkerId(id))
+	if err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	s := common.NewGrpcServer()
+	pb.RegisterKVWorkerServer(s, learner)
+	pb.RegisterKVBackupServer(s, learner)
+	go s.Serve(l)
+	c.Learners[id-1] = append(c.Learners[id-1], learner)
+	c.servers = append(c.servers, s)
+	if err := c.Workers[id-1].AttachLearner(name, addr.String()); err != nil {
+		return nil, err
+	}
+	c.Master.lock.Lock()
+	c.Master.learners[common.WorkerId(id)] = append(c.Master.learners[common.WorkerId(id)], addr)
+	c.Master.lock.Unlock()
+	return learner, nil
+}
+
+// Bump the slot table version on master & every worker, like a migration would.
+func (c *Cluster) BumpSlotVersion() {
+	c.Master.lock.Lock()
+	c.Master.version += 1
+	c.Master.lock.Unlock()
+	for _, w := range c.Workers {
+		w.IncSlotTableVersion()
+	}
+}
+
+// Hand slots over as in `slots` on master & every worker, bumping the slot table version.
+// Keys are not moved, so workers keep those of slots taken away, and lack those of slots given.
+func (c *Cluster) SetSlotTable(slots common.HashSlotRing) {
+	c.Master.lock.Lock()
+	c.Master.slots = slots
+	c.Master.version += 1
+	c.Master.lock.Unlock()
+	for _, w := range c.Workers {
+		w.SetSlotTable(slots)
+		w.IncSlotTableVersion()
+	}
+}
+
+// Stop all servers and remove their data.
+func (c *Cluster) Stop() {
+	for _, s := range c.servers {
+		s.Stop()
+	}
+	for i := range c.replicaServers {
+		c.StopReplica(i)
+	}
+	close(c.stopCh)
+	for i, w := range c.Workers {
+		for j := range c.Backups[i] {
+			_ = w.RemoveBackupRoutine(backupName(i+1, j))
+		}
+		for j := range c.Learners[i] {
+			_ = w.RemoveBackupRoutine(learnerName(i+1, j))
+		}
+		w.SyncStopChan <- struct{}{}
+	}
+	_ = os.RemoveAll(c.dir)
+}
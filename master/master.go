@@ -188,11 +188,28 @@ func (m *Server) GetWorkerById(_ context.Context, in *pb.WorkerId) (*pb.GetWorke
 		Port:     int32(p.Host.Port),
 	}
 	return &pb.GetWorkerResponse{
-		Status: 0,
-		Worker: &worker,
+		Status:   0,
+		Worker:   &worker,
+		Backups:  nodesByName(w.Backups),
+		Learners: nodesByName(w.Learners),
 	}, nil
 }
 
+// Get worker nodes in the order of their names, for follower reads.
+func nodesByName(nodes map[string]*common.WorkerNode) []*pb.Worker {
+	names := make([]string, 0, len(nodes))
+	for k := range nodes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	ret := make([]*pb.Worker, 0, len(names))
+	for _, k := range names {
+		n := nodes[k]
+		ret = append(ret, &pb.Worker{Hostname: n.Host.Hostname, Port: int32(n.Host.Port)})
+	}
+	return ret
+}
+
 func (m *Server) RegisterToZk(conn *zk.Conn) error {
 	log := common.Log()
 	m.conn = conn
@@ -24,6 +24,739 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+type CatchUpRequest struct {
+	Version              uint64   `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CatchUpRequest) Reset()         { *m = CatchUpRequest{} }
+func (m *CatchUpRequest) String() string { return proto.CompactTextString(m) }
+func (*CatchUpRequest) ProtoMessage()    {}
+func (*CatchUpRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{0}
+}
+
+func (m *CatchUpRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CatchUpRequest.Unmarshal(m, b)
+}
+func (m *CatchUpRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CatchUpRequest.Marshal(b, m, deterministic)
+}
+func (m *CatchUpRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CatchUpRequest.Merge(m, src)
+}
+func (m *CatchUpRequest) XXX_Size() int {
+	return xxx_messageInfo_CatchUpRequest.Size(m)
+}
+func (m *CatchUpRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CatchUpRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CatchUpRequest proto.InternalMessageInfo
+
+func (m *CatchUpRequest) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+type CatchUpResponse struct {
+	Status Status `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	// version applied by the backup
+	Version              uint64         `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	Entries              []*BackupEntry `protobuf:"bytes,3,rep,name=entries,proto3" json:"entries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *CatchUpResponse) Reset()         { *m = CatchUpResponse{} }
+func (m *CatchUpResponse) String() string { return proto.CompactTextString(m) }
+func (*CatchUpResponse) ProtoMessage()    {}
+func (*CatchUpResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{1}
+}
+
+func (m *CatchUpResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CatchUpResponse.Unmarshal(m, b)
+}
+func (m *CatchUpResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CatchUpResponse.Marshal(b, m, deterministic)
+}
+func (m *CatchUpResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CatchUpResponse.Merge(m, src)
+}
+func (m *CatchUpResponse) XXX_Size() int {
+	return xxx_messageInfo_CatchUpResponse.Size(m)
+}
+func (m *CatchUpResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_CatchUpResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CatchUpResponse proto.InternalMessageInfo
+
+func (m *CatchUpResponse) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *CatchUpResponse) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *CatchUpResponse) GetEntries() []*BackupEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type PromoteRequest struct {
+	Version              uint64   `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Epoch                uint64   `protobuf:"varint,2,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PromoteRequest) Reset()         { *m = PromoteRequest{} }
+func (m *PromoteRequest) String() string { return proto.CompactTextString(m) }
+func (*PromoteRequest) ProtoMessage()    {}
+func (*PromoteRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{2}
+}
+
+func (m *PromoteRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PromoteRequest.Unmarshal(m, b)
+}
+func (m *PromoteRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PromoteRequest.Marshal(b, m, deterministic)
+}
+func (m *PromoteRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PromoteRequest.Merge(m, src)
+}
+func (m *PromoteRequest) XXX_Size() int {
+	return xxx_messageInfo_PromoteRequest.Size(m)
+}
+func (m *PromoteRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PromoteRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PromoteRequest proto.InternalMessageInfo
+
+func (m *PromoteRequest) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *PromoteRequest) GetEpoch() uint64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+type DumpRequest struct {
+	Version              uint64   `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	TimeoutMs            uint32   `protobuf:"varint,2,opt,name=timeoutMs,proto3" json:"timeoutMs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DumpRequest) Reset()         { *m = DumpRequest{} }
+func (m *DumpRequest) String() string { return proto.CompactTextString(m) }
+func (*DumpRequest) ProtoMessage()    {}
+func (*DumpRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{3}
+}
+
+func (m *DumpRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DumpRequest.Unmarshal(m, b)
+}
+func (m *DumpRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DumpRequest.Marshal(b, m, deterministic)
+}
+func (m *DumpRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DumpRequest.Merge(m, src)
+}
+func (m *DumpRequest) XXX_Size() int {
+	return xxx_messageInfo_DumpRequest.Size(m)
+}
+func (m *DumpRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DumpRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DumpRequest proto.InternalMessageInfo
+
+func (m *DumpRequest) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *DumpRequest) GetTimeoutMs() uint32 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type DumpEntry struct {
+	Key                  string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value                string   `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Version              uint64   `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	Deleted              bool     `protobuf:"varint,4,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DumpEntry) Reset()         { *m = DumpEntry{} }
+func (m *DumpEntry) String() string { return proto.CompactTextString(m) }
+func (*DumpEntry) ProtoMessage()    {}
+func (*DumpEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{4}
+}
+
+func (m *DumpEntry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DumpEntry.Unmarshal(m, b)
+}
+func (m *DumpEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DumpEntry.Marshal(b, m, deterministic)
+}
+func (m *DumpEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DumpEntry.Merge(m, src)
+}
+func (m *DumpEntry) XXX_Size() int {
+	return xxx_messageInfo_DumpEntry.Size(m)
+}
+func (m *DumpEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_DumpEntry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DumpEntry proto.InternalMessageInfo
+
+func (m *DumpEntry) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *DumpEntry) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+func (m *DumpEntry) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *DumpEntry) GetDeleted() bool {
+	if m != nil {
+		return m.Deleted
+	}
+	return false
+}
+
+type DumpResponse struct {
+	Status Status `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	// version applied by the replica before entries are taken
+	Version              uint64       `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	Entries              []*DumpEntry `protobuf:"bytes,3,rep,name=entries,proto3" json:"entries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *DumpResponse) Reset()         { *m = DumpResponse{} }
+func (m *DumpResponse) String() string { return proto.CompactTextString(m) }
+func (*DumpResponse) ProtoMessage()    {}
+func (*DumpResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{5}
+}
+
+func (m *DumpResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DumpResponse.Unmarshal(m, b)
+}
+func (m *DumpResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DumpResponse.Marshal(b, m, deterministic)
+}
+func (m *DumpResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DumpResponse.Merge(m, src)
+}
+func (m *DumpResponse) XXX_Size() int {
+	return xxx_messageInfo_DumpResponse.Size(m)
+}
+func (m *DumpResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DumpResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DumpResponse proto.InternalMessageInfo
+
+func (m *DumpResponse) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *DumpResponse) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *DumpResponse) GetEntries() []*DumpEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type MerkleRequest struct {
+	Slots                []uint32 `protobuf:"varint,1,rep,packed,name=slots,proto3" json:"slots,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MerkleRequest) Reset()         { *m = MerkleRequest{} }
+func (m *MerkleRequest) String() string { return proto.CompactTextString(m) }
+func (*MerkleRequest) ProtoMessage()    {}
+func (*MerkleRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{6}
+}
+
+func (m *MerkleRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MerkleRequest.Unmarshal(m, b)
+}
+func (m *MerkleRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MerkleRequest.Marshal(b, m, deterministic)
+}
+func (m *MerkleRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MerkleRequest.Merge(m, src)
+}
+func (m *MerkleRequest) XXX_Size() int {
+	return xxx_messageInfo_MerkleRequest.Size(m)
+}
+func (m *MerkleRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MerkleRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MerkleRequest proto.InternalMessageInfo
+
+func (m *MerkleRequest) GetSlots() []uint32 {
+	if m != nil {
+		return m.Slots
+	}
+	return nil
+}
+
+type MerkleLeaves struct {
+	Slot                 uint32   `protobuf:"varint,1,opt,name=slot,proto3" json:"slot,omitempty"`
+	Hashes               []uint64 `protobuf:"varint,2,rep,packed,name=hashes,proto3" json:"hashes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MerkleLeaves) Reset()         { *m = MerkleLeaves{} }
+func (m *MerkleLeaves) String() string { return proto.CompactTextString(m) }
+func (*MerkleLeaves) ProtoMessage()    {}
+func (*MerkleLeaves) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{7}
+}
+
+func (m *MerkleLeaves) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MerkleLeaves.Unmarshal(m, b)
+}
+func (m *MerkleLeaves) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MerkleLeaves.Marshal(b, m, deterministic)
+}
+func (m *MerkleLeaves) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MerkleLeaves.Merge(m, src)
+}
+func (m *MerkleLeaves) XXX_Size() int {
+	return xxx_messageInfo_MerkleLeaves.Size(m)
+}
+func (m *MerkleLeaves) XXX_DiscardUnknown() {
+	xxx_messageInfo_MerkleLeaves.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MerkleLeaves proto.InternalMessageInfo
+
+func (m *MerkleLeaves) GetSlot() uint32 {
+	if m != nil {
+		return m.Slot
+	}
+	return 0
+}
+
+func (m *MerkleLeaves) GetHashes() []uint64 {
+	if m != nil {
+		return m.Hashes
+	}
+	return nil
+}
+
+type MerkleResponse struct {
+	Status               Status          `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	Roots                []uint64        `protobuf:"varint,2,rep,packed,name=roots,proto3" json:"roots,omitempty"`
+	Leaves               []*MerkleLeaves `protobuf:"bytes,3,rep,name=leaves,proto3" json:"leaves,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *MerkleResponse) Reset()         { *m = MerkleResponse{} }
+func (m *MerkleResponse) String() string { return proto.CompactTextString(m) }
+func (*MerkleResponse) ProtoMessage()    {}
+func (*MerkleResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{8}
+}
+
+func (m *MerkleResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MerkleResponse.Unmarshal(m, b)
+}
+func (m *MerkleResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MerkleResponse.Marshal(b, m, deterministic)
+}
+func (m *MerkleResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MerkleResponse.Merge(m, src)
+}
+func (m *MerkleResponse) XXX_Size() int {
+	return xxx_messageInfo_MerkleResponse.Size(m)
+}
+func (m *MerkleResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_MerkleResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MerkleResponse proto.InternalMessageInfo
+
+func (m *MerkleResponse) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *MerkleResponse) GetRoots() []uint64 {
+	if m != nil {
+		return m.Roots
+	}
+	return nil
+}
+
+func (m *MerkleResponse) GetLeaves() []*MerkleLeaves {
+	if m != nil {
+		return m.Leaves
+	}
+	return nil
+}
+
+type RepairRange struct {
+	Slot uint32 `protobuf:"varint,1,opt,name=slot,proto3" json:"slot,omitempty"`
+	Leaf uint32 `protobuf:"varint,2,opt,name=leaf,proto3" json:"leaf,omitempty"`
+	// every key of the primary in the leaf, deleted ones included
+	Entries              []*BackupEntry `protobuf:"bytes,3,rep,name=entries,proto3" json:"entries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *RepairRange) Reset()         { *m = RepairRange{} }
+func (m *RepairRange) String() string { return proto.CompactTextString(m) }
+func (*RepairRange) ProtoMessage()    {}
+func (*RepairRange) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{9}
+}
+
+func (m *RepairRange) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RepairRange.Unmarshal(m, b)
+}
+func (m *RepairRange) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RepairRange.Marshal(b, m, deterministic)
+}
+func (m *RepairRange) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RepairRange.Merge(m, src)
+}
+func (m *RepairRange) XXX_Size() int {
+	return xxx_messageInfo_RepairRange.Size(m)
+}
+func (m *RepairRange) XXX_DiscardUnknown() {
+	xxx_messageInfo_RepairRange.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RepairRange proto.InternalMessageInfo
+
+func (m *RepairRange) GetSlot() uint32 {
+	if m != nil {
+		return m.Slot
+	}
+	return 0
+}
+
+func (m *RepairRange) GetLeaf() uint32 {
+	if m != nil {
+		return m.Leaf
+	}
+	return 0
+}
+
+func (m *RepairRange) GetEntries() []*BackupEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type RepairRequest struct {
+	// version of the primary before entries are taken,
+	// keys missing on the primary and not written after it are deleted
+	Version              uint64         `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Epoch                uint64         `protobuf:"varint,2,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	Ranges               []*RepairRange `protobuf:"bytes,3,rep,name=ranges,proto3" json:"ranges,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *RepairRequest) Reset()         { *m = RepairRequest{} }
+func (m *RepairRequest) String() string { return proto.CompactTextString(m) }
+func (*RepairRequest) ProtoMessage()    {}
+func (*RepairRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{10}
+}
+
+func (m *RepairRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RepairRequest.Unmarshal(m, b)
+}
+func (m *RepairRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RepairRequest.Marshal(b, m, deterministic)
+}
+func (m *RepairRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RepairRequest.Merge(m, src)
+}
+func (m *RepairRequest) XXX_Size() int {
+	return xxx_messageInfo_RepairRequest.Size(m)
+}
+func (m *RepairRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RepairRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RepairRequest proto.InternalMessageInfo
+
+func (m *RepairRequest) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *RepairRequest) GetEpoch() uint64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *RepairRequest) GetRanges() []*RepairRange {
+	if m != nil {
+		return m.Ranges
+	}
+	return nil
+}
+
+type RepairReply struct {
+	Status Status `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	// number of keys overwritten
+	Repaired             uint64   `protobuf:"varint,2,opt,name=repaired,proto3" json:"repaired,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RepairReply) Reset()         { *m = RepairReply{} }
+func (m *RepairReply) String() string { return proto.CompactTextString(m) }
+func (*RepairReply) ProtoMessage()    {}
+func (*RepairReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{11}
+}
+
+func (m *RepairReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RepairReply.Unmarshal(m, b)
+}
+func (m *RepairReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RepairReply.Marshal(b, m, deterministic)
+}
+func (m *RepairReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RepairReply.Merge(m, src)
+}
+func (m *RepairReply) XXX_Size() int {
+	return xxx_messageInfo_RepairReply.Size(m)
+}
+func (m *RepairReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_RepairReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RepairReply proto.InternalMessageInfo
+
+func (m *RepairReply) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *RepairReply) GetRepaired() uint64 {
+	if m != nil {
+		return m.Repaired
+	}
+	return 0
+}
+
+type SnapshotChunk struct {
+	// version the snapshot is taken at, the same in every chunk
+	Version uint64 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// epoch of the primary
+	Epoch uint64 `protobuf:"varint,2,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	// position of data in the snapshot file
+	Offset uint64 `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`
+	Data   []byte `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	// CRC-32 (IEEE) of data
+	Checksum uint32 `protobuf:"varint,5,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	// set in the last chunk
+	Last                 bool     `protobuf:"varint,6,opt,name=last,proto3" json:"last,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SnapshotChunk) Reset()         { *m = SnapshotChunk{} }
+func (m *SnapshotChunk) String() string { return proto.CompactTextString(m) }
+func (*SnapshotChunk) ProtoMessage()    {}
+func (*SnapshotChunk) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{12}
+}
+
+func (m *SnapshotChunk) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SnapshotChunk.Unmarshal(m, b)
+}
+func (m *SnapshotChunk) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SnapshotChunk.Marshal(b, m, deterministic)
+}
+func (m *SnapshotChunk) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SnapshotChunk.Merge(m, src)
+}
+func (m *SnapshotChunk) XXX_Size() int {
+	return xxx_messageInfo_SnapshotChunk.Size(m)
+}
+func (m *SnapshotChunk) XXX_DiscardUnknown() {
+	xxx_messageInfo_SnapshotChunk.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SnapshotChunk proto.InternalMessageInfo
+
+func (m *SnapshotChunk) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *SnapshotChunk) GetEpoch() uint64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *SnapshotChunk) GetOffset() uint64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *SnapshotChunk) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *SnapshotChunk) GetChecksum() uint32 {
+	if m != nil {
+		return m.Checksum
+	}
+	return 0
+}
+
+func (m *SnapshotChunk) GetLast() bool {
+	if m != nil {
+		return m.Last
+	}
+	return false
+}
+
+type BackupBatch struct {
+	Entries              []*BackupEntry `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *BackupBatch) Reset()         { *m = BackupBatch{} }
+func (m *BackupBatch) String() string { return proto.CompactTextString(m) }
+func (*BackupBatch) ProtoMessage()    {}
+func (*BackupBatch) Descriptor() ([]byte, []int) {
+	return fileDescriptor_65240d19de191688, []int{13}
+}
+
+func (m *BackupBatch) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BackupBatch.Unmarshal(m, b)
+}
+func (m *BackupBatch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BackupBatch.Marshal(b, m, deterministic)
+}
+func (m *BackupBatch) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BackupBatch.Merge(m, src)
+}
+func (m *BackupBatch) XXX_Size() int {
+	return xxx_messageInfo_BackupBatch.Size(m)
+}
+func (m *BackupBatch) XXX_DiscardUnknown() {
+	xxx_messageInfo_BackupBatch.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BackupBatch proto.InternalMessageInfo
+
+func (m *BackupBatch) GetEntries() []*BackupEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
 type BackupReply struct {
 	Status               Status   `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
 	Version              uint64   `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
@@ -36,7 +769,7 @@ func (m *BackupReply) Reset()         { *m = BackupReply{} }
 func (m *BackupReply) String() string { return proto.CompactTextString(m) }
 func (*BackupReply) ProtoMessage()    {}
 func (*BackupReply) Descriptor() ([]byte, []int) {
-	return fileDescriptor_65240d19de191688, []int{0}
+	return fileDescriptor_65240d19de191688, []int{14}
 }
 
 func (m *BackupReply) XXX_Unmarshal(b []byte) error {
@@ -72,6 +805,20 @@ func (m *BackupReply) GetVersion() uint64 {
 }
 
 func init() {
+	proto.RegisterType((*CatchUpRequest)(nil), "kv.proto.CatchUpRequest")
+	proto.RegisterType((*CatchUpResponse)(nil), "kv.proto.CatchUpResponse")
+	proto.RegisterType((*PromoteRequest)(nil), "kv.proto.PromoteRequest")
+	proto.RegisterType((*DumpRequest)(nil), "kv.proto.DumpRequest")
+	proto.RegisterType((*DumpEntry)(nil), "kv.proto.DumpEntry")
+	proto.RegisterType((*DumpResponse)(nil), "kv.proto.DumpResponse")
+	proto.RegisterType((*MerkleRequest)(nil), "kv.proto.MerkleRequest")
+	proto.RegisterType((*MerkleLeaves)(nil), "kv.proto.MerkleLeaves")
+	proto.RegisterType((*MerkleResponse)(nil), "kv.proto.MerkleResponse")
+	proto.RegisterType((*RepairRange)(nil), "kv.proto.RepairRange")
+	proto.RegisterType((*RepairRequest)(nil), "kv.proto.RepairRequest")
+	proto.RegisterType((*RepairReply)(nil), "kv.proto.RepairReply")
+	proto.RegisterType((*SnapshotChunk)(nil), "kv.proto.SnapshotChunk")
+	proto.RegisterType((*BackupBatch)(nil), "kv.proto.BackupBatch")
 	proto.RegisterType((*BackupReply)(nil), "kv.proto.BackupReply")
 }
 
@@ -80,19 +827,51 @@ func init() {
 }
 
 var fileDescriptor_65240d19de191688 = []byte{
-	// 179 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x49, 0x4a, 0x4c, 0xce,
-	0x2e, 0x2d, 0xd0, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0xc8, 0x2e, 0x83, 0xb0, 0xa4, 0x78,
-	0x92, 0xf3, 0x73, 0x73, 0xf3, 0xf3, 0x20, 0x3c, 0xa5, 0x40, 0x2e, 0x6e, 0x27, 0xb0, 0xba, 0xa0,
-	0xd4, 0x82, 0x9c, 0x4a, 0x21, 0x0d, 0x2e, 0xb6, 0xe2, 0x92, 0xc4, 0x92, 0xd2, 0x62, 0x09, 0x46,
-	0x05, 0x46, 0x0d, 0x3e, 0x23, 0x01, 0x3d, 0x98, 0x3e, 0xbd, 0x60, 0xb0, 0x78, 0x10, 0x54, 0x5e,
-	0x48, 0x82, 0x8b, 0xbd, 0x2c, 0xb5, 0xa8, 0x38, 0x33, 0x3f, 0x4f, 0x82, 0x49, 0x81, 0x51, 0x83,
-	0x25, 0x08, 0xc6, 0x35, 0x6a, 0x61, 0xe4, 0xe2, 0xf0, 0x0e, 0x83, 0x98, 0x2a, 0x64, 0xc3, 0xc5,
-	0x11, 0x52, 0x94, 0x98, 0x57, 0x9c, 0x96, 0x5a, 0x24, 0x24, 0x8a, 0x30, 0x0c, 0x22, 0xeb, 0x9a,
-	0x57, 0x52, 0x54, 0x29, 0x85, 0x21, 0x0c, 0x76, 0x8a, 0x12, 0x83, 0x06, 0xa3, 0x90, 0x15, 0x17,
-	0x4b, 0x70, 0x65, 0x5e, 0x32, 0xe9, 0x3a, 0x0d, 0x18, 0x9d, 0x38, 0xa3, 0xd8, 0xf5, 0xac, 0xc1,
-	0x92, 0x49, 0x6c, 0x60, 0xca, 0x18, 0x10, 0x00, 0x00, 0xff, 0xff, 0xfc, 0x48, 0x64, 0x9d, 0x13,
-	0x01, 0x00, 0x00,
+	// 702 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x55, 0x4f, 0x6f, 0xd3, 0x4a,
+	0x10, 0xaf, 0x5f, 0x1c, 0x27, 0x99, 0xfc, 0x69, 0xb5, 0xaf, 0x2f, 0xcf, 0x44, 0x1c, 0xaa, 0x95,
+	0x90, 0x22, 0xa4, 0x86, 0xaa, 0x9c, 0x28, 0x15, 0x94, 0x96, 0x1e, 0x10, 0x54, 0x82, 0x4d, 0xe1,
+	0xc0, 0x6d, 0xeb, 0x4c, 0xea, 0x28, 0x8e, 0xd7, 0x78, 0xd7, 0x91, 0x72, 0x83, 0x0b, 0x5f, 0x82,
+	0xef, 0xc3, 0xe7, 0x42, 0xde, 0xb5, 0x13, 0xa7, 0x49, 0x5b, 0x82, 0x38, 0x79, 0x66, 0x77, 0xe6,
+	0x37, 0xbf, 0xf9, 0x79, 0x67, 0x17, 0x1a, 0x57, 0xdc, 0x1b, 0x27, 0x51, 0x2f, 0x8a, 0x85, 0x12,
+	0xa4, 0x3a, 0x9e, 0x1a, 0xab, 0xd3, 0xf0, 0xc4, 0x64, 0x22, 0x42, 0xe3, 0xd1, 0xc7, 0xd0, 0x3a,
+	0xe3, 0xca, 0xf3, 0x3f, 0x46, 0x0c, 0xbf, 0x24, 0x28, 0x15, 0x71, 0xa1, 0x32, 0xc5, 0x58, 0x8e,
+	0x44, 0xe8, 0x5a, 0x7b, 0x56, 0xd7, 0x66, 0xb9, 0x4b, 0xbf, 0x5b, 0xb0, 0x3d, 0x0f, 0x96, 0x91,
+	0x08, 0x25, 0x92, 0x2e, 0x38, 0x52, 0x71, 0x95, 0x48, 0x1d, 0xdc, 0x3a, 0xdc, 0xe9, 0xe5, 0x85,
+	0x7a, 0x7d, 0xbd, 0xce, 0xb2, 0xfd, 0x22, 0xee, 0x3f, 0x4b, 0xb8, 0xe4, 0x09, 0x54, 0x30, 0x54,
+	0xf1, 0x08, 0xa5, 0x5b, 0xda, 0x2b, 0x75, 0xeb, 0x87, 0xff, 0x2d, 0x40, 0x4e, 0x75, 0x13, 0xe7,
+	0xa1, 0x8a, 0x67, 0x2c, 0x8f, 0xa2, 0x27, 0xd0, 0x7a, 0x1f, 0x8b, 0x89, 0x50, 0x78, 0x2f, 0x69,
+	0xb2, 0x0b, 0x65, 0x8c, 0x84, 0xe7, 0x67, 0x45, 0x8d, 0x43, 0xcf, 0xa1, 0xfe, 0x3a, 0x99, 0xdc,
+	0xdf, 0x33, 0x79, 0x08, 0x35, 0x35, 0x9a, 0xa0, 0x48, 0xd4, 0x85, 0xd4, 0x10, 0x4d, 0xb6, 0x58,
+	0xa0, 0xd7, 0x50, 0x4b, 0x61, 0x34, 0x3d, 0xb2, 0x03, 0xa5, 0x31, 0xce, 0x34, 0x40, 0x8d, 0xa5,
+	0x66, 0x5a, 0x7b, 0xca, 0x83, 0x04, 0x75, 0x62, 0x8d, 0x19, 0xa7, 0x58, 0xac, 0xb4, 0x5c, 0xcc,
+	0x85, 0xca, 0x00, 0x03, 0x54, 0x38, 0x70, 0xed, 0x3d, 0xab, 0x5b, 0x65, 0xb9, 0x4b, 0xbf, 0x59,
+	0xd0, 0x30, 0x84, 0xff, 0xa2, 0xee, 0xfb, 0x37, 0x75, 0xff, 0x77, 0x01, 0x32, 0x6f, 0x6b, 0xa1,
+	0xfa, 0x23, 0x68, 0x5e, 0x60, 0x3c, 0x0e, 0xe6, 0xa2, 0xef, 0x42, 0x59, 0x06, 0x42, 0xa5, 0x14,
+	0x4a, 0xdd, 0x26, 0x33, 0x0e, 0x3d, 0x82, 0x86, 0x09, 0x7b, 0x87, 0x7c, 0x8a, 0x92, 0x10, 0xb0,
+	0xd3, 0x0d, 0xcd, 0xb3, 0xc9, 0xb4, 0x4d, 0xda, 0xe0, 0xf8, 0x5c, 0xfa, 0x98, 0x4a, 0x5a, 0xea,
+	0xda, 0x2c, 0xf3, 0xe8, 0x57, 0x0b, 0x5a, 0x79, 0x8d, 0x8d, 0x1b, 0xdd, 0x85, 0x72, 0x2c, 0x84,
+	0xca, 0x31, 0x8d, 0x43, 0x7a, 0xe0, 0x04, 0x9a, 0x48, 0xd6, 0x63, 0x7b, 0x91, 0x5f, 0xa4, 0xc9,
+	0xb2, 0x28, 0x3a, 0x84, 0x3a, 0xc3, 0x88, 0x8f, 0x62, 0xc6, 0xc3, 0x6b, 0x5c, 0xcb, 0x9e, 0x80,
+	0x1d, 0x20, 0x1f, 0x66, 0xc7, 0x41, 0xdb, 0x9b, 0x9f, 0xe1, 0x10, 0x9a, 0x59, 0x9d, 0x3f, 0x3b,
+	0xc2, 0x64, 0x1f, 0x9c, 0x38, 0xa5, 0xb8, 0xa6, 0x60, 0xa1, 0x01, 0x96, 0x05, 0xd1, 0xfe, 0xbc,
+	0x2f, 0x8c, 0x82, 0xd9, 0x06, 0xb2, 0x76, 0xa0, 0x1a, 0xeb, 0x44, 0x1c, 0x64, 0x04, 0xe6, 0x3e,
+	0xfd, 0x61, 0x41, 0xb3, 0x1f, 0xf2, 0x48, 0xfa, 0x42, 0x9d, 0xf9, 0x49, 0x38, 0xde, 0xb8, 0x8b,
+	0x36, 0x38, 0x62, 0x38, 0x94, 0xa8, 0xb2, 0x59, 0xc8, 0xbc, 0x54, 0xe3, 0x01, 0x57, 0x5c, 0xcf,
+	0x41, 0x83, 0x69, 0x3b, 0x65, 0xe2, 0xf9, 0xe8, 0x8d, 0x65, 0x32, 0x71, 0xcb, 0x5a, 0xfb, 0xb9,
+	0xaf, 0xff, 0x09, 0x97, 0xca, 0x75, 0xf4, 0xdc, 0x68, 0x9b, 0xbe, 0x80, 0xba, 0x91, 0xfe, 0x34,
+	0xbd, 0xb4, 0x8a, 0xbf, 0xc8, 0xfa, 0xad, 0x5f, 0xf4, 0x21, 0xcf, 0xdf, 0x54, 0xb2, 0x5b, 0x47,
+	0xee, 0xf0, 0xa7, 0x0d, 0xd5, 0xb7, 0x9f, 0x0c, 0x2a, 0x39, 0x86, 0xea, 0x65, 0xcc, 0x43, 0x39,
+	0xc4, 0x98, 0xac, 0xe7, 0xd2, 0x59, 0x59, 0xd6, 0x54, 0xe8, 0x56, 0xd7, 0x22, 0x47, 0x60, 0xf7,
+	0x67, 0xa1, 0xb7, 0x79, 0xe6, 0x81, 0x45, 0x5e, 0x42, 0x2d, 0xcd, 0x35, 0xba, 0xac, 0x44, 0xea,
+	0xe5, 0xbb, 0x01, 0xce, 0x61, 0xfb, 0x4d, 0x28, 0x15, 0x0f, 0x82, 0xfc, 0xf7, 0x93, 0xff, 0x0b,
+	0x72, 0x14, 0x8f, 0xc4, 0x5d, 0x3d, 0xbc, 0x02, 0x30, 0x43, 0x78, 0x19, 0x23, 0x16, 0x11, 0x96,
+	0x2e, 0x9a, 0x8e, 0xbb, 0xba, 0x61, 0x6e, 0x07, 0xba, 0x45, 0x8e, 0xc0, 0x31, 0xe7, 0xba, 0x98,
+	0xbe, 0x34, 0x59, 0x9d, 0xd5, 0xc9, 0x30, 0x04, 0xc8, 0x33, 0xb0, 0xd3, 0x7b, 0xae, 0xa8, 0x40,
+	0xe1, 0x55, 0xe8, 0xb4, 0x6f, 0x2e, 0xe7, 0x45, 0x0f, 0x2c, 0x72, 0x0c, 0x95, 0xec, 0x09, 0x22,
+	0x05, 0x76, 0xcb, 0xaf, 0xd2, 0xad, 0x9d, 0x93, 0x13, 0xa8, 0x64, 0x0f, 0x69, 0x31, 0x7b, 0xf9,
+	0x21, 0xee, 0x3c, 0x58, 0xb3, 0x93, 0x33, 0x38, 0xad, 0x7d, 0xae, 0xf4, 0x9e, 0xeb, 0xcd, 0x2b,
+	0x47, 0x7f, 0x9e, 0xfe, 0x1a, 0x00, 0xc7, 0xef, 0x0f, 0x93, 0xf1, 0x07, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -113,6 +892,25 @@ type KVBackupClient interface {
 	// Transfer one entry(or multiple, small amount of entries) and return one ack,
 	// this is for sync options, useful in lossless backup.
 	Sync(ctx context.Context, opts ...grpc.CallOption) (KVBackup_SyncClient, error)
+	// Same as Sync with entries sent in batches. Acks are cumulative, one for each batch at most.
+	SyncBatch(ctx context.Context, opts ...grpc.CallOption) (KVBackup_SyncBatchClient, error)
+	// Replace everything on a backup with a snapshot of the primary, sent in chunks.
+	// The backup sends the version & size of what it has got of a snapshot in header, so an interrupted
+	// install could resume from there. The reply carries the version of the installed snapshot.
+	InstallSnapshot(ctx context.Context, opts ...grpc.CallOption) (KVBackup_InstallSnapshotClient, error)
+	// Get the Merkle trees of a backup, to be compared with the primary's:
+	// roots of every slot, and leaves of the slots asked for.
+	MerkleTree(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (*MerkleResponse, error)
+	// Overwrite keys in leaves of Merkle trees found diverged with what the primary has.
+	Repair(ctx context.Context, in *RepairRequest, opts ...grpc.CallOption) (*RepairReply, error)
+	// Stream every key of a replica, deleted ones included, once it has applied `version`
+	// or `timeoutMs` has passed, for checking consistency of replicas.
+	Dump(ctx context.Context, in *DumpRequest, opts ...grpc.CallOption) (KVBackup_DumpClient, error)
+	// Take over the primary role from the primary sending it, once the backup has applied `version`.
+	Promote(ctx context.Context, in *PromoteRequest, opts ...grpc.CallOption) (*BackupReply, error)
+	// Get entries a backup has applied after `version`, for a backup elected primary to catch up with
+	// before serving. ETRUNCATED if some of them are no longer retained.
+	CatchUp(ctx context.Context, in *CatchUpRequest, opts ...grpc.CallOption) (*CatchUpResponse, error)
 }
 
 type kVBackupClient struct {
@@ -188,6 +986,139 @@ func (x *kVBackupSyncClient) Recv() (*BackupReply, error) {
 	return m, nil
 }
 
+func (c *kVBackupClient) SyncBatch(ctx context.Context, opts ...grpc.CallOption) (KVBackup_SyncBatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_KVBackup_serviceDesc.Streams[2], "/kv.proto.KVBackup/SyncBatch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVBackupSyncBatchClient{stream}
+	return x, nil
+}
+
+type KVBackup_SyncBatchClient interface {
+	Send(*BackupBatch) error
+	Recv() (*BackupReply, error)
+	grpc.ClientStream
+}
+
+type kVBackupSyncBatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVBackupSyncBatchClient) Send(m *BackupBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *kVBackupSyncBatchClient) Recv() (*BackupReply, error) {
+	m := new(BackupReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVBackupClient) InstallSnapshot(ctx context.Context, opts ...grpc.CallOption) (KVBackup_InstallSnapshotClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_KVBackup_serviceDesc.Streams[3], "/kv.proto.KVBackup/InstallSnapshot", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVBackupInstallSnapshotClient{stream}
+	return x, nil
+}
+
+type KVBackup_InstallSnapshotClient interface {
+	Send(*SnapshotChunk) error
+	CloseAndRecv() (*BackupReply, error)
+	grpc.ClientStream
+}
+
+type kVBackupInstallSnapshotClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVBackupInstallSnapshotClient) Send(m *SnapshotChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *kVBackupInstallSnapshotClient) CloseAndRecv() (*BackupReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(BackupReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVBackupClient) MerkleTree(ctx context.Context, in *MerkleRequest, opts ...grpc.CallOption) (*MerkleResponse, error) {
+	out := new(MerkleResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVBackup/MerkleTree", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVBackupClient) Repair(ctx context.Context, in *RepairRequest, opts ...grpc.CallOption) (*RepairReply, error) {
+	out := new(RepairReply)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVBackup/Repair", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVBackupClient) Dump(ctx context.Context, in *DumpRequest, opts ...grpc.CallOption) (KVBackup_DumpClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_KVBackup_serviceDesc.Streams[4], "/kv.proto.KVBackup/Dump", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVBackupDumpClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KVBackup_DumpClient interface {
+	Recv() (*DumpResponse, error)
+	grpc.ClientStream
+}
+
+type kVBackupDumpClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVBackupDumpClient) Recv() (*DumpResponse, error) {
+	m := new(DumpResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVBackupClient) Promote(ctx context.Context, in *PromoteRequest, opts ...grpc.CallOption) (*BackupReply, error) {
+	out := new(BackupReply)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVBackup/Promote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVBackupClient) CatchUp(ctx context.Context, in *CatchUpRequest, opts ...grpc.CallOption) (*CatchUpResponse, error) {
+	out := new(CatchUpResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVBackup/CatchUp", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // KVBackupServer is the server API for KVBackup service.
 type KVBackupServer interface {
 	// Transfer a lot of entries and return one reply. This is for full-size updates
@@ -196,6 +1127,25 @@ type KVBackupServer interface {
 	// Transfer one entry(or multiple, small amount of entries) and return one ack,
 	// this is for sync options, useful in lossless backup.
 	Sync(KVBackup_SyncServer) error
+	// Same as Sync with entries sent in batches. Acks are cumulative, one for each batch at most.
+	SyncBatch(KVBackup_SyncBatchServer) error
+	// Replace everything on a backup with a snapshot of the primary, sent in chunks.
+	// The backup sends the version & size of what it has got of a snapshot in header, so an interrupted
+	// install could resume from there. The reply carries the version of the installed snapshot.
+	InstallSnapshot(KVBackup_InstallSnapshotServer) error
+	// Get the Merkle trees of a backup, to be compared with the primary's:
+	// roots of every slot, and leaves of the slots asked for.
+	MerkleTree(context.Context, *MerkleRequest) (*MerkleResponse, error)
+	// Overwrite keys in leaves of Merkle trees found diverged with what the primary has.
+	Repair(context.Context, *RepairRequest) (*RepairReply, error)
+	// Stream every key of a replica, deleted ones included, once it has applied `version`
+	// or `timeoutMs` has passed, for checking consistency of replicas.
+	Dump(*DumpRequest, KVBackup_DumpServer) error
+	// Take over the primary role from the primary sending it, once the backup has applied `version`.
+	Promote(context.Context, *PromoteRequest) (*BackupReply, error)
+	// Get entries a backup has applied after `version`, for a backup elected primary to catch up with
+	// before serving. ETRUNCATED if some of them are no longer retained.
+	CatchUp(context.Context, *CatchUpRequest) (*CatchUpResponse, error)
 }
 
 // UnimplementedKVBackupServer can be embedded to have forward compatible implementations.
@@ -208,6 +1158,27 @@ func (*UnimplementedKVBackupServer) Transfer(srv KVBackup_TransferServer) error
 func (*UnimplementedKVBackupServer) Sync(srv KVBackup_SyncServer) error {
 	return status.Errorf(codes.Unimplemented, "method Sync not implemented")
 }
+func (*UnimplementedKVBackupServer) SyncBatch(srv KVBackup_SyncBatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method SyncBatch not implemented")
+}
+func (*UnimplementedKVBackupServer) InstallSnapshot(srv KVBackup_InstallSnapshotServer) error {
+	return status.Errorf(codes.Unimplemented, "method InstallSnapshot not implemented")
+}
+func (*UnimplementedKVBackupServer) MerkleTree(ctx context.Context, req *MerkleRequest) (*MerkleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MerkleTree not implemented")
+}
+func (*UnimplementedKVBackupServer) Repair(ctx context.Context, req *RepairRequest) (*RepairReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Repair not implemented")
+}
+func (*UnimplementedKVBackupServer) Dump(req *DumpRequest, srv KVBackup_DumpServer) error {
+	return status.Errorf(codes.Unimplemented, "method Dump not implemented")
+}
+func (*UnimplementedKVBackupServer) Promote(ctx context.Context, req *PromoteRequest) (*BackupReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Promote not implemented")
+}
+func (*UnimplementedKVBackupServer) CatchUp(ctx context.Context, req *CatchUpRequest) (*CatchUpResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CatchUp not implemented")
+}
 
 func RegisterKVBackupServer(s *grpc.Server, srv KVBackupServer) {
 	s.RegisterService(&_KVBackup_serviceDesc, srv)
@@ -265,10 +1236,172 @@ func (x *kVBackupSyncServer) Recv() (*BackupEntry, error) {
 	return m, nil
 }
 
+func _KVBackup_SyncBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KVBackupServer).SyncBatch(&kVBackupSyncBatchServer{stream})
+}
+
+type KVBackup_SyncBatchServer interface {
+	Send(*BackupReply) error
+	Recv() (*BackupBatch, error)
+	grpc.ServerStream
+}
+
+type kVBackupSyncBatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVBackupSyncBatchServer) Send(m *BackupReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *kVBackupSyncBatchServer) Recv() (*BackupBatch, error) {
+	m := new(BackupBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _KVBackup_InstallSnapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KVBackupServer).InstallSnapshot(&kVBackupInstallSnapshotServer{stream})
+}
+
+type KVBackup_InstallSnapshotServer interface {
+	SendAndClose(*BackupReply) error
+	Recv() (*SnapshotChunk, error)
+	grpc.ServerStream
+}
+
+type kVBackupInstallSnapshotServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVBackupInstallSnapshotServer) SendAndClose(m *BackupReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *kVBackupInstallSnapshotServer) Recv() (*SnapshotChunk, error) {
+	m := new(SnapshotChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _KVBackup_MerkleTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVBackupServer).MerkleTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVBackup/MerkleTree",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVBackupServer).MerkleTree(ctx, req.(*MerkleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVBackup_Repair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepairRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVBackupServer).Repair(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVBackup/Repair",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVBackupServer).Repair(ctx, req.(*RepairRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVBackup_Dump_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DumpRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KVBackupServer).Dump(m, &kVBackupDumpServer{stream})
+}
+
+type KVBackup_DumpServer interface {
+	Send(*DumpResponse) error
+	grpc.ServerStream
+}
+
+type kVBackupDumpServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVBackupDumpServer) Send(m *DumpResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KVBackup_Promote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PromoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVBackupServer).Promote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVBackup/Promote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVBackupServer).Promote(ctx, req.(*PromoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVBackup_CatchUp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CatchUpRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVBackupServer).CatchUp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVBackup/CatchUp",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVBackupServer).CatchUp(ctx, req.(*CatchUpRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _KVBackup_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "kv.proto.KVBackup",
 	HandlerType: (*KVBackupServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "MerkleTree",
+			Handler:    _KVBackup_MerkleTree_Handler,
+		},
+		{
+			MethodName: "Repair",
+			Handler:    _KVBackup_Repair_Handler,
+		},
+		{
+			MethodName: "Promote",
+			Handler:    _KVBackup_Promote_Handler,
+		},
+		{
+			MethodName: "CatchUp",
+			Handler:    _KVBackup_CatchUp_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "Transfer",
@@ -281,6 +1414,22 @@ var _KVBackup_serviceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "SyncBatch",
+			Handler:       _KVBackup_SyncBatch_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "InstallSnapshot",
+			Handler:       _KVBackup_InstallSnapshot_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Dump",
+			Handler:       _KVBackup_Dump_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "backup.proto",
 }
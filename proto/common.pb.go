@@ -20,6 +20,34 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+type Consistency int32
+
+const (
+	Consistency_STRONG  Consistency = 0
+	Consistency_BOUNDED Consistency = 1
+	Consistency_ANY     Consistency = 2
+)
+
+var Consistency_name = map[int32]string{
+	0: "STRONG",
+	1: "BOUNDED",
+	2: "ANY",
+}
+
+var Consistency_value = map[string]int32{
+	"STRONG":  0,
+	"BOUNDED": 1,
+	"ANY":     2,
+}
+
+func (x Consistency) String() string {
+	return proto.EnumName(Consistency_name, int32(x))
+}
+
+func (Consistency) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_555bd8c177793206, []int{0}
+}
+
 type Operation int32
 
 const (
@@ -51,7 +79,7 @@ func (x Operation) String() string {
 }
 
 func (Operation) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_555bd8c177793206, []int{0}
+	return fileDescriptor_555bd8c177793206, []int{1}
 }
 
 type Status int32
@@ -64,16 +92,24 @@ const (
 	Status_EINVSERVER  Status = 4
 	Status_EINVWID     Status = 5
 	Status_EINVVERSION Status = 6
+	Status_ETRUNCATED  Status = 7
+	Status_ELOCKED     Status = 8
+	Status_EINVEPOCH   Status = 9
+	Status_EREADONLY   Status = 10
 )
 
 var Status_name = map[int32]string{
-	0: "OK",
-	1: "ENOENT",
-	2: "ENOSERVER",
-	3: "EFAILED",
-	4: "EINVSERVER",
-	5: "EINVWID",
-	6: "EINVVERSION",
+	0:  "OK",
+	1:  "ENOENT",
+	2:  "ENOSERVER",
+	3:  "EFAILED",
+	4:  "EINVSERVER",
+	5:  "EINVWID",
+	6:  "EINVVERSION",
+	7:  "ETRUNCATED",
+	8:  "ELOCKED",
+	9:  "EINVEPOCH",
+	10: "EREADONLY",
 }
 
 var Status_value = map[string]int32{
@@ -84,6 +120,10 @@ var Status_value = map[string]int32{
 	"EINVSERVER":  4,
 	"EINVWID":     5,
 	"EINVVERSION": 6,
+	"ETRUNCATED":  7,
+	"ELOCKED":     8,
+	"EINVEPOCH":   9,
+	"EREADONLY":   10,
 }
 
 func (x Status) String() string {
@@ -91,12 +131,16 @@ func (x Status) String() string {
 }
 
 func (Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_555bd8c177793206, []int{1}
+	return fileDescriptor_555bd8c177793206, []int{2}
 }
 
 type Key struct {
-	Key                  string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	SlotVersion          uint32   `protobuf:"varint,2,opt,name=slotVersion,proto3" json:"slotVersion,omitempty"`
+	Key         string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	SlotVersion uint32 `protobuf:"varint,2,opt,name=slotVersion,proto3" json:"slotVersion,omitempty"`
+	// only for reads
+	Consistency Consistency `protobuf:"varint,3,opt,name=consistency,proto3,enum=kv.proto.Consistency" json:"consistency,omitempty"`
+	// in milliseconds, for BOUNDED reads
+	MaxStaleness         uint32   `protobuf:"varint,4,opt,name=maxStaleness,proto3" json:"maxStaleness,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -141,6 +185,20 @@ func (m *Key) GetSlotVersion() uint32 {
 	return 0
 }
 
+func (m *Key) GetConsistency() Consistency {
+	if m != nil {
+		return m.Consistency
+	}
+	return Consistency_STRONG
+}
+
+func (m *Key) GetMaxStaleness() uint32 {
+	if m != nil {
+		return m.MaxStaleness
+	}
+	return 0
+}
+
 type Value struct {
 	Value                string   `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -275,13 +333,17 @@ func (m *WorkerId) GetId() uint32 {
 }
 
 type BackupEntry struct {
-	Op                   Operation `protobuf:"varint,1,opt,name=op,proto3,enum=kv.proto.Operation" json:"op,omitempty"`
-	Version              uint64    `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
-	Key                  string    `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
-	Value                string    `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
-	XXX_unrecognized     []byte    `json:"-"`
-	XXX_sizecache        int32     `json:"-"`
+	Op      Operation `protobuf:"varint,1,opt,name=op,proto3,enum=kv.proto.Operation" json:"op,omitempty"`
+	Version uint64    `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	Key     string    `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	Value   string    `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	// epoch of the primary sending it
+	Epoch uint64 `protobuf:"varint,5,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	// only in commands of Raft groups, where version is the one a put compares against, 0 meaning not existing
+	Compare              bool     `protobuf:"varint,6,opt,name=compare,proto3" json:"compare,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *BackupEntry) Reset()         { *m = BackupEntry{} }
@@ -337,7 +399,22 @@ func (m *BackupEntry) GetValue() string {
 	return ""
 }
 
+func (m *BackupEntry) GetEpoch() uint64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *BackupEntry) GetCompare() bool {
+	if m != nil {
+		return m.Compare
+	}
+	return false
+}
+
 func init() {
+	proto.RegisterEnum("kv.proto.Consistency", Consistency_name, Consistency_value)
 	proto.RegisterEnum("kv.proto.Operation", Operation_name, Operation_value)
 	proto.RegisterEnum("kv.proto.Status", Status_name, Status_value)
 	proto.RegisterType((*Key)(nil), "kv.proto.Key")
@@ -352,28 +429,37 @@ func init() {
 }
 
 var fileDescriptor_555bd8c177793206 = []byte{
-	// 364 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x50, 0x4b, 0xaf, 0x9a, 0x40,
-	0x14, 0x96, 0x01, 0x41, 0x0e, 0xd5, 0x4e, 0x4f, 0x1f, 0x31, 0x4d, 0x9a, 0x18, 0xba, 0x31, 0x2e,
-	0x58, 0xb4, 0xab, 0xa6, 0x2b, 0xd4, 0x69, 0x43, 0x54, 0x30, 0x03, 0xc5, 0xa6, 0x9b, 0x86, 0xab,
-	0xdc, 0x84, 0xa0, 0x0c, 0x41, 0x34, 0xf1, 0xdf, 0xdf, 0x80, 0x8f, 0x70, 0xef, 0x75, 0x35, 0xdf,
-	0x39, 0x5f, 0xbe, 0xc7, 0x19, 0x78, 0xb3, 0x16, 0xbb, 0x9d, 0xc8, 0xac, 0xbc, 0x10, 0xa5, 0xc0,
-	0x4e, 0x7a, 0x3c, 0x23, 0xf3, 0x07, 0xc8, 0xb3, 0xf8, 0x84, 0x14, 0xe4, 0x34, 0x3e, 0xf5, 0xa5,
-	0x81, 0x34, 0xd4, 0x79, 0x05, 0x71, 0x00, 0xc6, 0x7e, 0x2b, 0xca, 0x30, 0x2e, 0xf6, 0x89, 0xc8,
-	0xfa, 0x64, 0x20, 0x0d, 0xbb, 0xbc, 0xb9, 0x32, 0xbf, 0x40, 0x3b, 0x8c, 0xb6, 0x87, 0x18, 0x3f,
-	0x40, 0xfb, 0x58, 0x81, 0x8b, 0xfc, 0x3c, 0x98, 0x1c, 0xd4, 0x59, 0xb8, 0x8c, 0x92, 0xe2, 0x8e,
-	0xf9, 0x4d, 0x41, 0x1a, 0x8a, 0x97, 0x91, 0xf2, 0xeb, 0xc8, 0xcf, 0xd0, 0x59, 0x89, 0x22, 0x8d,
-	0x0b, 0x67, 0x83, 0x3d, 0x20, 0xc9, 0xa6, 0x36, 0xed, 0x72, 0x92, 0x6c, 0xcc, 0x12, 0x8c, 0x71,
-	0xb4, 0x4e, 0x0f, 0x39, 0xcb, 0xca, 0xe2, 0x84, 0x5f, 0x81, 0x88, 0xbc, 0xa6, 0x7b, 0xdf, 0xde,
-	0x5b, 0xd7, 0x7b, 0x2d, 0x2f, 0x8f, 0x8b, 0xa8, 0x4c, 0x44, 0xc6, 0x89, 0xc8, 0xb1, 0x0f, 0xda,
-	0xb1, 0x71, 0xa0, 0xc2, 0xaf, 0xe3, 0xb5, 0xb3, 0x7c, 0xa7, 0xb3, 0xd2, 0xe8, 0x3c, 0xfa, 0x0b,
-	0xfa, 0xcd, 0x12, 0x35, 0x90, 0x7f, 0xb3, 0x80, 0xb6, 0x2a, 0xb0, 0xfc, 0x13, 0x50, 0x09, 0x01,
-	0xd4, 0x29, 0x9b, 0xb3, 0x80, 0x51, 0x82, 0x1f, 0xe1, 0x9d, 0x1f, 0xd8, 0x3c, 0xf8, 0x1f, 0x70,
-	0xdb, 0xf5, 0xed, 0x49, 0xe0, 0x78, 0x2e, 0x95, 0xf1, 0x13, 0xe0, 0xc4, 0x5b, 0x2c, 0x9c, 0xe7,
-	0x7b, 0x65, 0xf4, 0x08, 0xaa, 0x5f, 0x46, 0xe5, 0x61, 0x8f, 0x2a, 0x10, 0x6f, 0x46, 0x5b, 0x95,
-	0x19, 0x73, 0x3d, 0xe6, 0x56, 0xc6, 0x5d, 0xd0, 0x99, 0xeb, 0xf9, 0x8c, 0x87, 0x8c, 0x53, 0x82,
-	0x06, 0x68, 0xec, 0x97, 0xed, 0xcc, 0xd9, 0x94, 0xca, 0xd8, 0x03, 0x60, 0x8e, 0x1b, 0x5e, 0x48,
-	0xa5, 0x26, 0x1d, 0x37, 0x5c, 0x39, 0x53, 0xda, 0xc6, 0xb7, 0x60, 0x54, 0x43, 0xc8, 0xb8, 0x5f,
-	0xe5, 0xa8, 0x63, 0xfd, 0x9f, 0x66, 0xfd, 0xac, 0x3f, 0xe7, 0x41, 0xad, 0x9f, 0xef, 0x4f, 0x01,
-	0x00, 0x00, 0xff, 0xff, 0xbc, 0x86, 0x5d, 0xe6, 0x2d, 0x02, 0x00, 0x00,
+	// 508 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x51, 0x41, 0x6f, 0x9b, 0x4c,
+	0x10, 0x0d, 0x60, 0x63, 0x7b, 0x88, 0xfd, 0xed, 0xb7, 0x4d, 0x2a, 0x54, 0xa9, 0x92, 0x45, 0x2f,
+	0x96, 0x0f, 0xae, 0x94, 0x1e, 0x7a, 0xe8, 0x09, 0xc3, 0x36, 0x45, 0x76, 0x16, 0x6b, 0xc1, 0xa4,
+	0xe9, 0xa5, 0xa2, 0x18, 0xa9, 0xc8, 0x36, 0x8b, 0x00, 0x5b, 0xf5, 0x1f, 0xe9, 0xbd, 0x52, 0x7f,
+	0x68, 0xb5, 0xeb, 0x38, 0x21, 0x6d, 0x4e, 0xcc, 0x7b, 0x6f, 0xe6, 0xed, 0x9b, 0x01, 0xce, 0x13,
+	0xbe, 0xdd, 0xf2, 0x7c, 0x52, 0x94, 0xbc, 0xe6, 0xb8, 0xbb, 0xde, 0x1f, 0x2b, 0xeb, 0xa7, 0x02,
+	0xda, 0x2c, 0x3d, 0x60, 0x04, 0xda, 0x3a, 0x3d, 0x98, 0xca, 0x50, 0x19, 0xf5, 0x98, 0x28, 0xf1,
+	0x10, 0x8c, 0x6a, 0xc3, 0xeb, 0x28, 0x2d, 0xab, 0x8c, 0xe7, 0xa6, 0x3a, 0x54, 0x46, 0x7d, 0xd6,
+	0xa4, 0xf0, 0x7b, 0x30, 0x12, 0x9e, 0x57, 0x59, 0x55, 0xa7, 0x79, 0x72, 0x30, 0xb5, 0xa1, 0x32,
+	0x1a, 0x5c, 0x5d, 0x4e, 0x4e, 0xde, 0x13, 0xe7, 0x51, 0x64, 0xcd, 0x4e, 0x6c, 0xc1, 0xf9, 0x36,
+	0xfe, 0x11, 0xd4, 0xf1, 0x26, 0xcd, 0xd3, 0xaa, 0x32, 0x5b, 0xd2, 0xfb, 0x09, 0x67, 0xbd, 0x86,
+	0x76, 0x14, 0x6f, 0x76, 0x29, 0xbe, 0x80, 0xf6, 0x5e, 0x14, 0xf7, 0xd9, 0x8e, 0xc0, 0x62, 0xa0,
+	0xcf, 0xa2, 0x45, 0x9c, 0x95, 0xcf, 0x24, 0x7f, 0x98, 0x50, 0x1b, 0x13, 0x7f, 0xef, 0xa3, 0xfd,
+	0xb3, 0x8f, 0xf5, 0x0a, 0xba, 0xb7, 0xbc, 0x5c, 0xa7, 0xa5, 0xb7, 0xc2, 0x03, 0x50, 0xb3, 0x95,
+	0x34, 0xed, 0x33, 0x35, 0x5b, 0x59, 0xbf, 0x15, 0x30, 0xa6, 0x71, 0xb2, 0xde, 0x15, 0x24, 0xaf,
+	0xcb, 0x03, 0x7e, 0x03, 0x2a, 0x2f, 0xa4, 0x3e, 0xb8, 0x7a, 0xf1, 0xb8, 0xb2, 0x5f, 0xa4, 0x65,
+	0x5c, 0x67, 0x3c, 0x67, 0x2a, 0x2f, 0xb0, 0x09, 0x9d, 0x7d, 0xe3, 0x7c, 0x2d, 0x76, 0x82, 0xa7,
+	0xd0, 0xda, 0x33, 0xa1, 0x5b, 0xcd, 0xd0, 0x17, 0xd0, 0x4e, 0x0b, 0x9e, 0x7c, 0x37, 0xdb, 0x72,
+	0xfe, 0x08, 0x84, 0x6f, 0xc2, 0xb7, 0x45, 0x5c, 0xa6, 0xa6, 0x3e, 0x54, 0x46, 0x5d, 0x76, 0x82,
+	0xe3, 0xb7, 0x60, 0x34, 0xae, 0x8e, 0x01, 0xf4, 0x20, 0x64, 0x3e, 0xbd, 0x46, 0x67, 0xd8, 0x80,
+	0xce, 0xd4, 0x5f, 0x52, 0x97, 0xb8, 0x48, 0xc1, 0x1d, 0xd0, 0x6c, 0x7a, 0x87, 0xd4, 0xf1, 0x67,
+	0xe8, 0x3d, 0x64, 0x16, 0xec, 0x35, 0x09, 0xd1, 0x99, 0x28, 0x16, 0xcb, 0x10, 0x29, 0xc2, 0xc0,
+	0x25, 0x73, 0x12, 0x12, 0xa4, 0xe2, 0x4b, 0xf8, 0x3f, 0x08, 0x6d, 0x16, 0x7e, 0x0d, 0x99, 0x4d,
+	0x03, 0xdb, 0x09, 0x3d, 0x9f, 0x22, 0x0d, 0xbf, 0x04, 0xec, 0xf8, 0x37, 0x37, 0xde, 0x53, 0xbe,
+	0x35, 0xfe, 0xa5, 0x80, 0x1e, 0xd4, 0x71, 0xbd, 0xab, 0xb0, 0x0e, 0xaa, 0x3f, 0x43, 0x67, 0xc2,
+	0x8d, 0x50, 0x9f, 0x50, 0xe1, 0xdc, 0x87, 0x1e, 0xa1, 0x7e, 0x40, 0x58, 0x44, 0x18, 0x52, 0x45,
+	0x3a, 0xf2, 0xd1, 0xf6, 0xe6, 0xc4, 0x45, 0x1a, 0x1e, 0x00, 0x10, 0x8f, 0x46, 0xf7, 0x62, 0x4b,
+	0x8a, 0x1e, 0x8d, 0x6e, 0x3d, 0x17, 0xb5, 0xf1, 0x7f, 0x60, 0x08, 0x10, 0x11, 0x16, 0x88, 0x87,
+	0x74, 0xd9, 0x1d, 0xb2, 0x25, 0x75, 0xec, 0x90, 0xb8, 0xa8, 0x23, 0xbb, 0xe7, 0xbe, 0x33, 0x23,
+	0x2e, 0xea, 0xca, 0x67, 0x3c, 0x1a, 0x91, 0x85, 0xef, 0x7c, 0x42, 0x3d, 0x09, 0x19, 0xb1, 0x5d,
+	0x9f, 0xce, 0xef, 0x10, 0x4c, 0x7b, 0x5f, 0x3a, 0x93, 0x0f, 0xf2, 0xcf, 0x7d, 0xd3, 0xe5, 0xe7,
+	0xdd, 0x9f, 0x01, 0x00, 0xe4, 0x53, 0xad, 0x87, 0x29, 0x03, 0x00, 0x00,
 }
@@ -120,11 +120,15 @@ func (m *GetSlotsResponse) GetSlotTable() []*WorkerId {
 }
 
 type GetWorkerResponse struct {
-	Status               Status   `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
-	Worker               *Worker  `protobuf:"bytes,2,opt,name=worker,proto3" json:"worker,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Status Status `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	// the primary
+	Worker  *Worker   `protobuf:"bytes,2,opt,name=worker,proto3" json:"worker,omitempty"`
+	Backups []*Worker `protobuf:"bytes,3,rep,name=backups,proto3" json:"backups,omitempty"`
+	// replicas that never take over as primary, for reads kept off the primary & backups
+	Learners             []*Worker `protobuf:"bytes,4,rep,name=learners,proto3" json:"learners,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
 }
 
 func (m *GetWorkerResponse) Reset()         { *m = GetWorkerResponse{} }
@@ -166,6 +170,20 @@ func (m *GetWorkerResponse) GetWorker() *Worker {
 	return nil
 }
 
+func (m *GetWorkerResponse) GetBackups() []*Worker {
+	if m != nil {
+		return m.Backups
+	}
+	return nil
+}
+
+func (m *GetWorkerResponse) GetLearners() []*Worker {
+	if m != nil {
+		return m.Learners
+	}
+	return nil
+}
+
 type Worker struct {
 	Hostname             string   `protobuf:"bytes,1,opt,name=hostname,proto3" json:"hostname,omitempty"`
 	Port                 int32    `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
@@ -225,28 +243,30 @@ func init() {
 }
 
 var fileDescriptor_f9c348dec43a6705 = []byte{
-	// 335 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x91, 0x41, 0x4f, 0xc2, 0x30,
-	0x14, 0xc7, 0x19, 0x2a, 0x8c, 0x07, 0x18, 0xec, 0xc1, 0x2c, 0xe3, 0x42, 0x7a, 0xda, 0xa9, 0x18,
-	0xbc, 0x98, 0x78, 0x31, 0x4b, 0x0c, 0x41, 0x63, 0x62, 0x86, 0xd1, 0xc4, 0x83, 0xc9, 0x80, 0x8a,
-	0x86, 0x6d, 0x6f, 0x59, 0x1f, 0x18, 0xbe, 0xae, 0x9f, 0xc4, 0xd0, 0x6e, 0xcc, 0x25, 0x72, 0xf0,
-	0xb4, 0xbe, 0xbe, 0xb7, 0x5f, 0xdb, 0xdf, 0x1f, 0x3a, 0x71, 0xa8, 0x48, 0x66, 0x22, 0xcd, 0x90,
-	0x90, 0xd9, 0xab, 0x8d, 0x59, 0xb9, 0x9d, 0x39, 0xc6, 0x31, 0x26, 0x79, 0xd5, 0x5f, 0x22, 0x2e,
-	0x23, 0x39, 0xd4, 0xd5, 0x6c, 0xfd, 0x3e, 0x94, 0x71, 0x4a, 0x5b, 0xd3, 0xe4, 0x77, 0xe0, 0x8c,
-	0x25, 0xf9, 0xe1, 0x7c, 0xb5, 0x4e, 0x1f, 0x34, 0x4d, 0x05, 0x52, 0xa5, 0x98, 0x28, 0xc9, 0x5c,
-	0xb0, 0x3f, 0x50, 0x51, 0x12, 0xc6, 0xd2, 0xb1, 0x06, 0x96, 0xd7, 0x0a, 0xf6, 0x35, 0x63, 0x70,
-	0x9c, 0x62, 0x46, 0x4e, 0x7d, 0x60, 0x79, 0x27, 0x81, 0x5e, 0xf3, 0x37, 0xe8, 0x8d, 0x25, 0x4d,
-	0x23, 0xa4, 0x92, 0xe1, 0x40, 0x73, 0x23, 0x33, 0xf5, 0x89, 0x89, 0x46, 0x74, 0x83, 0xa2, 0x64,
-	0x17, 0xd0, 0x52, 0x11, 0xd2, 0x53, 0x38, 0x8b, 0xa4, 0x53, 0x1f, 0x1c, 0x79, 0xed, 0x11, 0x13,
-	0xc5, 0x13, 0xc4, 0x0b, 0x66, 0x2b, 0x99, 0x4d, 0x16, 0x41, 0x39, 0xc4, 0x97, 0x70, 0x36, 0x96,
-	0x64, 0x3a, 0xfb, 0x03, 0x3c, 0x68, 0x28, 0x0a, 0x69, 0xad, 0x34, 0xff, 0x74, 0xd4, 0x2b, 0x19,
-	0x53, 0xbd, 0x1f, 0xe4, 0xfd, 0xdd, 0xe4, 0x97, 0xfe, 0x57, 0x5f, 0xba, 0xfd, 0x7b, 0x32, 0x67,
-	0xe6, 0x7d, 0x7e, 0x05, 0x0d, 0xb3, 0xf3, 0x5f, 0x05, 0xa3, 0x6f, 0x0b, 0xec, 0xfb, 0x67, 0x23,
-	0x92, 0x3d, 0x6a, 0x1f, 0x15, 0xb7, 0xec, 0x5c, 0x98, 0x34, 0x44, 0x91, 0x86, 0xb8, 0xdd, 0xa5,
-	0xe1, 0xf2, 0xf2, 0x32, 0x87, 0xf2, 0xe0, 0x35, 0x76, 0x03, 0x76, 0x61, 0xf8, 0x20, 0xc9, 0xad,
-	0x90, 0x2a, 0x69, 0xf0, 0x1a, 0xf3, 0xa1, 0xbb, 0x77, 0xe8, 0x6f, 0x27, 0x0b, 0xf6, 0x87, 0x73,
-	0xb7, 0x5f, 0x41, 0x54, 0x85, 0xf3, 0x9a, 0xdf, 0x7a, 0x6d, 0x8a, 0x6b, 0x73, 0x6a, 0x43, 0x7f,
-	0x2e, 0x7f, 0x02, 0x00, 0x00, 0xff, 0xff, 0xa0, 0xd7, 0x40, 0x49, 0x8a, 0x02, 0x00, 0x00,
+	// 365 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x91, 0xcf, 0x6a, 0xea, 0x40,
+	0x14, 0x87, 0x8d, 0x7a, 0x35, 0x1e, 0xf5, 0xe2, 0x9d, 0xc5, 0x25, 0xc4, 0x4d, 0x98, 0x55, 0xb8,
+	0x5c, 0x62, 0xb1, 0x9b, 0x42, 0x37, 0x25, 0x50, 0xc4, 0x96, 0x42, 0x89, 0xa5, 0x85, 0x2e, 0x0a,
+	0x51, 0x4f, 0x6d, 0x31, 0xc9, 0x84, 0x99, 0xd1, 0xe2, 0xe3, 0xf5, 0x55, 0xfa, 0x24, 0xc5, 0x99,
+	0xfc, 0x69, 0x8a, 0x2e, 0xba, 0xca, 0x9c, 0x39, 0xbf, 0x7c, 0x67, 0x66, 0x3e, 0xe8, 0xc5, 0xa1,
+	0x90, 0xc8, 0xbd, 0x94, 0x33, 0xc9, 0x88, 0xb9, 0xde, 0xea, 0x95, 0xdd, 0x5b, 0xb0, 0x38, 0x66,
+	0x49, 0x56, 0x0d, 0x57, 0x8c, 0xad, 0x22, 0x1c, 0xa9, 0x6a, 0xbe, 0x79, 0x1e, 0x61, 0x9c, 0xca,
+	0x9d, 0x6e, 0xd2, 0x2b, 0xb0, 0x26, 0x28, 0xfd, 0x70, 0xb1, 0xde, 0xa4, 0x37, 0x8a, 0x26, 0x02,
+	0x14, 0x29, 0x4b, 0x04, 0x12, 0x1b, 0xcc, 0x17, 0x26, 0x64, 0x12, 0xc6, 0x68, 0x19, 0x8e, 0xe1,
+	0x76, 0x82, 0xa2, 0x26, 0x04, 0x9a, 0x29, 0xe3, 0xd2, 0xaa, 0x3b, 0x86, 0xfb, 0x2b, 0x50, 0x6b,
+	0xfa, 0x04, 0x83, 0x09, 0xca, 0x59, 0xc4, 0x64, 0xc9, 0xb0, 0xa0, 0xbd, 0x45, 0x2e, 0x5e, 0x59,
+	0xa2, 0x10, 0xfd, 0x20, 0x2f, 0xc9, 0x09, 0x74, 0x44, 0xc4, 0xe4, 0x5d, 0x38, 0x8f, 0xd0, 0xaa,
+	0x3b, 0x0d, 0xb7, 0x3b, 0x26, 0x5e, 0x7e, 0x05, 0xef, 0x81, 0xf1, 0x35, 0xf2, 0xe9, 0x32, 0x28,
+	0x43, 0xf4, 0xdd, 0x80, 0x3f, 0x13, 0x94, 0xba, 0x55, 0x4c, 0x70, 0xa1, 0x25, 0x64, 0x28, 0x37,
+	0x42, 0x0d, 0xf8, 0x3d, 0x1e, 0x94, 0x90, 0x99, 0xda, 0x0f, 0xb2, 0xfe, 0x3e, 0xf9, 0xa6, 0xfe,
+	0x55, 0xa7, 0xee, 0x7e, 0x4d, 0x66, 0xcc, 0xac, 0x4f, 0xfe, 0x41, 0x7b, 0xae, 0x9e, 0x44, 0x58,
+	0x0d, 0xa7, 0x71, 0x30, 0x9a, 0x07, 0xc8, 0x7f, 0x30, 0x23, 0x0c, 0x79, 0x82, 0x5c, 0x58, 0xcd,
+	0x23, 0xe1, 0x22, 0x41, 0xcf, 0xa0, 0xa5, 0xf7, 0x7e, 0xfa, 0xba, 0xe3, 0x0f, 0x03, 0xcc, 0xeb,
+	0x7b, 0xed, 0x88, 0xdc, 0xc2, 0xe0, 0xbb, 0x36, 0xf2, 0xd7, 0xd3, 0xa2, 0xbd, 0x5c, 0xb4, 0x77,
+	0xb9, 0x17, 0x6d, 0xd3, 0xf2, 0x38, 0xc7, 0x54, 0xd3, 0x1a, 0xb9, 0x00, 0x33, 0x97, 0x77, 0x94,
+	0x64, 0x57, 0x48, 0x15, 0xd1, 0xb4, 0x46, 0x7c, 0xe8, 0x17, 0x76, 0xfc, 0xdd, 0x74, 0x49, 0x0e,
+	0xe8, 0xb4, 0x87, 0x15, 0x44, 0x55, 0x25, 0xad, 0xf9, 0x9d, 0xc7, 0xb6, 0x77, 0xae, 0xa7, 0xb6,
+	0xd4, 0xe7, 0xf4, 0x73, 0x00, 0xfc, 0xd9, 0x8d, 0x7e, 0xe5, 0x02, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
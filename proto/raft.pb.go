@@ -0,0 +1,554 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: raft.proto
+
+package proto
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type RaftMessageType int32
+
+const (
+	RaftMessageType_RAFT_VOTE            RaftMessageType = 0
+	RaftMessageType_RAFT_VOTE_RESPONSE   RaftMessageType = 1
+	RaftMessageType_RAFT_APPEND          RaftMessageType = 2
+	RaftMessageType_RAFT_APPEND_RESPONSE RaftMessageType = 3
+)
+
+var RaftMessageType_name = map[int32]string{
+	0: "RAFT_VOTE",
+	1: "RAFT_VOTE_RESPONSE",
+	2: "RAFT_APPEND",
+	3: "RAFT_APPEND_RESPONSE",
+}
+
+var RaftMessageType_value = map[string]int32{
+	"RAFT_VOTE":            0,
+	"RAFT_VOTE_RESPONSE":   1,
+	"RAFT_APPEND":          2,
+	"RAFT_APPEND_RESPONSE": 3,
+}
+
+func (x RaftMessageType) String() string {
+	return proto.EnumName(RaftMessageType_name, int32(x))
+}
+
+func (RaftMessageType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{0}
+}
+
+type RaftEntryType int32
+
+const (
+	RaftEntryType_RAFT_NORMAL RaftEntryType = 0
+	// data is a RaftMembership, taking effect once appended
+	RaftEntryType_RAFT_MEMBERSHIP RaftEntryType = 1
+)
+
+var RaftEntryType_name = map[int32]string{
+	0: "RAFT_NORMAL",
+	1: "RAFT_MEMBERSHIP",
+}
+
+var RaftEntryType_value = map[string]int32{
+	"RAFT_NORMAL":     0,
+	"RAFT_MEMBERSHIP": 1,
+}
+
+func (x RaftEntryType) String() string {
+	return proto.EnumName(RaftEntryType_name, int32(x))
+}
+
+func (RaftEntryType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{1}
+}
+
+type RaftMessage struct {
+	Type RaftMessageType `protobuf:"varint,1,opt,name=type,proto3,enum=kv.proto.RaftMessageType" json:"type,omitempty"`
+	// worker the group replicates, messages of other groups are dropped
+	Group uint32 `protobuf:"varint,2,opt,name=group,proto3" json:"group,omitempty"`
+	From  uint64 `protobuf:"varint,3,opt,name=from,proto3" json:"from,omitempty"`
+	To    uint64 `protobuf:"varint,4,opt,name=to,proto3" json:"to,omitempty"`
+	Term  uint64 `protobuf:"varint,5,opt,name=term,proto3" json:"term,omitempty"`
+	// last entry of the candidate for votes, entry before those sent for appends,
+	// and last entry matched for append responses
+	LogTerm uint64       `protobuf:"varint,6,opt,name=logTerm,proto3" json:"logTerm,omitempty"`
+	Index   uint64       `protobuf:"varint,7,opt,name=index,proto3" json:"index,omitempty"`
+	Entries []*RaftEntry `protobuf:"bytes,8,rep,name=entries,proto3" json:"entries,omitempty"`
+	Commit  uint64       `protobuf:"varint,9,opt,name=commit,proto3" json:"commit,omitempty"`
+	// vote not granted, or entries not matched
+	Reject bool `protobuf:"varint,10,opt,name=reject,proto3" json:"reject,omitempty"`
+	// last index of a follower rejecting entries, so the leader could skip what it does not have
+	Hint uint64 `protobuf:"varint,11,opt,name=hint,proto3" json:"hint,omitempty"`
+	// tick of the leader sending appends, echoed in responses for its lease
+	Tick                 uint64   `protobuf:"varint,12,opt,name=tick,proto3" json:"tick,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RaftMessage) Reset()         { *m = RaftMessage{} }
+func (m *RaftMessage) String() string { return proto.CompactTextString(m) }
+func (*RaftMessage) ProtoMessage()    {}
+func (*RaftMessage) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{0}
+}
+
+func (m *RaftMessage) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RaftMessage.Unmarshal(m, b)
+}
+func (m *RaftMessage) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RaftMessage.Marshal(b, m, deterministic)
+}
+func (m *RaftMessage) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RaftMessage.Merge(m, src)
+}
+func (m *RaftMessage) XXX_Size() int {
+	return xxx_messageInfo_RaftMessage.Size(m)
+}
+func (m *RaftMessage) XXX_DiscardUnknown() {
+	xxx_messageInfo_RaftMessage.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RaftMessage proto.InternalMessageInfo
+
+func (m *RaftMessage) GetType() RaftMessageType {
+	if m != nil {
+		return m.Type
+	}
+	return RaftMessageType_RAFT_VOTE
+}
+
+func (m *RaftMessage) GetGroup() uint32 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *RaftMessage) GetFrom() uint64 {
+	if m != nil {
+		return m.From
+	}
+	return 0
+}
+
+func (m *RaftMessage) GetTo() uint64 {
+	if m != nil {
+		return m.To
+	}
+	return 0
+}
+
+func (m *RaftMessage) GetTerm() uint64 {
+	if m != nil {
+		return m.Term
+	}
+	return 0
+}
+
+func (m *RaftMessage) GetLogTerm() uint64 {
+	if m != nil {
+		return m.LogTerm
+	}
+	return 0
+}
+
+func (m *RaftMessage) GetIndex() uint64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *RaftMessage) GetEntries() []*RaftEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+func (m *RaftMessage) GetCommit() uint64 {
+	if m != nil {
+		return m.Commit
+	}
+	return 0
+}
+
+func (m *RaftMessage) GetReject() bool {
+	if m != nil {
+		return m.Reject
+	}
+	return false
+}
+
+func (m *RaftMessage) GetHint() uint64 {
+	if m != nil {
+		return m.Hint
+	}
+	return 0
+}
+
+func (m *RaftMessage) GetTick() uint64 {
+	if m != nil {
+		return m.Tick
+	}
+	return 0
+}
+
+type RaftEntry struct {
+	Term                 uint64        `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Index                uint64        `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
+	Type                 RaftEntryType `protobuf:"varint,3,opt,name=type,proto3,enum=kv.proto.RaftEntryType" json:"type,omitempty"`
+	Data                 []byte        `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *RaftEntry) Reset()         { *m = RaftEntry{} }
+func (m *RaftEntry) String() string { return proto.CompactTextString(m) }
+func (*RaftEntry) ProtoMessage()    {}
+func (*RaftEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{1}
+}
+
+func (m *RaftEntry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RaftEntry.Unmarshal(m, b)
+}
+func (m *RaftEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RaftEntry.Marshal(b, m, deterministic)
+}
+func (m *RaftEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RaftEntry.Merge(m, src)
+}
+func (m *RaftEntry) XXX_Size() int {
+	return xxx_messageInfo_RaftEntry.Size(m)
+}
+func (m *RaftEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_RaftEntry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RaftEntry proto.InternalMessageInfo
+
+func (m *RaftEntry) GetTerm() uint64 {
+	if m != nil {
+		return m.Term
+	}
+	return 0
+}
+
+func (m *RaftEntry) GetIndex() uint64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *RaftEntry) GetType() RaftEntryType {
+	if m != nil {
+		return m.Type
+	}
+	return RaftEntryType_RAFT_NORMAL
+}
+
+func (m *RaftEntry) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+type RaftPeer struct {
+	Id                   uint64   `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Addr                 string   `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RaftPeer) Reset()         { *m = RaftPeer{} }
+func (m *RaftPeer) String() string { return proto.CompactTextString(m) }
+func (*RaftPeer) ProtoMessage()    {}
+func (*RaftPeer) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{2}
+}
+
+func (m *RaftPeer) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RaftPeer.Unmarshal(m, b)
+}
+func (m *RaftPeer) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RaftPeer.Marshal(b, m, deterministic)
+}
+func (m *RaftPeer) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RaftPeer.Merge(m, src)
+}
+func (m *RaftPeer) XXX_Size() int {
+	return xxx_messageInfo_RaftPeer.Size(m)
+}
+func (m *RaftPeer) XXX_DiscardUnknown() {
+	xxx_messageInfo_RaftPeer.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RaftPeer proto.InternalMessageInfo
+
+func (m *RaftPeer) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *RaftPeer) GetAddr() string {
+	if m != nil {
+		return m.Addr
+	}
+	return ""
+}
+
+type RaftMembership struct {
+	Peers                []*RaftPeer `protobuf:"bytes,1,rep,name=peers,proto3" json:"peers,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *RaftMembership) Reset()         { *m = RaftMembership{} }
+func (m *RaftMembership) String() string { return proto.CompactTextString(m) }
+func (*RaftMembership) ProtoMessage()    {}
+func (*RaftMembership) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{3}
+}
+
+func (m *RaftMembership) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RaftMembership.Unmarshal(m, b)
+}
+func (m *RaftMembership) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RaftMembership.Marshal(b, m, deterministic)
+}
+func (m *RaftMembership) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RaftMembership.Merge(m, src)
+}
+func (m *RaftMembership) XXX_Size() int {
+	return xxx_messageInfo_RaftMembership.Size(m)
+}
+func (m *RaftMembership) XXX_DiscardUnknown() {
+	xxx_messageInfo_RaftMembership.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RaftMembership proto.InternalMessageInfo
+
+func (m *RaftMembership) GetPeers() []*RaftPeer {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+// state persisted before anything is sent
+type RaftHardState struct {
+	Term                 uint64   `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Vote                 uint64   `protobuf:"varint,2,opt,name=vote,proto3" json:"vote,omitempty"`
+	Commit               uint64   `protobuf:"varint,3,opt,name=commit,proto3" json:"commit,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RaftHardState) Reset()         { *m = RaftHardState{} }
+func (m *RaftHardState) String() string { return proto.CompactTextString(m) }
+func (*RaftHardState) ProtoMessage()    {}
+func (*RaftHardState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{4}
+}
+
+func (m *RaftHardState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RaftHardState.Unmarshal(m, b)
+}
+func (m *RaftHardState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RaftHardState.Marshal(b, m, deterministic)
+}
+func (m *RaftHardState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RaftHardState.Merge(m, src)
+}
+func (m *RaftHardState) XXX_Size() int {
+	return xxx_messageInfo_RaftHardState.Size(m)
+}
+func (m *RaftHardState) XXX_DiscardUnknown() {
+	xxx_messageInfo_RaftHardState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RaftHardState proto.InternalMessageInfo
+
+func (m *RaftHardState) GetTerm() uint64 {
+	if m != nil {
+		return m.Term
+	}
+	return 0
+}
+
+func (m *RaftHardState) GetVote() uint64 {
+	if m != nil {
+		return m.Vote
+	}
+	return 0
+}
+
+func (m *RaftHardState) GetCommit() uint64 {
+	if m != nil {
+		return m.Commit
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterEnum("kv.proto.RaftMessageType", RaftMessageType_name, RaftMessageType_value)
+	proto.RegisterEnum("kv.proto.RaftEntryType", RaftEntryType_name, RaftEntryType_value)
+	proto.RegisterType((*RaftMessage)(nil), "kv.proto.RaftMessage")
+	proto.RegisterType((*RaftEntry)(nil), "kv.proto.RaftEntry")
+	proto.RegisterType((*RaftPeer)(nil), "kv.proto.RaftPeer")
+	proto.RegisterType((*RaftMembership)(nil), "kv.proto.RaftMembership")
+	proto.RegisterType((*RaftHardState)(nil), "kv.proto.RaftHardState")
+}
+
+func init() {
+	proto.RegisterFile("raft.proto", fileDescriptor_b042552c306ae59b)
+}
+
+var fileDescriptor_b042552c306ae59b = []byte{
+	// 514 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x53, 0x4d, 0x6f, 0xda, 0x40,
+	0x10, 0xc5, 0xc6, 0x7c, 0x0d, 0xe1, 0x43, 0x93, 0x94, 0x6e, 0xd3, 0x0b, 0xf2, 0xc9, 0x4a, 0x15,
+	0x47, 0xa2, 0xaa, 0x2a, 0xb5, 0x27, 0xa2, 0xba, 0x4a, 0xd5, 0xf2, 0xa1, 0x05, 0xe5, 0xd0, 0x4b,
+	0xe4, 0xe0, 0x85, 0xb8, 0xc4, 0xac, 0xb5, 0xde, 0xa0, 0xf2, 0xf3, 0xfa, 0xcf, 0xaa, 0xdd, 0x35,
+	0xd4, 0x54, 0x9c, 0x3c, 0xef, 0xf9, 0xed, 0xce, 0x9b, 0x67, 0x0f, 0x80, 0x08, 0x97, 0xd2, 0x4f,
+	0x05, 0x97, 0x1c, 0xeb, 0xeb, 0xad, 0xa9, 0x2e, 0xdf, 0xae, 0x38, 0x5f, 0x3d, 0xb3, 0x1b, 0x8d,
+	0x1e, 0x5f, 0x96, 0x37, 0x2c, 0x49, 0xe5, 0xce, 0xbc, 0x74, 0xff, 0xd8, 0xd0, 0xa4, 0xe1, 0x52,
+	0x8e, 0x58, 0x96, 0x85, 0x2b, 0x86, 0xd7, 0xe0, 0xc8, 0x5d, 0xca, 0x88, 0xd5, 0xb7, 0xbc, 0xf6,
+	0xe0, 0x8d, 0xbf, 0xbf, 0xc5, 0x2f, 0x88, 0xe6, 0xbb, 0x94, 0x51, 0x2d, 0xc3, 0x0b, 0xa8, 0xac,
+	0x04, 0x7f, 0x49, 0x89, 0xdd, 0xb7, 0xbc, 0x16, 0x35, 0x00, 0x11, 0x9c, 0xa5, 0xe0, 0x09, 0x29,
+	0xf7, 0x2d, 0xcf, 0xa1, 0xba, 0xc6, 0x36, 0xd8, 0x92, 0x13, 0x47, 0x33, 0xb6, 0xe4, 0x4a, 0x23,
+	0x99, 0x48, 0x48, 0xc5, 0x68, 0x54, 0x8d, 0x04, 0x6a, 0xcf, 0x7c, 0x35, 0x57, 0x74, 0x55, 0xd3,
+	0x7b, 0xa8, 0xfa, 0xc4, 0x9b, 0x88, 0xfd, 0x26, 0x35, 0xcd, 0x1b, 0x80, 0xd7, 0x50, 0x63, 0x1b,
+	0x29, 0x62, 0x96, 0x91, 0x7a, 0xbf, 0xec, 0x35, 0x07, 0xe7, 0xc7, 0x7e, 0x83, 0x8d, 0x14, 0x3b,
+	0xba, 0xd7, 0x60, 0x0f, 0xaa, 0x0b, 0x9e, 0x24, 0xb1, 0x24, 0x0d, 0x7d, 0x4b, 0x8e, 0x14, 0x2f,
+	0xd8, 0x2f, 0xb6, 0x90, 0x04, 0xfa, 0x96, 0x57, 0xa7, 0x39, 0x52, 0x16, 0x9f, 0xe2, 0x8d, 0x24,
+	0x4d, 0x63, 0x51, 0xd5, 0xda, 0x76, 0xbc, 0x58, 0x93, 0xb3, 0xdc, 0x76, 0xbc, 0x58, 0xbb, 0x5b,
+	0x68, 0x1c, 0xba, 0x1d, 0xe6, 0xb2, 0x0a, 0x73, 0x1d, 0xdc, 0xdb, 0x45, 0xf7, 0xef, 0xf2, 0xa8,
+	0xcb, 0x3a, 0xea, 0xd7, 0x27, 0xac, 0x17, 0x82, 0x46, 0x70, 0xa2, 0x50, 0x86, 0x3a, 0xc0, 0x33,
+	0xaa, 0x6b, 0xd7, 0x87, 0xba, 0x92, 0x4e, 0x19, 0x13, 0x2a, 0xde, 0x38, 0xca, 0x9b, 0xda, 0x71,
+	0xa4, 0xf4, 0x61, 0x14, 0x09, 0xdd, 0xb1, 0x41, 0x75, 0xed, 0x7e, 0x82, 0xb6, 0xf9, 0x8a, 0xc9,
+	0x23, 0x13, 0xd9, 0x53, 0x9c, 0xa2, 0x07, 0x95, 0x94, 0x31, 0x91, 0x11, 0x4b, 0xc7, 0x87, 0xc7,
+	0x1e, 0xd4, 0xc5, 0xd4, 0x08, 0xdc, 0x09, 0xb4, 0x14, 0x75, 0x17, 0x8a, 0x68, 0x26, 0x43, 0xc9,
+	0x4e, 0xce, 0x89, 0xe0, 0x6c, 0xb9, 0x64, 0xf9, 0x98, 0xba, 0x2e, 0x84, 0x5e, 0x2e, 0x86, 0x7e,
+	0xb5, 0x80, 0xce, 0x7f, 0xbf, 0x14, 0xb6, 0xa0, 0x41, 0x87, 0x5f, 0xe7, 0x0f, 0xf7, 0x93, 0x79,
+	0xd0, 0x2d, 0x61, 0x0f, 0xf0, 0x00, 0x1f, 0x68, 0x30, 0x9b, 0x4e, 0xc6, 0xb3, 0xa0, 0x6b, 0x61,
+	0x07, 0x9a, 0x9a, 0x1f, 0x4e, 0xa7, 0xc1, 0xf8, 0x4b, 0xd7, 0x46, 0x02, 0x17, 0x05, 0xe2, 0x9f,
+	0xb4, 0x7c, 0xf5, 0x01, 0x5a, 0x47, 0x61, 0x1e, 0xce, 0x8e, 0x27, 0x74, 0x34, 0xfc, 0xd1, 0x2d,
+	0xe1, 0x39, 0x74, 0x34, 0x31, 0x0a, 0x46, 0xb7, 0x01, 0x9d, 0xdd, 0x7d, 0x9b, 0x76, 0xad, 0xc1,
+	0x10, 0xaa, 0xdf, 0xef, 0xd5, 0x41, 0xfc, 0x08, 0x4e, 0x26, 0x59, 0x8a, 0xaf, 0x4e, 0x2e, 0xc2,
+	0x65, 0xcf, 0x37, 0xbb, 0xe5, 0xef, 0x77, 0xcb, 0x0f, 0xd4, 0x6e, 0xb9, 0xa5, 0xdb, 0xc6, 0xcf,
+	0x9a, 0xff, 0xd9, 0xb0, 0x55, 0xfd, 0x78, 0xff, 0x77, 0x00, 0xd4, 0xb1, 0x44, 0xea, 0x9e, 0x03,
+	0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// KVRaftClient is the client API for KVRaft service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type KVRaftClient interface {
+	// Deliver a message to the replica. Messages could be lost or reordered, Raft copes with it.
+	Step(ctx context.Context, in *RaftMessage, opts ...grpc.CallOption) (*empty.Empty, error)
+}
+
+type kVRaftClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKVRaftClient(cc grpc.ClientConnInterface) KVRaftClient {
+	return &kVRaftClient{cc}
+}
+
+func (c *kVRaftClient) Step(ctx context.Context, in *RaftMessage, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVRaft/step", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KVRaftServer is the server API for KVRaft service.
+type KVRaftServer interface {
+	// Deliver a message to the replica. Messages could be lost or reordered, Raft copes with it.
+	Step(context.Context, *RaftMessage) (*empty.Empty, error)
+}
+
+// UnimplementedKVRaftServer can be embedded to have forward compatible implementations.
+type UnimplementedKVRaftServer struct {
+}
+
+func (*UnimplementedKVRaftServer) Step(ctx context.Context, req *RaftMessage) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Step not implemented")
+}
+
+func RegisterKVRaftServer(s *grpc.Server, srv KVRaftServer) {
+	s.RegisterService(&_KVRaft_serviceDesc, srv)
+}
+
+func _KVRaft_Step_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RaftMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVRaftServer).Step(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVRaft/Step",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVRaftServer).Step(ctx, req.(*RaftMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _KVRaft_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "kv.proto.KVRaft",
+	HandlerType: (*KVRaftServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "step",
+			Handler:    _KVRaft_Step_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "raft.proto",
+}
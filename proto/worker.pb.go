@@ -149,10 +149,648 @@ func (m *DeleteResponse) GetStatus() Status {
 	return Status_OK
 }
 
+type ScanRequest struct {
+	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// exclusive lower bound of keys, empty to start from the beginning
+	Start                string   `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	Limit                uint32   `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	SlotVersion          uint32   `protobuf:"varint,4,opt,name=slotVersion,proto3" json:"slotVersion,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ScanRequest) Reset()         { *m = ScanRequest{} }
+func (m *ScanRequest) String() string { return proto.CompactTextString(m) }
+func (*ScanRequest) ProtoMessage()    {}
+func (*ScanRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{3}
+}
+
+func (m *ScanRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ScanRequest.Unmarshal(m, b)
+}
+func (m *ScanRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ScanRequest.Marshal(b, m, deterministic)
+}
+func (m *ScanRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ScanRequest.Merge(m, src)
+}
+func (m *ScanRequest) XXX_Size() int {
+	return xxx_messageInfo_ScanRequest.Size(m)
+}
+func (m *ScanRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ScanRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ScanRequest proto.InternalMessageInfo
+
+func (m *ScanRequest) GetPrefix() string {
+	if m != nil {
+		return m.Prefix
+	}
+	return ""
+}
+
+func (m *ScanRequest) GetStart() string {
+	if m != nil {
+		return m.Start
+	}
+	return ""
+}
+
+func (m *ScanRequest) GetLimit() uint32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+func (m *ScanRequest) GetSlotVersion() uint32 {
+	if m != nil {
+		return m.SlotVersion
+	}
+	return 0
+}
+
+type ScanResponse struct {
+	Status               Status    `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	Pairs                []*KVPair `protobuf:"bytes,2,rep,name=pairs,proto3" json:"pairs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *ScanResponse) Reset()         { *m = ScanResponse{} }
+func (m *ScanResponse) String() string { return proto.CompactTextString(m) }
+func (*ScanResponse) ProtoMessage()    {}
+func (*ScanResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{4}
+}
+
+func (m *ScanResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ScanResponse.Unmarshal(m, b)
+}
+func (m *ScanResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ScanResponse.Marshal(b, m, deterministic)
+}
+func (m *ScanResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ScanResponse.Merge(m, src)
+}
+func (m *ScanResponse) XXX_Size() int {
+	return xxx_messageInfo_ScanResponse.Size(m)
+}
+func (m *ScanResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ScanResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ScanResponse proto.InternalMessageInfo
+
+func (m *ScanResponse) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *ScanResponse) GetPairs() []*KVPair {
+	if m != nil {
+		return m.Pairs
+	}
+	return nil
+}
+
+type WatchRequest struct {
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// watch every key starting with `key`
+	Prefix bool `protobuf:"varint,2,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// replay retained events after this version, 0 for new events only
+	FromVersion uint64 `protobuf:"varint,3,opt,name=fromVersion,proto3" json:"fromVersion,omitempty"`
+	// if fromVersion is 0, replay retained events since this worker adopted this slot table version
+	SinceSlotVersion     uint32   `protobuf:"varint,4,opt,name=sinceSlotVersion,proto3" json:"sinceSlotVersion,omitempty"`
+	SlotVersion          uint32   `protobuf:"varint,5,opt,name=slotVersion,proto3" json:"slotVersion,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchRequest) Reset()         { *m = WatchRequest{} }
+func (m *WatchRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchRequest) ProtoMessage()    {}
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{5}
+}
+
+func (m *WatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchRequest.Unmarshal(m, b)
+}
+func (m *WatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchRequest.Merge(m, src)
+}
+func (m *WatchRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchRequest.Size(m)
+}
+func (m *WatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchRequest proto.InternalMessageInfo
+
+func (m *WatchRequest) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *WatchRequest) GetPrefix() bool {
+	if m != nil {
+		return m.Prefix
+	}
+	return false
+}
+
+func (m *WatchRequest) GetFromVersion() uint64 {
+	if m != nil {
+		return m.FromVersion
+	}
+	return 0
+}
+
+func (m *WatchRequest) GetSinceSlotVersion() uint32 {
+	if m != nil {
+		return m.SinceSlotVersion
+	}
+	return 0
+}
+
+func (m *WatchRequest) GetSlotVersion() uint32 {
+	if m != nil {
+		return m.SlotVersion
+	}
+	return 0
+}
+
+// An event with op GET carries no change, only the version this worker has reached.
+type WatchEvent struct {
+	Status               Status    `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	Op                   Operation `protobuf:"varint,2,opt,name=op,proto3,enum=kv.proto.Operation" json:"op,omitempty"`
+	Key                  string    `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	Value                string    `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	Version              uint64    `protobuf:"varint,5,opt,name=version,proto3" json:"version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *WatchEvent) Reset()         { *m = WatchEvent{} }
+func (m *WatchEvent) String() string { return proto.CompactTextString(m) }
+func (*WatchEvent) ProtoMessage()    {}
+func (*WatchEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{6}
+}
+
+func (m *WatchEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchEvent.Unmarshal(m, b)
+}
+func (m *WatchEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchEvent.Marshal(b, m, deterministic)
+}
+func (m *WatchEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchEvent.Merge(m, src)
+}
+func (m *WatchEvent) XXX_Size() int {
+	return xxx_messageInfo_WatchEvent.Size(m)
+}
+func (m *WatchEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchEvent proto.InternalMessageInfo
+
+func (m *WatchEvent) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *WatchEvent) GetOp() Operation {
+	if m != nil {
+		return m.Op
+	}
+	return Operation_GET
+}
+
+func (m *WatchEvent) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *WatchEvent) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+func (m *WatchEvent) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+type PublishRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel,omitempty"`
+	Payload string `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	// keep the last `retain` messages of the channel in the store, 0 to keep none
+	Retain               uint32   `protobuf:"varint,3,opt,name=retain,proto3" json:"retain,omitempty"`
+	SlotVersion          uint32   `protobuf:"varint,4,opt,name=slotVersion,proto3" json:"slotVersion,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PublishRequest) Reset()         { *m = PublishRequest{} }
+func (m *PublishRequest) String() string { return proto.CompactTextString(m) }
+func (*PublishRequest) ProtoMessage()    {}
+func (*PublishRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{7}
+}
+
+func (m *PublishRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PublishRequest.Unmarshal(m, b)
+}
+func (m *PublishRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PublishRequest.Marshal(b, m, deterministic)
+}
+func (m *PublishRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PublishRequest.Merge(m, src)
+}
+func (m *PublishRequest) XXX_Size() int {
+	return xxx_messageInfo_PublishRequest.Size(m)
+}
+func (m *PublishRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PublishRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PublishRequest proto.InternalMessageInfo
+
+func (m *PublishRequest) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *PublishRequest) GetPayload() string {
+	if m != nil {
+		return m.Payload
+	}
+	return ""
+}
+
+func (m *PublishRequest) GetRetain() uint32 {
+	if m != nil {
+		return m.Retain
+	}
+	return 0
+}
+
+func (m *PublishRequest) GetSlotVersion() uint32 {
+	if m != nil {
+		return m.SlotVersion
+	}
+	return 0
+}
+
+type PublishResponse struct {
+	Status Status `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	Id     uint64 `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	// number of subscribers the message is delivered to
+	Receivers            uint32   `protobuf:"varint,3,opt,name=receivers,proto3" json:"receivers,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PublishResponse) Reset()         { *m = PublishResponse{} }
+func (m *PublishResponse) String() string { return proto.CompactTextString(m) }
+func (*PublishResponse) ProtoMessage()    {}
+func (*PublishResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{8}
+}
+
+func (m *PublishResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PublishResponse.Unmarshal(m, b)
+}
+func (m *PublishResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PublishResponse.Marshal(b, m, deterministic)
+}
+func (m *PublishResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PublishResponse.Merge(m, src)
+}
+func (m *PublishResponse) XXX_Size() int {
+	return xxx_messageInfo_PublishResponse.Size(m)
+}
+func (m *PublishResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PublishResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PublishResponse proto.InternalMessageInfo
+
+func (m *PublishResponse) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *PublishResponse) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *PublishResponse) GetReceivers() uint32 {
+	if m != nil {
+		return m.Receivers
+	}
+	return 0
+}
+
+type SubscribeRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel,omitempty"`
+	// replay retained messages with id after `afterId` before new ones
+	Replay               bool     `protobuf:"varint,2,opt,name=replay,proto3" json:"replay,omitempty"`
+	AfterId              uint64   `protobuf:"varint,3,opt,name=afterId,proto3" json:"afterId,omitempty"`
+	SlotVersion          uint32   `protobuf:"varint,4,opt,name=slotVersion,proto3" json:"slotVersion,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()    {}
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{9}
+}
+
+func (m *SubscribeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SubscribeRequest.Unmarshal(m, b)
+}
+func (m *SubscribeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SubscribeRequest.Marshal(b, m, deterministic)
+}
+func (m *SubscribeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SubscribeRequest.Merge(m, src)
+}
+func (m *SubscribeRequest) XXX_Size() int {
+	return xxx_messageInfo_SubscribeRequest.Size(m)
+}
+func (m *SubscribeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SubscribeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SubscribeRequest proto.InternalMessageInfo
+
+func (m *SubscribeRequest) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *SubscribeRequest) GetReplay() bool {
+	if m != nil {
+		return m.Replay
+	}
+	return false
+}
+
+func (m *SubscribeRequest) GetAfterId() uint64 {
+	if m != nil {
+		return m.AfterId
+	}
+	return 0
+}
+
+func (m *SubscribeRequest) GetSlotVersion() uint32 {
+	if m != nil {
+		return m.SlotVersion
+	}
+	return 0
+}
+
+// Message ids increase in each channel, and only continue across restarts & migrations while
+// some messages of the channel are retained.
+type Message struct {
+	Status               Status   `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	Channel              string   `protobuf:"bytes,2,opt,name=channel,proto3" json:"channel,omitempty"`
+	Id                   uint64   `protobuf:"varint,3,opt,name=id,proto3" json:"id,omitempty"`
+	Payload              string   `protobuf:"bytes,4,opt,name=payload,proto3" json:"payload,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+func (*Message) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{10}
+}
+
+func (m *Message) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Message.Unmarshal(m, b)
+}
+func (m *Message) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Message.Marshal(b, m, deterministic)
+}
+func (m *Message) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Message.Merge(m, src)
+}
+func (m *Message) XXX_Size() int {
+	return xxx_messageInfo_Message.Size(m)
+}
+func (m *Message) XXX_DiscardUnknown() {
+	xxx_messageInfo_Message.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Message proto.InternalMessageInfo
+
+func (m *Message) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *Message) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *Message) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Message) GetPayload() string {
+	if m != nil {
+		return m.Payload
+	}
+	return ""
+}
+
+type LockRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// token of the lease, for renewing & releasing. When acquiring, an optional one chosen by the client,
+	// so that a retried request gets the lease it has already been given
+	Token string `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	// lease duration in milliseconds, for acquiring & renewing
+	Ttl                  uint32   `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	SlotVersion          uint32   `protobuf:"varint,4,opt,name=slotVersion,proto3" json:"slotVersion,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LockRequest) Reset()         { *m = LockRequest{} }
+func (m *LockRequest) String() string { return proto.CompactTextString(m) }
+func (*LockRequest) ProtoMessage()    {}
+func (*LockRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{11}
+}
+
+func (m *LockRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LockRequest.Unmarshal(m, b)
+}
+func (m *LockRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LockRequest.Marshal(b, m, deterministic)
+}
+func (m *LockRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LockRequest.Merge(m, src)
+}
+func (m *LockRequest) XXX_Size() int {
+	return xxx_messageInfo_LockRequest.Size(m)
+}
+func (m *LockRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LockRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LockRequest proto.InternalMessageInfo
+
+func (m *LockRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *LockRequest) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+func (m *LockRequest) GetTtl() uint32 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+func (m *LockRequest) GetSlotVersion() uint32 {
+	if m != nil {
+		return m.SlotVersion
+	}
+	return 0
+}
+
+type LockResponse struct {
+	Status Status `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	Token  string `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	// fencing token, increasing each time the lock is acquired
+	Fence                uint64   `protobuf:"varint,3,opt,name=fence,proto3" json:"fence,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LockResponse) Reset()         { *m = LockResponse{} }
+func (m *LockResponse) String() string { return proto.CompactTextString(m) }
+func (*LockResponse) ProtoMessage()    {}
+func (*LockResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e4ff6184b07e587a, []int{12}
+}
+
+func (m *LockResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LockResponse.Unmarshal(m, b)
+}
+func (m *LockResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LockResponse.Marshal(b, m, deterministic)
+}
+func (m *LockResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LockResponse.Merge(m, src)
+}
+func (m *LockResponse) XXX_Size() int {
+	return xxx_messageInfo_LockResponse.Size(m)
+}
+func (m *LockResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_LockResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LockResponse proto.InternalMessageInfo
+
+func (m *LockResponse) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *LockResponse) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+func (m *LockResponse) GetFence() uint64 {
+	if m != nil {
+		return m.Fence
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*PutResponse)(nil), "kv.proto.PutResponse")
 	proto.RegisterType((*GetResponse)(nil), "kv.proto.GetResponse")
 	proto.RegisterType((*DeleteResponse)(nil), "kv.proto.DeleteResponse")
+	proto.RegisterType((*ScanRequest)(nil), "kv.proto.ScanRequest")
+	proto.RegisterType((*ScanResponse)(nil), "kv.proto.ScanResponse")
+	proto.RegisterType((*WatchRequest)(nil), "kv.proto.WatchRequest")
+	proto.RegisterType((*WatchEvent)(nil), "kv.proto.WatchEvent")
+	proto.RegisterType((*PublishRequest)(nil), "kv.proto.PublishRequest")
+	proto.RegisterType((*PublishResponse)(nil), "kv.proto.PublishResponse")
+	proto.RegisterType((*SubscribeRequest)(nil), "kv.proto.SubscribeRequest")
+	proto.RegisterType((*Message)(nil), "kv.proto.Message")
+	proto.RegisterType((*LockRequest)(nil), "kv.proto.LockRequest")
+	proto.RegisterType((*LockResponse)(nil), "kv.proto.LockResponse")
 }
 
 func init() {
@@ -160,21 +798,53 @@ func init() {
 }
 
 var fileDescriptor_e4ff6184b07e587a = []byte{
-	// 218 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x29, 0xcf, 0x2f, 0xca,
-	0x4e, 0x2d, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0xc8, 0x2e, 0x83, 0xb0, 0xa4, 0x78,
-	0x92, 0xf3, 0x73, 0x73, 0xf3, 0xf3, 0x20, 0x3c, 0x25, 0x73, 0x2e, 0xee, 0x80, 0xd2, 0x92, 0xa0,
-	0xd4, 0xe2, 0x82, 0xfc, 0xbc, 0xe2, 0x54, 0x21, 0x0d, 0x2e, 0xb6, 0xe2, 0x92, 0xc4, 0x92, 0xd2,
-	0x62, 0x09, 0x46, 0x05, 0x46, 0x0d, 0x3e, 0x23, 0x01, 0x3d, 0x98, 0x3e, 0xbd, 0x60, 0xb0, 0x78,
-	0x10, 0x54, 0x5e, 0xc9, 0x97, 0x8b, 0xdb, 0x3d, 0x95, 0x0c, 0x8d, 0x42, 0x22, 0x5c, 0xac, 0x65,
-	0x89, 0x39, 0xa5, 0xa9, 0x12, 0x4c, 0x0a, 0x8c, 0x1a, 0x9c, 0x41, 0x10, 0x8e, 0x92, 0x15, 0x17,
-	0x9f, 0x4b, 0x6a, 0x4e, 0x6a, 0x49, 0x2a, 0xe9, 0x26, 0x1a, 0x2d, 0x60, 0xe4, 0xe2, 0xf0, 0x0e,
-	0x0b, 0x07, 0x7b, 0x57, 0xc8, 0x80, 0x8b, 0xb9, 0xa0, 0xb4, 0x44, 0x08, 0x49, 0xb5, 0x77, 0x58,
-	0x40, 0x62, 0x66, 0x91, 0x94, 0x28, 0x42, 0x04, 0xc9, 0xc7, 0x4a, 0x0c, 0x42, 0xba, 0x5c, 0xcc,
-	0xe9, 0xa9, 0x25, 0x42, 0xbc, 0x48, 0x3a, 0x52, 0x2b, 0x91, 0x95, 0x23, 0xf9, 0x53, 0x89, 0x41,
-	0xc8, 0x98, 0x8b, 0x2d, 0x05, 0xec, 0x52, 0x74, 0x1d, 0x12, 0x08, 0x2e, 0xaa, 0x57, 0x94, 0x18,
-	0x9c, 0x38, 0xa3, 0xd8, 0xf5, 0xac, 0xc1, 0x72, 0x49, 0x6c, 0x60, 0xca, 0x18, 0x10, 0x00, 0x00,
-	0xff, 0xff, 0x9a, 0xf3, 0xba, 0x02, 0xa0, 0x01, 0x00, 0x00,
+	// 732 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0xc1, 0x6a, 0xdb, 0x40,
+	0x10, 0xb5, 0x6c, 0xd9, 0x8e, 0x47, 0x8e, 0xeb, 0x6e, 0xd3, 0xa0, 0x8a, 0x1e, 0x8c, 0x0a, 0xc5,
+	0x14, 0x6a, 0x42, 0x72, 0x08, 0xb4, 0x34, 0x94, 0xd2, 0x52, 0x4a, 0x12, 0x1a, 0x64, 0x48, 0xa0,
+	0xa7, 0xae, 0xe5, 0x71, 0xb2, 0x58, 0x96, 0x94, 0xdd, 0x95, 0x5d, 0x5f, 0x4a, 0x7f, 0xa4, 0xf7,
+	0xfe, 0x52, 0xff, 0xa6, 0x68, 0x25, 0xd9, 0x1b, 0x3b, 0xd0, 0xd8, 0x27, 0xef, 0x9b, 0xd9, 0xf1,
+	0xbc, 0x37, 0x7a, 0x3b, 0xd0, 0x9c, 0x45, 0x7c, 0x8c, 0xbc, 0x17, 0xf3, 0x48, 0x46, 0x64, 0x67,
+	0x3c, 0xcd, 0x4e, 0x4e, 0xd3, 0x8f, 0x26, 0x93, 0x28, 0xcc, 0x90, 0x7b, 0x0c, 0xd6, 0x45, 0x22,
+	0x3d, 0x14, 0x71, 0x14, 0x0a, 0x24, 0x5d, 0xa8, 0x09, 0x49, 0x65, 0x22, 0x6c, 0xa3, 0x63, 0x74,
+	0x5b, 0x87, 0xed, 0x5e, 0x51, 0xd7, 0xeb, 0xab, 0xb8, 0x97, 0xe7, 0xdd, 0x73, 0xb0, 0x3e, 0xe3,
+	0x16, 0x85, 0x64, 0x0f, 0xaa, 0x53, 0x1a, 0x24, 0x68, 0x97, 0x3b, 0x46, 0xb7, 0xe1, 0x65, 0xc0,
+	0x7d, 0x03, 0xad, 0x8f, 0x18, 0xa0, 0xc4, 0x2d, 0xa8, 0x08, 0xb0, 0xfa, 0x3e, 0x0d, 0x3d, 0xbc,
+	0x4d, 0x50, 0x48, 0xb2, 0x0f, 0xb5, 0x98, 0xe3, 0x88, 0xfd, 0x50, 0x85, 0x0d, 0x2f, 0x47, 0x69,
+	0x63, 0x21, 0x29, 0x97, 0x45, 0x63, 0x05, 0xd2, 0x68, 0xc0, 0x26, 0x4c, 0xda, 0x95, 0x8e, 0xd1,
+	0xdd, 0xf5, 0x32, 0x40, 0x3a, 0x60, 0x89, 0x20, 0x92, 0x97, 0xc8, 0x05, 0x8b, 0x42, 0xdb, 0x54,
+	0x39, 0x3d, 0xe4, 0x7e, 0x87, 0x66, 0xd6, 0x74, 0xe3, 0x01, 0xbc, 0x84, 0x6a, 0x4c, 0x19, 0x17,
+	0x76, 0xb9, 0x53, 0xe9, 0x5a, 0xfa, 0xc5, 0xd3, 0xcb, 0x0b, 0xca, 0xb8, 0x97, 0xa5, 0xdd, 0x3f,
+	0x06, 0x34, 0xaf, 0xa8, 0xf4, 0x6f, 0x0a, 0x61, 0x6d, 0xa8, 0x8c, 0x71, 0x9e, 0xab, 0x4a, 0x8f,
+	0x9a, 0xd4, 0x54, 0xd3, 0xce, 0x42, 0x6a, 0x07, 0xac, 0x11, 0x8f, 0x26, 0x05, 0xfd, 0x54, 0x9a,
+	0xe9, 0xe9, 0x21, 0xf2, 0x0a, 0xda, 0x82, 0x85, 0x3e, 0xf6, 0xd7, 0x54, 0xae, 0xc5, 0x57, 0x87,
+	0x51, 0x5d, 0x1f, 0xc6, 0x6f, 0x03, 0x40, 0x51, 0xfd, 0x34, 0xc5, 0x50, 0x6e, 0x30, 0x8b, 0x17,
+	0x50, 0x8e, 0x62, 0x45, 0xbe, 0x75, 0xf8, 0x64, 0x79, 0xeb, 0x6b, 0x8c, 0x9c, 0x4a, 0x16, 0x85,
+	0x5e, 0x39, 0x8a, 0x0b, 0xdd, 0x95, 0xa5, 0xee, 0x85, 0x87, 0x4c, 0xcd, 0x43, 0xc4, 0x86, 0xfa,
+	0x54, 0xe3, 0x68, 0x7a, 0x05, 0x74, 0x7f, 0x42, 0xeb, 0x22, 0x19, 0x04, 0x4c, 0x2c, 0x66, 0x69,
+	0x43, 0xdd, 0xbf, 0xa1, 0x61, 0x88, 0x41, 0x3e, 0xcf, 0x02, 0xa6, 0x99, 0x98, 0xce, 0x83, 0x88,
+	0x0e, 0x73, 0xa3, 0x14, 0x30, 0x9d, 0x36, 0x47, 0x49, 0x59, 0x98, 0x7b, 0x25, 0x47, 0x0f, 0x30,
+	0x0b, 0x83, 0x47, 0x8b, 0xfe, 0x1b, 0xfb, 0xa5, 0x05, 0x65, 0x96, 0x71, 0x31, 0xbd, 0x32, 0x1b,
+	0x92, 0xe7, 0xd0, 0xe0, 0xe8, 0x23, 0x4b, 0xc5, 0xe5, 0x4c, 0x96, 0x01, 0xf7, 0x97, 0x01, 0xed,
+	0x7e, 0x32, 0x10, 0x3e, 0x67, 0x03, 0xfc, 0xbf, 0x5a, 0xa5, 0x29, 0x0e, 0xe8, 0xbc, 0x70, 0x50,
+	0x86, 0xd2, 0x0a, 0x3a, 0x92, 0xc8, 0xbf, 0x0c, 0x73, 0xf7, 0x14, 0xf0, 0x01, 0x6a, 0x67, 0x50,
+	0x3f, 0x47, 0x21, 0xe8, 0xf5, 0x26, 0x2a, 0x35, 0x8a, 0xe5, 0xbb, 0x14, 0x33, 0xfd, 0x95, 0x85,
+	0x7e, 0xed, 0x03, 0x99, 0x77, 0x3e, 0x90, 0x3b, 0x06, 0xeb, 0x2c, 0xf2, 0xc7, 0x85, 0x6a, 0x02,
+	0x66, 0x48, 0x27, 0x98, 0x4b, 0x56, 0xe7, 0xd4, 0x39, 0x32, 0x1a, 0x63, 0x58, 0x2c, 0x01, 0x05,
+	0x52, 0x87, 0x49, 0x19, 0xe4, 0xc3, 0x4c, 0x8f, 0x0f, 0x50, 0x39, 0x84, 0x66, 0xd6, 0x6c, 0x9b,
+	0x0d, 0x78, 0x0f, 0x87, 0x3d, 0xa8, 0x8e, 0x30, 0xf4, 0x31, 0x57, 0x9a, 0x81, 0xc3, 0xbf, 0x26,
+	0xec, 0x9c, 0x5e, 0x5e, 0xa9, 0x55, 0x4e, 0x0e, 0xa0, 0x12, 0x27, 0x92, 0xac, 0x6d, 0x0c, 0xe7,
+	0xe9, 0x32, 0xa2, 0x6d, 0x73, 0xb7, 0x44, 0x5e, 0x43, 0xe5, 0x1a, 0x25, 0xd9, 0xd5, 0x2a, 0x70,
+	0xae, 0x5f, 0xd7, 0x76, 0xb8, 0x5b, 0x22, 0x47, 0x50, 0x1b, 0xaa, 0x2d, 0xbc, 0x5a, 0x61, 0x2f,
+	0xe1, 0xdd, 0x35, 0xed, 0x96, 0xc8, 0x31, 0x98, 0xc2, 0xa7, 0x21, 0xd1, 0xfe, 0x55, 0x5b, 0xc7,
+	0xce, 0xfe, 0x6a, 0x78, 0x51, 0xf8, 0x0e, 0x1a, 0xb3, 0x74, 0x69, 0x9c, 0xe2, 0x5c, 0x10, 0xed,
+	0x9a, 0xbe, 0xf4, 0x9c, 0xbd, 0x95, 0xb8, 0xda, 0x30, 0x6e, 0xe9, 0xc0, 0x20, 0xef, 0xa1, 0x1e,
+	0x67, 0x8f, 0x8a, 0xd8, 0xba, 0x7e, 0xfd, 0x9d, 0x3b, 0xcf, 0xee, 0xc9, 0x2c, 0x08, 0x9c, 0x40,
+	0x43, 0x14, 0x4f, 0x85, 0x38, 0x1a, 0xcf, 0x95, 0xf7, 0xe3, 0x3c, 0x5e, 0xe6, 0x72, 0x67, 0x2b,
+	0x06, 0x27, 0x60, 0x51, 0xff, 0x36, 0x61, 0x1c, 0x53, 0x27, 0xe8, 0x03, 0xd0, 0x6c, 0xe8, 0xec,
+	0xaf, 0x86, 0xb5, 0x01, 0x00, 0xc7, 0x10, 0x67, 0x67, 0x48, 0x05, 0x6e, 0x5e, 0x7e, 0x02, 0x16,
+	0xc7, 0x20, 0xad, 0xdd, 0xaa, 0xfd, 0x87, 0xc6, 0xb7, 0x7a, 0xef, 0xad, 0xca, 0x0c, 0x6a, 0xea,
+	0xe7, 0xe8, 0xdf, 0x00, 0xcf, 0xa2, 0x2b, 0x70, 0x35, 0x08, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -192,6 +862,24 @@ type KVWorkerClient interface {
 	Put(ctx context.Context, in *KVPair, opts ...grpc.CallOption) (*PutResponse, error)
 	Get(ctx context.Context, in *Key, opts ...grpc.CallOption) (*GetResponse, error)
 	Delete(ctx context.Context, in *Key, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Scan keys in order. Only keys of this worker are returned,
+	// the caller has to merge results from all workers.
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanResponse, error)
+	// Stream changes of a key, or of all keys with a prefix, as they are committed.
+	// The stream ends with a non-OK status once this worker stops serving the request,
+	// e.g. EINVVERSION after a migration, or EINVSERVER after stepping down.
+	WatchKeys(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (KVWorker_WatchKeysClient, error)
+	// Publish a message to a channel, channels are hashed to workers like keys.
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error)
+	// Stream messages published to a channel. Like watchKeys, the stream ends with a non-OK status
+	// once this worker stops serving the channel.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (KVWorker_SubscribeClient, error)
+	// Acquire a lock for `ttl` milliseconds, returns ELOCKED if someone else holds it.
+	AcquireLock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error)
+	// Extend a lease to `ttl` milliseconds from now, returns ENOENT if it has been lost.
+	RenewLease(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error)
+	// Release a lock, returns ENOENT if the lease has been lost.
+	ReleaseLock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error)
 }
 
 type kVWorkerClient struct {
@@ -229,11 +917,138 @@ func (c *kVWorkerClient) Delete(ctx context.Context, in *Key, opts ...grpc.CallO
 	return out, nil
 }
 
+func (c *kVWorkerClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanResponse, error) {
+	out := new(ScanResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVWorker/scan", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVWorkerClient) WatchKeys(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (KVWorker_WatchKeysClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_KVWorker_serviceDesc.Streams[0], "/kv.proto.KVWorker/watchKeys", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVWorkerWatchKeysClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KVWorker_WatchKeysClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type kVWorkerWatchKeysClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVWorkerWatchKeysClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVWorkerClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error) {
+	out := new(PublishResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVWorker/publish", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVWorkerClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (KVWorker_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_KVWorker_serviceDesc.Streams[1], "/kv.proto.KVWorker/subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVWorkerSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KVWorker_SubscribeClient interface {
+	Recv() (*Message, error)
+	grpc.ClientStream
+}
+
+type kVWorkerSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVWorkerSubscribeClient) Recv() (*Message, error) {
+	m := new(Message)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVWorkerClient) AcquireLock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error) {
+	out := new(LockResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVWorker/acquireLock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVWorkerClient) RenewLease(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error) {
+	out := new(LockResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVWorker/renewLease", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVWorkerClient) ReleaseLock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error) {
+	out := new(LockResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVWorker/releaseLock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // KVWorkerServer is the server API for KVWorker service.
 type KVWorkerServer interface {
 	Put(context.Context, *KVPair) (*PutResponse, error)
 	Get(context.Context, *Key) (*GetResponse, error)
 	Delete(context.Context, *Key) (*DeleteResponse, error)
+	// Scan keys in order. Only keys of this worker are returned,
+	// the caller has to merge results from all workers.
+	Scan(context.Context, *ScanRequest) (*ScanResponse, error)
+	// Stream changes of a key, or of all keys with a prefix, as they are committed.
+	// The stream ends with a non-OK status once this worker stops serving the request,
+	// e.g. EINVVERSION after a migration, or EINVSERVER after stepping down.
+	WatchKeys(*WatchRequest, KVWorker_WatchKeysServer) error
+	// Publish a message to a channel, channels are hashed to workers like keys.
+	Publish(context.Context, *PublishRequest) (*PublishResponse, error)
+	// Stream messages published to a channel. Like watchKeys, the stream ends with a non-OK status
+	// once this worker stops serving the channel.
+	Subscribe(*SubscribeRequest, KVWorker_SubscribeServer) error
+	// Acquire a lock for `ttl` milliseconds, returns ELOCKED if someone else holds it.
+	AcquireLock(context.Context, *LockRequest) (*LockResponse, error)
+	// Extend a lease to `ttl` milliseconds from now, returns ENOENT if it has been lost.
+	RenewLease(context.Context, *LockRequest) (*LockResponse, error)
+	// Release a lock, returns ENOENT if the lease has been lost.
+	ReleaseLock(context.Context, *LockRequest) (*LockResponse, error)
 }
 
 // UnimplementedKVWorkerServer can be embedded to have forward compatible implementations.
@@ -249,6 +1064,27 @@ func (*UnimplementedKVWorkerServer) Get(ctx context.Context, req *Key) (*GetResp
 func (*UnimplementedKVWorkerServer) Delete(ctx context.Context, req *Key) (*DeleteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
+func (*UnimplementedKVWorkerServer) Scan(ctx context.Context, req *ScanRequest) (*ScanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (*UnimplementedKVWorkerServer) WatchKeys(req *WatchRequest, srv KVWorker_WatchKeysServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchKeys not implemented")
+}
+func (*UnimplementedKVWorkerServer) Publish(ctx context.Context, req *PublishRequest) (*PublishResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+func (*UnimplementedKVWorkerServer) Subscribe(req *SubscribeRequest, srv KVWorker_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (*UnimplementedKVWorkerServer) AcquireLock(ctx context.Context, req *LockRequest) (*LockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AcquireLock not implemented")
+}
+func (*UnimplementedKVWorkerServer) RenewLease(ctx context.Context, req *LockRequest) (*LockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewLease not implemented")
+}
+func (*UnimplementedKVWorkerServer) ReleaseLock(ctx context.Context, req *LockRequest) (*LockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseLock not implemented")
+}
 
 func RegisterKVWorkerServer(s *grpc.Server, srv KVWorkerServer) {
 	s.RegisterService(&_KVWorker_serviceDesc, srv)
@@ -308,6 +1144,138 @@ func _KVWorker_Delete_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KVWorker_Scan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVWorkerServer).Scan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVWorker/Scan",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVWorkerServer).Scan(ctx, req.(*ScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVWorker_WatchKeys_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KVWorkerServer).WatchKeys(m, &kVWorkerWatchKeysServer{stream})
+}
+
+type KVWorker_WatchKeysServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type kVWorkerWatchKeysServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVWorkerWatchKeysServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KVWorker_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVWorkerServer).Publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVWorker/Publish",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVWorkerServer).Publish(ctx, req.(*PublishRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVWorker_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KVWorkerServer).Subscribe(m, &kVWorkerSubscribeServer{stream})
+}
+
+type KVWorker_SubscribeServer interface {
+	Send(*Message) error
+	grpc.ServerStream
+}
+
+type kVWorkerSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVWorkerSubscribeServer) Send(m *Message) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KVWorker_AcquireLock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVWorkerServer).AcquireLock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVWorker/AcquireLock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVWorkerServer).AcquireLock(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVWorker_RenewLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVWorkerServer).RenewLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVWorker/RenewLease",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVWorkerServer).RenewLease(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVWorker_ReleaseLock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVWorkerServer).ReleaseLock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVWorker/ReleaseLock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVWorkerServer).ReleaseLock(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _KVWorker_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "kv.proto.KVWorker",
 	HandlerType: (*KVWorkerServer)(nil),
@@ -324,7 +1292,38 @@ var _KVWorker_serviceDesc = grpc.ServiceDesc{
 			MethodName: "delete",
 			Handler:    _KVWorker_Delete_Handler,
 		},
+		{
+			MethodName: "scan",
+			Handler:    _KVWorker_Scan_Handler,
+		},
+		{
+			MethodName: "publish",
+			Handler:    _KVWorker_Publish_Handler,
+		},
+		{
+			MethodName: "acquireLock",
+			Handler:    _KVWorker_AcquireLock_Handler,
+		},
+		{
+			MethodName: "renewLease",
+			Handler:    _KVWorker_RenewLease_Handler,
+		},
+		{
+			MethodName: "releaseLock",
+			Handler:    _KVWorker_ReleaseLock_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "watchKeys",
+			Handler:       _KVWorker_WatchKeys_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "subscribe",
+			Handler:       _KVWorker_Subscribe_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "worker.proto",
 }
@@ -103,9 +103,439 @@ func (m *FlushResponse) GetStatus() Status {
 	return Status_OK
 }
 
+type LatencyHistogram struct {
+	Mode string `protobuf:"bytes,1,opt,name=mode,proto3" json:"mode,omitempty"`
+	// upper bounds of buckets in microseconds, the last bucket has no upper bound
+	Bounds               []uint64 `protobuf:"varint,2,rep,packed,name=bounds,proto3" json:"bounds,omitempty"`
+	Counts               []uint64 `protobuf:"varint,3,rep,packed,name=counts,proto3" json:"counts,omitempty"`
+	SumMicros            uint64   `protobuf:"varint,4,opt,name=sumMicros,proto3" json:"sumMicros,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LatencyHistogram) Reset()         { *m = LatencyHistogram{} }
+func (m *LatencyHistogram) String() string { return proto.CompactTextString(m) }
+func (*LatencyHistogram) ProtoMessage()    {}
+func (*LatencyHistogram) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8f142f2b1de3db81, []int{2}
+}
+
+func (m *LatencyHistogram) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LatencyHistogram.Unmarshal(m, b)
+}
+func (m *LatencyHistogram) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LatencyHistogram.Marshal(b, m, deterministic)
+}
+func (m *LatencyHistogram) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LatencyHistogram.Merge(m, src)
+}
+func (m *LatencyHistogram) XXX_Size() int {
+	return xxx_messageInfo_LatencyHistogram.Size(m)
+}
+func (m *LatencyHistogram) XXX_DiscardUnknown() {
+	xxx_messageInfo_LatencyHistogram.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LatencyHistogram proto.InternalMessageInfo
+
+func (m *LatencyHistogram) GetMode() string {
+	if m != nil {
+		return m.Mode
+	}
+	return ""
+}
+
+func (m *LatencyHistogram) GetBounds() []uint64 {
+	if m != nil {
+		return m.Bounds
+	}
+	return nil
+}
+
+func (m *LatencyHistogram) GetCounts() []uint64 {
+	if m != nil {
+		return m.Counts
+	}
+	return nil
+}
+
+func (m *LatencyHistogram) GetSumMicros() uint64 {
+	if m != nil {
+		return m.SumMicros
+	}
+	return 0
+}
+
+type BackupLag struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// version the backup has acknowledged
+	Version uint64 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	// versions and microseconds it is behind the primary
+	LagVersions uint64 `protobuf:"varint,3,opt,name=lagVersions,proto3" json:"lagVersions,omitempty"`
+	LagMicros   uint64 `protobuf:"varint,4,opt,name=lagMicros,proto3" json:"lagMicros,omitempty"`
+	// not counted toward acks of semi-sync mode for falling too far behind
+	Ejected   bool   `protobuf:"varint,5,opt,name=ejected,proto3" json:"ejected,omitempty"`
+	Ejections uint64 `protobuf:"varint,6,opt,name=ejections,proto3" json:"ejections,omitempty"`
+	// synced like a backup, but never counted toward acks
+	Learner              bool     `protobuf:"varint,7,opt,name=learner,proto3" json:"learner,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BackupLag) Reset()         { *m = BackupLag{} }
+func (m *BackupLag) String() string { return proto.CompactTextString(m) }
+func (*BackupLag) ProtoMessage()    {}
+func (*BackupLag) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8f142f2b1de3db81, []int{3}
+}
+
+func (m *BackupLag) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BackupLag.Unmarshal(m, b)
+}
+func (m *BackupLag) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BackupLag.Marshal(b, m, deterministic)
+}
+func (m *BackupLag) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BackupLag.Merge(m, src)
+}
+func (m *BackupLag) XXX_Size() int {
+	return xxx_messageInfo_BackupLag.Size(m)
+}
+func (m *BackupLag) XXX_DiscardUnknown() {
+	xxx_messageInfo_BackupLag.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BackupLag proto.InternalMessageInfo
+
+func (m *BackupLag) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *BackupLag) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *BackupLag) GetLagVersions() uint64 {
+	if m != nil {
+		return m.LagVersions
+	}
+	return 0
+}
+
+func (m *BackupLag) GetLagMicros() uint64 {
+	if m != nil {
+		return m.LagMicros
+	}
+	return 0
+}
+
+func (m *BackupLag) GetEjected() bool {
+	if m != nil {
+		return m.Ejected
+	}
+	return false
+}
+
+func (m *BackupLag) GetEjections() uint64 {
+	if m != nil {
+		return m.Ejections
+	}
+	return 0
+}
+
+func (m *BackupLag) GetLearner() bool {
+	if m != nil {
+		return m.Learner
+	}
+	return false
+}
+
+type ReplicationStatsResponse struct {
+	Status Status `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	// replication mode in use
+	Mode       string              `protobuf:"bytes,2,opt,name=mode,proto3" json:"mode,omitempty"`
+	Histograms []*LatencyHistogram `protobuf:"bytes,3,rep,name=histograms,proto3" json:"histograms,omitempty"`
+	// anti-entropy rounds done with backups, leaves of Merkle trees found diverged and keys repaired
+	AntiEntropyRounds uint64 `protobuf:"varint,4,opt,name=antiEntropyRounds,proto3" json:"antiEntropyRounds,omitempty"`
+	DivergedRanges    uint64 `protobuf:"varint,5,opt,name=divergedRanges,proto3" json:"divergedRanges,omitempty"`
+	RepairedKeys      uint64 `protobuf:"varint,6,opt,name=repairedKeys,proto3" json:"repairedKeys,omitempty"`
+	// in order of name
+	Backups []*BackupLag `protobuf:"bytes,7,rep,name=backups,proto3" json:"backups,omitempty"`
+	// refusing writes until enough backups are in sync
+	ReadOnly             bool     `protobuf:"varint,8,opt,name=readOnly,proto3" json:"readOnly,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReplicationStatsResponse) Reset()         { *m = ReplicationStatsResponse{} }
+func (m *ReplicationStatsResponse) String() string { return proto.CompactTextString(m) }
+func (*ReplicationStatsResponse) ProtoMessage()    {}
+func (*ReplicationStatsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8f142f2b1de3db81, []int{4}
+}
+
+func (m *ReplicationStatsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReplicationStatsResponse.Unmarshal(m, b)
+}
+func (m *ReplicationStatsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReplicationStatsResponse.Marshal(b, m, deterministic)
+}
+func (m *ReplicationStatsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReplicationStatsResponse.Merge(m, src)
+}
+func (m *ReplicationStatsResponse) XXX_Size() int {
+	return xxx_messageInfo_ReplicationStatsResponse.Size(m)
+}
+func (m *ReplicationStatsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReplicationStatsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReplicationStatsResponse proto.InternalMessageInfo
+
+func (m *ReplicationStatsResponse) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *ReplicationStatsResponse) GetMode() string {
+	if m != nil {
+		return m.Mode
+	}
+	return ""
+}
+
+func (m *ReplicationStatsResponse) GetHistograms() []*LatencyHistogram {
+	if m != nil {
+		return m.Histograms
+	}
+	return nil
+}
+
+func (m *ReplicationStatsResponse) GetAntiEntropyRounds() uint64 {
+	if m != nil {
+		return m.AntiEntropyRounds
+	}
+	return 0
+}
+
+func (m *ReplicationStatsResponse) GetDivergedRanges() uint64 {
+	if m != nil {
+		return m.DivergedRanges
+	}
+	return 0
+}
+
+func (m *ReplicationStatsResponse) GetRepairedKeys() uint64 {
+	if m != nil {
+		return m.RepairedKeys
+	}
+	return 0
+}
+
+func (m *ReplicationStatsResponse) GetBackups() []*BackupLag {
+	if m != nil {
+		return m.Backups
+	}
+	return nil
+}
+
+func (m *ReplicationStatsResponse) GetReadOnly() bool {
+	if m != nil {
+		return m.ReadOnly
+	}
+	return false
+}
+
+type ReadOnlyResponse struct {
+	Status Status `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	// backups in sync, and how many of them are required to leave read-only mode by itself
+	SyncedBackups        uint32   `protobuf:"varint,2,opt,name=syncedBackups,proto3" json:"syncedBackups,omitempty"`
+	RequiredBackups      uint32   `protobuf:"varint,3,opt,name=requiredBackups,proto3" json:"requiredBackups,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReadOnlyResponse) Reset()         { *m = ReadOnlyResponse{} }
+func (m *ReadOnlyResponse) String() string { return proto.CompactTextString(m) }
+func (*ReadOnlyResponse) ProtoMessage()    {}
+func (*ReadOnlyResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8f142f2b1de3db81, []int{5}
+}
+
+func (m *ReadOnlyResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReadOnlyResponse.Unmarshal(m, b)
+}
+func (m *ReadOnlyResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReadOnlyResponse.Marshal(b, m, deterministic)
+}
+func (m *ReadOnlyResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReadOnlyResponse.Merge(m, src)
+}
+func (m *ReadOnlyResponse) XXX_Size() int {
+	return xxx_messageInfo_ReadOnlyResponse.Size(m)
+}
+func (m *ReadOnlyResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReadOnlyResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReadOnlyResponse proto.InternalMessageInfo
+
+func (m *ReadOnlyResponse) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *ReadOnlyResponse) GetSyncedBackups() uint32 {
+	if m != nil {
+		return m.SyncedBackups
+	}
+	return 0
+}
+
+func (m *ReadOnlyResponse) GetRequiredBackups() uint32 {
+	if m != nil {
+		return m.RequiredBackups
+	}
+	return 0
+}
+
+type SwitchoverRequest struct {
+	// node name or address of the backup to promote
+	Target string `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	// how long to wait for it to catch up
+	TimeoutMs            uint32   `protobuf:"varint,2,opt,name=timeoutMs,proto3" json:"timeoutMs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SwitchoverRequest) Reset()         { *m = SwitchoverRequest{} }
+func (m *SwitchoverRequest) String() string { return proto.CompactTextString(m) }
+func (*SwitchoverRequest) ProtoMessage()    {}
+func (*SwitchoverRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8f142f2b1de3db81, []int{6}
+}
+
+func (m *SwitchoverRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SwitchoverRequest.Unmarshal(m, b)
+}
+func (m *SwitchoverRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SwitchoverRequest.Marshal(b, m, deterministic)
+}
+func (m *SwitchoverRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SwitchoverRequest.Merge(m, src)
+}
+func (m *SwitchoverRequest) XXX_Size() int {
+	return xxx_messageInfo_SwitchoverRequest.Size(m)
+}
+func (m *SwitchoverRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SwitchoverRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SwitchoverRequest proto.InternalMessageInfo
+
+func (m *SwitchoverRequest) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+func (m *SwitchoverRequest) GetTimeoutMs() uint32 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type SwitchoverResponse struct {
+	Status Status `protobuf:"varint,1,opt,name=status,proto3,enum=kv.proto.Status" json:"status,omitempty"`
+	// node name of the new primary, and the version handed over
+	Primary string `protobuf:"bytes,2,opt,name=primary,proto3" json:"primary,omitempty"`
+	Version uint64 `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	// how long writes are refused in total
+	PausedMicros         uint64   `protobuf:"varint,4,opt,name=pausedMicros,proto3" json:"pausedMicros,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SwitchoverResponse) Reset()         { *m = SwitchoverResponse{} }
+func (m *SwitchoverResponse) String() string { return proto.CompactTextString(m) }
+func (*SwitchoverResponse) ProtoMessage()    {}
+func (*SwitchoverResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8f142f2b1de3db81, []int{7}
+}
+
+func (m *SwitchoverResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SwitchoverResponse.Unmarshal(m, b)
+}
+func (m *SwitchoverResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SwitchoverResponse.Marshal(b, m, deterministic)
+}
+func (m *SwitchoverResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SwitchoverResponse.Merge(m, src)
+}
+func (m *SwitchoverResponse) XXX_Size() int {
+	return xxx_messageInfo_SwitchoverResponse.Size(m)
+}
+func (m *SwitchoverResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SwitchoverResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SwitchoverResponse proto.InternalMessageInfo
+
+func (m *SwitchoverResponse) GetStatus() Status {
+	if m != nil {
+		return m.Status
+	}
+	return Status_OK
+}
+
+func (m *SwitchoverResponse) GetPrimary() string {
+	if m != nil {
+		return m.Primary
+	}
+	return ""
+}
+
+func (m *SwitchoverResponse) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *SwitchoverResponse) GetPausedMicros() uint64 {
+	if m != nil {
+		return m.PausedMicros
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*MigrationResponse)(nil), "kv.proto.MigrationResponse")
 	proto.RegisterType((*FlushResponse)(nil), "kv.proto.FlushResponse")
+	proto.RegisterType((*LatencyHistogram)(nil), "kv.proto.LatencyHistogram")
+	proto.RegisterType((*BackupLag)(nil), "kv.proto.BackupLag")
+	proto.RegisterType((*ReplicationStatsResponse)(nil), "kv.proto.ReplicationStatsResponse")
+	proto.RegisterType((*ReadOnlyResponse)(nil), "kv.proto.ReadOnlyResponse")
+	proto.RegisterType((*SwitchoverRequest)(nil), "kv.proto.SwitchoverRequest")
+	proto.RegisterType((*SwitchoverResponse)(nil), "kv.proto.SwitchoverResponse")
 }
 
 func init() {
@@ -113,20 +543,49 @@ func init() {
 }
 
 var fileDescriptor_8f142f2b1de3db81 = []byte{
-	// 199 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0x29, 0xcf, 0x2f, 0xca,
-	0x4e, 0x2d, 0xf2, 0xcc, 0x2b, 0x49, 0x2d, 0xca, 0x4b, 0xcc, 0xd1, 0x2b, 0x28, 0xca, 0x2f, 0xc9,
-	0x17, 0xe2, 0xc8, 0x2e, 0x83, 0xb0, 0xa4, 0x78, 0x92, 0xf3, 0x73, 0x73, 0xf3, 0xf3, 0xa0, 0x3c,
-	0xe9, 0xf4, 0xfc, 0xfc, 0xf4, 0x9c, 0x54, 0x7d, 0x30, 0x2f, 0xa9, 0x34, 0x4d, 0x3f, 0x35, 0xb7,
-	0xa0, 0xa4, 0x12, 0x22, 0xa9, 0x64, 0xcb, 0x25, 0xe8, 0x9b, 0x99, 0x5e, 0x94, 0x58, 0x92, 0x99,
-	0x9f, 0x17, 0x94, 0x5a, 0x5c, 0x90, 0x9f, 0x57, 0x9c, 0x2a, 0xa4, 0xc1, 0xc5, 0x56, 0x5c, 0x92,
-	0x58, 0x52, 0x5a, 0x2c, 0xc1, 0xa8, 0xc0, 0xa8, 0xc1, 0x67, 0x24, 0xa0, 0x07, 0x33, 0x5a, 0x2f,
-	0x18, 0x2c, 0x1e, 0x04, 0x95, 0x57, 0xb2, 0xe4, 0xe2, 0x75, 0xcb, 0x29, 0x2d, 0xce, 0x20, 0x5d,
-	0xab, 0x51, 0x30, 0x97, 0x80, 0x77, 0x58, 0x38, 0x8a, 0x47, 0x84, 0xec, 0xb9, 0xb8, 0x92, 0x33,
-	0x52, 0x93, 0xb3, 0x0b, 0xf2, 0x33, 0xf3, 0x4a, 0x84, 0xc4, 0xf4, 0x20, 0x2e, 0xd7, 0x83, 0xb9,
-	0x5c, 0xcf, 0x15, 0xe4, 0x72, 0x29, 0x71, 0x84, 0x99, 0x28, 0x96, 0x2b, 0x31, 0x38, 0x71, 0x46,
-	0xb1, 0xeb, 0x59, 0x43, 0x94, 0xb3, 0x81, 0x29, 0x63, 0x40, 0x00, 0x00, 0x00, 0xff, 0xff, 0x57,
-	0xf0, 0xc1, 0x89, 0x2d, 0x01, 0x00, 0x00,
+	// 666 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0xcd, 0x6e, 0x13, 0x3d,
+	0x14, 0x6d, 0xd2, 0x7c, 0xf9, 0xb9, 0x6d, 0xfa, 0xa5, 0x06, 0x95, 0x51, 0xda, 0x45, 0x34, 0x42,
+	0x28, 0x0b, 0x48, 0xa5, 0xb2, 0x02, 0x84, 0x90, 0x2a, 0x15, 0x51, 0xb5, 0x15, 0xc8, 0x95, 0x8a,
+	0xc4, 0xce, 0x9d, 0xb9, 0x4c, 0x4c, 0x66, 0xec, 0xa9, 0xed, 0x49, 0x95, 0x77, 0x60, 0xcd, 0xab,
+	0xf0, 0x10, 0x3c, 0x01, 0x6f, 0x83, 0xec, 0xf9, 0x49, 0x26, 0xd0, 0x45, 0xbb, 0x8a, 0xcf, 0xf1,
+	0xdc, 0x73, 0x9d, 0x73, 0x7f, 0xe0, 0xf1, 0xad, 0x54, 0x33, 0x54, 0xa7, 0xc2, 0xa0, 0x12, 0x2c,
+	0x9e, 0xa4, 0x4a, 0x1a, 0x49, 0xba, 0xb3, 0x79, 0x7e, 0x1a, 0x6e, 0x07, 0x32, 0x49, 0xa4, 0x28,
+	0xd0, 0x7e, 0x24, 0x65, 0x14, 0xe3, 0xa1, 0x43, 0xd7, 0xd9, 0xd7, 0x43, 0x4c, 0x52, 0xb3, 0xc8,
+	0x2f, 0xfd, 0xb7, 0xb0, 0x7b, 0xc1, 0x23, 0xc5, 0x0c, 0x97, 0x82, 0xa2, 0x4e, 0xa5, 0xd0, 0x48,
+	0xc6, 0xd0, 0xd6, 0x86, 0x99, 0x4c, 0x7b, 0x8d, 0x51, 0x63, 0xbc, 0x73, 0x34, 0x98, 0x94, 0xd2,
+	0x93, 0x4b, 0xc7, 0xd3, 0xe2, 0xde, 0x7f, 0x05, 0xfd, 0xf7, 0x71, 0xa6, 0xa7, 0x0f, 0x08, 0x35,
+	0x30, 0x38, 0x67, 0x06, 0x45, 0xb0, 0xf8, 0xc0, 0xb5, 0x91, 0x91, 0x62, 0x09, 0x21, 0xd0, 0x4a,
+	0x64, 0x88, 0x2e, 0xb6, 0x47, 0xdd, 0x99, 0xec, 0x41, 0xfb, 0x5a, 0x66, 0x22, 0xd4, 0x5e, 0x73,
+	0xb4, 0x39, 0x6e, 0xd1, 0x02, 0x59, 0x3e, 0x90, 0x99, 0x30, 0xda, 0xdb, 0xcc, 0xf9, 0x1c, 0x91,
+	0x03, 0xe8, 0xe9, 0x2c, 0xb9, 0xe0, 0x81, 0x92, 0xda, 0x6b, 0x8d, 0x1a, 0xe3, 0x16, 0x5d, 0x12,
+	0xfe, 0xaf, 0x06, 0xf4, 0x8e, 0x59, 0x30, 0xcb, 0xd2, 0x73, 0x16, 0xd9, 0x7c, 0x82, 0x25, 0x55,
+	0x3e, 0x7b, 0x26, 0x1e, 0x74, 0xe6, 0xa8, 0x34, 0x97, 0xc2, 0x6b, 0xba, 0xe8, 0x12, 0x92, 0x11,
+	0x6c, 0xc5, 0x2c, 0xba, 0xca, 0x91, 0x4d, 0x6b, 0x6f, 0x57, 0x29, 0x9b, 0x3b, 0x66, 0x51, 0x3d,
+	0x77, 0x45, 0x58, 0x65, 0xfc, 0x86, 0x81, 0xc1, 0xd0, 0xfb, 0x6f, 0xd4, 0x18, 0x77, 0x69, 0x09,
+	0x6d, 0x9c, 0x3b, 0x3a, 0xdd, 0x76, 0x1e, 0x57, 0x11, 0x36, 0x2e, 0x46, 0xa6, 0x04, 0x2a, 0xaf,
+	0x93, 0xc7, 0x15, 0xd0, 0xff, 0xdd, 0x04, 0x8f, 0x62, 0x1a, 0xf3, 0xc0, 0x15, 0xd0, 0x3a, 0xac,
+	0xef, 0x5f, 0x8a, 0xca, 0xf6, 0xe6, 0x8a, 0xed, 0xaf, 0x01, 0xa6, 0x65, 0x5d, 0x72, 0x8b, 0xb7,
+	0x8e, 0x86, 0x4b, 0x85, 0xf5, 0xd2, 0xd1, 0x95, 0xaf, 0xc9, 0x73, 0xd8, 0x65, 0xc2, 0xf0, 0x13,
+	0x61, 0x94, 0x4c, 0x17, 0x34, 0xaf, 0x5e, 0x6e, 0xc7, 0xdf, 0x17, 0xe4, 0x19, 0xec, 0x84, 0x7c,
+	0x8e, 0x2a, 0xc2, 0x90, 0x32, 0x11, 0xa1, 0x76, 0xee, 0xb4, 0xe8, 0x1a, 0x4b, 0x7c, 0xd8, 0x56,
+	0x98, 0x32, 0xae, 0x30, 0x3c, 0xc3, 0x45, 0xe9, 0x53, 0x8d, 0x23, 0x2f, 0xa0, 0x73, 0xed, 0xaa,
+	0xab, 0xbd, 0x8e, 0x7b, 0xf2, 0xa3, 0xe5, 0x93, 0xab, 0xb2, 0xd3, 0xf2, 0x1b, 0x32, 0x84, 0xae,
+	0x42, 0x16, 0x7e, 0x14, 0xf1, 0xc2, 0xeb, 0x3a, 0x6b, 0x2b, 0xec, 0x7f, 0x6f, 0xc0, 0x80, 0x16,
+	0xe0, 0x01, 0x9e, 0x3e, 0x85, 0xbe, 0x5e, 0x88, 0x00, 0xc3, 0xe3, 0xe2, 0x3d, 0xd6, 0xdc, 0x3e,
+	0xad, 0x93, 0x64, 0x0c, 0xff, 0x2b, 0xbc, 0xc9, 0xb8, 0xaa, 0x28, 0xd7, 0x56, 0x7d, 0xba, 0x4e,
+	0xfb, 0xa7, 0xb0, 0x7b, 0x79, 0xcb, 0x4d, 0x30, 0x95, 0x73, 0x54, 0x14, 0x6f, 0x32, 0xd4, 0xc6,
+	0xce, 0x80, 0x61, 0x2a, 0x42, 0x53, 0x74, 0x70, 0x81, 0x6c, 0x3f, 0x19, 0x9e, 0xa0, 0xcc, 0xcc,
+	0x45, 0x99, 0x78, 0x49, 0xf8, 0x3f, 0x1a, 0x40, 0x56, 0xb5, 0xee, 0xfd, 0xdf, 0x3c, 0xe8, 0xa4,
+	0x8a, 0x27, 0x4c, 0x2d, 0x8a, 0x96, 0x29, 0xe1, 0xea, 0xf0, 0x6c, 0xd6, 0x87, 0xc7, 0x87, 0xed,
+	0x94, 0x65, 0x1a, 0xc3, 0xda, 0x74, 0xd4, 0xb8, 0xa3, 0x9f, 0x4d, 0x18, 0x9c, 0x5d, 0x7d, 0xae,
+	0x2d, 0x37, 0xf2, 0x0e, 0x20, 0x98, 0x62, 0x30, 0x4b, 0x25, 0x17, 0x86, 0xec, 0x4d, 0xf2, 0x6d,
+	0x36, 0x29, 0xb7, 0xd9, 0xe4, 0xc4, 0x6e, 0xb3, 0xe1, 0x93, 0xe5, 0x63, 0x6b, 0x0b, 0xc9, 0xdf,
+	0x20, 0x9f, 0x60, 0xa0, 0xd6, 0x66, 0xe4, 0x4e, 0x19, 0x7f, 0x29, 0x73, 0xd7, 0x5c, 0xf9, 0x1b,
+	0xe4, 0x04, 0xfa, 0x31, 0xb2, 0x39, 0x96, 0xed, 0x71, 0xa7, 0xdc, 0x70, 0x55, 0xae, 0xde, 0x4a,
+	0xfe, 0x06, 0x39, 0x05, 0xd0, 0x55, 0x19, 0xc8, 0xfe, 0x8a, 0xdd, 0xeb, 0x85, 0x1e, 0x1e, 0xfc,
+	0xfb, 0xb2, 0x94, 0x3a, 0xee, 0x7d, 0xe9, 0x4c, 0xde, 0xe4, 0xc9, 0xdb, 0xee, 0xe7, 0xe5, 0x9f,
+	0x01, 0x00, 0x11, 0xed, 0x58, 0x31, 0x25, 0x06, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -142,6 +601,12 @@ const _ = grpc.SupportPackageIsVersion6
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type KVWorkerInternalClient interface {
 	Checkpoint(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*FlushResponse, error)
+	// latency of waiting for backups in each replication mode
+	ReplicationStats(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ReplicationStatsResponse, error)
+	// accept writes again without waiting for enough backups to be in sync
+	LeaveReadOnly(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ReadOnlyResponse, error)
+	// hand the primary role over to a backup, pausing writes until it has caught up
+	Switchover(ctx context.Context, in *SwitchoverRequest, opts ...grpc.CallOption) (*SwitchoverResponse, error)
 }
 
 type kVWorkerInternalClient struct {
@@ -161,9 +626,42 @@ func (c *kVWorkerInternalClient) Checkpoint(ctx context.Context, in *empty.Empty
 	return out, nil
 }
 
+func (c *kVWorkerInternalClient) ReplicationStats(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ReplicationStatsResponse, error) {
+	out := new(ReplicationStatsResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVWorkerInternal/replicationStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVWorkerInternalClient) LeaveReadOnly(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ReadOnlyResponse, error) {
+	out := new(ReadOnlyResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVWorkerInternal/leaveReadOnly", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVWorkerInternalClient) Switchover(ctx context.Context, in *SwitchoverRequest, opts ...grpc.CallOption) (*SwitchoverResponse, error) {
+	out := new(SwitchoverResponse)
+	err := c.cc.Invoke(ctx, "/kv.proto.KVWorkerInternal/switchover", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // KVWorkerInternalServer is the server API for KVWorkerInternal service.
 type KVWorkerInternalServer interface {
 	Checkpoint(context.Context, *empty.Empty) (*FlushResponse, error)
+	// latency of waiting for backups in each replication mode
+	ReplicationStats(context.Context, *empty.Empty) (*ReplicationStatsResponse, error)
+	// accept writes again without waiting for enough backups to be in sync
+	LeaveReadOnly(context.Context, *empty.Empty) (*ReadOnlyResponse, error)
+	// hand the primary role over to a backup, pausing writes until it has caught up
+	Switchover(context.Context, *SwitchoverRequest) (*SwitchoverResponse, error)
 }
 
 // UnimplementedKVWorkerInternalServer can be embedded to have forward compatible implementations.
@@ -173,6 +671,15 @@ type UnimplementedKVWorkerInternalServer struct {
 func (*UnimplementedKVWorkerInternalServer) Checkpoint(ctx context.Context, req *empty.Empty) (*FlushResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Checkpoint not implemented")
 }
+func (*UnimplementedKVWorkerInternalServer) ReplicationStats(ctx context.Context, req *empty.Empty) (*ReplicationStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplicationStats not implemented")
+}
+func (*UnimplementedKVWorkerInternalServer) LeaveReadOnly(ctx context.Context, req *empty.Empty) (*ReadOnlyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LeaveReadOnly not implemented")
+}
+func (*UnimplementedKVWorkerInternalServer) Switchover(ctx context.Context, req *SwitchoverRequest) (*SwitchoverResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Switchover not implemented")
+}
 
 func RegisterKVWorkerInternalServer(s *grpc.Server, srv KVWorkerInternalServer) {
 	s.RegisterService(&_KVWorkerInternal_serviceDesc, srv)
@@ -196,6 +703,60 @@ func _KVWorkerInternal_Checkpoint_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KVWorkerInternal_ReplicationStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVWorkerInternalServer).ReplicationStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVWorkerInternal/ReplicationStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVWorkerInternalServer).ReplicationStats(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVWorkerInternal_LeaveReadOnly_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVWorkerInternalServer).LeaveReadOnly(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVWorkerInternal/LeaveReadOnly",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVWorkerInternalServer).LeaveReadOnly(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVWorkerInternal_Switchover_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SwitchoverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVWorkerInternalServer).Switchover(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kv.proto.KVWorkerInternal/Switchover",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVWorkerInternalServer).Switchover(ctx, req.(*SwitchoverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _KVWorkerInternal_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "kv.proto.KVWorkerInternal",
 	HandlerType: (*KVWorkerInternalServer)(nil),
@@ -204,6 +765,18 @@ var _KVWorkerInternal_serviceDesc = grpc.ServiceDesc{
 			MethodName: "checkpoint",
 			Handler:    _KVWorkerInternal_Checkpoint_Handler,
 		},
+		{
+			MethodName: "replicationStats",
+			Handler:    _KVWorkerInternal_ReplicationStats_Handler,
+		},
+		{
+			MethodName: "leaveReadOnly",
+			Handler:    _KVWorkerInternal_LeaveReadOnly_Handler,
+		},
+		{
+			MethodName: "switchover",
+			Handler:    _KVWorkerInternal_Switchover_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "workerInternal.proto",
@@ -0,0 +1,160 @@
+// Stateless routing proxy for the KV store.
+// It serves the KVWorker API on behalf of all workers, so thin clients neither need the slot table
+// nor have to deal with slot table changes & failovers. Proxies share no state,
+// so any number of them can be run behind one address.
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	pb "github.com/eyeKill/KV/proto"
+)
+
+type Server struct {
+	pb.UnimplementedKVWorkerServer
+	kv *client.Client
+}
+
+func NewServer(kv *client.Client) *Server {
+	return &Server{kv: kv}
+}
+
+// Slot versions sent by callers are ignored, the proxy keeps track of them itself.
+
+func (s *Server) Get(ctx context.Context, key *pb.Key) (*pb.GetResponse, error) {
+	value, err := s.kv.GetWith(ctx, key.Key, client.Consistency{
+		Level:        key.Consistency,
+		MaxStaleness: time.Duration(key.MaxStaleness) * time.Millisecond,
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &pb.GetResponse{Status: client.StatusOf(err), Value: value}, nil
+}
+
+func (s *Server) Put(ctx context.Context, pair *pb.KVPair) (*pb.PutResponse, error) {
+	err := s.kv.Put(ctx, pair.Key, pair.Value)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &pb.PutResponse{Status: client.StatusOf(err)}, nil
+}
+
+func (s *Server) Delete(ctx context.Context, key *pb.Key) (*pb.DeleteResponse, error) {
+	err := s.kv.Delete(ctx, key.Key)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &pb.DeleteResponse{Status: client.StatusOf(err)}, nil
+}
+
+// Scan all workers. Pass the last key returned as `start` to get the next page.
+// Pages of the client could be short before the last one, so they are gathered until there are `limit` pairs,
+// since callers take a short page as the last one, as they would from a worker.
+func (s *Server) Scan(ctx context.Context, req *pb.ScanRequest) (*pb.ScanResponse, error) {
+	var resp pb.ScanResponse
+	start := req.Start
+	for len(resp.Pairs) < int(req.Limit) {
+		pairs, next, err := s.kv.Scan(ctx, req.Prefix, start, int(req.Limit)-len(resp.Pairs))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err != nil {
+			return &pb.ScanResponse{Status: client.StatusOf(err)}, nil
+		}
+		for _, p := range pairs {
+			resp.Pairs = append(resp.Pairs, &pb.KVPair{Key: p.Key, Value: p.Value})
+		}
+		if next == "" {
+			break
+		}
+		start = next
+	}
+	return &resp, nil
+}
+
+// Send the status a stream of the client ended with, as the last message of a stream served.
+func endStream(ctx context.Context, err error, send func(pb.Status) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return send(client.StatusOf(err))
+}
+
+// Watch keys across failovers & migrations, so the stream only ends once the history asked for is truncated.
+// Events carry versions of the worker owning the key at the time, which are not comparable across workers.
+// So `fromVersion` is only taken for a single key, and prefix watches asking for it are rejected with EFAILED.
+// `sinceSlotVersion` is ignored, the proxy replays what new owners retain itself.
+func (s *Server) WatchKeys(req *pb.WatchRequest, server pb.KVWorker_WatchKeysServer) error {
+	ctx := server.Context()
+	send := func(st pb.Status) error {
+		return server.Send(&pb.WatchEvent{Status: st})
+	}
+	var cursor client.WatchCursor
+	if req.FromVersion != 0 {
+		if req.Prefix {
+			return send(pb.Status_EFAILED)
+		}
+		slots, _, err := s.kv.Slots(ctx)
+		if err != nil {
+			return endStream(ctx, err, send)
+		}
+		cursor = client.WatchCursor{slots.GetWorkerIdByKey(req.Key): req.FromVersion}
+	}
+	err := s.kv.Watch(ctx, req.Key, req.Prefix, cursor, func(ev client.Event) error {
+		return server.Send(&pb.WatchEvent{Status: pb.Status_OK, Op: ev.Op, Key: ev.Key, Value: ev.Value, Version: ev.Version})
+	})
+	return endStream(ctx, err, send)
+}
+
+func (s *Server) Publish(ctx context.Context, req *pb.PublishRequest) (*pb.PublishResponse, error) {
+	id, receivers, err := s.kv.Publish(ctx, req.Channel, req.Payload, int(req.Retain))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &pb.PublishResponse{Status: client.StatusOf(err), Id: id, Receivers: uint32(receivers)}, nil
+}
+
+// Subscribe to a channel across failovers & migrations, the stream only ends when the caller is gone.
+func (s *Server) Subscribe(req *pb.SubscribeRequest, server pb.KVWorker_SubscribeServer) error {
+	ctx := server.Context()
+	err := s.kv.Subscribe(ctx, req.Channel, req.Replay, func(m client.Message) error {
+		if m.Id <= req.AfterId {
+			return nil
+		}
+		return server.Send(&pb.Message{Status: pb.Status_OK, Channel: m.Channel, Id: m.Id, Payload: m.Payload})
+	})
+	return endStream(ctx, err, func(st pb.Status) error {
+		return server.Send(&pb.Message{Status: st})
+	})
+}
+
+func (s *Server) AcquireLock(ctx context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
+	lease, err := s.kv.TryLockAs(ctx, req.Name, req.Token, time.Duration(req.Ttl)*time.Millisecond)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return &pb.LockResponse{Status: client.StatusOf(err)}, nil
+	}
+	return &pb.LockResponse{Status: pb.Status_OK, Token: lease.Token, Fence: lease.Fence}, nil
+}
+
+// Fences are only reported on acquiring, holders keep the one they were given then.
+func (s *Server) RenewLease(ctx context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
+	err := s.kv.RenewLease(ctx, &client.Lease{Name: req.Name, Token: req.Token}, time.Duration(req.Ttl)*time.Millisecond)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &pb.LockResponse{Status: client.StatusOf(err), Token: req.Token}, nil
+}
+
+func (s *Server) ReleaseLock(ctx context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
+	err := s.kv.ReleaseLock(ctx, &client.Lease{Name: req.Name, Token: req.Token})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &pb.LockResponse{Status: client.StatusOf(err)}, nil
+}
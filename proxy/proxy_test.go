@@ -0,0 +1,189 @@
+package proxy_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	"github.com/eyeKill/KV/localcluster"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/proxy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// start a proxy in front of a local cluster, and connect to it
+func startProxy(t *testing.T, c *localcluster.Cluster) (pb.KVWorkerClient, func()) {
+	opts := client.DefaultOptions(c.MasterAddr)
+	opts.BaseBackoff = time.Millisecond
+	kv, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := common.NewGrpcServer()
+	pb.RegisterKVWorkerServer(s, proxy.NewServer(kv))
+	go s.Serve(l)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pb.NewKVWorkerClient(conn), func() {
+		_ = conn.Close()
+		s.Stop()
+		_ = kv.Close()
+	}
+}
+
+func TestProxy(t *testing.T) {
+	c, err := localcluster.Start(3)
+	assert.Nil(t, err)
+	defer c.Stop()
+	p, stop := startProxy(t, c)
+	defer stop()
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("k%d", i)
+		resp, err := p.Put(ctx, &pb.KVPair{Key: k, Value: k})
+		assert.Nil(t, err)
+		assert.Equal(t, pb.Status_OK, resp.Status)
+	}
+	// slot version changes are hidden from callers
+	c.BumpSlotVersion()
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("k%d", i)
+		resp, err := p.Get(ctx, &pb.Key{Key: k})
+		assert.Nil(t, err)
+		assert.Equal(t, pb.Status_OK, resp.Status)
+		assert.Equal(t, k, resp.Value)
+	}
+	dResp, err := p.Delete(ctx, &pb.Key{Key: "k0"})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, dResp.Status)
+	gResp, err := p.Get(ctx, &pb.Key{Key: "k0"})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_ENOENT, gResp.Status)
+
+	sResp, err := p.Scan(ctx, &pb.ScanRequest{Prefix: "k", Start: "k3", Limit: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, sResp.Status)
+	var keys []string
+	for _, pair := range sResp.Pairs {
+		keys = append(keys, pair.Key)
+	}
+	assert.Equal(t, []string{"k4", "k5", "k6", "k7", "k8", "k9"}, keys)
+}
+
+func TestProxy_Watch(t *testing.T) {
+	c, err := localcluster.Start(3)
+	assert.Nil(t, err)
+	defer c.Stop()
+	p, stop := startProxy(t, c)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	put := func(k string, v string) {
+		resp, err := p.Put(ctx, &pb.KVPair{Key: k, Value: v})
+		assert.Nil(t, err)
+		assert.Equal(t, pb.Status_OK, resp.Status)
+	}
+	recv := func(stream pb.KVWorker_WatchKeysClient) *pb.WatchEvent {
+		ev, err := stream.Recv()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return ev
+	}
+
+	stream, err := p.WatchKeys(ctx, &pb.WatchRequest{Key: "w/a"})
+	assert.Nil(t, err)
+	prefix, err := p.WatchKeys(ctx, &pb.WatchRequest{Key: "w/", Prefix: true})
+	assert.Nil(t, err)
+	// wait for all streams to be set up
+	time.Sleep(100 * time.Millisecond)
+	put("w/a", "1")
+	put("w/a", "2")
+	first := recv(stream)
+	assert.Equal(t, pb.Status_OK, first.Status)
+	assert.Equal(t, "1", first.Value)
+	// a single key is replayed from a version
+	replay, err := p.WatchKeys(ctx, &pb.WatchRequest{Key: "w/a", FromVersion: first.Version})
+	assert.Nil(t, err)
+	ev := recv(replay)
+	assert.Equal(t, pb.Status_OK, ev.Status)
+	assert.Equal(t, "2", ev.Value)
+
+	// the proxy follows slot version changes
+	assert.Equal(t, "w/a", recv(prefix).Key)
+	assert.Equal(t, "w/a", recv(prefix).Key)
+	c.BumpSlotVersion()
+	put("w/b", "3")
+	ev = recv(prefix)
+	assert.Equal(t, pb.Status_OK, ev.Status)
+	assert.Equal(t, "w/b", ev.Key)
+
+	// versions of different workers are not comparable, so prefixes could not be replayed
+	replay, err = p.WatchKeys(ctx, &pb.WatchRequest{Key: "w/", Prefix: true, FromVersion: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_EFAILED, recv(replay).Status)
+}
+
+func TestProxy_PubSub(t *testing.T) {
+	c, err := localcluster.Start(3)
+	assert.Nil(t, err)
+	defer c.Stop()
+	p, stop := startProxy(t, c)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 1; i <= 3; i++ {
+		resp, err := p.Publish(ctx, &pb.PublishRequest{Channel: "news", Payload: fmt.Sprint(i), Retain: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, pb.Status_OK, resp.Status)
+		assert.Equal(t, uint64(i), resp.Id)
+	}
+	stream, err := p.Subscribe(ctx, &pb.SubscribeRequest{Channel: "news", Replay: true, AfterId: 1})
+	assert.Nil(t, err)
+	for i := 2; i <= 3; i++ {
+		m, err := stream.Recv()
+		assert.Nil(t, err)
+		assert.Equal(t, pb.Status_OK, m.Status)
+		assert.Equal(t, uint64(i), m.Id)
+		assert.Equal(t, fmt.Sprint(i), m.Payload)
+	}
+}
+
+func TestProxy_Lock(t *testing.T) {
+	c, err := localcluster.Start(3)
+	assert.Nil(t, err)
+	defer c.Stop()
+	p, stop := startProxy(t, c)
+	defer stop()
+	ctx := context.Background()
+
+	lease, err := p.AcquireLock(ctx, &pb.LockRequest{Name: "l", Token: "a", Ttl: 60000})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, lease.Status)
+	assert.Equal(t, "a", lease.Token)
+	resp, err := p.AcquireLock(ctx, &pb.LockRequest{Name: "l", Ttl: 60000})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_ELOCKED, resp.Status)
+	resp, err = p.RenewLease(ctx, &pb.LockRequest{Name: "l", Token: "a", Ttl: 60000})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, resp.Status)
+	resp, err = p.ReleaseLock(ctx, &pb.LockRequest{Name: "l", Token: "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, resp.Status)
+	resp, err = p.ReleaseLock(ctx, &pb.LockRequest{Name: "l", Token: "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_ENOENT, resp.Status)
+}
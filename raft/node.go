@@ -0,0 +1,346 @@
+package raft
+
+// a member of a group, applying committed entries to its state machine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+)
+
+// What a group replicates. Entries are applied in order of their indices, once each,
+// so the state machine has to persist the index of the last one applied along with its state.
+type StateMachine interface {
+	// Apply command `data` committed at `index`, returning the result handed to whoever proposed it.
+	Apply(index uint64, data []byte) (interface{}, error)
+	// Index of the last entry applied, those after it are applied once committed.
+	Applied() uint64
+	// Called once the node becomes the leader, or stops being it. None of these should call back into the node.
+	LeaderChanged(leader bool)
+}
+
+// Where messages go to other members. Messages could be lost or reordered.
+type Transport interface {
+	// Send a message to the member at `addr`, without blocking.
+	Send(addr string, m *pb.RaftMessage)
+}
+
+// An entry proposed, done once it is applied or dropped.
+type Proposal struct {
+	Index  uint64
+	Term   uint64
+	done   chan struct{}
+	result interface{}
+	err    error
+}
+
+func (p *Proposal) Done() <-chan struct{} {
+	return p.done
+}
+
+// Get what applying the entry returns, only valid once it is done.
+func (p *Proposal) Result() (interface{}, error) {
+	return p.result, p.err
+}
+
+// Wait until the entry is applied or dropped.
+func (p *Proposal) Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-p.done:
+		return p.result, p.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *Proposal) finish(result interface{}, err error) {
+	p.result, p.err = result, err
+	close(p.done)
+}
+
+// Status of a member.
+type Status struct {
+	Id        NodeId
+	State     string
+	Term      uint64
+	Lead      NodeId
+	LeadAddr  string
+	Commit    uint64
+	Applied   uint64
+	LastIndex uint64
+	Members   map[NodeId]string
+}
+
+type Node struct {
+	lock      sync.Mutex
+	raft      *Raft
+	fsm       StateMachine
+	transport Transport
+	// entries are applied under applyLock, taken before lock is released, so that they are applied in order
+	applyLock sync.Mutex
+	applied   uint64
+	proposals map[uint64]*Proposal
+	leader    bool
+	stopped   bool
+	stopCh    chan struct{}
+}
+
+func NewNode(config Config, fsm StateMachine, transport Transport) (*Node, error) {
+	r, err := newRaft(config)
+	if err != nil {
+		return nil, err
+	}
+	applied := fsm.Applied()
+	if applied > r.lastIndex() {
+		return nil, errors.New("state machine has applied entries not in the log")
+	}
+	// entries applied must have been committed
+	r.applied = applied
+	if r.commit < applied {
+		r.commit = applied
+	}
+	n := &Node{
+		raft:      r,
+		fsm:       fsm,
+		transport: transport,
+		applied:   applied,
+		proposals: make(map[uint64]*Proposal),
+		stopCh:    make(chan struct{}),
+	}
+	// entries known to be committed are applied right away
+	n.lock.Lock()
+	n.advance()
+	return n, nil
+}
+
+func (n *Node) Id() NodeId {
+	return n.raft.id
+}
+
+// Advance the logical clock of the node by one tick.
+func (n *Node) Tick() {
+	n.lock.Lock()
+	if n.stopped {
+		n.lock.Unlock()
+		return
+	}
+	if err := n.raft.tick(); err != nil {
+		n.fail(err)
+	}
+	n.advance()
+}
+
+// Handle a message from another member.
+func (n *Node) Step(m *pb.RaftMessage) {
+	n.lock.Lock()
+	if n.stopped || m.Group != uint32(n.raft.group) || NodeId(m.To) != n.raft.id {
+		n.lock.Unlock()
+		return
+	}
+	if err := n.raft.step(m); err != nil {
+		n.fail(err)
+	}
+	n.advance()
+}
+
+// Propose command `data` as the leader.
+func (n *Node) Propose(data []byte) (*Proposal, error) {
+	return n.propose(pb.RaftEntryType_RAFT_NORMAL, data)
+}
+
+// Add member `id` at `addr` as the leader, or change its address.
+// The node there should be started with no peers, and it catches up before it counts in quorums.
+func (n *Node) AddMember(id NodeId, addr string) (*Proposal, error) {
+	return n.changeMembership(func(peers map[NodeId]string) { peers[id] = addr })
+}
+
+// Remove member `id` as the leader, which could be the leader itself. It steps down once the removal is committed.
+func (n *Node) RemoveMember(id NodeId) (*Proposal, error) {
+	return n.changeMembership(func(peers map[NodeId]string) { delete(peers, id) })
+}
+
+func (n *Node) changeMembership(change func(peers map[NodeId]string)) (*Proposal, error) {
+	n.lock.Lock()
+	peers := make(map[NodeId]string, len(n.raft.peers)+1)
+	for id, addr := range n.raft.peers {
+		peers[id] = addr
+	}
+	n.lock.Unlock()
+	change(peers)
+	if len(peers) == 0 {
+		return nil, errors.New("group should have at least one member")
+	}
+	var membership pb.RaftMembership
+	for id, addr := range peers {
+		membership.Peers = append(membership.Peers, &pb.RaftPeer{Id: uint64(id), Addr: addr})
+	}
+	data, err := proto.Marshal(&membership)
+	if err != nil {
+		return nil, err
+	}
+	return n.propose(pb.RaftEntryType_RAFT_MEMBERSHIP, data)
+}
+
+func (n *Node) propose(typ pb.RaftEntryType, data []byte) (*Proposal, error) {
+	n.lock.Lock()
+	if n.stopped {
+		n.lock.Unlock()
+		return nil, ESTOPPED
+	}
+	ent, err := n.raft.propose(typ, data)
+	if err == ENOTLEADER || err == EPENDING {
+		n.lock.Unlock()
+		return nil, err
+	} else if err != nil {
+		n.fail(err)
+		n.advance()
+		return nil, err
+	}
+	p := &Proposal{Index: ent.Index, Term: ent.Term, done: make(chan struct{})}
+	n.proposals[ent.Index] = p
+	n.advance()
+	return p, nil
+}
+
+// Get the status of the node.
+func (n *Node) Status() Status {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	r := n.raft
+	members := make(map[NodeId]string, len(r.peers))
+	for id, addr := range r.peers {
+		members[id] = addr
+	}
+	return Status{
+		Id:        r.id,
+		State:     r.state,
+		Term:      r.term,
+		Lead:      r.lead,
+		LeadAddr:  r.peers[r.lead],
+		Commit:    r.commit,
+		Applied:   r.applied,
+		LastIndex: r.lastIndex(),
+		Members:   members,
+	}
+}
+
+// Check whether this node is the leader, and could serve reads from its state machine:
+// no other leader could have been elected, and everything committed by earlier leaders is applied.
+func (n *Node) HasLease() bool {
+	n.lock.Lock()
+	r := n.raft
+	ok := !n.stopped && r.hasLease()
+	start := r.leaderStart
+	n.lock.Unlock()
+	n.applyLock.Lock()
+	defer n.applyLock.Unlock()
+	return ok && n.applied >= start
+}
+
+// Tick every `interval` until stopped.
+func (n *Node) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.Tick()
+		case <-n.stopCh:
+			return
+		}
+	}
+}
+
+// Get a channel closed once the node is stopped.
+func (n *Node) Stopped() <-chan struct{} {
+	return n.stopCh
+}
+
+// Stop the node, failing entries proposed but not applied yet.
+func (n *Node) Stop() {
+	n.lock.Lock()
+	if n.stopped {
+		n.lock.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.stopCh)
+	n.advance()
+}
+
+// Stop for good after failing to persist, since going on could break promises made to other members.
+func (n *Node) fail(err error) {
+	common.Log().Error("Raft node failed, stopping.", zap.Uint64("id", uint64(n.raft.id)), zap.Error(err))
+	if !n.stopped {
+		n.stopped = true
+		close(n.stopCh)
+	}
+}
+
+// Send messages & apply entries committed, with lock held, which is released.
+func (n *Node) advance() {
+	r := n.raft
+	msgs := r.msgs
+	r.msgs = nil
+	addrs := make([]string, len(msgs))
+	for i, m := range msgs {
+		addrs[i] = r.peers[NodeId(m.To)]
+	}
+	var entries []*pb.RaftEntry
+	if !n.stopped && r.commit > r.applied {
+		entries = r.entries[r.applied:r.commit]
+		r.applied = r.commit
+	}
+	stopped := n.stopped
+	leader := r.state == STATE_LEADER && !stopped
+	var proposals map[uint64]*Proposal
+	if stopped {
+		proposals, n.proposals = n.proposals, make(map[uint64]*Proposal)
+	} else if len(entries) > 0 {
+		proposals = make(map[uint64]*Proposal)
+		for _, ent := range entries {
+			if p, ok := n.proposals[ent.Index]; ok {
+				proposals[ent.Index] = p
+				delete(n.proposals, ent.Index)
+			}
+		}
+	}
+	n.applyLock.Lock()
+	n.lock.Unlock()
+	defer n.applyLock.Unlock()
+
+	if !stopped {
+		for i, m := range msgs {
+			n.transport.Send(addrs[i], m)
+		}
+	}
+	for _, ent := range entries {
+		var result interface{}
+		var err error
+		if ent.Type == pb.RaftEntryType_RAFT_NORMAL && ent.Data != nil {
+			result, err = n.fsm.Apply(ent.Index, ent.Data)
+		}
+		n.applied = ent.Index
+		if p, ok := proposals[ent.Index]; ok {
+			delete(proposals, ent.Index)
+			if p.Term != ent.Term {
+				p.finish(nil, EDROPPED)
+			} else {
+				p.finish(result, err)
+			}
+		}
+	}
+	for _, p := range proposals {
+		p.finish(nil, ESTOPPED)
+	}
+	if leader != n.leader {
+		n.leader = leader
+		n.fsm.LeaderChanged(leader)
+	}
+}
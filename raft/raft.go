@@ -0,0 +1,621 @@
+// Raft consensus for the replicas of a worker, as an alternative to primary/backup replication coordinated
+// through zookeeper. A group elects its leader, replicates a log of commands to every member before they are
+// applied, and changes its membership one member at a time.
+// The core is driven by ticks & messages only, so tests could run groups deterministically in process.
+package raft
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/golang/protobuf/proto"
+)
+
+// Id of a member, unique in its group. 0 means none.
+type NodeId uint64
+
+const (
+	STATE_FOLLOWER  = "follower"
+	STATE_CANDIDATE = "candidate"
+	STATE_LEADER    = "leader"
+)
+
+const (
+	DEFAULT_ELECTION_TICKS     = 10
+	DEFAULT_HEARTBEAT_TICKS    = 1
+	DEFAULT_MAX_APPEND_ENTRIES = 64
+)
+
+var (
+	ENOTLEADER = errors.New("not the leader")
+	EPENDING   = errors.New("another membership change is in progress")
+	EDROPPED   = errors.New("entry dropped by another leader")
+	ESTOPPED   = errors.New("node stopped")
+)
+
+type Config struct {
+	Id NodeId
+	// worker the group replicates, messages of other groups are dropped
+	Group common.WorkerId
+	// members by address, until the log has a membership entry. Every member of a new group is given the same ones,
+	// while a node joining a group is given none, and waits for the leader to send it the membership.
+	Peers map[NodeId]string
+	// ticks without hearing from the leader before campaigning, randomized up to twice as many
+	ElectionTicks int
+	// ticks between appends of the leader, sent as heartbeats if there is nothing new
+	HeartbeatTicks   int
+	MaxAppendEntries int
+	Storage          Storage
+	// seed of randomized election timeouts
+	Seed int64
+}
+
+// Replication state of a member kept by the leader.
+type progress struct {
+	// last entry known to be on the member, and the next one to send
+	match uint64
+	next  uint64
+	// heard from during the current election timeout
+	active bool
+	// latest tick of the leader the member has responded to
+	ack uint64
+}
+
+type Raft struct {
+	id      NodeId
+	group   common.WorkerId
+	state   string
+	term    uint64
+	vote    NodeId
+	lead    NodeId
+	storage Storage
+	// the whole log, entries[i] being of index i+1
+	entries []*pb.RaftEntry
+	commit  uint64
+	applied uint64
+
+	// members by address, from the latest membership entry of the log, or the config if there is none
+	peers           map[NodeId]string
+	initialPeers    map[NodeId]string
+	membershipIndex uint64
+
+	// kept by leaders & candidates only
+	progress map[NodeId]*progress
+	votes    map[NodeId]bool
+	// first entry of the current term, appended once elected
+	leaderStart uint64
+
+	electionTicks   int
+	heartbeatTicks  int
+	maxAppend       int
+	electionTimeout int
+	// ticks since last hearing from the leader, or since the last quorum check of leaders
+	electionElapsed  int
+	heartbeatElapsed int
+	ticks            uint64
+	rand             *rand.Rand
+
+	// messages to send
+	msgs []*pb.RaftMessage
+}
+
+func newRaft(config Config) (*Raft, error) {
+	if config.Id == 0 {
+		return nil, errors.New("node id should not be 0")
+	}
+	if config.Storage == nil {
+		return nil, errors.New("no storage")
+	}
+	state, entries, err := config.Storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	for i, ent := range entries {
+		if ent.Index != uint64(i+1) {
+			return nil, errors.New("entries persisted not consecutive")
+		}
+	}
+	r := &Raft{
+		id:             config.Id,
+		group:          config.Group,
+		state:          STATE_FOLLOWER,
+		term:           state.Term,
+		vote:           NodeId(state.Vote),
+		storage:        config.Storage,
+		entries:        entries,
+		commit:         state.Commit,
+		initialPeers:   config.Peers,
+		electionTicks:  config.ElectionTicks,
+		heartbeatTicks: config.HeartbeatTicks,
+		maxAppend:      config.MaxAppendEntries,
+		rand:           rand.New(rand.NewSource(config.Seed)),
+	}
+	if r.electionTicks <= 0 {
+		r.electionTicks = DEFAULT_ELECTION_TICKS
+	}
+	if r.heartbeatTicks <= 0 {
+		r.heartbeatTicks = DEFAULT_HEARTBEAT_TICKS
+	}
+	if r.maxAppend <= 0 {
+		r.maxAppend = DEFAULT_MAX_APPEND_ENTRIES
+	}
+	if r.commit > r.lastIndex() {
+		r.commit = r.lastIndex()
+	}
+	if err := r.loadMembership(); err != nil {
+		return nil, err
+	}
+	r.resetElection()
+	return r, nil
+}
+
+func (r *Raft) lastIndex() uint64 {
+	return uint64(len(r.entries))
+}
+
+// Get term of the entry at `index`, 0 if there is none.
+func (r *Raft) termAt(index uint64) uint64 {
+	if index == 0 || index > r.lastIndex() {
+		return 0
+	}
+	return r.entries[index-1].Term
+}
+
+func (r *Raft) lastTerm() uint64 {
+	return r.termAt(r.lastIndex())
+}
+
+func (r *Raft) quorum() int {
+	return len(r.peers)/2 + 1
+}
+
+// Members in order of their ids, so that messages are sent in the same order every time.
+func (r *Raft) members() []NodeId {
+	ids := make([]NodeId, 0, len(r.peers))
+	for id := range r.peers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Only members could become the leader.
+func (r *Raft) promotable() bool {
+	_, ok := r.peers[r.id]
+	return ok
+}
+
+func (r *Raft) persistState() error {
+	return r.storage.SetHardState(&pb.RaftHardState{Term: r.term, Vote: uint64(r.vote), Commit: r.commit})
+}
+
+// Take members from the latest membership entry in the log, which takes effect once appended, committed or not.
+func (r *Raft) loadMembership() error {
+	r.peers, r.membershipIndex = r.initialPeers, 0
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		if r.entries[i].Type != pb.RaftEntryType_RAFT_MEMBERSHIP {
+			continue
+		}
+		var membership pb.RaftMembership
+		if err := proto.Unmarshal(r.entries[i].Data, &membership); err != nil {
+			return err
+		}
+		r.peers = make(map[NodeId]string, len(membership.Peers))
+		for _, peer := range membership.Peers {
+			r.peers[NodeId(peer.Id)] = peer.Addr
+		}
+		r.membershipIndex = r.entries[i].Index
+		break
+	}
+	if r.peers == nil {
+		r.peers = make(map[NodeId]string)
+	}
+	if r.state == STATE_LEADER {
+		for id := range r.peers {
+			if _, ok := r.progress[id]; !ok {
+				r.progress[id] = &progress{next: r.lastIndex() + 1, active: true}
+			}
+		}
+		for id := range r.progress {
+			if _, ok := r.peers[id]; !ok {
+				delete(r.progress, id)
+			}
+		}
+	}
+	return nil
+}
+
+// Persist & append entries of consecutive indices, replacing those from the index of the first one on.
+func (r *Raft) appendEntries(entries []*pb.RaftEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := r.storage.Append(entries); err != nil {
+		return err
+	}
+	first := entries[0].Index
+	r.entries = append(r.entries[:first-1], entries...)
+	changed := first <= r.membershipIndex
+	for _, ent := range entries {
+		changed = changed || ent.Type == pb.RaftEntryType_RAFT_MEMBERSHIP
+	}
+	if changed {
+		return r.loadMembership()
+	}
+	return nil
+}
+
+func (r *Raft) send(m *pb.RaftMessage) {
+	m.Group = uint32(r.group)
+	m.From = uint64(r.id)
+	m.Term = r.term
+	r.msgs = append(r.msgs, m)
+}
+
+func (r *Raft) resetElection() {
+	r.electionElapsed = 0
+	r.electionTimeout = r.electionTicks + r.rand.Intn(r.electionTicks)
+}
+
+func (r *Raft) becomeFollower(term uint64, lead NodeId) {
+	if term != r.term {
+		r.term = term
+		r.vote = 0
+	}
+	r.state = STATE_FOLLOWER
+	r.lead = lead
+	r.progress = nil
+	r.votes = nil
+	r.resetElection()
+}
+
+func (r *Raft) campaign() error {
+	r.state = STATE_CANDIDATE
+	r.term++
+	r.vote = r.id
+	r.lead = 0
+	r.votes = map[NodeId]bool{r.id: true}
+	r.resetElection()
+	if err := r.persistState(); err != nil {
+		return err
+	}
+	if r.poll() {
+		return r.becomeLeader()
+	}
+	for _, id := range r.members() {
+		if id == r.id {
+			continue
+		}
+		r.send(&pb.RaftMessage{
+			Type:    pb.RaftMessageType_RAFT_VOTE,
+			To:      uint64(id),
+			LogTerm: r.lastTerm(),
+			Index:   r.lastIndex(),
+		})
+	}
+	return nil
+}
+
+// Check whether a quorum of members has voted for us.
+func (r *Raft) poll() bool {
+	granted := 0
+	for id := range r.peers {
+		if r.votes[id] {
+			granted++
+		}
+	}
+	return granted >= r.quorum()
+}
+
+func (r *Raft) becomeLeader() error {
+	r.state = STATE_LEADER
+	r.lead = r.id
+	r.votes = nil
+	r.electionElapsed = 0
+	r.heartbeatElapsed = 0
+	r.progress = make(map[NodeId]*progress, len(r.peers))
+	for id := range r.peers {
+		r.progress[id] = &progress{next: r.lastIndex() + 1, active: true}
+	}
+	// entries of older terms are only committed along with one of our own
+	ent := &pb.RaftEntry{Term: r.term, Index: r.lastIndex() + 1, Type: pb.RaftEntryType_RAFT_NORMAL}
+	r.leaderStart = ent.Index
+	if err := r.appendEntries([]*pb.RaftEntry{ent}); err != nil {
+		return err
+	}
+	r.selfMatched()
+	r.maybeCommit()
+	r.broadcastAppend()
+	return nil
+}
+
+// Count everything appended as matched by the leader itself.
+func (r *Raft) selfMatched() {
+	if pr, ok := r.progress[r.id]; ok {
+		pr.match, pr.next, pr.ack = r.lastIndex(), r.lastIndex()+1, r.ticks
+	}
+}
+
+func (r *Raft) tick() error {
+	r.ticks++
+	if r.state != STATE_LEADER {
+		r.electionElapsed++
+		if r.electionElapsed >= r.electionTimeout && r.promotable() {
+			return r.campaign()
+		}
+		return nil
+	}
+	r.selfMatched()
+	r.electionElapsed++
+	if r.electionElapsed >= r.electionTicks {
+		// step down if cut off from a quorum, so that clients go look for the new leader
+		r.electionElapsed = 0
+		active := 0
+		for id, pr := range r.progress {
+			if pr.active || id == r.id {
+				active++
+			}
+			pr.active = false
+		}
+		if active < r.quorum() {
+			r.becomeFollower(r.term, 0)
+			return nil
+		}
+	}
+	r.heartbeatElapsed++
+	if r.heartbeatElapsed >= r.heartbeatTicks {
+		r.heartbeatElapsed = 0
+		r.broadcastAppend()
+	}
+	return nil
+}
+
+func (r *Raft) step(m *pb.RaftMessage) error {
+	switch {
+	case m.Term > r.term:
+		if m.Type == pb.RaftMessageType_RAFT_VOTE && r.lead != 0 && r.electionElapsed < r.electionTicks {
+			// the leader is still around, which our lease relies on
+			return nil
+		}
+		var lead NodeId = 0
+		if m.Type == pb.RaftMessageType_RAFT_APPEND {
+			lead = NodeId(m.From)
+		}
+		r.becomeFollower(m.Term, lead)
+		if err := r.persistState(); err != nil {
+			return err
+		}
+	case m.Term < r.term:
+		// tell stale leaders & candidates about the new term
+		switch m.Type {
+		case pb.RaftMessageType_RAFT_APPEND:
+			r.send(&pb.RaftMessage{Type: pb.RaftMessageType_RAFT_APPEND_RESPONSE, To: m.From, Reject: true})
+		case pb.RaftMessageType_RAFT_VOTE:
+			r.send(&pb.RaftMessage{Type: pb.RaftMessageType_RAFT_VOTE_RESPONSE, To: m.From, Reject: true})
+		}
+		return nil
+	}
+	switch m.Type {
+	case pb.RaftMessageType_RAFT_VOTE:
+		return r.handleVote(m)
+	case pb.RaftMessageType_RAFT_VOTE_RESPONSE:
+		if r.state != STATE_CANDIDATE {
+			return nil
+		}
+		r.votes[NodeId(m.From)] = !m.Reject
+		if r.poll() {
+			return r.becomeLeader()
+		}
+		rejected := 0
+		for id, granted := range r.votes {
+			if _, ok := r.peers[id]; ok && !granted {
+				rejected++
+			}
+		}
+		if rejected >= r.quorum() {
+			r.becomeFollower(r.term, 0)
+		}
+	case pb.RaftMessageType_RAFT_APPEND:
+		if r.state != STATE_FOLLOWER {
+			r.becomeFollower(r.term, NodeId(m.From))
+		}
+		r.lead = NodeId(m.From)
+		r.electionElapsed = 0
+		return r.handleAppend(m)
+	case pb.RaftMessageType_RAFT_APPEND_RESPONSE:
+		if r.state == STATE_LEADER {
+			r.handleAppendResponse(m)
+		}
+	}
+	return nil
+}
+
+func (r *Raft) handleVote(m *pb.RaftMessage) error {
+	canVote := r.vote == NodeId(m.From) || r.vote == 0 && r.lead == 0
+	upToDate := m.LogTerm > r.lastTerm() || m.LogTerm == r.lastTerm() && m.Index >= r.lastIndex()
+	resp := &pb.RaftMessage{Type: pb.RaftMessageType_RAFT_VOTE_RESPONSE, To: m.From}
+	if canVote && upToDate {
+		r.vote = NodeId(m.From)
+		r.resetElection()
+		if err := r.persistState(); err != nil {
+			return err
+		}
+	} else {
+		resp.Reject = true
+	}
+	r.send(resp)
+	return nil
+}
+
+func (r *Raft) handleAppend(m *pb.RaftMessage) error {
+	resp := &pb.RaftMessage{Type: pb.RaftMessageType_RAFT_APPEND_RESPONSE, To: m.From, Tick: m.Tick}
+	if m.Index > r.lastIndex() || r.termAt(m.Index) != m.LogTerm {
+		resp.Reject, resp.Index, resp.Hint = true, m.Index, r.lastIndex()
+		r.send(resp)
+		return nil
+	}
+	// entries already there are skipped, those conflicting are replaced
+	for i, ent := range m.Entries {
+		if ent.Index <= r.lastIndex() && r.termAt(ent.Index) == ent.Term {
+			continue
+		}
+		if ent.Index <= r.commit {
+			return errors.New("committed entry conflicts with the leader")
+		}
+		if err := r.appendEntries(m.Entries[i:]); err != nil {
+			return err
+		}
+		break
+	}
+	last := m.Index + uint64(len(m.Entries))
+	if m.Commit > r.commit {
+		r.commit = m.Commit
+		if r.commit > last {
+			r.commit = last
+		}
+	}
+	resp.Index = last
+	r.send(resp)
+	return nil
+}
+
+func (r *Raft) handleAppendResponse(m *pb.RaftMessage) {
+	pr, ok := r.progress[NodeId(m.From)]
+	if !ok {
+		return
+	}
+	pr.active = true
+	if m.Tick > pr.ack {
+		pr.ack = m.Tick
+	}
+	if m.Reject {
+		// go back to what the follower has, at least one before the entry rejected
+		next := m.Hint + 1
+		if m.Index < next {
+			next = m.Index
+		}
+		if next <= pr.match {
+			next = pr.match + 1
+		}
+		pr.next = next
+		r.sendAppend(NodeId(m.From))
+		return
+	}
+	if m.Index > pr.match {
+		pr.match = m.Index
+	}
+	if pr.next <= pr.match {
+		pr.next = pr.match + 1
+	}
+	if r.maybeCommit() {
+		r.broadcastAppend()
+		r.stepDownIfRemoved()
+	} else if pr.next <= r.lastIndex() {
+		r.sendAppend(NodeId(m.From))
+	}
+}
+
+// Commit entries matched by a quorum of members, returning whether anything is committed.
+// Only entries of the current term are counted, older ones are committed along with them.
+func (r *Raft) maybeCommit() bool {
+	matches := make([]uint64, 0, len(r.peers))
+	for id := range r.peers {
+		if pr, ok := r.progress[id]; ok {
+			matches = append(matches, pr.match)
+		}
+	}
+	if len(matches) < r.quorum() {
+		return false
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+	index := matches[r.quorum()-1]
+	if index <= r.commit || r.termAt(index) != r.term {
+		return false
+	}
+	r.commit = index
+	return true
+}
+
+// A leader removed from the group leads until the removal is committed.
+func (r *Raft) stepDownIfRemoved() {
+	if !r.promotable() && r.commit >= r.membershipIndex {
+		r.becomeFollower(r.term, 0)
+	}
+}
+
+func (r *Raft) broadcastAppend() {
+	for _, id := range r.members() {
+		if id != r.id {
+			r.sendAppend(id)
+		}
+	}
+}
+
+// Send entries the member does not have yet, as many as allowed in one append, or an empty one as heartbeat.
+// They are taken as received, and sent again from what the member has if it rejects a later append.
+func (r *Raft) sendAppend(to NodeId) {
+	pr := r.progress[to]
+	prev := pr.next - 1
+	last := r.lastIndex()
+	if last > prev+uint64(r.maxAppend) {
+		last = prev + uint64(r.maxAppend)
+	}
+	m := &pb.RaftMessage{
+		Type:    pb.RaftMessageType_RAFT_APPEND,
+		To:      uint64(to),
+		LogTerm: r.termAt(prev),
+		Index:   prev,
+		Commit:  r.commit,
+		Tick:    r.ticks,
+	}
+	if last > prev {
+		// copied, since messages could still be around once our log is replaced
+		m.Entries = append([]*pb.RaftEntry(nil), r.entries[prev:last]...)
+		pr.next = last + 1
+	}
+	r.send(m)
+}
+
+// Append an entry as the leader. Membership entries are only appended once the last one is committed.
+func (r *Raft) propose(typ pb.RaftEntryType, data []byte) (*pb.RaftEntry, error) {
+	if r.state != STATE_LEADER {
+		return nil, ENOTLEADER
+	}
+	if typ == pb.RaftEntryType_RAFT_MEMBERSHIP && r.membershipIndex > r.commit {
+		return nil, EPENDING
+	}
+	ent := &pb.RaftEntry{Term: r.term, Index: r.lastIndex() + 1, Type: typ, Data: data}
+	if err := r.appendEntries([]*pb.RaftEntry{ent}); err != nil {
+		return nil, err
+	}
+	r.selfMatched()
+	if r.maybeCommit() {
+		r.stepDownIfRemoved()
+	}
+	r.broadcastAppend()
+	return ent, nil
+}
+
+// Check whether no other leader could have been elected. A quorum of members has responded to appends
+// sent at some tick, after which none of them votes until an election timeout passes without hearing from us.
+// One tick is given up for ticks of members being out of phase.
+func (r *Raft) hasLease() bool {
+	if r.state != STATE_LEADER {
+		return false
+	}
+	acks := make([]uint64, 0, len(r.peers))
+	for id := range r.peers {
+		if pr, ok := r.progress[id]; ok {
+			acks = append(acks, pr.ack)
+		}
+	}
+	if len(acks) < r.quorum() {
+		return false
+	}
+	sort.Slice(acks, func(i, j int) bool { return acks[i] > acks[j] })
+	ack := acks[r.quorum()-1]
+	return ack > 0 && r.ticks+1 < ack+uint64(r.electionTicks)
+}
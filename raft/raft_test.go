@@ -0,0 +1,352 @@
+package raft_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// State machine keeping every command applied, as if persisted along with the index applied.
+type recorder struct {
+	lock     sync.Mutex
+	applied  uint64
+	commands []string
+	leader   bool
+}
+
+func (r *recorder) Apply(index uint64, data []byte) (interface{}, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.applied = index
+	r.commands = append(r.commands, string(data))
+	return len(r.commands), nil
+}
+
+func (r *recorder) Applied() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.applied
+}
+
+func (r *recorder) LeaderChanged(leader bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.leader = leader
+}
+
+func (r *recorder) get() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+// A group in process, driven by ticks.
+type group struct {
+	t        *testing.T
+	net      *raft.Network
+	nodes    map[raft.NodeId]*raft.Node
+	storages map[raft.NodeId]*raft.MemoryStorage
+	fsms     map[raft.NodeId]*recorder
+}
+
+func newGroup(t *testing.T, n int) *group {
+	g := &group{
+		t:        t,
+		net:      raft.NewNetwork(),
+		nodes:    make(map[raft.NodeId]*raft.Node),
+		storages: make(map[raft.NodeId]*raft.MemoryStorage),
+		fsms:     make(map[raft.NodeId]*recorder),
+	}
+	peers := make(map[raft.NodeId]string)
+	for i := 1; i <= n; i++ {
+		peers[raft.NodeId(i)] = fmt.Sprintf("node-%d", i)
+	}
+	for id := range peers {
+		g.start(id, peers)
+	}
+	return g
+}
+
+// Start node `id`, or restart it with what it has persisted.
+func (g *group) start(id raft.NodeId, peers map[raft.NodeId]string) {
+	if _, ok := g.storages[id]; !ok {
+		g.storages[id] = raft.NewMemoryStorage()
+		g.fsms[id] = &recorder{}
+	}
+	node, err := raft.NewNode(raft.Config{
+		Id:      id,
+		Group:   1,
+		Peers:   peers,
+		Storage: g.storages[id],
+		Seed:    int64(id),
+	}, g.fsms[id], g.net)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	g.nodes[id] = node
+	g.net.Join(node)
+}
+
+func (g *group) stop(id raft.NodeId) {
+	g.nodes[id].Stop()
+	g.net.Leave(id)
+	delete(g.nodes, id)
+}
+
+func (g *group) ids() []raft.NodeId {
+	var ids []raft.NodeId
+	for id := range g.nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Tick every node `n` times, delivering messages after each tick.
+func (g *group) tick(n int) {
+	for i := 0; i < n; i++ {
+		for _, id := range g.ids() {
+			g.nodes[id].Tick()
+		}
+		g.net.Deliver()
+	}
+}
+
+// Get leaders among nodes running.
+func (g *group) leaders() []raft.NodeId {
+	var ret []raft.NodeId
+	for _, id := range g.ids() {
+		if g.nodes[id].Status().State == raft.STATE_LEADER {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// Tick until some node other than `except` leads, failing if none does in a few election timeouts.
+func (g *group) waitLeader(except ...raft.NodeId) *raft.Node {
+	for i := 0; i < 100; i++ {
+		g.tick(1)
+		for _, id := range g.leaders() {
+			excluded := false
+			for _, e := range except {
+				excluded = excluded || e == id
+			}
+			if !excluded {
+				return g.nodes[id]
+			}
+		}
+	}
+	g.t.Fatal("no leader elected")
+	return nil
+}
+
+func (g *group) propose(node *raft.Node, command string) *raft.Proposal {
+	p, err := node.Propose([]byte(command))
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	return p
+}
+
+func TestRaft_Election(t *testing.T) {
+	g := newGroup(t, 3)
+	leader := g.waitLeader()
+	g.tick(20)
+	assert.Equal(t, []raft.NodeId{leader.Id()}, g.leaders())
+	status := leader.Status()
+	for _, node := range g.nodes {
+		assert.Equal(t, status.Term, node.Status().Term)
+		assert.Equal(t, leader.Id(), node.Status().Lead)
+	}
+	assert.True(t, g.fsms[leader.Id()].leader)
+	_, err := g.nodes[status.Id%3+1].Propose([]byte("x"))
+	assert.Equal(t, raft.ENOTLEADER, err)
+}
+
+func TestRaft_Replication(t *testing.T) {
+	g := newGroup(t, 3)
+	leader := g.waitLeader()
+	var proposals []*raft.Proposal
+	for i := 0; i < 100; i++ {
+		proposals = append(proposals, g.propose(leader, fmt.Sprint(i)))
+	}
+	g.net.Deliver()
+	for i, p := range proposals {
+		result, err := p.Wait(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, i+1, result)
+	}
+	g.tick(2)
+	for id := range g.nodes {
+		assert.Len(t, g.fsms[id].get(), 100, "node %d", id)
+	}
+	assert.Equal(t, g.fsms[1].get(), g.fsms[2].get())
+	assert.Equal(t, g.fsms[1].get(), g.fsms[3].get())
+}
+
+func TestRaft_LeaderPartitioned(t *testing.T) {
+	g := newGroup(t, 5)
+	old := g.waitLeader()
+	g.propose(old, "committed")
+	g.tick(2)
+
+	// cut off from a quorum, what it takes is never committed
+	g.net.Isolate(old.Id())
+	lost := g.propose(old, "lost")
+	leader := g.waitLeader(old.Id())
+	kept := g.propose(leader, "kept")
+	g.tick(30)
+	assert.NotEqual(t, raft.STATE_LEADER, old.Status().State)
+	assert.False(t, old.HasLease())
+	assert.True(t, leader.HasLease())
+
+	g.net.Heal()
+	g.tick(30)
+	_, err := lost.Result()
+	assert.Equal(t, raft.EDROPPED, err)
+	_, err = kept.Result()
+	assert.Nil(t, err)
+	for id := range g.nodes {
+		assert.Equal(t, []string{"committed", "kept"}, g.fsms[id].get(), "node %d", id)
+	}
+	assert.Len(t, g.leaders(), 1)
+}
+
+func TestRaft_Lease(t *testing.T) {
+	g := newGroup(t, 3)
+	old := g.waitLeader()
+	g.tick(2)
+	assert.True(t, old.HasLease())
+	// the lease is gone by the time anyone else is elected
+	g.net.Isolate(old.Id())
+	for i := 0; i < 100; i++ {
+		g.nodes[old.Id()].Tick()
+		if old.HasLease() {
+			assert.Len(t, g.leaders(), 1)
+		}
+		for _, id := range g.ids() {
+			if id != old.Id() {
+				g.nodes[id].Tick()
+			}
+		}
+		g.net.Deliver()
+	}
+	assert.False(t, old.HasLease())
+	assert.Len(t, g.leaders(), 1)
+}
+
+func TestRaft_Membership(t *testing.T) {
+	g := newGroup(t, 3)
+	leader := g.waitLeader()
+	g.propose(leader, "a")
+	g.tick(2)
+
+	// a new node knows nobody until the leader adds it
+	g.start(4, nil)
+	p, err := leader.AddMember(4, "node-4")
+	assert.Nil(t, err)
+	_, err = leader.AddMember(5, "node-5")
+	assert.Equal(t, raft.EPENDING, err)
+	g.tick(5)
+	_, err = p.Result()
+	assert.Nil(t, err)
+	assert.Len(t, g.nodes[4].Status().Members, 4)
+	assert.Equal(t, []string{"a"}, g.fsms[4].get())
+
+	// the leader removes itself, and steps down once it is committed
+	p, err = leader.RemoveMember(leader.Id())
+	assert.Nil(t, err)
+	g.tick(5)
+	_, err = p.Result()
+	assert.Nil(t, err)
+	assert.NotEqual(t, raft.STATE_LEADER, leader.Status().State)
+	g.stop(leader.Id())
+	next := g.waitLeader()
+	g.propose(next, "b")
+	g.tick(5)
+	for id := range g.nodes {
+		assert.Equal(t, []string{"a", "b"}, g.fsms[id].get(), "node %d", id)
+		assert.Len(t, g.nodes[id].Status().Members, 3)
+	}
+}
+
+func TestRaft_Restart(t *testing.T) {
+	g := newGroup(t, 3)
+	leader := g.waitLeader()
+	g.propose(leader, "a")
+	g.propose(leader, "b")
+	g.tick(2)
+	peers := leader.Status().Members
+	for _, id := range g.ids() {
+		g.stop(id)
+	}
+	for id := range peers {
+		g.start(id, peers)
+	}
+	leader = g.waitLeader()
+	p := g.propose(leader, "c")
+	g.tick(2)
+	_, err := p.Result()
+	assert.Nil(t, err)
+	for id := range g.nodes {
+		// applied ones are not applied again
+		assert.Equal(t, []string{"a", "b", "c"}, g.fsms[id].get(), "node %d", id)
+	}
+}
+
+func TestRaft_Deterministic(t *testing.T) {
+	run := func() []raft.Status {
+		g := newGroup(t, 5)
+		leader := g.waitLeader()
+		g.net.Isolate(leader.Id())
+		g.waitLeader(leader.Id())
+		g.net.Heal()
+		g.tick(20)
+		var ret []raft.Status
+		for _, id := range g.ids() {
+			ret = append(ret, g.nodes[id].Status())
+		}
+		return ret
+	}
+	assert.Equal(t, run(), run())
+}
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := raft.NewFileStorage(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, s.SetHardState(&pb.RaftHardState{Term: 2, Vote: 1}))
+	var entries []*pb.RaftEntry
+	for i := uint64(1); i <= 5; i++ {
+		entries = append(entries, &pb.RaftEntry{Term: 1, Index: i, Data: []byte{byte(i)}})
+	}
+	assert.Nil(t, s.Append(entries))
+	// replacing the last two
+	assert.Nil(t, s.Append([]*pb.RaftEntry{{Term: 2, Index: 4}}))
+	assert.Nil(t, s.Close())
+
+	s, err = raft.NewFileStorage(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+	state, loaded, err := s.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), state.Term)
+	assert.Equal(t, uint64(1), state.Vote)
+	if assert.Len(t, loaded, 4) {
+		assert.Equal(t, uint64(2), loaded[3].Term)
+		assert.Equal(t, []byte{3}, loaded[2].Data)
+	}
+}
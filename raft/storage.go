@@ -0,0 +1,254 @@
+package raft
+
+// persistence of terms, votes & log entries
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	STATE_FILENAME = "raft-state"
+	LOG_FILENAME   = "raft-log"
+)
+
+// Where a node persists what it must not forget across restarts. Everything is persisted before
+// the node sends any message depending on it.
+type Storage interface {
+	// Get the hard state & every entry persisted, empty ones if there is nothing.
+	Load() (*pb.RaftHardState, []*pb.RaftEntry, error)
+	SetHardState(state *pb.RaftHardState) error
+	// Append entries of consecutive indices, replacing those persisted from the index of the first one on.
+	Append(entries []*pb.RaftEntry) error
+}
+
+// Storage in memory, for tests. What is persisted survives as long as it is kept,
+// so a node restarted with it behaves as if restarted from disk.
+type MemoryStorage struct {
+	lock    sync.Mutex
+	state   pb.RaftHardState
+	entries []*pb.RaftEntry
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (m *MemoryStorage) Load() (*pb.RaftHardState, []*pb.RaftEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	state := m.state
+	return &state, append([]*pb.RaftEntry(nil), m.entries...), nil
+}
+
+func (m *MemoryStorage) SetHardState(state *pb.RaftHardState) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.state = *state
+	return nil
+}
+
+func (m *MemoryStorage) Append(entries []*pb.RaftEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.entries = append(truncateEntries(m.entries, entries[0].Index), entries...)
+	return nil
+}
+
+// Drop entries from `index` on.
+func truncateEntries(entries []*pb.RaftEntry, index uint64) []*pb.RaftEntry {
+	for i, ent := range entries {
+		if ent.Index >= index {
+			return entries[:i]
+		}
+	}
+	return entries
+}
+
+// Storage in files of a directory: the hard state, and entries appended to a log file,
+// each prefixed with its length. The log file is rewritten if entries are replaced, which is rare.
+type FileStorage struct {
+	lock sync.Mutex
+	dir  string
+	log  *os.File
+	// entries in the log file, for rewriting it
+	entries []*pb.RaftEntry
+}
+
+// Open storage in `dir`, creating its files if they do not exist.
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f := &FileStorage{dir: dir}
+	entries, err := f.readLog()
+	if err != nil {
+		return nil, err
+	}
+	f.entries = entries
+	f.log, err = os.OpenFile(path.Join(dir, LOG_FILENAME), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Read entries in the log file, dropping a torn one at its end.
+func (f *FileStorage) readLog() ([]*pb.RaftEntry, error) {
+	file, err := os.Open(path.Join(f.dir, LOG_FILENAME))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var entries []*pb.RaftEntry
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		bin := make([]byte, size)
+		if _, err := io.ReadFull(r, bin); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var ent pb.RaftEntry
+		if err := proto.Unmarshal(bin, &ent); err != nil {
+			return nil, err
+		}
+		entries = append(truncateEntries(entries, ent.Index), &ent)
+	}
+}
+
+func (f *FileStorage) Load() (*pb.RaftHardState, []*pb.RaftEntry, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var state pb.RaftHardState
+	bin, err := ioutil.ReadFile(path.Join(f.dir, STATE_FILENAME))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if err := proto.Unmarshal(bin, &state); err != nil {
+		return nil, nil, err
+	}
+	return &state, append([]*pb.RaftEntry(nil), f.entries...), nil
+}
+
+func (f *FileStorage) SetHardState(state *pb.RaftHardState) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	bin, err := proto.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(path.Join(f.dir, STATE_FILENAME), bin)
+}
+
+func (f *FileStorage) Append(entries []*pb.RaftEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	kept := truncateEntries(f.entries, entries[0].Index)
+	if len(kept) < len(f.entries) {
+		// entries replaced, keep what is left only
+		if err := f.rewrite(kept); err != nil {
+			return err
+		}
+	}
+	var buf []byte
+	for _, ent := range entries {
+		bin, err := proto.Marshal(ent)
+		if err != nil {
+			return err
+		}
+		buf = appendRecord(buf, bin)
+	}
+	if _, err := f.log.Write(buf); err != nil {
+		return err
+	}
+	if err := f.log.Sync(); err != nil {
+		return err
+	}
+	f.entries = append(kept, entries...)
+	return nil
+}
+
+// Replace the log file with one of `entries`.
+func (f *FileStorage) rewrite(entries []*pb.RaftEntry) error {
+	var buf []byte
+	for _, ent := range entries {
+		bin, err := proto.Marshal(ent)
+		if err != nil {
+			return err
+		}
+		buf = appendRecord(buf, bin)
+	}
+	_ = f.log.Close()
+	if err := writeFileSync(path.Join(f.dir, LOG_FILENAME), buf); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(path.Join(f.dir, LOG_FILENAME), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	f.log = log
+	f.entries = entries
+	return nil
+}
+
+func (f *FileStorage) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.log == nil {
+		return errors.New("storage closed")
+	}
+	err := f.log.Close()
+	f.log = nil
+	return err
+}
+
+func appendRecord(buf []byte, bin []byte) []byte {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(bin)))
+	return append(append(buf, size[:]...), bin...)
+}
+
+// Write a file & sync it, replacing it atomically through a temporary file.
+func writeFileSync(file string, bin []byte) error {
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bin); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
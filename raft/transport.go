@@ -0,0 +1,212 @@
+package raft
+
+// transports between members: in process for tests, and over gRPC
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+)
+
+const (
+	// messages waiting to be sent to a member before new ones are dropped
+	SEND_QUEUE_SIZE = 1024
+	// time given to a member to take a message
+	SEND_TIMEOUT = time.Second
+)
+
+// Members of a group in process, exchanging messages through a queue. Messages are only delivered
+// when asked to, in the order they are sent, so that groups driven by ticks behave the same every time.
+// Links could be cut to simulate partitions, messages over them are dropped.
+type Network struct {
+	lock  sync.Mutex
+	nodes map[NodeId]*Node
+	queue []*pb.RaftMessage
+	cut   map[[2]NodeId]bool
+	// wakes up Run once messages are sent
+	sent chan struct{}
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		nodes: make(map[NodeId]*Node),
+		cut:   make(map[[2]NodeId]bool),
+		sent:  make(chan struct{}, 1),
+	}
+}
+
+// Put node on the network, replacing the one of the same id, e.g. once it is restarted.
+func (net *Network) Join(n *Node) {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	net.nodes[n.Id()] = n
+}
+
+// Take node `id` off the network, e.g. once it crashes. Messages to it are dropped.
+func (net *Network) Leave(id NodeId) {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	delete(net.nodes, id)
+}
+
+// Drop messages between `a` and `b`, both ways.
+func (net *Network) Cut(a, b NodeId) {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	net.cut[[2]NodeId{a, b}] = true
+	net.cut[[2]NodeId{b, a}] = true
+}
+
+// Cut node `id` off from every other one.
+func (net *Network) Isolate(id NodeId) {
+	net.lock.Lock()
+	ids := make([]NodeId, 0, len(net.nodes))
+	for other := range net.nodes {
+		ids = append(ids, other)
+	}
+	net.lock.Unlock()
+	for _, other := range ids {
+		if other != id {
+			net.Cut(id, other)
+		}
+	}
+}
+
+// Restore every link cut.
+func (net *Network) Heal() {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	net.cut = make(map[[2]NodeId]bool)
+}
+
+func (net *Network) Send(_ string, m *pb.RaftMessage) {
+	net.lock.Lock()
+	net.queue = append(net.queue, m)
+	net.lock.Unlock()
+	select {
+	case net.sent <- struct{}{}:
+	default:
+	}
+}
+
+// Deliver messages sent, and those sent in response, until there are none.
+// Returns the number of messages delivered.
+func (net *Network) Deliver() int {
+	delivered := 0
+	for {
+		net.lock.Lock()
+		queue := net.queue
+		net.queue = nil
+		net.lock.Unlock()
+		if len(queue) == 0 {
+			return delivered
+		}
+		for _, m := range queue {
+			net.lock.Lock()
+			n, ok := net.nodes[NodeId(m.To)]
+			cut := net.cut[[2]NodeId{NodeId(m.From), NodeId(m.To)}]
+			net.lock.Unlock()
+			if ok && !cut {
+				n.Step(m)
+				delivered++
+			}
+		}
+	}
+}
+
+// Deliver messages as soon as they are sent until `stopCh` is closed, for nodes ticking on their own.
+func (net *Network) Run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-net.sent:
+			net.Deliver()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Transport over gRPC. Messages to each member are sent in order from a queue of its own,
+// and dropped once the queue is full, e.g. when the member is down.
+type GrpcTransport struct {
+	lock   sync.Mutex
+	queues map[string]chan *pb.RaftMessage
+	stopCh chan struct{}
+}
+
+func NewGrpcTransport() *GrpcTransport {
+	return &GrpcTransport{
+		queues: make(map[string]chan *pb.RaftMessage),
+		stopCh: make(chan struct{}),
+	}
+}
+
+func (t *GrpcTransport) Send(addr string, m *pb.RaftMessage) {
+	if addr == "" {
+		return
+	}
+	t.lock.Lock()
+	queue, ok := t.queues[addr]
+	if !ok {
+		queue = make(chan *pb.RaftMessage, SEND_QUEUE_SIZE)
+		t.queues[addr] = queue
+		go t.sendRoutine(addr, queue)
+	}
+	t.lock.Unlock()
+	select {
+	case queue <- m:
+	default:
+	}
+}
+
+func (t *GrpcTransport) sendRoutine(addr string, queue chan *pb.RaftMessage) {
+	log := common.SugaredLog()
+	conn, err := common.ConnectGrpc(addr)
+	if err != nil {
+		log.Errorf("Failed to connect to raft member at %s: %v", addr, err)
+		t.lock.Lock()
+		delete(t.queues, addr)
+		t.lock.Unlock()
+		return
+	}
+	defer conn.Close()
+	client := pb.NewKVRaftClient(conn)
+	for {
+		select {
+		case m := <-queue:
+			ctx, cancel := context.WithTimeout(context.Background(), SEND_TIMEOUT)
+			_, err := client.Step(ctx, m, grpc.WaitForReady(false))
+			cancel()
+			if err != nil {
+				log.Debugf("Failed to send raft message to %s: %v", addr, err)
+			}
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+// Stop sending messages.
+func (t *GrpcTransport) Close() {
+	close(t.stopCh)
+}
+
+// gRPC service taking messages for a node.
+type Service struct {
+	pb.UnimplementedKVRaftServer
+	node *Node
+}
+
+func NewService(node *Node) *Service {
+	return &Service{node: node}
+}
+
+func (s *Service) Step(_ context.Context, m *pb.RaftMessage) (*empty.Empty, error) {
+	s.node.Step(m)
+	return &empty.Empty{}, nil
+}
@@ -0,0 +1,379 @@
+package resp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+)
+
+const DEFAULT_SCAN_COUNT = 10
+
+func (s *Server) ping(_ context.Context, w *Writer, args []string) {
+	if len(args) > 0 {
+		w.Bulk(args[0])
+	} else {
+		w.SimpleString("PONG")
+	}
+}
+
+func (s *Server) echo(_ context.Context, w *Writer, args []string) {
+	w.Bulk(args[0])
+}
+
+// clients send COMMAND on connect, an empty reply is fine for them
+func (s *Server) commandCmd(_ context.Context, w *Writer, _ []string) {
+	w.Array(0)
+}
+
+func (s *Server) selectCmd(_ context.Context, w *Writer, args []string) {
+	if args[0] != "0" {
+		w.Error("ERR only database 0 is supported")
+		return
+	}
+	w.SimpleString("OK")
+}
+
+// Get a key, treating keys whose TTL elapsed as missing.
+func (s *Server) getValue(ctx context.Context, key string) (string, error) {
+	if s.expiry.Expired(key, time.Now()) {
+		return "", client.ENOENT
+	}
+	return s.kv.Get(ctx, key)
+}
+
+func (s *Server) get(ctx context.Context, w *Writer, args []string) {
+	value, err := s.getValue(ctx, args[0])
+	if errors.Is(err, client.ENOENT) {
+		w.Null()
+	} else if err != nil {
+		writeKVError(w, err)
+	} else {
+		w.Bulk(value)
+	}
+}
+
+// SET key value [EX seconds | PX milliseconds] [NX | XX]
+func (s *Server) set(ctx context.Context, w *Writer, args []string) {
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	nx, xx := false, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "ex", "px":
+			if i+1 >= len(args) {
+				w.Error("ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				w.Error("ERR invalid expire time in 'set' command")
+				return
+			}
+			if strings.ToLower(args[i]) == "ex" {
+				ttl = time.Duration(n) * time.Second
+			} else {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+			i++
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		default:
+			w.Error("ERR syntax error")
+			return
+		}
+	}
+	if nx && xx {
+		w.Error("ERR syntax error")
+		return
+	}
+	l := s.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	if nx || xx {
+		_, err := s.getValue(ctx, key)
+		if err != nil && !errors.Is(err, client.ENOENT) {
+			writeKVError(w, err)
+			return
+		}
+		exists := err == nil
+		if (nx && exists) || (xx && !exists) {
+			w.Null()
+			return
+		}
+	}
+	if err := s.kv.Put(ctx, key, value); err != nil {
+		writeKVError(w, err)
+		return
+	}
+	if ttl > 0 {
+		s.expiry.Set(key, time.Now().Add(ttl))
+	} else {
+		s.expiry.Clear(key)
+	}
+	w.SimpleString("OK")
+}
+
+func (s *Server) del(ctx context.Context, w *Writer, args []string) {
+	var n int64 = 0
+	now := time.Now()
+	for _, r := range s.kv.BatchDelete(ctx, args) {
+		if r.Err != nil && !errors.Is(r.Err, client.ENOENT) {
+			writeKVError(w, r.Err)
+			return
+		}
+		if r.Err == nil && !s.expiry.Expired(r.Key, now) {
+			n++
+		}
+		s.expiry.Clear(r.Key)
+	}
+	w.Integer(n)
+}
+
+func (s *Server) mget(ctx context.Context, w *Writer, args []string) {
+	results := s.kv.BatchGet(ctx, args)
+	for _, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, client.ENOENT) {
+			writeKVError(w, r.Err)
+			return
+		}
+	}
+	now := time.Now()
+	w.Array(len(results))
+	for _, r := range results {
+		if r.Err != nil || s.expiry.Expired(r.Key, now) {
+			w.Null()
+		} else {
+			w.Bulk(r.Value)
+		}
+	}
+}
+
+func (s *Server) mset(ctx context.Context, w *Writer, args []string) {
+	if len(args)%2 != 0 {
+		w.Error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	pairs := make([]client.KeyValue, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		pairs = append(pairs, client.KeyValue{Key: args[i], Value: args[i+1]})
+	}
+	for _, r := range s.kv.BatchPut(ctx, pairs) {
+		if r.Err != nil {
+			writeKVError(w, r.Err)
+			return
+		}
+		s.expiry.Clear(r.Key)
+	}
+	w.SimpleString("OK")
+}
+
+func (s *Server) exists(ctx context.Context, w *Writer, args []string) {
+	var n int64 = 0
+	now := time.Now()
+	for _, r := range s.kv.BatchGet(ctx, args) {
+		if r.Err != nil && !errors.Is(r.Err, client.ENOENT) {
+			writeKVError(w, r.Err)
+			return
+		}
+		if r.Err == nil && !s.expiry.Expired(r.Key, now) {
+			n++
+		}
+	}
+	w.Integer(n)
+}
+
+// INCR is atomic among clients of the same front-end only.
+func (s *Server) incr(ctx context.Context, w *Writer, args []string) {
+	key := args[0]
+	l := s.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	var n int64 = 0
+	value, err := s.getValue(ctx, key)
+	if err == nil {
+		n, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			w.Error("ERR value is not an integer or out of range")
+			return
+		}
+	} else if !errors.Is(err, client.ENOENT) {
+		writeKVError(w, err)
+		return
+	}
+	n++
+	if err := s.kv.Put(ctx, key, strconv.FormatInt(n, 10)); err != nil {
+		writeKVError(w, err)
+		return
+	}
+	w.Integer(n)
+}
+
+func (s *Server) expire(ctx context.Context, w *Writer, args []string) {
+	key := args[0]
+	seconds, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		w.Error("ERR value is not an integer or out of range")
+		return
+	}
+	l := s.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	if _, err := s.getValue(ctx, key); errors.Is(err, client.ENOENT) {
+		w.Integer(0)
+		return
+	} else if err != nil {
+		writeKVError(w, err)
+		return
+	}
+	if seconds <= 0 {
+		s.expiry.Clear(key)
+		if err := s.kv.Delete(ctx, key); err != nil && !errors.Is(err, client.ENOENT) {
+			writeKVError(w, err)
+			return
+		}
+	} else {
+		s.expiry.Set(key, time.Now().Add(time.Duration(seconds)*time.Second))
+	}
+	w.Integer(1)
+}
+
+func (s *Server) ttl(ctx context.Context, w *Writer, args []string) {
+	key := args[0]
+	if _, err := s.getValue(ctx, key); errors.Is(err, client.ENOENT) {
+		w.Integer(-2)
+		return
+	} else if err != nil {
+		writeKVError(w, err)
+		return
+	}
+	deadline, ok := s.expiry.Get(key)
+	if !ok {
+		w.Integer(-1)
+		return
+	}
+	w.Integer(int64((time.Until(deadline) + time.Second - 1) / time.Second))
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+func (s *Server) scan(ctx context.Context, w *Writer, args []string) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		w.Error("ERR invalid cursor")
+		return
+	}
+	start := ""
+	if cursor != 0 {
+		var ok bool
+		if start, ok = s.cursors.Get(cursor); !ok {
+			w.Error("ERR invalid cursor")
+			return
+		}
+	}
+	pattern := "*"
+	count := DEFAULT_SCAN_COUNT
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.Error("ERR syntax error")
+			return
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				w.Error("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.Error("ERR syntax error")
+			return
+		}
+	}
+	prefix := globPrefix(pattern)
+	if start < prefix {
+		// nothing before the prefix could match
+		start = ""
+	}
+	pairs, next, err := s.kv.Scan(ctx, prefix, start, count)
+	if err != nil {
+		writeKVError(w, err)
+		return
+	}
+	var keys []string
+	now := time.Now()
+	for _, p := range pairs {
+		if globMatch(pattern, p.Key) && !s.expiry.Expired(p.Key, now) {
+			keys = append(keys, p.Key)
+		}
+	}
+	var nextCursor uint64 = 0
+	if next != "" {
+		nextCursor = s.cursors.Issue(next)
+	}
+	w.Array(2)
+	w.Bulk(strconv.FormatUint(nextCursor, 10))
+	w.Array(len(keys))
+	for _, k := range keys {
+		w.Bulk(k)
+	}
+}
+
+// CLUSTER SLOTS | CLUSTER KEYSLOT key
+// The KV store does not hash keys like Redis (CRC32 over 1024 slots instead of CRC16 over 16384),
+// so no Redis slot is owned by a single worker. Rather than claim ownership it does not have,
+// each front-end announces itself for the whole slot space and routes keys to their owners itself.
+func (s *Server) cluster(_ context.Context, w *Writer, args []string) {
+	switch strings.ToLower(args[0]) {
+	case "keyslot":
+		if len(args) != 2 {
+			w.Error("ERR wrong number of arguments for 'cluster keyslot' command")
+			return
+		}
+		w.Integer(int64(KeySlot(args[1])))
+	case "slots":
+		w.Array(1)
+		w.Array(3)
+		w.Integer(0)
+		w.Integer(REDIS_SLOT_COUNT - 1)
+		w.Array(3)
+		w.Bulk(s.Advertise.Hostname)
+		w.Integer(int64(s.Advertise.Port))
+		w.Bulk(fmt.Sprintf("%s:%d", s.Advertise.Hostname, s.Advertise.Port))
+	default:
+		w.Error("ERR unknown subcommand '" + args[0] + "'")
+	}
+}
+
+// Redis slot of a key, as cluster-aware clients compute it: CRC16 of the key, or of its hash tag if it has one.
+func KeySlot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(key)) % REDIS_SLOT_COUNT
+}
+
+// CRC16-CCITT (XMODEM) as used by Redis cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,95 @@
+package resp
+
+import (
+	"sync"
+	"time"
+)
+
+// Deadlines of keys with a TTL.
+// The KV store has no notion of expiration, so TTLs are kept by the front-end itself:
+// they do not survive a restart and are not shared between front-ends. A key given a TTL through one front-end
+// is only hidden and swept by that one, others and clients of the KV store keep seeing it until it is deleted,
+// and if that front-end restarts before the deadline, the key never expires.
+type expiryTable struct {
+	lock      sync.Mutex
+	deadlines map[string]time.Time
+}
+
+func newExpiryTable() *expiryTable {
+	return &expiryTable{deadlines: make(map[string]time.Time)}
+}
+
+func (t *expiryTable) Set(key string, deadline time.Time) {
+	t.lock.Lock()
+	t.deadlines[key] = deadline
+	t.lock.Unlock()
+}
+
+func (t *expiryTable) Clear(key string) {
+	t.lock.Lock()
+	delete(t.deadlines, key)
+	t.lock.Unlock()
+}
+
+// Get the deadline of a key, ok is false if it has none.
+func (t *expiryTable) Get(key string) (deadline time.Time, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	deadline, ok = t.deadlines[key]
+	return
+}
+
+func (t *expiryTable) Expired(key string, now time.Time) bool {
+	deadline, ok := t.Get(key)
+	return ok && !now.Before(deadline)
+}
+
+// Remove and return all keys that expired before `now`.
+func (t *expiryTable) PopExpired(now time.Time) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var ret []string
+	for k, d := range t.deadlines {
+		if !now.Before(d) {
+			ret = append(ret, k)
+			delete(t.deadlines, k)
+		}
+	}
+	return ret
+}
+
+const MAX_CURSORS = 4096
+
+// RESP cursors are integers while the KV store scans from a key, so remember the key behind each cursor.
+type cursorTable struct {
+	lock   sync.Mutex
+	next   uint64
+	keys   map[uint64]string
+	issued []uint64
+}
+
+func newCursorTable() *cursorTable {
+	return &cursorTable{next: 1, keys: make(map[uint64]string)}
+}
+
+func (t *cursorTable) Issue(key string) uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	id := t.next
+	t.next++
+	t.keys[id] = key
+	t.issued = append(t.issued, id)
+	if len(t.issued) > MAX_CURSORS {
+		// forget the oldest cursor
+		delete(t.keys, t.issued[0])
+		t.issued = t.issued[1:]
+	}
+	return id
+}
+
+func (t *cursorTable) Get(id uint64) (string, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	key, ok := t.keys[id]
+	return key, ok
+}
@@ -0,0 +1,88 @@
+package resp
+
+// Match `s` against a Redis style glob pattern, supporting `*`, `?`, `[...]`, `[^...]` and `\` escapes.
+func globMatch(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			negate := end < len(pattern) && pattern[end] == '^'
+			if negate {
+				end++
+			}
+			matched := false
+			for end < len(pattern) && pattern[end] != ']' {
+				c := pattern[end]
+				if c == '\\' && end+1 < len(pattern) {
+					end++
+					c = pattern[end]
+				}
+				if end+2 < len(pattern) && pattern[end+1] == '-' && pattern[end+2] != ']' {
+					lo, hi := c, pattern[end+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if s[0] >= lo && s[0] <= hi {
+						matched = true
+					}
+					end += 3
+					continue
+				}
+				if s[0] == c {
+					matched = true
+				}
+				end++
+			}
+			if matched == negate {
+				return false
+			}
+			if end < len(pattern) {
+				end++ // skip ']'
+			}
+			pattern, s = pattern[end:], s[1:]
+		default:
+			c := pattern[0]
+			if c == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+				c = pattern[0]
+			}
+			if len(s) == 0 || s[0] != c {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// The literal part of a glob pattern before its first special character.
+func globPrefix(pattern string) string {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[', '\\':
+			return pattern[:i]
+		}
+	}
+	return pattern
+}
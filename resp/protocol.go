@@ -0,0 +1,139 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAX_BULK_LENGTH  = 512 * 1024 * 1024
+	MAX_ARRAY_LENGTH = 1024 * 1024
+)
+
+var EPROTOCOL = errors.New("protocol error")
+
+// Reads RESP2 commands, either as arrays of bulk strings or as inline commands.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+func (r *Reader) readLine() (string, error) {
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// Parse the length of an array or a bulk string. Null ones (-1) are not expected in commands.
+func (r *Reader) readLength(line string, max int) (int, error) {
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > max {
+		return 0, EPROTOCOL
+	}
+	return n, nil
+}
+
+// Read the next command, returning its arguments.
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			continue
+		}
+		if line[0] != '*' {
+			// inline command, e.g. from telnet
+			return strings.Fields(line), nil
+		}
+		n, err := r.readLength(line, MAX_ARRAY_LENGTH)
+		if err != nil {
+			return nil, err
+		}
+		args := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			line, err := r.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if len(line) == 0 || line[0] != '$' {
+				return nil, EPROTOCOL
+			}
+			l, err := r.readLength(line, MAX_BULK_LENGTH)
+			if err != nil {
+				return nil, err
+			}
+			buf := make([]byte, l+2)
+			if _, err := io.ReadFull(r.r, buf); err != nil {
+				return nil, err
+			}
+			if buf[l] != '\r' || buf[l+1] != '\n' {
+				return nil, EPROTOCOL
+			}
+			args = append(args, string(buf[:l]))
+		}
+		if len(args) == 0 {
+			continue
+		}
+		return args, nil
+	}
+}
+
+// Writes RESP2 replies. Errors are sticky and reported by Flush.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) write(s string) {
+	if w.err == nil {
+		_, w.err = w.w.WriteString(s)
+	}
+}
+
+func (w *Writer) SimpleString(s string) {
+	w.write("+" + s + "\r\n")
+}
+
+// Error replies should start with an error code, like "ERR" or "WRONGTYPE".
+func (w *Writer) Error(s string) {
+	w.write("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(s) + "\r\n")
+}
+
+func (w *Writer) Integer(n int64) {
+	w.write(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *Writer) Bulk(s string) {
+	w.write(fmt.Sprintf("$%d\r\n%s\r\n", len(s), s))
+}
+
+func (w *Writer) Null() {
+	w.write("$-1\r\n")
+}
+
+// Start an array of `n` elements, which should be written right after.
+func (w *Writer) Array(n int) {
+	w.write(fmt.Sprintf("*%d\r\n", n))
+}
+
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,49 @@
+package resp_test
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/eyeKill/KV/resp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader_ReadCommand(t *testing.T) {
+	r := resp.NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$5\r\nhe\r\no\r\nPING  hello\r\n"))
+	args, err := r.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"SET", "a", "he\r\no"}, args)
+	args, err = r.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"PING", "hello"}, args)
+
+	for _, bad := range []string{"*1\r\n$-5\r\n", "*-1\r\n", "*-5\r\n", "*1\r\n$-1\r\n"} {
+		r = resp.NewReader(strings.NewReader(bad))
+		_, err = r.ReadCommand()
+		assert.Equal(t, resp.EPROTOCOL, err, bad)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := resp.NewWriter(&buf)
+	w.Array(4)
+	w.SimpleString("OK")
+	w.Integer(-2)
+	w.Bulk("a\r\nb")
+	w.Null()
+	w.Error("ERR oops")
+	assert.Nil(t, w.Flush())
+	assert.Equal(t, "*4\r\n+OK\r\n:-2\r\n$4\r\na\r\nb\r\n$-1\r\n-ERR oops\r\n", buf.String())
+	// what we write can be read back as a command
+	buf.Reset()
+	w.Array(2)
+	w.Bulk("GET")
+	w.Bulk("k")
+	assert.Nil(t, w.Flush())
+	args, err := resp.NewReader(bufio.NewReader(&buf)).ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"GET", "k"}, args)
+}
@@ -0,0 +1,183 @@
+// Redis RESP2 front-end for the KV store.
+// It translates Redis commands into calls through the client library, so any front-end serves any key.
+package resp
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	"go.uber.org/zap"
+)
+
+const (
+	SWEEP_INTERVAL = time.Second
+	LOCK_STRIPES   = 64
+	// number of slots in a Redis cluster
+	REDIS_SLOT_COUNT = 16384
+)
+
+type handler func(ctx context.Context, w *Writer, args []string)
+
+type command struct {
+	// Redis style arity: positive for exact number of arguments including the command name,
+	// negative for a minimum.
+	arity int
+	fn    handler
+}
+
+type Server struct {
+	kv *client.Client
+	// address announced in CLUSTER SLOTS
+	Advertise common.Node
+	commands  map[string]command
+	expiry    *expiryTable
+	cursors   *cursorTable
+	// striped locks serializing read-modify-write commands on the same key
+	locks [LOCK_STRIPES]sync.Mutex
+
+	lock     sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]bool
+	stopCh   chan struct{}
+}
+
+func NewServer(kv *client.Client, advertise common.Node) *Server {
+	s := &Server{
+		kv:        kv,
+		Advertise: advertise,
+		expiry:    newExpiryTable(),
+		cursors:   newCursorTable(),
+		conns:     make(map[net.Conn]bool),
+		stopCh:    make(chan struct{}),
+	}
+	s.commands = map[string]command{
+		"ping":    {-1, s.ping},
+		"echo":    {2, s.echo},
+		"command": {-1, s.commandCmd},
+		"select":  {2, s.selectCmd},
+		"get":     {2, s.get},
+		"set":     {-3, s.set},
+		"del":     {-2, s.del},
+		"mget":    {-2, s.mget},
+		"mset":    {-3, s.mset},
+		"exists":  {-2, s.exists},
+		"incr":    {2, s.incr},
+		"expire":  {3, s.expire},
+		"ttl":     {2, s.ttl},
+		"scan":    {-2, s.scan},
+		"cluster": {-2, s.cluster},
+	}
+	return s
+}
+
+// Serve connections on `l` until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	s.listener = l
+	s.lock.Unlock()
+	go s.sweep()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.stopCh:
+				return nil
+			default:
+				return err
+			}
+		}
+		s.lock.Lock()
+		s.conns[conn] = true
+		s.lock.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	close(s.stopCh)
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+// delete keys whose TTL elapsed
+func (s *Server) sweep() {
+	ticker := time.NewTicker(SWEEP_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, k := range s.expiry.PopExpired(now) {
+				if err := s.kv.Delete(context.Background(), k); err != nil && !errors.Is(err, client.ENOENT) {
+					common.Log().Warn("Failed to delete expired key.", zap.String("key", k), zap.Error(err))
+				}
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	log := common.Log()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		_ = conn.Close()
+	}()
+	r := NewReader(conn)
+	w := NewWriter(conn)
+	ctx := context.Background()
+	for {
+		args, err := r.ReadCommand()
+		if err != nil {
+			if err == EPROTOCOL {
+				w.Error("ERR Protocol error")
+				_ = w.Flush()
+			} else if err != io.EOF {
+				log.Debug("Connection closed.", zap.Error(err))
+			}
+			return
+		}
+		name := strings.ToLower(args[0])
+		if name == "quit" {
+			w.SimpleString("OK")
+			_ = w.Flush()
+			return
+		}
+		cmd, ok := s.commands[name]
+		if !ok {
+			w.Error("ERR unknown command '" + args[0] + "'")
+		} else if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+			w.Error("ERR wrong number of arguments for '" + name + "' command")
+		} else {
+			cmd.fn(ctx, w, args[1:])
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) keyLock(key string) *sync.Mutex {
+	return &s.locks[crc32.ChecksumIEEE([]byte(key))%LOCK_STRIPES]
+}
+
+// Reply with an error for a failed KV request.
+func writeKVError(w *Writer, err error) {
+	w.Error("ERR " + err.Error())
+}
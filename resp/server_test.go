@@ -0,0 +1,196 @@
+package resp_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	"github.com/eyeKill/KV/localcluster"
+	"github.com/eyeKill/KV/resp"
+	"github.com/stretchr/testify/assert"
+)
+
+// minimal RESP2 client for testing
+type conn struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+	w *resp.Writer
+}
+
+func (c *conn) do(args ...string) interface{} {
+	c.w.Array(len(args))
+	for _, a := range args {
+		c.w.Bulk(a)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+// Read a reply, errors are returned as `error`, nulls as nil.
+func (c *conn) read() interface{} {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		ret := make([]interface{}, n)
+		for i := range ret {
+			ret[i] = c.read()
+		}
+		return ret
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func startServer(t *testing.T, workers int) (*localcluster.Cluster, *resp.Server, *conn) {
+	c, err := localcluster.Start(workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := client.DefaultOptions(c.MasterAddr)
+	opts.BaseBackoff = time.Millisecond
+	kv, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := resp.NewServer(kv, common.Node{Hostname: "127.0.0.1", Port: uint16(l.Addr().(*net.TCPAddr).Port)})
+	go func() {
+		_ = s.Serve(l)
+	}()
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, s, &conn{t: t, c: nc, r: bufio.NewReader(nc), w: resp.NewWriter(nc)}
+}
+
+func TestServer_Strings(t *testing.T) {
+	c, s, r := startServer(t, 2)
+	defer c.Stop()
+	defer s.Close()
+
+	assert.Equal(t, "PONG", r.do("PING"))
+	assert.Equal(t, "OK", r.do("SET", "a", "1"))
+	assert.Equal(t, "1", r.do("GET", "a"))
+	assert.Nil(t, r.do("GET", "missing"))
+	assert.Nil(t, r.do("SET", "a", "2", "NX"))
+	assert.Equal(t, "OK", r.do("SET", "a", "2", "XX"))
+	assert.Nil(t, r.do("SET", "b", "2", "XX"))
+	assert.Equal(t, int64(3), r.do("INCR", "a"))
+	assert.Equal(t, int64(1), r.do("INCR", "counter"))
+	assert.Equal(t, "OK", r.do("MSET", "x", "1", "y", "2"))
+	assert.Equal(t, []interface{}{"1", nil, "2"}, r.do("MGET", "x", "missing", "y"))
+	assert.Equal(t, int64(2), r.do("EXISTS", "x", "y", "z"))
+	assert.Equal(t, int64(2), r.do("DEL", "x", "y", "z"))
+	assert.Equal(t, int64(0), r.do("EXISTS", "x", "y"))
+	_, isErr := r.do("GET").(error)
+	assert.True(t, isErr)
+	_, isErr = r.do("NOSUCHCOMMAND").(error)
+	assert.True(t, isErr)
+}
+
+func TestServer_Expire(t *testing.T) {
+	c, s, r := startServer(t, 1)
+	defer c.Stop()
+	defer s.Close()
+
+	assert.Equal(t, "OK", r.do("SET", "a", "1", "PX", "100"))
+	ttl := r.do("TTL", "a")
+	assert.Equal(t, int64(1), ttl)
+	assert.Equal(t, int64(-2), r.do("TTL", "missing"))
+	assert.Equal(t, "OK", r.do("SET", "b", "1"))
+	assert.Equal(t, int64(-1), r.do("TTL", "b"))
+	assert.Equal(t, int64(1), r.do("EXPIRE", "b", "100"))
+	assert.Equal(t, int64(0), r.do("EXPIRE", "missing", "100"))
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, r.do("GET", "a"))
+	assert.Equal(t, "1", r.do("GET", "b"))
+}
+
+func TestServer_Scan(t *testing.T) {
+	c, s, r := startServer(t, 3)
+	defer c.Stop()
+	defer s.Close()
+
+	for i := 0; i < 25; i++ {
+		assert.Equal(t, "OK", r.do("SET", fmt.Sprintf("user:%02d", i), "v"))
+	}
+	assert.Equal(t, "OK", r.do("SET", "other", "v"))
+	var keys []string
+	cursor := "0"
+	for {
+		reply := r.do("SCAN", cursor, "MATCH", "user:1*", "COUNT", "4").([]interface{})
+		for _, k := range reply[1].([]interface{}) {
+			keys = append(keys, k.(string))
+		}
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, 10, len(keys))
+	for i, k := range keys {
+		assert.Equal(t, fmt.Sprintf("user:1%d", i), k)
+	}
+}
+
+func TestServer_ClusterSlots(t *testing.T) {
+	c, s, r := startServer(t, 2)
+	defer c.Stop()
+	defer s.Close()
+
+	// every front-end serves every key, so it announces itself for all slots
+	reply := r.do("CLUSTER", "SLOTS").([]interface{})
+	assert.Equal(t, 1, len(reply))
+	e := reply[0].([]interface{})
+	assert.Equal(t, int64(0), e[0])
+	assert.Equal(t, int64(resp.REDIS_SLOT_COUNT-1), e[1])
+	node := e[2].([]interface{})
+	assert.Equal(t, "127.0.0.1", node[0])
+	assert.Equal(t, int64(s.Advertise.Port), node[1])
+	assert.Equal(t, int64(resp.KeySlot("foo")), r.do("CLUSTER", "KEYSLOT", "foo"))
+}
+
+func TestKeySlot(t *testing.T) {
+	assert.Equal(t, 12182, resp.KeySlot("foo"))
+	assert.Equal(t, 0x31c3, resp.KeySlot("123456789"))
+	assert.Equal(t, resp.KeySlot("user1000"), resp.KeySlot("{user1000}.following"))
+	assert.Equal(t, resp.KeySlot("{user1000}.following"), resp.KeySlot("{user1000}.followers"))
+	// empty hash tags are not tags
+	assert.NotEqual(t, resp.KeySlot("{}a"), resp.KeySlot("{}b"))
+}
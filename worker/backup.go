@@ -8,29 +8,40 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc/metadata"
 	"io"
+	"math"
 	"path"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	// time given to backups to join an election once one has, before it is decided among those joined
+	ELECTION_TIMEOUT = 3 * time.Second
+	// znode in the election directory naming the winner, once decided
+	ELECTION_DECISION_NAME = "decision"
+)
+
 func (s *WorkerServer) registerBackup() error {
 	// backup do not have to ensure that path exists
-	// just register itself will do
+	// just register itself will do, learners register the same way under a name of their own
 	node := common.NewWorkerNode(s.Hostname, s.Port, s.Id)
-	nodePath := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)), common.ZK_BACKUP_WORKER_NAME)
+	nodePath := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)), s.mode.Load())
 	name, err := common.ZkCreate(s.conn, nodePath, node, true, true)
 	if err != nil {
 		return err
 	}
 	s.NodeName = path.Base(name)
-	return nil
+	_, err = s.loadEpoch()
+	return err
 }
 
 // Watch current worker node. Fire up backup election if primary worker is down.
 func (s *WorkerServer) backupWatch(worker common.Worker) {
 	log := common.SugaredLog()
+	if _, err := s.loadEpoch(); err != nil {
+		log.Error("Failed to load epoch.", zap.Error(err))
+	}
 	if len(worker.Primaries) == 0 {
 		log.Info("Primary down detected, beginning primary election.")
 		if err := s.backupElection(); err != nil {
@@ -39,6 +50,12 @@ func (s *WorkerServer) backupWatch(worker common.Worker) {
 	}
 }
 
+// Join the election of a new primary among backups. Candidates register under the election directory
+// with the version they have applied, and the one of the highest version wins, ties broken by node name,
+// so that no write acknowledged by a backup is lost. It is decided once every backup has registered,
+// or ELECTION_TIMEOUT after joining, by whoever decides first, and the winner catches up with its peers
+// before taking over. The decision stands as long as the winner is a candidate, and is cleared by the
+// others if it is gone before taking over, so that the election is decided again.
 func (s *WorkerServer) backupElection() error {
 	log := common.SugaredLog()
 	electionPath := path.Join(common.ZK_ELECTION_ROOT, strconv.Itoa(int(s.Id)))
@@ -47,10 +64,9 @@ func (s *WorkerServer) backupElection() error {
 		return err
 	}
 	// register itself and watch
-	myName := fmt.Sprintf("%020d%s", s.version, s.NodeName)
+	myName := candidateName(s.kv.GetVersion(), s.NodeName)
 	log.Infof("Name to assign: %s\n", myName)
-	_, err := s.conn.Create(path.Join(electionPath, myName), []byte(s.NodeName), zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
-	if err != nil {
+	if err := s.joinElection(path.Join(electionPath, myName)); err != nil {
 		log.Error("Failed to create node.", zap.Error(err))
 		return err
 	}
@@ -63,30 +79,193 @@ func (s *WorkerServer) backupElection() error {
 	backups := common.FilterString(workers, func(i int) bool { return strings.Contains(workers[i], common.ZK_BACKUP_WORKER_NAME) })
 	// watch the znode
 	log.Infof("Should have %d backups.", len(backups))
+	timeout := time.After(ELECTION_TIMEOUT)
 	for {
 		children, _, eventChan, err := s.conn.ChildrenW(electionPath)
 		if err != nil {
 			log.Error("Failed to watch election path", zap.Error(err))
 			return nil
 		}
-		if len(children) == len(backups) {
-			sort.Strings(children)
-			if children[0] == myName {
-				// i am the lucky guy
-				log.Infof("Seems like I am the lucky guy, upgrading...")
-				if err := s.transformTo(MODE_PRIMARY); err != nil {
-					log.Error("Transformation failed.", zap.Error(err))
-					return err
+		decided := false
+		for _, c := range children {
+			decided = decided || c == ELECTION_DECISION_NAME
+		}
+		if !decided && len(children) < len(backups) {
+			select {
+			case <-eventChan:
+				continue
+			case <-timeout:
+				log.Infof("Only %d of %d backups joined in time, deciding among them.", len(children), len(backups))
+			}
+		}
+		winner, err := s.decideElection(electionPath, children)
+		if err != nil {
+			log.Error("Failed to decide election.", zap.Error(err))
+			return err
+		} else if winner == "" {
+			log.Info("Stale decision cleared, deciding again.")
+			continue
+		}
+		if winner == myName {
+			// i am the lucky guy
+			log.Infof("Seems like I am the lucky guy, catching up with peers before upgrading...")
+			s.catchUpWithPeers()
+			if err := s.transformTo(MODE_PRIMARY); err != nil {
+				log.Error("Transformation failed.", zap.Error(err))
+				// step out, so that the others decide again
+				_ = s.conn.Delete(path.Join(electionPath, myName), -1)
+				return err
+			}
+			if err := s.closeElection(electionPath); err != nil {
+				return err
+			}
+			log.Infof("Transformation successful.")
+		} else {
+			log.Infof("Not so lucky this time, %s wins.", winner)
+			done, err := s.awaitWinner(electionPath, winner)
+			if err != nil {
+				return err
+			} else if !done {
+				log.Infof("%s is gone before taking over, deciding again.", winner)
+				continue
+			}
+		}
+		break
+	}
+	return nil
+}
+
+// Register as a candidate at `candidatePath`. Registering again, e.g. when an election is retried,
+// succeeds as long as the znode is of this session.
+func (s *WorkerServer) joinElection(candidatePath string) error {
+	_, err := s.conn.Create(candidatePath, []byte(s.NodeName), zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+	if err != zk.ErrNodeExists {
+		return err
+	}
+	_, stat, err := s.conn.Get(candidatePath)
+	if err != nil {
+		return err
+	} else if stat.EphemeralOwner != s.conn.SessionID() {
+		return zk.ErrNodeExists
+	}
+	return nil
+}
+
+// Name of the election znode of a candidate, which has applied `version`.
+func candidateName(version uint64, nodeName string) string {
+	return fmt.Sprintf("%020d%s", version, nodeName)
+}
+
+// Pick the winner among election znodes `candidates`: the highest version, then the lowest node name.
+func electionWinner(candidates []string) string {
+	winner := ""
+	for _, c := range candidates {
+		if len(c) < 20 || c == ELECTION_DECISION_NAME {
+			continue
+		}
+		// versions are zero padded, so they compare the same as strings
+		if winner == "" || c[:20] > winner[:20] || (c[:20] == winner[:20] && c[20:] < winner[20:]) {
+			winner = c
+		}
+	}
+	return winner
+}
+
+// Record the winner among `candidates` in the decision znode, unless someone else has decided already.
+// Returns the winner decided, or an empty string if the decision named a candidate no longer there,
+// e.g. one left over by a winner that died before closing the election, which is cleared.
+func (s *WorkerServer) decideElection(electionPath string, candidates []string) (string, error) {
+	decisionPath := path.Join(electionPath, ELECTION_DECISION_NAME)
+	winner := electionWinner(candidates)
+	// not ephemeral, a decision must not go away with whoever made it while the winner takes over
+	_, err := s.conn.Create(decisionPath, []byte(winner), 0, zk.WorldACL(zk.PermAll))
+	if err == nil {
+		return winner, nil
+	} else if err != zk.ErrNodeExists {
+		return "", err
+	}
+	data, stat, err := s.conn.Get(decisionPath)
+	if err == zk.ErrNoNode {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	decided := string(data)
+	exists, _, err := s.conn.Exists(path.Join(electionPath, decided))
+	if err != nil {
+		return "", err
+	} else if !exists {
+		return "", s.clearDecision(decisionPath, stat.Version)
+	}
+	return decided, nil
+}
+
+// Delete the decision znode of `version`, unless someone else has.
+func (s *WorkerServer) clearDecision(decisionPath string, version int32) error {
+	err := s.conn.Delete(decisionPath, version)
+	if err == zk.ErrNoNode || err == zk.ErrBadVersion {
+		return nil
+	}
+	return err
+}
+
+// Wait for `winner` to take over as primary. Returns false if it is gone before that,
+// having cleared the decision that named it.
+func (s *WorkerServer) awaitWinner(electionPath string, winner string) (bool, error) {
+	decisionPath := path.Join(electionPath, ELECTION_DECISION_NAME)
+	workerPath := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)))
+	for {
+		exists, _, eventChan, err := s.conn.ExistsW(path.Join(electionPath, winner))
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			// either cleaned up by the winner as primary, or gone with it
+			workers, _, err := s.conn.Children(workerPath)
+			if err != nil {
+				return false, err
+			}
+			for _, w := range workers {
+				if strings.Contains(w, common.ZK_PRIMARY_WORKER_NAME) {
+					return true, nil
 				}
-				log.Infof("Transformation successful.")
-			} else {
-				log.Infof("Not so lucky this time...")
-				// wait for a little while for primary to complete
-				time.Sleep(500 * time.Millisecond)
 			}
-			break
+			data, stat, err := s.conn.Get(decisionPath)
+			if err == zk.ErrNoNode {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+			if string(data) != winner {
+				return false, nil
+			}
+			return false, s.clearDecision(decisionPath, stat.Version)
 		}
-		_ = <-eventChan
+		<-eventChan
+	}
+}
+
+// Clean up the election directory as its winner, which goes read-only
+// if fewer backups than in config took part in the election.
+func (s *WorkerServer) closeElection(electionPath string) error {
+	log := common.SugaredLog()
+	worker, err := common.GetAndWatchWorker(s.conn, s.Id)
+	if err != nil {
+		log.Info("Failed to get worker info.", zap.Error(err))
+	}
+	// get number of actual backups from election path
+	children, _, err := s.conn.Children(electionPath)
+	children = common.FilterString(children, func(i int) bool { return children[i] != ELECTION_DECISION_NAME })
+	// primary should be responsible for cleaning the election directory
+	if err := common.ZkDeleteRecursive(s.conn, electionPath); err != nil {
+		log.Error("Failed to clear election path", zap.Error(err))
+		return err
+	}
+	if len(children) < worker.NumBackups {
+		log.Infof("Backup number and config number, config num = %d, actual = %d",
+			worker.NumBackups, len(children))
+		// this one has taken over, the rest are all the backups the worker could still have
+		s.EnterReadOnly(worker.NumBackups - 1)
 	}
 	return nil
 }
@@ -154,6 +333,8 @@ func (s *WorkerServer) BackupTransfer(server pb.KVBackup_TransferServer) error {
 			s.versionCond.L.Lock()
 			s.version = version
 			s.versionCond.L.Unlock()
+			// history before the bulk transfer is unknown
+			s.tail.reset(version)
 			return server.SendAndClose(&pb.BackupReply{
 				Status:  pb.Status_OK,
 				Version: version,
@@ -161,6 +342,16 @@ func (s *WorkerServer) BackupTransfer(server pb.KVBackup_TransferServer) error {
 		} else if err != nil {
 			return err
 		}
+		if !s.checkEpoch(ent.Epoch) {
+			log.Warnf("Rejecting transfer from a primary of epoch %d.", ent.Epoch)
+			if err := s.kv.Rollback(tid); err != nil {
+				log.Error("Failed to rollback", zap.Error(err))
+			}
+			return server.SendAndClose(&pb.BackupReply{
+				Status:  pb.Status_EINVEPOCH,
+				Version: version,
+			})
+		}
 		numEntries += 1
 		switch ent.Op {
 		case pb.Operation_PUT:
@@ -192,6 +383,64 @@ func (s *WorkerServer) BackupTransfer(server pb.KVBackup_TransferServer) error {
 	}
 }
 
+// Check epoch of an entry from the primary, adopting it if it is newer.
+// Entries of primaries older than the latest one known are rejected.
+func (s *WorkerServer) checkEpoch(epoch uint64) bool {
+	if epoch < s.epoch.Load() {
+		return false
+	}
+	s.adoptEpoch(epoch)
+	return true
+}
+
+// Take note of an entry or a heartbeat of the primary, which has reached `version`.
+func (s *WorkerServer) heardFromPrimary(version uint64) {
+	s.heardAt.Store(time.Now().UnixNano())
+	for {
+		v := s.primaryVersion.Load()
+		if version <= v || s.primaryVersion.CAS(v, version) {
+			return
+		}
+	}
+}
+
+// Take note of being in sync, if everything the primary is known to have reached is applied.
+func (s *WorkerServer) checkSynced() {
+	if s.kv.GetVersion() >= s.primaryVersion.Load() {
+		s.syncedAt.Store(time.Now().UnixNano())
+	}
+}
+
+// How long it has been since this backup was last known to be in sync with its primary.
+// It is in sync as long as it has applied all the primary is known to have reached, and has heard from it lately,
+// since entries are sent as soon as they are written, and heartbeats in between.
+func (s *WorkerServer) staleness() time.Duration {
+	if heard := s.heardAt.Load(); heard != 0 && time.Since(time.Unix(0, heard)) < SYNC_LIVENESS_TIMEOUT &&
+		s.kv.GetVersion() >= s.primaryVersion.Load() {
+		return 0
+	}
+	at := s.syncedAt.Load()
+	if at == 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Since(time.Unix(0, at))
+}
+
+// Check whether a read could be served by this worker as a backup or a learner.
+func (s *WorkerServer) servesFollowerRead(key *pb.Key) bool {
+	if !s.isReplica() {
+		return false
+	}
+	switch key.Consistency {
+	case pb.Consistency_ANY:
+		return true
+	case pb.Consistency_BOUNDED:
+		return s.staleness() <= time.Duration(key.MaxStaleness)*time.Millisecond
+	default:
+		return false
+	}
+}
+
 // loss less sync, with sync version number
 func (s *WorkerServer) BackupSync(server pb.KVBackup_SyncServer) error {
 	// get latest version
@@ -203,6 +452,35 @@ func (s *WorkerServer) BackupSync(server pb.KVBackup_SyncServer) error {
 		} else if err != nil {
 			return err
 		}
+		if !s.checkEpoch(ent.Epoch) {
+			log.Warnf("Rejecting entries from a primary of epoch %d.", ent.Epoch)
+			return server.Send(&pb.BackupReply{
+				Status:  pb.Status_EINVEPOCH,
+				Version: ent.Version,
+			})
+		}
+		s.heardFromPrimary(ent.Version)
+		if ent.Op == pb.Operation_GET {
+			// heartbeat, entries before it have all been sent
+			s.checkSynced()
+			continue
+		}
+		// entries could be sent again while catching up,
+		// and versions taken by writes not replicated (e.g. migrations) are skipped
+		if v := s.kv.GetVersion(); ent.Version <= v {
+			s.checkSynced()
+			if err := server.Send(&pb.BackupReply{
+				Status:  pb.Status_OK,
+				Version: ent.Version,
+			}); err != nil {
+				return err
+			}
+			continue
+		} else if ent.Version > v+1 {
+			if err := s.kv.SetVersion(ent.Version - 1); err != nil {
+				log.Error("Failed to skip versions.", zap.Error(err))
+			}
+		}
 		var newVersion uint64
 		switch ent.Op {
 		case pb.Operation_PUT:
@@ -227,7 +505,13 @@ func (s *WorkerServer) BackupSync(server pb.KVBackup_SyncServer) error {
 				return err
 			}
 		}
+		s.versionCond.L.Lock()
 		s.version = ent.Version
+		s.versionCond.L.Unlock()
+		if err == nil {
+			s.tail.publish(ent)
+			s.checkSynced()
+		}
 		if err := server.Send(&pb.BackupReply{
 			Status:  pb.Status_OK,
 			Version: ent.Version,
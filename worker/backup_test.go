@@ -0,0 +1,287 @@
+package worker_test
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Serve a worker 1 in `mode` stored in `dir`, returning it with a connection to it and a function to stop both.
+func serveWorker(t *testing.T, mode string, dir string) (*worker.WorkerServer, *grpc.ClientConn, func()) {
+	w, err := worker.NewServer("127.0.0.1", 0, dir, 1, mode)
+	assert.Nil(t, err)
+	go w.DoSync()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := common.NewGrpcServer()
+	pb.RegisterKVWorkerServer(s, w)
+	pb.RegisterKVBackupServer(s, w)
+	go s.Serve(l)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	return w, conn, func() {
+		conn.Close()
+		s.Stop()
+		close(w.SyncStopChan)
+	}
+}
+
+// Serve a fresh worker 1 in `mode`, as serveWorker does.
+func startWorker(t *testing.T, mode string) (*worker.WorkerServer, *grpc.ClientConn, func()) {
+	dir, err := ioutil.TempDir("", "worker")
+	assert.Nil(t, err)
+	w, conn, stop := serveWorker(t, mode, dir)
+	return w, conn, func() {
+		stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// Sync `entries` to the backup as the primary of worker 1 would.
+func syncEntries(t *testing.T, conn *grpc.ClientConn, entries ...*pb.BackupEntry) {
+	sctx := metadata.AppendToOutgoingContext(context.Background(), worker.HEADER_CLIENT_WORKER_ID, "1")
+	stream, err := pb.NewKVBackupClient(conn).Sync(sctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, ent := range entries {
+		assert.Nil(t, stream.Send(ent))
+		reply, err := stream.Recv()
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, pb.Status_OK, reply.Status)
+	}
+}
+
+func TestBackup_FollowerRead(t *testing.T) {
+	_, conn, stop := startWorker(t, worker.MODE_BACKUP)
+	defer stop()
+	kv := pb.NewKVWorkerClient(conn)
+	ctx := context.Background()
+	get := func(consistency pb.Consistency, staleness uint32) pb.Status {
+		resp, err := kv.Get(ctx, &pb.Key{Key: "k", Consistency: consistency, MaxStaleness: staleness})
+		assert.Nil(t, err)
+		return resp.Status
+	}
+
+	// never synced, only reads of ANY consistency are served
+	assert.Equal(t, pb.Status_EINVSERVER, get(pb.Consistency_STRONG, 0))
+	assert.Equal(t, pb.Status_EINVSERVER, get(pb.Consistency_BOUNDED, 1000))
+	assert.Equal(t, pb.Status_ENOENT, get(pb.Consistency_ANY, 0))
+
+	// syncing from the primary of worker 1
+	sctx := metadata.AppendToOutgoingContext(ctx, worker.HEADER_CLIENT_WORKER_ID, "1")
+	stream, err := pb.NewKVBackupClient(conn).Sync(sctx)
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "k", Value: "v", Version: 1}))
+	reply, err := stream.Recv()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, pb.Status_OK, reply.Status)
+	assert.Equal(t, pb.Status_OK, get(pb.Consistency_BOUNDED, 1000))
+	assert.Equal(t, pb.Status_EINVSERVER, get(pb.Consistency_STRONG, 0))
+
+	// in sync with all the primary has reached, even between heartbeats
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, pb.Status_OK, get(pb.Consistency_BOUNDED, 10))
+	// a heartbeat ahead of what the backup has tells it is behind
+	assert.Nil(t, stream.Send(&pb.BackupEntry{Op: pb.Operation_GET, Version: 2}))
+	assert.Eventually(t, func() bool {
+		return get(pb.Consistency_BOUNDED, 50) == pb.Status_EINVSERVER
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, pb.Status_OK, get(pb.Consistency_BOUNDED, 1000))
+	// and catches up with the entry
+	assert.Nil(t, stream.Send(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "k", Value: "w", Version: 2}))
+	_, err = stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, get(pb.Consistency_BOUNDED, 10))
+
+	// falls behind once the primary is no longer heard from
+	time.Sleep(worker.SYNC_LIVENESS_TIMEOUT)
+	assert.Equal(t, pb.Status_EINVSERVER, get(pb.Consistency_BOUNDED, 50))
+	assert.Nil(t, stream.Send(&pb.BackupEntry{Op: pb.Operation_GET, Version: 2}))
+	assert.Eventually(t, func() bool {
+		return get(pb.Consistency_BOUNDED, 10) == pb.Status_OK
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBackup_StaleEpoch(t *testing.T) {
+	_, conn, stop := startWorker(t, worker.MODE_BACKUP)
+	defer stop()
+	sctx := metadata.AppendToOutgoingContext(context.Background(), worker.HEADER_CLIENT_WORKER_ID, "1")
+	sync := func(ent *pb.BackupEntry) pb.Status {
+		stream, err := pb.NewKVBackupClient(conn).Sync(sctx)
+		assert.Nil(t, err)
+		assert.Nil(t, stream.Send(ent))
+		reply, err := stream.Recv()
+		if !assert.Nil(t, err) {
+			return pb.Status_EFAILED
+		}
+		return reply.Status
+	}
+
+	// primary of epoch 2 is adopted
+	assert.Equal(t, pb.Status_OK, sync(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "k", Value: "v", Version: 1, Epoch: 2}))
+	// a deposed primary of epoch 1 is rejected
+	assert.Equal(t, pb.Status_EINVEPOCH, sync(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "k", Value: "x", Version: 2, Epoch: 1}))
+	assert.Equal(t, pb.Status_OK, sync(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "k", Value: "y", Version: 2, Epoch: 2}))
+}
+
+func TestBackup_InstallSnapshot(t *testing.T) {
+	_, conn, stop := startWorker(t, worker.MODE_BACKUP)
+	defer stop()
+	sctx := metadata.AppendToOutgoingContext(context.Background(), worker.HEADER_CLIENT_WORKER_ID, "1")
+	snapshot := []byte(`{"a":{"Value":"1","Version":3},"b":{"Value":"2","Version":5}}`)
+	chunk := func(offset int, end int) *pb.SnapshotChunk {
+		data := snapshot[offset:end]
+		return &pb.SnapshotChunk{Version: 5, Offset: uint64(offset), Data: data,
+			Checksum: crc32.ChecksumIEEE(data), Last: end == len(snapshot)}
+	}
+	// send chunks, returning the offset the backup resumes from and its reply
+	install := func(chunks ...*pb.SnapshotChunk) (string, *pb.BackupReply) {
+		stream, err := pb.NewKVBackupClient(conn).InstallSnapshot(sctx)
+		assert.Nil(t, err)
+		header, err := stream.Header()
+		assert.Nil(t, err)
+		for _, c := range chunks {
+			assert.Nil(t, stream.Send(c))
+		}
+		reply, err := stream.CloseAndRecv()
+		assert.Nil(t, err)
+		return header.Get(worker.HEADER_SNAPSHOT_OFFSET)[0], reply
+	}
+
+	// interrupted after the first chunk
+	offset, reply := install(chunk(0, 10))
+	assert.Equal(t, "0", offset)
+	assert.Equal(t, pb.Status_EFAILED, reply.Status)
+	// a corrupted chunk is rejected
+	bad := chunk(10, 20)
+	bad.Checksum++
+	offset, reply = install(bad)
+	assert.Equal(t, "a", offset)
+	assert.Equal(t, pb.Status_EFAILED, reply.Status)
+	// and the rest is sent from where it stopped
+	offset, reply = install(chunk(10, 20), chunk(20, len(snapshot)))
+	assert.Equal(t, "a", offset)
+	assert.Equal(t, pb.Status_OK, reply.Status)
+	assert.Equal(t, uint64(5), reply.Version)
+
+	kv := pb.NewKVWorkerClient(conn)
+	resp, err := kv.Get(context.Background(), &pb.Key{Key: "b", Consistency: pb.Consistency_ANY})
+	assert.Nil(t, err)
+	assert.Equal(t, "2", resp.Value)
+	// syncing goes on from the version of the snapshot
+	stream, err := pb.NewKVBackupClient(conn).Sync(sctx)
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "c", Value: "3", Version: 6}))
+	r, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, r.Status)
+	resp, err = kv.Get(context.Background(), &pb.Key{Key: "c", Consistency: pb.Consistency_ANY})
+	assert.Nil(t, err)
+	assert.Equal(t, "3", resp.Value)
+}
+
+func TestBackup_CatchUp(t *testing.T) {
+	_, conn, stop := startWorker(t, worker.MODE_BACKUP)
+	defer stop()
+	client := pb.NewKVBackupClient(conn)
+	sctx := metadata.AppendToOutgoingContext(context.Background(), worker.HEADER_CLIENT_WORKER_ID, "1")
+	// versions skipped by the primary are skipped here too
+	for _, v := range []uint64{1, 2, 4} {
+		syncEntries(t, conn, &pb.BackupEntry{Op: pb.Operation_PUT, Key: "k", Value: fmt.Sprint(v), Version: v})
+	}
+
+	// a peer elected primary at version 1 gets what it has missed
+	resp, err := client.CatchUp(sctx, &pb.CatchUpRequest{Version: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, resp.Status)
+	assert.Equal(t, uint64(4), resp.Version)
+	if assert.Len(t, resp.Entries, 2) {
+		assert.Equal(t, uint64(2), resp.Entries[0].Version)
+		assert.Equal(t, "4", resp.Entries[1].Value)
+	}
+	resp, err = client.CatchUp(sctx, &pb.CatchUpRequest{Version: 4})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Entries, 0)
+	// only peers of the same worker are served
+	other := metadata.AppendToOutgoingContext(context.Background(), worker.HEADER_CLIENT_WORKER_ID, "2")
+	resp, err = client.CatchUp(other, &pb.CatchUpRequest{Version: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_EINVSERVER, resp.Status)
+}
+
+func TestBackup_CatchUpWithPeers(t *testing.T) {
+	put := func(key string, version uint64) *pb.BackupEntry {
+		return &pb.BackupEntry{Op: pb.Operation_PUT, Key: key, Value: fmt.Sprint(version), Version: version}
+	}
+	w, conn, stop := startWorker(t, worker.MODE_BACKUP)
+	defer stop()
+	syncEntries(t, conn, put("a", 1))
+	get := func(key string) string {
+		resp, err := pb.NewKVWorkerClient(conn).Get(context.Background(), &pb.Key{Key: key, Consistency: pb.Consistency_ANY})
+		assert.Nil(t, err)
+		return resp.Value
+	}
+
+	// a peer that has kept every entry since hands them over
+	_, ahead, stopAhead := startWorker(t, worker.MODE_BACKUP)
+	defer stopAhead()
+	syncEntries(t, ahead, put("a", 1), put("b", 2), &pb.BackupEntry{Op: pb.Operation_DELETE, Key: "a", Version: 3})
+	w.CatchUpWith(map[string]string{"ahead": ahead.Target()})
+	resp, err := pb.NewKVWorkerClient(conn).Get(context.Background(), &pb.Key{Key: "a", Consistency: pb.Consistency_ANY})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_ENOENT, resp.Status)
+	assert.Equal(t, "2", get("b"))
+
+	// a peer restarted since, which has not kept them, hands over its keys written later instead
+	dir, err := ioutil.TempDir("", "backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	_, restarted, stopRestarted := serveWorker(t, worker.MODE_BACKUP, dir)
+	syncEntries(t, restarted, put("a", 1), put("b", 2), put("c", 4), put("b", 5))
+	stopRestarted()
+	_, restarted, stopRestarted = serveWorker(t, worker.MODE_BACKUP, dir)
+	defer stopRestarted()
+	w.CatchUpWith(map[string]string{"restarted": restarted.Target()})
+	assert.Equal(t, "4", get("c"))
+	assert.Equal(t, "5", get("b"))
+	// and goes on from its version
+	syncEntries(t, conn, put("d", 6))
+	assert.Equal(t, "6", get("d"))
+}
+
+func TestBackup_ElectionWinner(t *testing.T) {
+	// the highest version wins
+	assert.Equal(t, worker.CandidateName(5, "backup2"), worker.ElectionWinner([]string{
+		worker.CandidateName(3, "backup1"), worker.CandidateName(5, "backup2"), worker.CandidateName(4, "backup3"),
+	}))
+	// versions compare as numbers, not as they are written
+	assert.Equal(t, worker.CandidateName(10, "backup2"), worker.ElectionWinner([]string{
+		worker.CandidateName(9, "backup1"), worker.CandidateName(10, "backup2"),
+	}))
+	// the lowest name breaks ties
+	assert.Equal(t, worker.CandidateName(5, "backup1"), worker.ElectionWinner([]string{
+		worker.CandidateName(5, "backup3"), worker.CandidateName(5, "backup1"), worker.CandidateName(2, "backup0"),
+	}))
+	// the decision is not a candidate
+	assert.Equal(t, worker.CandidateName(0, "backup1"), worker.ElectionWinner([]string{
+		worker.ELECTION_DECISION_NAME, worker.CandidateName(0, "backup1"),
+	}))
+	assert.Equal(t, "", worker.ElectionWinner([]string{worker.ELECTION_DECISION_NAME}))
+}
@@ -0,0 +1,178 @@
+package worker
+
+// catching up with peers as a backup elected primary, so that entries some backup has got are not lost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// time given to each peer to hand over entries the new primary has not applied
+const CATCH_UP_TIMEOUT = 5 * time.Second
+
+// Pull entries applied by other backups of the worker but not by this one, before it starts serving as primary.
+func (s *WorkerServer) catchUpWithPeers() {
+	worker, err := common.GetAndWatchWorker(s.conn, s.Id)
+	if err != nil {
+		common.Log().Error("Failed to get peers to catch up with.", zap.Error(err))
+		return
+	}
+	peers := make(map[string]string)
+	for name, node := range worker.Backups {
+		if name != s.NodeName {
+			peers[name] = fmt.Sprintf("%s:%d", node.Host.Hostname, node.Host.Port)
+		}
+	}
+	s.catchUpWith(peers)
+}
+
+// Catch up with each of `peers`, addresses by node name.
+// Peers that could not be reached are skipped, what only they have got is lost.
+func (s *WorkerServer) catchUpWith(peers map[string]string) {
+	log := common.SugaredLog()
+	for name, addr := range peers {
+		before := s.kv.GetVersion()
+		if err := s.catchUpFrom(addr); err != nil {
+			log.Warnf("Failed to catch up with %s: %v", name, err)
+		} else if after := s.kv.GetVersion(); after > before {
+			log.Infof("Caught up with %s from version %x to %x.", name, before, after)
+		}
+	}
+}
+
+func (s *WorkerServer) catchUpFrom(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), CATCH_UP_TIMEOUT)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx = metadata.AppendToOutgoingContext(ctx, HEADER_CLIENT_WORKER_ID, strconv.Itoa(int(s.Id)))
+	client := pb.NewKVBackupClient(conn)
+	resp, err := client.CatchUp(ctx, &pb.CatchUpRequest{Version: s.kv.GetVersion()})
+	if err != nil {
+		return err
+	}
+	switch resp.Status {
+	case pb.Status_OK:
+		for _, ent := range resp.Entries {
+			if err := s.applyCaughtUp(ent); err != nil {
+				return err
+			}
+		}
+		s.kv.Flush()
+		return nil
+	case pb.Status_ETRUNCATED:
+		// too far behind for the peer to have kept every entry, take the keys it has written since
+		return s.restoreFrom(ctx, client)
+	default:
+		return fmt.Errorf("peer replied %s", resp.Status)
+	}
+}
+
+// Apply an entry pulled from a peer, the same way one synced from the primary is.
+func (s *WorkerServer) applyCaughtUp(ent *pb.BackupEntry) error {
+	v := s.kv.GetVersion()
+	if ent.Version <= v {
+		return nil
+	} else if ent.Version > v+1 {
+		if err := s.kv.SetVersion(ent.Version - 1); err != nil {
+			return err
+		}
+	}
+	var err error
+	switch ent.Op {
+	case pb.Operation_PUT:
+		_, err = s.kv.Put(ent.Key, ent.Value, 0)
+	case pb.Operation_DELETE:
+		_, err = s.kv.Delete(ent.Key, 0)
+	default:
+		err = errors.New("invalid operation")
+	}
+	if err != nil {
+		return err
+	}
+	s.versionCond.L.Lock()
+	s.version = ent.Version
+	s.versionCond.L.Unlock()
+	s.tail.publish(ent)
+	return nil
+}
+
+// Restore keys written after the version of this replica from a dump of the peer.
+// Entries in between are not known one by one, so watchers could not resume from before it.
+func (s *WorkerServer) restoreFrom(ctx context.Context, client pb.KVBackupClient) error {
+	version := s.kv.GetVersion()
+	stream, err := client.Dump(ctx, &pb.DumpRequest{})
+	if err != nil {
+		return err
+	}
+	latest := version
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if resp.Status != pb.Status_OK {
+			return fmt.Errorf("peer replied %s", resp.Status)
+		}
+		if resp.Version > latest {
+			latest = resp.Version
+		}
+		for _, ent := range resp.Entries {
+			if ent.Version <= version {
+				continue
+			}
+			v := ValueWithVersion{Version: ent.Version}
+			if !ent.Deleted {
+				value := ent.Value
+				v.Value = &value
+			}
+			if _, err := s.kv.Restore(ent.Key, v); err != nil {
+				return err
+			}
+		}
+	}
+	if latest == version {
+		return nil
+	}
+	if err := s.kv.SetVersion(latest); err != nil {
+		return err
+	}
+	s.kv.Flush()
+	s.versionCond.L.Lock()
+	s.version = latest
+	s.versionCond.L.Unlock()
+	s.tail.reset(latest)
+	return nil
+}
+
+// Hand over entries applied after the version asked for, to a backup of the same worker elected primary.
+func (s *WorkerServer) CatchUp(ctx context.Context, req *pb.CatchUpRequest) (*pb.CatchUpResponse, error) {
+	remoteId, err := remoteWorkerId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if remoteId != s.Id || s.mode.Load() != MODE_BACKUP {
+		return &pb.CatchUpResponse{Status: pb.Status_EINVSERVER}, nil
+	}
+	version := s.kv.GetVersion()
+	entries, _, err := s.tail.since(req.Version)
+	if err == ETRUNCATED {
+		return &pb.CatchUpResponse{Status: pb.Status_ETRUNCATED, Version: version}, nil
+	}
+	return &pb.CatchUpResponse{Status: pb.Status_OK, Version: version, Entries: entries}, nil
+}
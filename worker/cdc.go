@@ -0,0 +1,132 @@
+package worker
+
+// change data capture export, built on entries synced by DoSync
+
+import (
+	"strings"
+	"time"
+
+	"github.com/eyeKill/KV/cdc"
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/zap"
+)
+
+// interval between attempts to write the feed after failing to
+const CDC_RETRY_INTERVAL = time.Second
+
+// Get slot of key in the current slot table.
+func (s *WorkerServer) slotOf(key string) common.SlotId {
+	s.slotLock.RLock()
+	defer s.slotLock.RUnlock()
+	if len(s.slots) == 0 {
+		return common.GetSlotId(key, int(common.DEFAULT_SLOT_COUNT))
+	}
+	return s.slots.GetSlotId(key)
+}
+
+// Write committed mutations to `w` in the order of their versions while being the primary,
+// continuing after the last record in it. Runs until CDCStopChan is closed.
+// Writes are acknowledged only once synced to `w`, so that none acknowledged is missing from the feed.
+// Mutations no longer retained in memory, e.g. those committed before a restart but not yet exported,
+// are exported from the store, where only the latest version of each key is left.
+func (s *WorkerServer) ExportCDC(w *cdc.Writer) {
+	log := common.Log()
+	cursor := w.LastVersion()
+	s.setCDCVersion(cursor)
+	s.cdcExporting.Store(true)
+	defer func() {
+		s.cdcExporting.Store(false)
+		s.setCDCVersion(cursor)
+	}()
+	var retry <-chan time.Time
+	for {
+		var records []cdc.Record
+		last := cursor
+		entries, notify, err := s.tail.since(cursor)
+		if err == ETRUNCATED {
+			// entries up to where the tail starts are taken from the store, the rest in the next round
+			last = s.tail.truncatedVersion()
+			records = s.storedRecords(cursor, last)
+			entries = nil
+		}
+		for _, e := range entries {
+			records = append(records, s.recordOf(e))
+			last = e.Version
+		}
+		if s.mode.Load() != MODE_PRIMARY {
+			// backups only keep their tail up to date, and export once promoted
+			records, last = nil, cursor
+		}
+		retry = nil
+		exported := false
+		for _, r := range records {
+			if strings.HasPrefix(r.Key, INTERNAL_KEY_PREFIX) {
+				continue
+			}
+			if err := w.Append(r); err != nil {
+				log.Error("Failed to write CDC record.", zap.Uint64("version", r.Version), zap.Error(err))
+				retry = time.After(CDC_RETRY_INTERVAL)
+				break
+			}
+		}
+		if retry == nil && last > cursor {
+			if err := w.Sync(); err != nil {
+				log.Error("Failed to sync CDC file.", zap.Error(err))
+				retry = time.After(CDC_RETRY_INTERVAL)
+			} else {
+				cursor, exported = last, true
+				s.setCDCVersion(cursor)
+			}
+		}
+		if exported && err == ETRUNCATED {
+			continue
+		}
+		select {
+		case <-notify:
+		case <-retry:
+		case <-s.CDCStopChan:
+			return
+		}
+	}
+}
+
+// Record of an entry synced by DoSync.
+func (s *WorkerServer) recordOf(e *pb.BackupEntry) cdc.Record {
+	r := cdc.Record{
+		Worker:  s.Id,
+		Epoch:   s.tail.epochOf(e.Version),
+		Version: e.Version,
+		Slot:    s.slotOf(e.Key),
+		Key:     e.Key,
+	}
+	if e.Op == pb.Operation_DELETE {
+		r.Op = cdc.OP_DELETE
+	} else {
+		r.Op, r.Value = cdc.OP_PUT, e.Value
+	}
+	return r
+}
+
+// Records of keys last written after version `from` up to `to`, taken from the store in the order of their versions.
+func (s *WorkerServer) storedRecords(from uint64, to uint64) []cdc.Record {
+	var records []cdc.Record
+	for _, e := range s.storedEntries(from, to, func(_ string) bool { return true }) {
+		records = append(records, s.recordOf(e))
+	}
+	return records
+}
+
+// Mark mutations up to `version` exported, waking up writes waiting for it.
+func (s *WorkerServer) setCDCVersion(version uint64) {
+	s.versionCond.L.Lock()
+	s.cdcVersion = version
+	s.versionCond.L.Unlock()
+	s.versionCond.Broadcast()
+}
+
+// Whether the mutation of `version` is committed, i.e. synced to backups and exported to the feed
+// if there is one. Should be called with versionCond.L held.
+func (s *WorkerServer) committedLocked(version uint64) bool {
+	return s.version >= version && (!s.cdcExporting.Load() || s.cdcVersion >= version)
+}
@@ -0,0 +1,165 @@
+package worker_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/cdc"
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/localcluster"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorker_ExportCDC(t *testing.T) {
+	c, err := localcluster.Start(1)
+	assert.Nil(t, err)
+	defer c.Stop()
+	dir, err := ioutil.TempDir("", "cdc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	w, err := cdc.NewWriter(dir, cdc.DEFAULT_MAX_FILE_SIZE, 0)
+	assert.Nil(t, err)
+	defer w.Close()
+	go c.Workers[0].ExportCDC(w)
+	defer close(c.Workers[0].CDCStopChan)
+
+	kv, err := client.New(client.DefaultOptions(c.MasterAddr))
+	assert.Nil(t, err)
+	defer kv.Close()
+	ctx := context.Background()
+	assert.Nil(t, kv.Put(ctx, "a", "1"))
+	c.BumpSlotVersion()
+	assert.Nil(t, kv.Put(ctx, "b", "2"))
+	assert.Nil(t, kv.Delete(ctx, "a"))
+
+	r, err := cdc.NewReader(dir, "")
+	assert.Nil(t, err)
+	defer r.Close()
+	var records []cdc.Record
+	deadline := time.Now().Add(5 * time.Second)
+	for len(records) < 3 && time.Now().Before(deadline) {
+		rec, err := r.Next()
+		if err == io.EOF {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		assert.Nil(t, err)
+		records = append(records, rec)
+	}
+	if !assert.Equal(t, 3, len(records)) {
+		return
+	}
+	assert.Equal(t, cdc.OP_PUT, records[0].Op)
+	assert.Equal(t, "1", records[0].Value)
+	assert.Equal(t, uint32(0), records[0].Epoch)
+	assert.Equal(t, "b", records[1].Key)
+	assert.Equal(t, uint32(1), records[1].Epoch)
+	assert.Equal(t, cdc.OP_DELETE, records[2].Op)
+	assert.Equal(t, "a", records[2].Key)
+	assert.True(t, records[0].Version < records[1].Version && records[1].Version < records[2].Version)
+	for _, rec := range records {
+		assert.Equal(t, c.Workers[0].Id, rec.Worker)
+	}
+}
+
+func TestWorker_ExportCDCBeforeAck(t *testing.T) {
+	c, err := localcluster.Start(1)
+	assert.Nil(t, err)
+	defer c.Stop()
+	dir, err := ioutil.TempDir("", "cdc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	w, err := cdc.NewWriter(dir, cdc.DEFAULT_MAX_FILE_SIZE, 0)
+	assert.Nil(t, err)
+	go c.Workers[0].ExportCDC(w)
+	defer close(c.Workers[0].CDCStopChan)
+
+	kv, err := client.New(client.DefaultOptions(c.MasterAddr))
+	assert.Nil(t, err)
+	defer kv.Close()
+	r, err := cdc.NewReader(dir, "")
+	assert.Nil(t, err)
+	defer r.Close()
+	ctx := context.Background()
+	assert.Nil(t, kv.Put(ctx, "a", "1"))
+	assert.Eventually(t, func() bool {
+		_, err := r.Next()
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	// once exporting, acknowledged writes are in the feed already
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, kv.Put(ctx, "a", fmt.Sprint(i)))
+		rec, err := r.Next()
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, fmt.Sprint(i), rec.Value)
+	}
+	// and those that could not be exported are not acknowledged
+	assert.Nil(t, w.Close())
+	tctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, kv.Put(tctx, "a", "lost"))
+}
+
+func TestWorker_ExportCDCFromStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	// committed before a restart, never exported
+	// after slot table version 2 is adopted, which outlives checkpoints as well
+	store, err := worker.NewKVStore(dir)
+	assert.Nil(t, err)
+	_, err = store.Put("a", "a", 0)
+	assert.Nil(t, err)
+	assert.Nil(t, store.MarkSlotVersion(2, store.GetVersion()))
+	_, err = store.Put("b", "b", 0)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Checkpoint())
+	_, err = store.Put("c", "c", 0)
+	assert.Nil(t, err)
+	_, err = store.Delete("a", 0)
+	assert.Nil(t, err)
+	store.Flush()
+	store.Close()
+
+	s, err := worker.NewPrimaryServer("127.0.0.1", 0, dir, 1)
+	assert.Nil(t, err)
+	feed, err := ioutil.TempDir("", "cdc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(feed)
+	w, err := cdc.NewWriter(feed, cdc.DEFAULT_MAX_FILE_SIZE, 0)
+	assert.Nil(t, err)
+	defer w.Close()
+	go s.ExportCDC(w)
+	defer close(s.CDCStopChan)
+
+	r, err := cdc.NewReader(feed, "")
+	assert.Nil(t, err)
+	defer r.Close()
+	var records []cdc.Record
+	assert.Eventually(t, func() bool {
+		if rec, err := r.Next(); err == nil {
+			records = append(records, rec)
+		}
+		return len(records) == 3
+	}, time.Second, 10*time.Millisecond)
+	if !assert.Len(t, records, 3) {
+		return
+	}
+	// each key once, at its latest version
+	assert.Equal(t, "b", records[0].Key)
+	assert.Equal(t, "c", records[1].Key)
+	assert.Equal(t, cdc.OP_DELETE, records[2].Op)
+	assert.Equal(t, "a", records[2].Key)
+	assert.True(t, records[0].Version < records[1].Version && records[1].Version < records[2].Version)
+	for _, rec := range records {
+		assert.Equal(t, uint32(2), rec.Epoch)
+	}
+}
@@ -0,0 +1,47 @@
+package worker
+
+// dumps of replicas for consistency checkers to compare
+
+import (
+	"time"
+
+	pb "github.com/eyeKill/KV/proto"
+)
+
+const (
+	// entries sent in one message of a dump
+	DUMP_BATCH_SIZE = 1024
+	// interval of checking whether a replica has applied the version a dump waits for
+	DUMP_POLL_INTERVAL = 10 * time.Millisecond
+)
+
+// Dump everything of this replica, primary or backup, in batches.
+func (s *WorkerServer) Dump(req *pb.DumpRequest, server pb.KVBackup_DumpServer) error {
+	deadline := time.Now().Add(time.Duration(req.TimeoutMs) * time.Millisecond)
+	version := s.kv.GetVersion()
+	for version < req.Version && time.Now().Before(deadline) {
+		select {
+		case <-time.After(DUMP_POLL_INTERVAL):
+		case <-server.Context().Done():
+			return server.Context().Err()
+		}
+		version = s.kv.GetVersion()
+	}
+	resp := pb.DumpResponse{Status: pb.Status_OK, Version: version}
+	content := s.kv.Extract(func(_ string) bool { return true }, 0)
+	for k, v := range content {
+		ent := pb.DumpEntry{Key: k, Version: v.Version, Deleted: v.Value == nil}
+		if v.Value != nil {
+			ent.Value = *v.Value
+		}
+		resp.Entries = append(resp.Entries, &ent)
+		if len(resp.Entries) == DUMP_BATCH_SIZE {
+			if err := server.Send(&resp); err != nil {
+				return err
+			}
+			resp.Entries = nil
+		}
+	}
+	// the last one is sent even if it is empty, so the version is always known
+	return server.Send(&resp)
+}
@@ -0,0 +1,110 @@
+package worker
+
+// fencing epochs, telling apart primaries of the same worker elected at different times
+
+import (
+	"errors"
+	"path"
+	"strconv"
+
+	"github.com/eyeKill/KV/common"
+	"github.com/samuel/go-zookeeper/zk"
+	"go.uber.org/zap"
+)
+
+// returned by writes on a primary that has found out a newer one was elected
+var EDEPOSED = errors.New("deposed by a primary of a newer epoch")
+
+// Epoch counter of this worker in zookeeper, bumped on every election.
+func (s *WorkerServer) epochCounter() common.DistributedAtomicInteger {
+	return common.DistributedAtomicInteger{
+		Conn: s.conn,
+		Path: path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)), common.ZK_WORKER_EPOCH_NAME),
+	}
+}
+
+// Read the epoch from zookeeper, creating the counter if it does not exist yet.
+// It is adopted if it is newer than ours, which is reported.
+func (s *WorkerServer) loadEpoch() (bool, error) {
+	c := s.epochCounter()
+	if err := c.SetDefault(0); err != nil && err != zk.ErrNodeExists {
+		return false, err
+	}
+	epoch, err := c.Get()
+	if err != nil {
+		return false, err
+	}
+	return s.adoptEpoch(uint64(epoch)), nil
+}
+
+// Keep up with the epoch in zookeeper until EpochStopChan is closed, a primary is deposed as soon as
+// a newer one is started. Backups fence off a deposed primary once they see the new epoch, but one
+// with no backup in sync, e.g. in async mode or with all of them ejected, would go on acknowledging writes.
+func (s *WorkerServer) WatchEpoch() {
+	log := common.SugaredLog()
+	for {
+		// fired by the counter being created as well as bumped
+		exists, _, eventChan, err := s.conn.ExistsW(s.epochCounter().Path)
+		if err != nil {
+			log.Error("Failed to watch epoch.", zap.Error(err))
+			return
+		}
+		if exists {
+			if newer, err := s.loadEpoch(); err != nil {
+				log.Error("Failed to load epoch.", zap.Error(err))
+			} else if newer && s.mode.Load() == MODE_PRIMARY {
+				s.depose()
+			}
+		}
+		select {
+		case <-eventChan:
+		case <-s.EpochStopChan:
+			return
+		}
+	}
+}
+
+// Start a new epoch, done by a backup elected as primary before it serves anything.
+func (s *WorkerServer) bumpEpoch() error {
+	c := s.epochCounter()
+	if err := c.SetDefault(0); err != nil && err != zk.ErrNodeExists {
+		return err
+	}
+	epoch, err := c.Inc()
+	if err != nil {
+		return err
+	}
+	s.adoptEpoch(uint64(epoch + 1))
+	s.deposed.Store(false)
+	common.SugaredLog().Infof("Primary of epoch %d.", s.epoch.Load())
+	return nil
+}
+
+// Move on to epoch if it is newer than ours, return whether it is.
+func (s *WorkerServer) adoptEpoch(epoch uint64) bool {
+	s.epochLock.Lock()
+	defer s.epochLock.Unlock()
+	if epoch <= s.epoch.Load() {
+		return false
+	}
+	if err := s.kv.SetEpoch(epoch); err != nil {
+		common.SugaredLog().Errorf("Failed to persist epoch %d: %v", epoch, err)
+	}
+	s.epoch.Store(epoch)
+	return true
+}
+
+// Stop accepting writes as a primary, since a newer one is around.
+// Writes waiting for backups are woken up and fail.
+func (s *WorkerServer) depose() {
+	if s.deposed.Swap(true) {
+		return
+	}
+	common.SugaredLog().Warnf("Deposed by a primary of a newer epoch, my epoch is %d.", s.epoch.Load())
+	s.backupCond.L.Lock()
+	s.backupCond.Broadcast()
+	s.backupCond.L.Unlock()
+	s.versionCond.L.Lock()
+	s.versionCond.Broadcast()
+	s.versionCond.L.Unlock()
+}
@@ -0,0 +1,43 @@
+package worker_test
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEpoch_DeposedWithoutBackups(t *testing.T) {
+	conn := connectZk(t)
+	defer conn.Close()
+	const id = common.WorkerId(901)
+	defer cleanUpZkWorker(t, conn, id)
+	w, wConn, stop := startZkWorker(t, id, worker.MODE_PRIMARY)
+	defer stop()
+	kv := pb.NewKVWorkerClient(wConn)
+	put := func() pb.Status {
+		resp, err := kv.Put(context.Background(), &pb.KVPair{Key: "k", Value: "v", SlotVersion: w.SlotTableVersion.Load()})
+		assert.Nil(t, err)
+		return resp.Status
+	}
+	// acknowledged with no backup to fence it off
+	assert.Equal(t, pb.Status_OK, put())
+
+	// a newer primary is elected while it is cut off from its backups
+	epoch := common.DistributedAtomicInteger{
+		Conn: conn,
+		Path: path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(id)), common.ZK_WORKER_EPOCH_NAME),
+	}
+	_, err := epoch.Inc()
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return put() == pb.Status_EINVSERVER
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, pb.Status_EINVSERVER, put())
+}
@@ -0,0 +1,21 @@
+package worker
+
+import "time"
+
+// unexported parts of the worker opened up to tests
+
+var ElectionWinner = electionWinner
+var CandidateName = candidateName
+var IsReplicated = isReplicated
+
+func (s *WorkerServer) CatchUpWith(peers map[string]string) {
+	s.catchUpWith(peers)
+}
+
+func (s *WorkerServer) CloseElection(electionPath string) error {
+	return s.closeElection(electionPath)
+}
+
+func (s *WorkerServer) ExtendLease(start time.Time, timeout time.Duration) {
+	s.extendLease(start, timeout)
+}
@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,6 +29,9 @@ var (
 	ENOENT    = errors.New("entry does not exist")
 	EINVTRANS = errors.New("invalid transaction id")
 	ENOTRANS  = errors.New("no available transaction id, try again later")
+	ECONFLICT = errors.New("version of entry does not match")
+	// for watchers
+	ETRUNCATED = errors.New("history is no longer retained")
 )
 
 const (
@@ -39,6 +43,11 @@ type KVStore interface {
 	Get(key string, transactionId int) (value string, err error)
 	Put(key string, value string, transactionId int) (version uint64, err error)
 	Delete(key string, transactionId int) (version uint64, err error)
+	// Get committed value of key together with the version it was last written at.
+	GetWithVersion(key string) (ValueWithVersion, error)
+	// Atomically put key if its current version equals `version`, 0 meaning that key should not exist.
+	// Returns ENOENT if it should exist but does not, ECONFLICT if version does not match.
+	CompareAndPut(key string, value string, version uint64) (newVersion uint64, err error)
 	// transactional APIs
 	StartTransaction() (transactionId int, err error)
 	Rollback(transactionId int) error
@@ -46,13 +55,35 @@ type KVStore interface {
 	// persist kv store
 	Flush()
 	Checkpoint() error
+	// Checkpoint, and keep a copy of the new slot file at `file`, which stays intact through later checkpoints.
+	// Returns the version the copy is taken at.
+	Snapshot(file string) (version uint64, err error)
+	// Replace everything with the content of slot file `file` taken at `version`, which is moved in place.
+	InstallSnapshot(file string, version uint64) error
+	// Roots of Merkle trees of every slot, and leaves of the tree of `slot`, over committed values.
+	MerkleRoots() []uint64
+	MerkleLeaves(slot int) []uint64
+	// Set committed value of key to what the primary has at `value.Version`, nil meaning deleted,
+	// unless it has been written at a newer version. Current version is left alone.
+	// Returns whether the value is changed.
+	Restore(key string, value ValueWithVersion) (bool, error)
 	// Extract all values for keys that satisfies the divider function at the time this method is called.
 	// This method should not block. When doing calculation, the KVStore should continue to serve on other threads.
 	Extract(divider func(key string) bool, version uint64) map[string]ValueWithVersion
+	// Get at most `limit` existing keys with `prefix` in ascending order, starting after `start`.
+	Scan(prefix string, start string, limit int) []KeyWithValue
 	GetVersion() uint64
 	// A helper function to manually set current version
 	// helpful in bulk transfer scenarios where you have to keep version numbers sync
 	SetVersion(version uint64) error
+	// Epoch of the primary writes come from. Committed records in the log are stamped with it.
+	GetEpoch() uint64
+	// Move on to a newer epoch, older ones are ignored.
+	SetEpoch(epoch uint64) error
+	// Slot table versions adopted, each with the version after which writes belong to it. Kept in log like epoch.
+	SlotVersions() map[uint32]uint64
+	// Record that slot table version `slotVersion` is adopted at `version`, unless it already is.
+	MarkSlotVersion(slotVersion uint32, version uint64) error
 }
 
 type ValueWithVersion struct {
@@ -67,6 +98,11 @@ func NewValueWithVersion(value string, version uint64) ValueWithVersion {
 	}
 }
 
+type KeyWithValue struct {
+	Key string
+	ValueWithVersion
+}
+
 type TransactionStruct struct {
 	Lock  sync.RWMutex
 	Layer map[string]ValueWithVersion
@@ -88,7 +124,10 @@ type SimpleKV struct {
 	checkpointLock sync.Mutex
 	path           string
 	version        uint64
+	epoch          uint64 // epoch of the primary, see SetEpoch
+	slotVersions   map[uint32]uint64
 	logFile        *os.File
+	merkle         *merkleForest // of committed values, updated under lock of transaction zero
 }
 
 func (kv *SimpleKV) getTransaction(transactionId int) *TransactionStruct {
@@ -133,7 +172,7 @@ func (kv *SimpleKV) Get(key string, transactionId int) (string, error) {
 		}
 		t.Lock.RUnlock()
 	}
-	v, ok := kv.base[key]
+	v, ok := kv.getBase()[key]
 	if ok && v.Value != nil {
 		return *v.Value, nil
 	} else {
@@ -151,8 +190,10 @@ func (kv *SimpleKV) Put(key string, value string, transactionId int) (uint64, er
 	if transactionId == 0 {
 		kv.version += 1
 		common.SugaredLog().Debugf("KV PUT %s %s %d %x", key, value, transactionId, kv.version)
-		kv.writeLog("put", key, value, "0", strconv.FormatUint(kv.version, 16))
+		kv.writeLog("put", key, value, "0", strconv.FormatUint(kv.version, 16), strconv.FormatUint(kv.epoch, 16))
+		old, _ := kv.lookup(t, key)
 		t.Layer[key] = ValueWithVersion{Value: &value, Version: kv.version}
+		kv.merkle.update(key, old, t.Layer[key])
 		return kv.version, nil
 	} else {
 		common.SugaredLog().Debugf("KV PUT %s %s %d", key, value, transactionId)
@@ -162,6 +203,54 @@ func (kv *SimpleKV) Put(key string, value string, transactionId int) (uint64, er
 	}
 }
 
+// Base map is replaced as a whole under lock of transaction zero on checkpoints & snapshot installs,
+// and never modified in place, so it could be read without the lock once got.
+func (kv *SimpleKV) getBase() map[string]ValueWithVersion {
+	t := kv.getTransaction(0)
+	t.Lock.RLock()
+	defer t.Lock.RUnlock()
+	return kv.base
+}
+
+// look up committed value of key, caller should hold lock of transaction zero
+func (kv *SimpleKV) lookup(t *TransactionStruct, key string) (ValueWithVersion, bool) {
+	if v, ok := t.Layer[key]; ok {
+		return v, v.Value != nil
+	}
+	v, ok := kv.base[key]
+	return v, ok && v.Value != nil
+}
+
+func (kv *SimpleKV) GetWithVersion(key string) (ValueWithVersion, error) {
+	t := kv.getTransaction(0)
+	t.Lock.RLock()
+	defer t.Lock.RUnlock()
+	if v, ok := kv.lookup(t, key); ok {
+		return v, nil
+	}
+	return ValueWithVersion{}, ENOENT
+}
+
+func (kv *SimpleKV) CompareAndPut(key string, value string, version uint64) (uint64, error) {
+	t := kv.getTransaction(0)
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+	v, ok := kv.lookup(t, key)
+	if version == 0 && ok {
+		return 0, ECONFLICT
+	} else if version != 0 && !ok {
+		return 0, ENOENT
+	} else if version != 0 && v.Version != version {
+		return 0, ECONFLICT
+	}
+	kv.version += 1
+	common.SugaredLog().Debugf("KV CAS %s %s %x %x", key, value, version, kv.version)
+	kv.writeLog("put", key, value, "0", strconv.FormatUint(kv.version, 16), strconv.FormatUint(kv.epoch, 16))
+	t.Layer[key] = ValueWithVersion{Value: &value, Version: kv.version}
+	kv.merkle.update(key, v, t.Layer[key])
+	return kv.version, nil
+}
+
 // Make sure that key is removed from KVStore, regardless of whether it exists beforehand or not.
 // You should check if the key exists in the KV beforehand, otherwise this API could thrash the KV.
 func (kv *SimpleKV) Delete(key string, transactionId int) (uint64, error) {
@@ -175,8 +264,10 @@ func (kv *SimpleKV) Delete(key string, transactionId int) (uint64, error) {
 	if transactionId == 0 {
 		kv.version += 1
 		common.SugaredLog().Debugf("KV DELETE %s %d %x", key, transactionId, kv.version)
-		kv.writeLog("del", key, "0", strconv.FormatUint(kv.version, 16))
+		kv.writeLog("del", key, "0", strconv.FormatUint(kv.version, 16), strconv.FormatUint(kv.epoch, 16))
+		old, _ := kv.lookup(t, key)
 		t.Layer[key] = ValueWithVersion{Value: nil, Version: kv.version}
+		kv.merkle.update(key, old, t.Layer[key])
 		return kv.version, nil
 	} else {
 		common.SugaredLog().Debugf("KV DELETE %s %d", key, transactionId)
@@ -236,10 +327,13 @@ func (kv *SimpleKV) Commit(transactionId int) error {
 		// merge it into transaction zero
 		kv.transactions[0].Lock.Lock()
 		kv.version += 1
-		kv.writeLog("commit", strconv.FormatInt(int64(transactionId), 16), strconv.FormatUint(kv.version, 16))
+		kv.writeLog("commit", strconv.FormatInt(int64(transactionId), 16), strconv.FormatUint(kv.version, 16),
+			strconv.FormatUint(kv.epoch, 16))
 		t.Lock.RLock()
 		for k, v := range t.Layer {
+			old, _ := kv.lookup(kv.transactions[0], k)
 			kv.transactions[0].Layer[k] = ValueWithVersion{Value: v.Value, Version: kv.version}
+			kv.merkle.update(k, old, kv.transactions[0].Layer[k])
 		}
 		t.Lock.RUnlock()
 		kv.transactions[0].Lock.Unlock()
@@ -256,12 +350,22 @@ func (kv *SimpleKV) Commit(transactionId int) error {
 // Clear log entries, flush current kv in memory to slots.
 // Call this when log file is getting too large.
 func (kv *SimpleKV) Checkpoint() error {
+	_, err := kv.checkpoint("")
+	return err
+}
+
+func (kv *SimpleKV) Snapshot(file string) (uint64, error) {
+	return kv.checkpoint(file)
+}
+
+// Checkpoint and link the new slot file to `snapshot` if it is not empty, returning the version it is taken at.
+func (kv *SimpleKV) checkpoint(snapshot string) (uint64, error) {
 	// cannot checkpoint when there is on-going transactions
 	kv.tLock.RLock()
 	for i, b := range kv.transactions {
 		if i != 0 && b != nil {
 			kv.tLock.RUnlock()
-			return errors.New("have transactions going on")
+			return 0, errors.New("have transactions going on")
 		}
 	}
 	kv.tLock.RUnlock()
@@ -287,32 +391,96 @@ func (kv *SimpleKV) Checkpoint() error {
 	// write new slot file
 	bin, err := json.Marshal(b)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	tmpSlotFile, err := ioutil.TempFile(kv.path, SLOT_TMP_FILENAME_PATTERN)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if _, err := tmpSlotFile.Write(bin); err != nil {
-		return err
+		return 0, err
 	}
 	// rename temporary slot file to actual slot file
 	slotFileName := path.Join(kv.path, SLOT_FILENAME)
 	if err := os.Rename(tmpSlotFile.Name(), slotFileName); err != nil {
-		return err
+		return 0, err
+	}
+	if snapshot != "" {
+		_ = os.Remove(snapshot)
+		if err := os.Link(slotFileName, snapshot); err != nil {
+			return 0, err
+		}
 	}
 	// truncate log
 	tmpLogFile, err := ioutil.TempFile(kv.path, LOG_TMP_FILENAME_PATTERN)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if err := os.Rename(tmpLogFile.Name(), kv.logFile.Name()); err != nil {
-		return err
+		return 0, err
 	}
 	// update base and transactions
 	kv.base = b
 	kv.transactions[0].Layer = make(map[string]ValueWithVersion)
 	kv.logFile = tmpLogFile
+	// epoch and slot table versions are only kept in log
+	if kv.epoch > 0 {
+		kv.writeLog("set-epoch", strconv.FormatUint(kv.epoch, 16))
+	}
+	kv.writeSlotVersions()
+	return kv.version, nil
+}
+
+func (kv *SimpleKV) InstallSnapshot(file string, version uint64) error {
+	bin, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	b := make(map[string]ValueWithVersion)
+	if err := json.Unmarshal(bin, &b); err != nil {
+		return err
+	}
+	kv.tLock.RLock()
+	for i, t := range kv.transactions {
+		if i != 0 && t != nil {
+			kv.tLock.RUnlock()
+			return errors.New("have transactions going on")
+		}
+	}
+	kv.tLock.RUnlock()
+
+	kv.checkpointLock.Lock()
+	defer kv.checkpointLock.Unlock()
+	common.SugaredLog().Debugf("KV INSTALL %x", version)
+	t := kv.getTransaction(0)
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+	tmpLogFile, err := ioutil.TempFile(kv.path, LOG_TMP_FILENAME_PATTERN)
+	if err != nil {
+		return err
+	}
+	// slot file goes first, so a crash in between leaves the snapshot with the old log redone on it,
+	// which is at the old version and gets fixed by transferring everything newer than it again
+	if err := os.Rename(file, path.Join(kv.path, SLOT_FILENAME)); err != nil {
+		_ = tmpLogFile.Close()
+		_ = os.Remove(tmpLogFile.Name())
+		return err
+	}
+	if err := os.Rename(tmpLogFile.Name(), kv.logFile.Name()); err != nil {
+		return err
+	}
+	_ = kv.logFile.Close()
+	kv.base = b
+	t.Layer = make(map[string]ValueWithVersion)
+	kv.merkle.reset(b, t.Layer)
+	kv.logFile = tmpLogFile
+	kv.version = version
+	kv.writeLog("set-version", strconv.FormatUint(version, 16))
+	if kv.epoch > 0 {
+		kv.writeLog("set-epoch", strconv.FormatUint(kv.epoch, 16))
+	}
+	kv.writeSlotVersions()
+	kv.Flush()
 	return nil
 }
 
@@ -353,7 +521,8 @@ func NewKVStore(pathString string) (*SimpleKV, error) {
 	slotFileName := path.Join(pathString, SLOT_FILENAME)
 	var logFile, slotFile *os.File
 
-	var version uint64 = 0
+	var version, epoch uint64 = 0, 0
+	slotVersions := make(map[uint32]uint64)
 	base := make(map[string]ValueWithVersion)
 	latest := make(map[string]ValueWithVersion)
 
@@ -403,10 +572,13 @@ func NewKVStore(pathString string) (*SimpleKV, error) {
 			return nil, err
 		}
 		scanner := bufio.NewScanner(logFile)
-		latest, version, err = ReadLog(scanner)
+		latest, version, epoch, slotVersions, err = readLog(scanner)
 		if latest == nil {
 			latest = make(map[string]ValueWithVersion)
 		}
+		if slotVersions == nil {
+			slotVersions = make(map[uint32]uint64)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -418,13 +590,18 @@ func NewKVStore(pathString string) (*SimpleKV, error) {
 		Lock:  sync.RWMutex{},
 		Layer: latest,
 	}
+	merkle := newMerkleForest()
+	merkle.reset(base, latest)
 	// others are nil
 	return &SimpleKV{
 		base:         base,
 		transactions: ts,
 		path:         pathString,
 		version:      version,
+		epoch:        epoch,
+		slotVersions: slotVersions,
 		logFile:      logFile,
+		merkle:       merkle,
 	}, nil
 }
 
@@ -447,6 +624,84 @@ func (kv *SimpleKV) SetVersion(version uint64) error {
 	return nil
 }
 
+func (kv *SimpleKV) GetEpoch() uint64 {
+	trans := kv.getTransaction(0)
+	trans.Lock.RLock()
+	defer trans.Lock.RUnlock()
+	return kv.epoch
+}
+
+func (kv *SimpleKV) SetEpoch(epoch uint64) error {
+	trans := kv.getTransaction(0)
+	trans.Lock.Lock()
+	defer trans.Lock.Unlock()
+	if epoch > kv.epoch {
+		common.SugaredLog().Debugf("KV SET EPOCH %x", epoch)
+		kv.writeLog("set-epoch", strconv.FormatUint(epoch, 16))
+		kv.Flush()
+		kv.epoch = epoch
+	}
+	return nil
+}
+
+func (kv *SimpleKV) SlotVersions() map[uint32]uint64 {
+	trans := kv.getTransaction(0)
+	trans.Lock.RLock()
+	defer trans.Lock.RUnlock()
+	m := make(map[uint32]uint64, len(kv.slotVersions))
+	for v, start := range kv.slotVersions {
+		m[v] = start
+	}
+	return m
+}
+
+func (kv *SimpleKV) MarkSlotVersion(slotVersion uint32, version uint64) error {
+	trans := kv.getTransaction(0)
+	trans.Lock.Lock()
+	defer trans.Lock.Unlock()
+	if _, ok := kv.slotVersions[slotVersion]; !ok {
+		common.SugaredLog().Debugf("KV SET SLOT VERSION %x AT %x", slotVersion, version)
+		kv.writeLog("slot-version", strconv.FormatUint(uint64(slotVersion), 16), strconv.FormatUint(version, 16))
+		kv.Flush()
+		kv.slotVersions[slotVersion] = version
+	}
+	return nil
+}
+
+// should be called with lock of transaction zero held
+func (kv *SimpleKV) writeSlotVersions() {
+	for v, start := range kv.slotVersions {
+		kv.writeLog("slot-version", strconv.FormatUint(uint64(v), 16), strconv.FormatUint(start, 16))
+	}
+}
+
+func (kv *SimpleKV) MerkleRoots() []uint64 {
+	return kv.merkle.roots()
+}
+
+func (kv *SimpleKV) MerkleLeaves(slot int) []uint64 {
+	return kv.merkle.leaves(slot)
+}
+
+func (kv *SimpleKV) Restore(key string, value ValueWithVersion) (bool, error) {
+	t := kv.getTransaction(0)
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+	old, _ := kv.lookup(t, key)
+	if old.Version > value.Version || entryHash(key, old) == entryHash(key, value) {
+		return false, nil
+	}
+	common.SugaredLog().Debugf("KV RESTORE %s %x", key, value.Version)
+	if value.Value == nil {
+		kv.writeLog("restore", key, strconv.FormatUint(value.Version, 16))
+	} else {
+		kv.writeLog("restore", key, strconv.FormatUint(value.Version, 16), *value.Value)
+	}
+	t.Layer[key] = value
+	kv.merkle.update(key, old, value)
+	return true, nil
+}
+
 func readString(quoted string) string {
 	ret, err := strconv.Unquote(quoted)
 	if err != nil {
@@ -466,11 +721,26 @@ func readNum(quoted string, base int) uint64 {
 
 // redo log logic
 func ReadLog(scanner *bufio.Scanner) (map[string]ValueWithVersion, uint64, error) {
+	latest, version, _, _, err := readLog(scanner)
+	return latest, version, err
+}
+
+// Redo log, returning the latest epoch seen and slot table versions adopted as well.
+// Records written before epochs were introduced carry no epoch.
+func readLog(scanner *bufio.Scanner) (map[string]ValueWithVersion, uint64, uint64, map[uint32]uint64, error) {
 	log := common.Log()
 	trans := make([]map[string]ValueWithVersion, TRANSACTION_COUNT)
 	trans[0] = make(map[string]ValueWithVersion)
 	var tokens []string
-	var version uint64
+	var version, epoch uint64
+	slotVersions := make(map[uint32]uint64)
+	readEpoch := func(i int) {
+		if len(tokens) > i {
+			if e := readNum(tokens[i], 16); e > epoch {
+				epoch = e
+			}
+		}
+	}
 	defer func() {
 		if x := recover(); x != nil {
 			log.Error("Failed to parse log.", zap.Any("error", x), zap.Strings("tokens", tokens))
@@ -498,6 +768,7 @@ func ReadLog(scanner *bufio.Scanner) (map[string]ValueWithVersion, uint64, error
 					panic(0)
 				}
 				version = readNum(tokens[4], 16)
+				readEpoch(5)
 			}
 			trans[transNum][key] = ValueWithVersion{Value: &value, Version: version}
 		case "del":
@@ -514,6 +785,7 @@ func ReadLog(scanner *bufio.Scanner) (map[string]ValueWithVersion, uint64, error
 					panic(0)
 				}
 				version = readNum(tokens[3], 16)
+				readEpoch(4)
 			}
 			trans[transNum][key] = ValueWithVersion{Value: nil, Version: version}
 		case "start":
@@ -528,7 +800,7 @@ func ReadLog(scanner *bufio.Scanner) (map[string]ValueWithVersion, uint64, error
 			trans[transNum] = make(map[string]ValueWithVersion)
 		case "commit":
 			// commit transaction
-			if len(tokens) != 3 {
+			if len(tokens) != 3 && len(tokens) != 4 {
 				panic(0)
 			}
 			transNum := int(readNum(tokens[1], 16))
@@ -541,6 +813,7 @@ func ReadLog(scanner *bufio.Scanner) (map[string]ValueWithVersion, uint64, error
 			}
 			trans[transNum] = nil
 			version = readNum(tokens[2], 16)
+			readEpoch(3)
 		case "rollback":
 			if len(tokens) != 2 {
 				panic(0)
@@ -555,6 +828,30 @@ func ReadLog(scanner *bufio.Scanner) (map[string]ValueWithVersion, uint64, error
 				panic(0)
 			}
 			version = readNum(tokens[1], 16)
+		case "set-epoch":
+			if len(tokens) != 2 {
+				panic(0)
+			}
+			readEpoch(1)
+		case "slot-version":
+			if len(tokens) != 3 {
+				panic(0)
+			}
+			v := uint32(readNum(tokens[1], 16))
+			if _, ok := slotVersions[v]; !ok {
+				slotVersions[v] = readNum(tokens[2], 16)
+			}
+		case "restore":
+			// committed value of the primary, version counter is untouched
+			if len(tokens) != 3 && len(tokens) != 4 {
+				panic(0)
+			}
+			v := ValueWithVersion{Version: readNum(tokens[2], 16)}
+			if len(tokens) == 4 {
+				value := readString(tokens[3])
+				v.Value = &value
+			}
+			trans[0][readString(tokens[1])] = v
 		default:
 			panic(nil)
 		}
@@ -563,13 +860,13 @@ func ReadLog(scanner *bufio.Scanner) (map[string]ValueWithVersion, uint64, error
 		log.Warn("Nil trans[0] detected")
 		trans[0] = make(map[string]ValueWithVersion)
 	}
-	return trans[0], version, nil
+	return trans[0], version, epoch, slotVersions, nil
 }
 
 func (kv *SimpleKV) Extract(divider func(key string) bool, version uint64) map[string]ValueWithVersion {
 	// extract content out
 	b := make(map[string]ValueWithVersion)
-	for k, v := range kv.base {
+	for k, v := range kv.getBase() {
 		if divider(k) && v.Version > version {
 			b[k] = v
 		}
@@ -588,6 +885,45 @@ func (kv *SimpleKV) Extract(divider func(key string) bool, version uint64) map[s
 	return b
 }
 
+func (kv *SimpleKV) Scan(prefix string, start string, limit int) []KeyWithValue {
+	matches := func(k string) bool {
+		return strings.HasPrefix(k, prefix) && k > start
+	}
+	b := make(map[string]ValueWithVersion)
+	for k, v := range kv.getBase() {
+		if matches(k) {
+			b[k] = v
+		}
+	}
+	kv.tLock.RLock()
+	t := kv.transactions[0]
+	kv.tLock.RUnlock()
+
+	t.Lock.RLock()
+	for k, v := range t.Layer {
+		if matches(k) {
+			b[k] = v
+		}
+	}
+	t.Lock.RUnlock()
+	// deleted keys are still in the layer with nil values
+	keys := make([]string, 0, len(b))
+	for k, v := range b {
+		if v.Value != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	ret := make([]KeyWithValue, len(keys))
+	for i, k := range keys {
+		ret[i] = KeyWithValue{Key: k, ValueWithVersion: b[k]}
+	}
+	return ret
+}
+
 func (kv *SimpleKV) Close() {
 	log := common.Log()
 	kv.Flush()
@@ -283,6 +283,65 @@ func TestSimpleKV_Extract(t *testing.T) {
 	}
 }
 
+func TestSimpleKV_Scan(t *testing.T) {
+	setUp()
+	defer tearDown()
+	kv, err := worker.NewKVStore(pathString)
+	assert.Nil(t, err)
+	for _, k := range []string{"b", "a2", "a1", "a3", "c"} {
+		_, err := kv.Put(k, k+"!", 0)
+		assert.Nil(t, err)
+	}
+	// some of them are in base, some of them are in the latest layer
+	assert.Nil(t, kv.Checkpoint())
+	_, err = kv.Put("a4", "a4!", 0)
+	assert.Nil(t, err)
+	_, err = kv.Delete("a2", 0)
+	assert.Nil(t, err)
+
+	ret := kv.Scan("a", "", 10)
+	var keys []string
+	for _, e := range ret {
+		keys = append(keys, e.Key)
+		assert.Equal(t, e.Key+"!", *e.Value)
+	}
+	assert.Equal(t, []string{"a1", "a3", "a4"}, keys)
+	ret = kv.Scan("", "a3", 2)
+	assert.Equal(t, 2, len(ret))
+	assert.Equal(t, "a4", ret[0].Key)
+	assert.Equal(t, "b", ret[1].Key)
+}
+
+func TestSimpleKV_CompareAndPut(t *testing.T) {
+	setUp()
+	defer tearDown()
+	kv, err := worker.NewKVStore(pathString)
+	assert.Nil(t, err)
+	_, err = kv.CompareAndPut("a", "1", 1)
+	assert.Equal(t, worker.ENOENT, err)
+	v1, err := kv.CompareAndPut("a", "1", 0)
+	assert.Nil(t, err)
+	_, err = kv.CompareAndPut("a", "2", 0)
+	assert.Equal(t, worker.ECONFLICT, err)
+	// versions survive checkpoints
+	assert.Nil(t, kv.Checkpoint())
+	e, err := kv.GetWithVersion("a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", *e.Value)
+	assert.Equal(t, v1, e.Version)
+	v2, err := kv.CompareAndPut("a", "2", v1)
+	assert.Nil(t, err)
+	assert.True(t, v2 > v1)
+	_, err = kv.CompareAndPut("a", "3", v1)
+	assert.Equal(t, worker.ECONFLICT, err)
+	_, err = kv.Delete("a", 0)
+	assert.Nil(t, err)
+	_, err = kv.GetWithVersion("a")
+	assert.Equal(t, worker.ENOENT, err)
+	_, err = kv.CompareAndPut("a", "4", 0)
+	assert.Nil(t, err)
+}
+
 func BenchmarkSequentialPut(b *testing.B) {
 	setUp()
 	defer tearDown()
@@ -299,6 +358,36 @@ func BenchmarkSequentialPut(b *testing.B) {
 	}
 }
 
+func TestSimpleKV_Epoch(t *testing.T) {
+	setUp()
+	defer tearDown()
+	// logs written before epochs existed
+	logs := `put "a" "b" "0" "1"` + "\n" + `put "c" "d" "0" "2" "3"` + "\n"
+	_ = ioutil.WriteFile(path.Join(pathString, "log.txt"), []byte(logs), 0644)
+	kv, err := worker.NewKVStore(pathString)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), kv.GetEpoch())
+	// older epochs are ignored
+	assert.Nil(t, kv.SetEpoch(2))
+	assert.Equal(t, uint64(3), kv.GetEpoch())
+	assert.Nil(t, kv.SetEpoch(5))
+	_, err = kv.Put("e", "f", 0)
+	assert.Nil(t, err)
+	kv.Close()
+
+	kv2, err := worker.NewKVStore(pathString)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), kv2.GetEpoch())
+	// kept across checkpoints
+	assert.Nil(t, kv2.Checkpoint())
+	kv2.Close()
+	kv3, err := worker.NewKVStore(pathString)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), kv3.GetEpoch())
+	v, _ := kv3.Get("e", 0)
+	assert.Equal(t, "f", v)
+}
+
 func BenchmarkConcurrentPut(b *testing.B) {
 	setUp()
 	defer tearDown()
@@ -316,3 +405,51 @@ func BenchmarkConcurrentPut(b *testing.B) {
 		}
 	})
 }
+
+func TestSimpleKV_Merkle(t *testing.T) {
+	setUp()
+	defer tearDown()
+	kv, err := worker.NewKVStore(pathString)
+	assert.Nil(t, err)
+	empty := kv.MerkleRoots()
+	_, err = kv.Put("a", "1", 0)
+	assert.Nil(t, err)
+	_, err = kv.Put("b", "2", 0)
+	assert.Nil(t, err)
+	roots := kv.MerkleRoots()
+	assert.NotEqual(t, empty, roots)
+	// deleted keys are as if they were never there
+	_, err = kv.Put("c", "3", 0)
+	assert.Nil(t, err)
+	_, err = kv.Delete("c", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, roots, kv.MerkleRoots())
+
+	// restoring an older value is ignored, and a newer one changes it
+	ok, err := kv.Restore("a", worker.NewValueWithVersion("x", 0))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = kv.Restore("b", worker.NewValueWithVersion("x", 9))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(4), kv.GetVersion())
+	ok, err = kv.Restore("a", worker.ValueWithVersion{Version: 9})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	roots = kv.MerkleRoots()
+	kv.Close()
+
+	// the same after recovery from log and slot file
+	kv2, err := worker.NewKVStore(pathString)
+	assert.Nil(t, err)
+	assert.Equal(t, roots, kv2.MerkleRoots())
+	_, err = kv2.Get("a", 0)
+	assert.Equal(t, worker.ENOENT, err)
+	v, _ := kv2.Get("b", 0)
+	assert.Equal(t, "x", v)
+	assert.Nil(t, kv2.Checkpoint())
+	kv2.Close()
+	kv3, err := worker.NewKVStore(pathString)
+	assert.Nil(t, err)
+	assert.Equal(t, roots, kv3.MerkleRoots())
+}
@@ -0,0 +1,150 @@
+package worker
+
+// replication lag of backups, and ejecting those too far behind from the semi-sync set
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+)
+
+const (
+	// interval of checking whether backups should be ejected or could rejoin
+	LAG_CHECK_INTERVAL = 100 * time.Millisecond
+	// number of recent batches whose time of dispatch is retained
+	LAG_HISTORY_SIZE = 4096
+)
+
+// Times batches of entries are handed over to backups, for telling how long backups are behind.
+type dispatchLog struct {
+	lock sync.Mutex
+	// last version of each batch, and when it is handed over
+	versions []uint64
+	times    []time.Time
+}
+
+func (l *dispatchLog) record(version uint64, at time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.versions) >= LAG_HISTORY_SIZE {
+		// drop the older half at once, so that dropping is amortized
+		n := len(l.versions) / 2
+		l.versions = append([]uint64(nil), l.versions[n:]...)
+		l.times = append([]time.Time(nil), l.times[n:]...)
+	}
+	l.versions = append(l.versions, version)
+	l.times = append(l.times, at)
+}
+
+// Get when the first batch after `version` is handed over, zero if there is none.
+// Batches no longer retained are taken as handed over with the oldest one retained.
+func (l *dispatchLog) after(version uint64) time.Time {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	i := sort.Search(len(l.versions), func(i int) bool { return l.versions[i] > version })
+	if i == len(l.versions) {
+		return time.Time{}
+	}
+	return l.times[i]
+}
+
+// Get the version the backup has acknowledged, and how many versions and how long it is behind.
+func (s *WorkerServer) backupLag(routine *SyncRoutine) (uint64, uint64, time.Duration) {
+	routine.Condition.L.Lock()
+	version := routine.Version
+	routine.Condition.L.Unlock()
+	last := s.wal.lastVersion()
+	if version >= last {
+		return version, 0, 0
+	}
+	var lag time.Duration = 0
+	if at := s.dispatches.after(version); !at.IsZero() {
+		lag = time.Since(at)
+	}
+	return version, last - version, lag
+}
+
+// Check whether a backup `versions` and `lag` behind should be ejected as config requires.
+func isLagging(config common.WorkerConfig, versions uint64, lag time.Duration) bool {
+	if replicationMode(config) != common.REPLICATION_SEMI_SYNC {
+		return false
+	}
+	return config.EjectLag > 0 && versions > config.EjectLag ||
+		config.EjectLagMs > 0 && lag > time.Duration(config.EjectLagMs)*time.Millisecond
+}
+
+// Eject the backup from the semi-sync set once it falls too far behind, and let it rejoin once it catches up,
+// until the routine is stopped. Read-only mode is left from here once enough backups are in sync.
+func (s *WorkerServer) lagRoutine(routine *SyncRoutine) {
+	log := common.SugaredLog()
+	ticker := time.NewTicker(LAG_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-routine.StopCh:
+			return
+		}
+		if s.mode.Load() != MODE_PRIMARY {
+			continue
+		}
+		s.checkReadOnly()
+		if routine.learner {
+			// never in the semi-sync set
+			continue
+		}
+		config := s.getConfig()
+		_, versions, lag := s.backupLag(routine)
+		if !routine.ejected.Load() {
+			if !isLagging(config, versions, lag) {
+				continue
+			}
+			log.Warnf("Ejecting %s from the semi-sync set, %d versions and %v behind.", routine.name, versions, lag)
+			routine.eject()
+		} else if replicationMode(config) != common.REPLICATION_SEMI_SYNC ||
+			!routine.overflown.Load() && versions <= config.RejoinLag {
+			log.Infof("%s rejoins the semi-sync set, %d versions behind.", routine.name, versions)
+			routine.rejoin()
+		} else {
+			continue
+		}
+		// writes waiting for backups should check against the new set
+		s.backupCond.L.Lock()
+		s.backupCond.Broadcast()
+		s.backupCond.L.Unlock()
+	}
+}
+
+// Get backup routines by name. Versions they have got are read under backupCond,
+// which is taken before backupLock, so they should be read from what is returned.
+func (s *WorkerServer) backupRoutines() map[string]*SyncRoutine {
+	s.backupLock.RLock()
+	defer s.backupLock.RUnlock()
+	routines := make(map[string]*SyncRoutine, len(s.backups))
+	for name, routine := range s.backups {
+		routines[name] = routine
+	}
+	return routines
+}
+
+// Get lag of every backup, in order of their names.
+func (s *WorkerServer) backupLags() []*pb.BackupLag {
+	var ret []*pb.BackupLag
+	for name, routine := range s.backupRoutines() {
+		version, versions, lag := s.backupLag(routine)
+		ret = append(ret, &pb.BackupLag{
+			Name:        name,
+			Version:     version,
+			LagVersions: versions,
+			LagMicros:   uint64(lag / time.Microsecond),
+			Ejected:     routine.ejected.Load(),
+			Ejections:   routine.ejections.Load(),
+			Learner:     routine.learner,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
@@ -0,0 +1,51 @@
+package worker
+
+// learners, replicas synced by the primary like backups that never acknowledge writes or take over as primary,
+// e.g. for analytics reads, off-site copies and warming up replacements
+
+import (
+	"path"
+	"strconv"
+
+	"github.com/eyeKill/KV/common"
+	"go.uber.org/zap"
+)
+
+func NewLearnerServer(hostname string, port uint16, filePath string, id common.WorkerId) (*WorkerServer, error) {
+	return NewServer(hostname, port, filePath, id, MODE_LEARNER)
+}
+
+// Check whether this worker is synced by the primary, as a backup or a learner.
+func (s *WorkerServer) isReplica() bool {
+	return s.mode.Load() == MODE_BACKUP || s.mode.Load() == MODE_LEARNER
+}
+
+// Keep up with the epoch of the primary. Learners are left out of elections, so there is nothing else to do
+// when the primary is down, but to wait for a new one to sync them.
+func (s *WorkerServer) learnerWatch(_ common.Worker) {
+	if _, err := s.loadEpoch(); err != nil {
+		common.Log().Error("Failed to load epoch.", zap.Error(err))
+	}
+}
+
+func (s *WorkerServer) AddLearnerRoutine(nodeName string) error {
+	fullName := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)), nodeName)
+	routine, err := NewSyncRoutine(s, fullName, func(_ string) bool { return true }, s.backupCond)
+	if err != nil {
+		return err
+	}
+	routine.learner = true
+	s.startBackupRoutine(nodeName, routine)
+	return nil
+}
+
+// Sync the learner listening on `addr`, for workers not coordinated through zookeeper.
+func (s *WorkerServer) AttachLearner(name string, addr string) error {
+	routine, err := newSyncRoutine(s, name, addr, func(_ string) bool { return true }, s.backupCond)
+	if err != nil {
+		return err
+	}
+	routine.learner = true
+	s.startBackupRoutine(name, routine)
+	return nil
+}
@@ -0,0 +1,76 @@
+package worker
+
+// read lease of the primary, bound to liveness of its zookeeper session
+
+import (
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+)
+
+// share of the session timeout given up for clock drift between us & zookeeper
+const LEASE_CLOCK_DRIFT = 0.1
+
+// Keep renewing the read lease until LeaseStopChan is closed.
+// A backup is only elected after the session of the primary expires, which zookeeper does
+// no earlier than a session timeout after last hearing from it. So once our own ephemeral node
+// is seen at some moment, the lease is good for a session timeout from that moment, minus the margin for drift.
+// The session timeout is the one zookeeper has negotiated, it is renewed four times in one.
+func (s *WorkerServer) KeepLease() {
+	for {
+		s.renewLease()
+		select {
+		case <-time.After(common.SessionTimeout(s.conn) / 4):
+		case <-s.LeaseStopChan:
+			return
+		}
+	}
+}
+
+func (s *WorkerServer) renewLease() {
+	if s.mode.Load() != MODE_PRIMARY || s.conn == nil {
+		return
+	}
+	start := time.Now()
+	p := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)), s.NodeName)
+	exists, _, err := s.conn.Exists(p)
+	if err != nil || !exists {
+		common.SugaredLog().Warnf("Failed to renew lease, %s is not alive: %v", p, err)
+		return
+	}
+	s.extendLease(start, common.SessionTimeout(s.conn))
+}
+
+// Extend the lease to `timeout` from `start` minus the margin for drift, the moment the session is known alive.
+func (s *WorkerServer) extendLease(start time.Time, timeout time.Duration) {
+	margin := time.Duration(float64(timeout) * LEASE_CLOCK_DRIFT)
+	s.leaseLock.Lock()
+	if expiry := start.Add(timeout - margin); expiry.After(s.leaseExpiry) {
+		s.leaseExpiry = expiry
+	}
+	s.leaseLock.Unlock()
+}
+
+// Hold the read lease for good, as a worker not coordinated through zookeeper, e.g. in in-process clusters,
+// where no other primary could be elected behind its back.
+func (s *WorkerServer) HoldLease() {
+	s.standalone.Store(true)
+}
+
+// Check whether this primary could serve reads, i.e. no other primary could have been elected.
+func (s *WorkerServer) holdsLease() bool {
+	if s.raft != nil {
+		return s.raft.HasLease()
+	}
+	if s.deposed.Load() {
+		return false
+	}
+	if s.standalone.Load() {
+		return true
+	}
+	s.leaseLock.Lock()
+	defer s.leaseLock.Unlock()
+	return time.Now().Before(s.leaseExpiry)
+}
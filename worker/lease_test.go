@@ -0,0 +1,66 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func getStatus(t *testing.T, conn *grpc.ClientConn, slotVersion uint32) pb.Status {
+	resp, err := pb.NewKVWorkerClient(conn).Get(context.Background(), &pb.Key{Key: "k", SlotVersion: slotVersion})
+	assert.Nil(t, err)
+	return resp.Status
+}
+
+func TestLease_Expiry(t *testing.T) {
+	w, conn, stop := startWorker(t, worker.MODE_PRIMARY)
+	defer stop()
+	// not coordinated through zookeeper, but not standalone either
+	assert.Equal(t, pb.Status_EINVSERVER, getStatus(t, conn, 0))
+
+	w.ExtendLease(time.Now(), 200*time.Millisecond)
+	assert.Equal(t, pb.Status_ENOENT, getStatus(t, conn, 0))
+	// renewed before it lapses
+	time.Sleep(100 * time.Millisecond)
+	w.ExtendLease(time.Now(), 200*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, pb.Status_ENOENT, getStatus(t, conn, 0))
+	// a renewal seen late does not shorten it
+	w.ExtendLease(time.Now().Add(-time.Second), 200*time.Millisecond)
+	assert.Equal(t, pb.Status_ENOENT, getStatus(t, conn, 0))
+	// reads are refused once it lapses, the drift margin included
+	time.Sleep(120 * time.Millisecond)
+	assert.Equal(t, pb.Status_EINVSERVER, getStatus(t, conn, 0))
+
+	w.HoldLease()
+	assert.Equal(t, pb.Status_ENOENT, getStatus(t, conn, 0))
+}
+
+func TestLease_SessionTimeout(t *testing.T) {
+	conn := connectZk(t)
+	defer conn.Close()
+	const id = common.WorkerId(902)
+	defer cleanUpZkWorker(t, conn, id)
+	w, wConn, stop := startZkWorker(t, id, worker.MODE_PRIMARY)
+	defer stop()
+	// the lease follows the session timeout the server has negotiated, whatever it is
+	assert.Eventually(t, func() bool {
+		return getStatus(t, wConn, w.SlotTableVersion.Load()) == pb.Status_ENOENT
+	}, time.Second, 10*time.Millisecond)
+	timeout := common.SessionTimeout(conn)
+	assert.True(t, timeout > 0)
+
+	// renewal stops, as if cut off from zookeeper, reads are refused before a backup could be elected
+	w.LeaseStopChan <- struct{}{}
+	start := time.Now()
+	assert.Eventually(t, func() bool {
+		return getStatus(t, wConn, w.SlotTableVersion.Load()) == pb.Status_EINVSERVER
+	}, 2*timeout, 10*time.Millisecond)
+	assert.True(t, time.Since(start) < timeout)
+}
@@ -0,0 +1,181 @@
+package worker
+
+// locks & leases, kept as internal keys so that they are replicated & migrated like other keys
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/zap"
+)
+
+// lock states are kept as $lock/<name>
+const LOCK_KEY_PREFIX = INTERNAL_KEY_PREFIX + "lock/"
+
+// State of a lock. Released locks are kept with an empty owner, so that fencing tokens keep increasing.
+// Expiration time is taken from the clock of the primary.
+type lockState struct {
+	Owner string `json:"owner"`
+	Fence uint64 `json:"fence"`
+	// unix time in milliseconds
+	Expires int64 `json:"expires"`
+}
+
+func (l lockState) held(now time.Time) bool {
+	return l.Owner != "" && now.UnixNano()/int64(time.Millisecond) < l.Expires
+}
+
+func lockKey(name string) string {
+	return LOCK_KEY_PREFIX + name
+}
+
+func newLockToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Get state of a lock with its version, version 0 if it does not exist.
+func (s *WorkerServer) getLock(name string) (lockState, uint64, error) {
+	var state lockState
+	v, err := s.kv.GetWithVersion(lockKey(name))
+	if err == ENOENT {
+		return state, 0, nil
+	} else if err != nil {
+		return state, 0, err
+	}
+	if err := json.Unmarshal([]byte(*v.Value), &state); err != nil {
+		return state, 0, err
+	}
+	return state, v.Version, nil
+}
+
+// Update state of a lock if it is still of `version`, returns ECONFLICT otherwise.
+func (s *WorkerServer) setLock(name string, state lockState, version uint64) error {
+	bin, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = s.compareAndPut(lockKey(name), string(bin), version)
+	if err == ENOENT {
+		return ECONFLICT
+	}
+	return err
+}
+
+// Check a lock request, returning a non-OK status if it could not be served.
+func (s *WorkerServer) checkLockRequest(req *pb.LockRequest, needTtl bool) pb.Status {
+	if req.SlotVersion != s.SlotTableVersion.Load() {
+		return pb.Status_EINVVERSION
+	}
+	if st := s.writeStatus(); st != pb.Status_OK {
+		return st
+	}
+	if req.Name == "" || (needTtl && req.Ttl == 0) {
+		return pb.Status_EFAILED
+	}
+	return pb.Status_OK
+}
+
+// Update a lock with `update` until no one else changes it in between.
+func (s *WorkerServer) updateLock(name string, update func(state lockState, now time.Time) (lockState, pb.Status)) (lockState, pb.Status) {
+	for {
+		state, version, err := s.getLock(name)
+		if err != nil {
+			common.Log().Error("Failed to read lock.", zap.String("name", name), zap.Error(err))
+			return state, pb.Status_EFAILED
+		}
+		next, st := update(state, time.Now())
+		if st != pb.Status_OK {
+			return state, st
+		}
+		if err := s.setLock(name, next, version); err == ECONFLICT {
+			continue
+		} else if err == EDEPOSED {
+			return state, pb.Status_EINVSERVER
+		} else if err != nil {
+			common.Log().Error("Failed to write lock.", zap.String("name", name), zap.Error(err))
+			return state, pb.Status_EFAILED
+		}
+		return next, pb.Status_OK
+	}
+}
+
+func expiresAt(now time.Time, ttl uint32) int64 {
+	return now.Add(time.Duration(ttl)*time.Millisecond).UnixNano() / int64(time.Millisecond)
+}
+
+func (s *WorkerServer) AcquireLock(_ context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
+	if st := s.checkLockRequest(req, true); st != pb.Status_OK {
+		return &pb.LockResponse{Status: st}, nil
+	}
+	token := req.Token
+	if token == "" {
+		var err error
+		if token, err = newLockToken(); err != nil {
+			return &pb.LockResponse{Status: pb.Status_EFAILED}, nil
+		}
+	}
+	// held under the same token, the reply of an earlier request from the client must have been lost
+	acquired := false
+	state, st := s.updateLock(req.Name, func(state lockState, now time.Time) (lockState, pb.Status) {
+		if state.held(now) {
+			acquired = state.Owner == token
+			return state, pb.Status_ELOCKED
+		}
+		// taken from the version counter, and larger than any fence given out before,
+		// even by previous owners of the slot
+		fence := s.kv.GetVersion()
+		if state.Fence > fence {
+			fence = state.Fence
+		}
+		return lockState{Owner: token, Fence: fence + 1, Expires: expiresAt(now, req.Ttl)}, pb.Status_OK
+	})
+	if st == pb.Status_ELOCKED && acquired {
+		st = pb.Status_OK
+	}
+	if st != pb.Status_OK {
+		return &pb.LockResponse{Status: st}, nil
+	}
+	return &pb.LockResponse{Status: pb.Status_OK, Token: state.Owner, Fence: state.Fence}, nil
+}
+
+func (s *WorkerServer) RenewLease(_ context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
+	if st := s.checkLockRequest(req, true); st != pb.Status_OK {
+		return &pb.LockResponse{Status: st}, nil
+	}
+	state, st := s.updateLock(req.Name, func(state lockState, now time.Time) (lockState, pb.Status) {
+		if !state.held(now) || state.Owner != req.Token {
+			return state, pb.Status_ENOENT
+		}
+		state.Expires = expiresAt(now, req.Ttl)
+		return state, pb.Status_OK
+	})
+	if st != pb.Status_OK {
+		return &pb.LockResponse{Status: st}, nil
+	}
+	return &pb.LockResponse{Status: pb.Status_OK, Token: state.Owner, Fence: state.Fence}, nil
+}
+
+func (s *WorkerServer) ReleaseLock(_ context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
+	if st := s.checkLockRequest(req, false); st != pb.Status_OK {
+		return &pb.LockResponse{Status: st}, nil
+	}
+	state, st := s.updateLock(req.Name, func(state lockState, now time.Time) (lockState, pb.Status) {
+		if !state.held(now) || state.Owner != req.Token {
+			return state, pb.Status_ENOENT
+		}
+		return lockState{Fence: state.Fence}, pb.Status_OK
+	})
+	if st != pb.Status_OK {
+		return &pb.LockResponse{Status: st}, nil
+	}
+	return &pb.LockResponse{Status: pb.Status_OK, Fence: state.Fence}, nil
+}
@@ -0,0 +1,31 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock_Retried(t *testing.T) {
+	w, conn, stop := startWorker(t, worker.MODE_PRIMARY)
+	defer stop()
+	kv := pb.NewKVWorkerClient(conn)
+	acquire := func(token string) *pb.LockResponse {
+		resp, err := kv.AcquireLock(context.Background(),
+			&pb.LockRequest{Name: "l", Token: token, Ttl: 60000, SlotVersion: w.SlotTableVersion.Load()})
+		assert.Nil(t, err)
+		return resp
+	}
+	first := acquire("a")
+	assert.Equal(t, pb.Status_OK, first.Status)
+	assert.Equal(t, "a", first.Token)
+	// as if the reply was lost, the same lease is given again
+	again := acquire("a")
+	assert.Equal(t, pb.Status_OK, again.Status)
+	assert.Equal(t, first.Fence, again.Fence)
+	assert.Equal(t, pb.Status_ELOCKED, acquire("b").Status)
+	assert.Equal(t, pb.Status_ELOCKED, acquire("").Status)
+}
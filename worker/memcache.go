@@ -0,0 +1,433 @@
+package worker
+
+// memcached text protocol listener, backed directly by the local KV store
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/eyeKill/KV/common"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MEMCACHE_MAX_KEY_LENGTH   = 250
+	MEMCACHE_MAX_VALUE_LENGTH = 1024 * 1024
+	// exptime larger than this is an absolute unix timestamp instead of seconds from now
+	MEMCACHE_RELATIVE_EXPTIME_LIMIT = 60 * 60 * 24 * 30
+	MEMCACHE_SWEEP_INTERVAL         = time.Second
+)
+
+// Flags & expiration time of an item. They only live in memory of the primary,
+// and are neither persisted nor replicated.
+// Metadata is bound to the version of the item, so that it is dropped once the item is written in other ways.
+type memcacheMeta struct {
+	version  uint64
+	flags    uint32
+	deadline time.Time
+}
+
+type memcacheServer struct {
+	s    *WorkerServer
+	lock sync.Mutex
+	meta map[string]memcacheMeta
+}
+
+type memcacheConn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// Serve memcached text protocol on `l`. Only keys owned by this worker are served.
+func (s *WorkerServer) ServeMemcache(l net.Listener) error {
+	m := &memcacheServer{
+		s:    s,
+		meta: make(map[string]memcacheMeta),
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go m.sweep(stopCh)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go m.serveConn(conn)
+	}
+}
+
+func (m *memcacheServer) getMeta(key string, version uint64) memcacheMeta {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	meta, ok := m.meta[key]
+	if !ok || meta.version != version {
+		return memcacheMeta{version: version}
+	}
+	return meta
+}
+
+func (m *memcacheServer) setMeta(key string, meta memcacheMeta) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if meta.flags == 0 && meta.deadline.IsZero() {
+		delete(m.meta, key)
+	} else {
+		m.meta[key] = meta
+	}
+}
+
+// Get an item, returning whether it exists and has not expired yet.
+// Version of an expired item is still returned so that it could be overwritten.
+func (m *memcacheServer) lookup(key string) (ValueWithVersion, memcacheMeta, bool) {
+	v, err := m.s.kv.GetWithVersion(key)
+	if err != nil {
+		return v, memcacheMeta{}, false
+	}
+	meta := m.getMeta(key, v.Version)
+	if !meta.deadline.IsZero() && !time.Now().Before(meta.deadline) {
+		return v, meta, false
+	}
+	return v, meta, true
+}
+
+// delete expired items
+func (m *memcacheServer) sweep(stopCh chan struct{}) {
+	log := common.Log()
+	ticker := time.NewTicker(MEMCACHE_SWEEP_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if m.s.mode.Load() != MODE_PRIMARY || m.s.readOnly.Load() {
+				continue
+			}
+			var expired []memcacheMeta
+			var keys []string
+			m.lock.Lock()
+			for k, meta := range m.meta {
+				if !meta.deadline.IsZero() && !now.Before(meta.deadline) {
+					keys = append(keys, k)
+					expired = append(expired, meta)
+					delete(m.meta, k)
+				}
+			}
+			m.lock.Unlock()
+			for i, k := range keys {
+				v, err := m.s.kv.GetWithVersion(k)
+				if err != nil || v.Version != expired[i].version {
+					continue
+				}
+				if err := m.s.delete(k); err != nil {
+					log.Warn("Failed to delete expired item.", zap.String("key", k), zap.Error(err))
+				}
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Convert memcached exptime to a deadline, zero time meaning never.
+func memcacheDeadline(exptime int64, now time.Time) time.Time {
+	if exptime == 0 {
+		return time.Time{}
+	} else if exptime < 0 {
+		return now
+	} else if exptime <= MEMCACHE_RELATIVE_EXPTIME_LIMIT {
+		return now.Add(time.Duration(exptime) * time.Second)
+	} else {
+		return time.Unix(exptime, 0)
+	}
+}
+
+func validMemcacheKey(key string) bool {
+	if len(key) == 0 || len(key) > MEMCACHE_MAX_KEY_LENGTH {
+		return false
+	}
+	for _, c := range []byte(key) {
+		if c <= ' ' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *memcacheConn) reply(line string) {
+	_, _ = c.w.WriteString(line + "\r\n")
+}
+
+func (m *memcacheServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	c := &memcacheConn{r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				common.Log().Debug("Memcache connection closed.", zap.Error(err))
+			}
+			return
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) == 0 {
+			c.reply("ERROR")
+		} else if tokens[0] == "quit" {
+			_ = c.w.Flush()
+			return
+		} else if err := m.handle(c, tokens); err != nil {
+			return
+		}
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Handle a single command. Returned errors are connection errors.
+func (m *memcacheServer) handle(c *memcacheConn, tokens []string) error {
+	cmd, args := tokens[0], tokens[1:]
+	noreply := len(args) > 0 && args[len(args)-1] == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	// replies suppressed by noreply
+	reply := func(line string) {
+		if !noreply {
+			c.reply(line)
+		}
+	}
+	switch cmd {
+	case "get", "gets":
+		if len(args) == 0 {
+			c.reply("ERROR")
+			return nil
+		}
+		for _, key := range args {
+			if !m.checkKey(c, key, false) {
+				return nil
+			}
+		}
+		for _, key := range args {
+			v, meta, ok := m.lookup(key)
+			if !ok {
+				continue
+			}
+			if cmd == "gets" {
+				c.reply(fmt.Sprintf("VALUE %s %d %d %d", key, meta.flags, len(*v.Value), v.Version))
+			} else {
+				c.reply(fmt.Sprintf("VALUE %s %d %d", key, meta.flags, len(*v.Value)))
+			}
+			c.reply(*v.Value)
+		}
+		c.reply("END")
+	case "set", "add", "replace", "cas":
+		n := 4
+		if cmd == "cas" {
+			n = 5
+		}
+		if len(args) != n {
+			c.reply("ERROR")
+			return nil
+		}
+		flags, err1 := strconv.ParseUint(args[1], 10, 32)
+		exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+		length, err3 := strconv.Atoi(args[3])
+		var unique uint64
+		var err4 error
+		if cmd == "cas" {
+			unique, err4 = strconv.ParseUint(args[4], 10, 64)
+		}
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || length < 0 {
+			c.reply("CLIENT_ERROR bad command line format")
+			return nil
+		}
+		if length > MEMCACHE_MAX_VALUE_LENGTH {
+			if _, err := io.CopyN(ioutil.Discard, c.r, int64(length)+2); err != nil {
+				return err
+			}
+			c.reply("SERVER_ERROR object too large for cache")
+			return nil
+		}
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return err
+		}
+		if buf[length] != '\r' || buf[length+1] != '\n' {
+			c.reply("CLIENT_ERROR bad data chunk")
+			return nil
+		}
+		key, value := args[0], string(buf[:length])
+		if !m.checkKey(c, key, true) {
+			return nil
+		}
+		meta := memcacheMeta{flags: uint32(flags), deadline: memcacheDeadline(exptime, time.Now())}
+		reply(m.store(cmd, key, value, unique, meta))
+	case "delete":
+		// a trailing 0 is allowed for backward compatibility
+		if len(args) == 2 && args[1] == "0" {
+			args = args[:1]
+		}
+		if len(args) != 1 {
+			c.reply("CLIENT_ERROR bad command line format")
+			return nil
+		}
+		if !m.checkKey(c, args[0], true) {
+			return nil
+		}
+		v, _, ok := m.lookup(args[0])
+		if v.Value == nil {
+			reply("NOT_FOUND")
+		} else if err := m.s.delete(args[0]); err != nil {
+			c.reply("SERVER_ERROR " + err.Error())
+		} else if ok {
+			reply("DELETED")
+		} else {
+			reply("NOT_FOUND")
+		}
+	case "incr", "decr":
+		if len(args) != 2 {
+			c.reply("ERROR")
+			return nil
+		}
+		delta, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			c.reply("CLIENT_ERROR invalid numeric delta argument")
+			return nil
+		}
+		if !m.checkKey(c, args[0], true) {
+			return nil
+		}
+		reply(m.incr(args[0], delta, cmd == "incr"))
+	case "touch":
+		if len(args) != 2 {
+			c.reply("ERROR")
+			return nil
+		}
+		exptime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			c.reply("CLIENT_ERROR invalid exptime argument")
+			return nil
+		}
+		if !m.checkKey(c, args[0], true) {
+			return nil
+		}
+		_, meta, ok := m.lookup(args[0])
+		if !ok {
+			reply("NOT_FOUND")
+			return nil
+		}
+		meta.deadline = memcacheDeadline(exptime, time.Now())
+		m.setMeta(args[0], meta)
+		reply("TOUCHED")
+	default:
+		c.reply("ERROR")
+	}
+	return nil
+}
+
+// Check that key is valid & owned by this worker, and that this worker is able to serve it.
+// Replies with an error if it is not.
+func (m *memcacheServer) checkKey(c *memcacheConn, key string, write bool) bool {
+	if !validMemcacheKey(key) {
+		c.reply("CLIENT_ERROR bad key")
+		return false
+	}
+	if m.s.mode.Load() != MODE_PRIMARY {
+		c.reply("SERVER_ERROR not a primary")
+		return false
+	}
+	if !m.s.holdsLease() {
+		c.reply("SERVER_ERROR primary lease expired")
+		return false
+	}
+	if write && m.s.readOnly.Load() {
+		c.reply("SERVER_ERROR worker is read-only")
+		return false
+	}
+	if owner, ok := m.s.ownerOf(key); !ok {
+		c.reply(fmt.Sprintf("CLIENT_ERROR key %s is owned by worker %d", key, owner))
+		return false
+	}
+	return true
+}
+
+// Execute a storage command, returning the reply
+func (m *memcacheServer) store(cmd string, key string, value string, unique uint64, meta memcacheMeta) string {
+	for {
+		v, _, ok := m.lookup(key)
+		var expected uint64 = 0
+		if v.Value != nil {
+			expected = v.Version
+		}
+		switch cmd {
+		case "add":
+			if ok {
+				return "NOT_STORED"
+			}
+		case "replace":
+			if !ok {
+				return "NOT_STORED"
+			}
+		case "cas":
+			if !ok {
+				return "NOT_FOUND"
+			}
+			if v.Version != unique {
+				return "EXISTS"
+			}
+		}
+		var version uint64
+		var err error
+		if cmd == "set" {
+			version, err = m.s.put(key, value)
+		} else {
+			version, err = m.s.compareAndPut(key, value, expected)
+		}
+		if err == ECONFLICT || err == ENOENT {
+			// changed in between, try again
+			continue
+		} else if err != nil {
+			return "SERVER_ERROR " + err.Error()
+		}
+		meta.version = version
+		m.setMeta(key, meta)
+		return "STORED"
+	}
+}
+
+// Execute incr or decr, returning the reply
+func (m *memcacheServer) incr(key string, delta uint64, incr bool) string {
+	for {
+		v, meta, ok := m.lookup(key)
+		if !ok {
+			return "NOT_FOUND"
+		}
+		n, err := strconv.ParseUint(*v.Value, 10, 64)
+		if err != nil {
+			return "CLIENT_ERROR cannot increment or decrement non-numeric value"
+		}
+		if incr {
+			// wraps around at 64 bits, just like memcached
+			n += delta
+		} else if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		value := strconv.FormatUint(n, 10)
+		version, err := m.s.compareAndPut(key, value, v.Version)
+		if err == ECONFLICT || err == ENOENT {
+			continue
+		} else if err != nil {
+			return "SERVER_ERROR " + err.Error()
+		}
+		meta.version = version
+		m.setMeta(key, meta)
+		return value
+	}
+}
@@ -0,0 +1,139 @@
+package worker_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	"github.com/eyeKill/KV/localcluster"
+	"github.com/stretchr/testify/assert"
+)
+
+type memcacheClient struct {
+	t *testing.T
+	r *bufio.Reader
+	w net.Conn
+}
+
+// send a request and read `lines` lines of reply
+func (c *memcacheClient) do(req string, lines int) []string {
+	if _, err := c.w.Write([]byte(req)); err != nil {
+		c.t.Fatal(err)
+	}
+	var ret []string
+	for i := 0; i < lines; i++ {
+		l, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		ret = append(ret, strings.TrimSuffix(l, "\r\n"))
+	}
+	return ret
+}
+
+func startMemcache(t *testing.T, c *localcluster.Cluster, i int) *memcacheClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = c.Workers[i].ServeMemcache(l)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &memcacheClient{t: t, r: bufio.NewReader(conn), w: conn}
+}
+
+// find a key owned by worker `id`
+func keyOwnedBy(id common.WorkerId, n int) string {
+	slots := *common.NewHashSlotRing()
+	for i := range slots {
+		slots[i] = common.WorkerId(i%n + 1)
+	}
+	for i := 0; ; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if slots.GetWorkerIdByKey(k) == id {
+			return k
+		}
+	}
+}
+
+func TestMemcache_Storage(t *testing.T) {
+	c, err := localcluster.Start(1)
+	assert.Nil(t, err)
+	defer c.Stop()
+	mc := startMemcache(t, c, 0)
+
+	assert.Equal(t, []string{"STORED"}, mc.do("set a 5 0 3\r\nabc\r\n", 1))
+	assert.Equal(t, []string{"VALUE a 5 3", "abc", "END"}, mc.do("get a missing\r\n", 3))
+	assert.Equal(t, []string{"NOT_STORED"}, mc.do("add a 0 0 1\r\nx\r\n", 1))
+	assert.Equal(t, []string{"STORED"}, mc.do("add b 0 0 1\r\n1\r\n", 1))
+	assert.Equal(t, []string{"NOT_STORED"}, mc.do("replace c 0 0 1\r\nx\r\n", 1))
+	assert.Equal(t, []string{"STORED"}, mc.do("replace b 0 0 2\r\n10\r\n", 1))
+	assert.Equal(t, []string{"15"}, mc.do("incr b 5\r\n", 1))
+	assert.Equal(t, []string{"0"}, mc.do("decr b 100\r\n", 1))
+	assert.Equal(t, []string{"CLIENT_ERROR cannot increment or decrement non-numeric value"}, mc.do("incr a 1\r\n", 1))
+	assert.Equal(t, []string{"NOT_FOUND"}, mc.do("incr c 1\r\n", 1))
+	assert.Equal(t, []string{"DELETED"}, mc.do("delete b\r\n", 1))
+	assert.Equal(t, []string{"NOT_FOUND"}, mc.do("delete b\r\n", 1))
+	assert.Equal(t, []string{"END"}, mc.do("get b\r\n", 1))
+	// noreply only suppresses the reply
+	assert.Equal(t, []string{"VALUE d 0 1", "x", "END"}, mc.do("set d 0 0 1 noreply\r\nx\r\nget d\r\n", 3))
+	assert.Equal(t, []string{"ERROR"}, mc.do("bogus\r\n", 1))
+}
+
+func TestMemcache_Cas(t *testing.T) {
+	c, err := localcluster.Start(1)
+	assert.Nil(t, err)
+	defer c.Stop()
+	mc := startMemcache(t, c, 0)
+
+	assert.Equal(t, []string{"NOT_FOUND"}, mc.do("cas a 0 0 1 1\r\nx\r\n", 1))
+	assert.Equal(t, []string{"STORED"}, mc.do("set a 0 0 1\r\nx\r\n", 1))
+	ret := mc.do("gets a\r\n", 3)
+	var unique uint64
+	_, err = fmt.Sscanf(ret[0], "VALUE a 0 1 %d", &unique)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"EXISTS"}, mc.do(fmt.Sprintf("cas a 0 0 1 %d\r\ny\r\n", unique+1), 1))
+	assert.Equal(t, []string{"STORED"}, mc.do(fmt.Sprintf("cas a 0 0 1 %d\r\ny\r\n", unique), 1))
+	// unique changed after the write
+	assert.Equal(t, []string{"EXISTS"}, mc.do(fmt.Sprintf("cas a 0 0 1 %d\r\nz\r\n", unique), 1))
+	assert.Equal(t, []string{"VALUE a 0 1", "y", "END"}, mc.do("get a\r\n", 3))
+}
+
+func TestMemcache_Expiry(t *testing.T) {
+	c, err := localcluster.Start(1)
+	assert.Nil(t, err)
+	defer c.Stop()
+	mc := startMemcache(t, c, 0)
+
+	assert.Equal(t, []string{"STORED"}, mc.do("set a 0 -1 1\r\nx\r\n", 1))
+	assert.Equal(t, []string{"END"}, mc.do("get a\r\n", 1))
+	assert.Equal(t, []string{"STORED"}, mc.do("add a 0 1 1\r\ny\r\n", 1))
+	assert.Equal(t, []string{"TOUCHED"}, mc.do("touch a 100\r\n", 1))
+	assert.Equal(t, []string{"NOT_FOUND"}, mc.do("touch b 100\r\n", 1))
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, []string{"VALUE a 0 1", "y", "END"}, mc.do("get a\r\n", 3))
+	assert.Equal(t, []string{"TOUCHED"}, mc.do("touch a -1\r\n", 1))
+	assert.Equal(t, []string{"END"}, mc.do("get a\r\n", 1))
+}
+
+func TestMemcache_Ownership(t *testing.T) {
+	c, err := localcluster.Start(2)
+	assert.Nil(t, err)
+	defer c.Stop()
+	mc := startMemcache(t, c, 0)
+
+	mine, theirs := keyOwnedBy(1, 2), keyOwnedBy(2, 2)
+	assert.Equal(t, []string{"STORED"}, mc.do(fmt.Sprintf("set %s 0 0 1\r\nx\r\n", mine), 1))
+	assert.Equal(t, []string{fmt.Sprintf("CLIENT_ERROR key %s is owned by worker 2", theirs)},
+		mc.do(fmt.Sprintf("set %s 0 0 1\r\nx\r\n", theirs), 1))
+	assert.Equal(t, []string{fmt.Sprintf("CLIENT_ERROR key %s is owned by worker 2", theirs)},
+		mc.do(fmt.Sprintf("get %s %s\r\n", mine, theirs), 1))
+}
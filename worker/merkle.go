@@ -0,0 +1,337 @@
+package worker
+
+// Merkle trees of committed keys, maintained by the KV store for anti-entropy between primary and backups
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// leaves of the tree of each slot, keys are spread among them by hash
+	MERKLE_LEAVES                 = 16
+	DEFAULT_ANTI_ENTROPY_INTERVAL = 30 * time.Second
+	ANTI_ENTROPY_TIMEOUT          = 10 * time.Second
+)
+
+// a leaf of the Merkle tree of a slot
+type merkleRange struct {
+	slot int
+	leaf int
+}
+
+type antiEntropyStats struct {
+	rounds   atomic.Uint64
+	diverged atomic.Uint64
+	repaired atomic.Uint64
+}
+
+// Merkle trees of every slot, in heap order with the root at 1 and leaves from MERKLE_LEAVES on.
+// A leaf is the XOR of hashes of (key, value, version) of the keys in it, so it could be updated
+// as a value changes without looking at other keys, and inner nodes are hashes of their children.
+// Slots are taken from the key itself, so they could differ from where locks & channels are routed.
+type merkleForest struct {
+	lock  sync.RWMutex
+	trees [][2 * MERKLE_LEAVES]uint64
+}
+
+func newMerkleForest() *merkleForest {
+	return &merkleForest{trees: make([][2 * MERKLE_LEAVES]uint64, common.DEFAULT_SLOT_COUNT)}
+}
+
+// Get slot & leaf key belongs to.
+func merkleLocation(key string) (int, int) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(common.GetSlotId(key, int(common.DEFAULT_SLOT_COUNT))), int(h.Sum32() % MERKLE_LEAVES)
+}
+
+// Hash of a committed value of key, deleted ones hash to zero as if they were never there.
+func entryHash(key string, v ValueWithVersion) uint64 {
+	if v.Value == nil {
+		return 0
+	}
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(len(key)))
+	_, _ = h.Write(buf[:])
+	_, _ = h.Write([]byte(key))
+	binary.BigEndian.PutUint64(buf[:], v.Version)
+	_, _ = h.Write(buf[:])
+	_, _ = h.Write([]byte(*v.Value))
+	return h.Sum64()
+}
+
+func combine(left uint64, right uint64) uint64 {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], left)
+	binary.BigEndian.PutUint64(buf[8:], right)
+	h := fnv.New64a()
+	_, _ = h.Write(buf[:])
+	return h.Sum64()
+}
+
+// Replace committed value of key from `old` to `new`.
+func (f *merkleForest) update(key string, old ValueWithVersion, new ValueWithVersion) {
+	d := entryHash(key, old) ^ entryHash(key, new)
+	if d == 0 {
+		return
+	}
+	slot, leaf := merkleLocation(key)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	t := &f.trees[slot]
+	i := MERKLE_LEAVES + leaf
+	t[i] ^= d
+	for i /= 2; i > 0; i /= 2 {
+		t[i] = combine(t[2*i], t[2*i+1])
+	}
+}
+
+// Rebuild every tree from committed values, `layer` taking precedence over `base`.
+func (f *merkleForest) reset(base map[string]ValueWithVersion, layer map[string]ValueWithVersion) {
+	trees := make([][2 * MERKLE_LEAVES]uint64, common.DEFAULT_SLOT_COUNT)
+	add := func(k string, v ValueWithVersion) {
+		slot, leaf := merkleLocation(k)
+		trees[slot][MERKLE_LEAVES+leaf] ^= entryHash(k, v)
+	}
+	for k, v := range base {
+		if _, ok := layer[k]; !ok {
+			add(k, v)
+		}
+	}
+	for k, v := range layer {
+		add(k, v)
+	}
+	for slot := range trees {
+		t := &trees[slot]
+		for i := MERKLE_LEAVES - 1; i > 0; i-- {
+			t[i] = combine(t[2*i], t[2*i+1])
+		}
+	}
+	f.lock.Lock()
+	f.trees = trees
+	f.lock.Unlock()
+}
+
+func (f *merkleForest) roots() []uint64 {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	ret := make([]uint64, len(f.trees))
+	for i := range f.trees {
+		ret[i] = f.trees[i][1]
+	}
+	return ret
+}
+
+func (f *merkleForest) leaves(slot int) []uint64 {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if slot < 0 || slot >= len(f.trees) {
+		return nil
+	}
+	ret := make([]uint64, MERKLE_LEAVES)
+	copy(ret, f.trees[slot][MERKLE_LEAVES:])
+	return ret
+}
+
+// Compare Merkle trees with the backup of `routine` every AntiEntropyInterval, until it is stopped.
+// Leaves found diverged in two rounds in a row are repaired, since those in one round only could be entries in flight.
+func (s *WorkerServer) antiEntropyRoutine(routine *SyncRoutine) {
+	if s.AntiEntropyInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.AntiEntropyInterval)
+	defer ticker.Stop()
+	suspects := make(map[merkleRange]bool)
+	for {
+		select {
+		case <-ticker.C:
+		case <-routine.StopCh:
+			return
+		}
+		if s.mode.Load() != MODE_PRIMARY || s.deposed.Load() {
+			continue
+		}
+		var err error
+		if suspects, err = s.antiEntropyRound(routine, suspects); err != nil {
+			common.Log().Warn("Anti-entropy round failed.", zap.String("backup", routine.name), zap.Error(err))
+		}
+	}
+}
+
+// Compare Merkle trees with the backup once and repair leaves among `suspects` diverged again.
+// Returns leaves diverged for the first time.
+func (s *WorkerServer) antiEntropyRound(routine *SyncRoutine, suspects map[merkleRange]bool) (map[merkleRange]bool, error) {
+	log := common.SugaredLog()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), HEADER_CLIENT_WORKER_ID, strconv.Itoa(int(s.Id)))
+	ctx, cancel := context.WithTimeout(ctx, ANTI_ENTROPY_TIMEOUT)
+	defer cancel()
+	resp, err := routine.conn.MerkleTree(ctx, &pb.MerkleRequest{})
+	if err != nil {
+		return suspects, err
+	} else if resp.Status != pb.Status_OK {
+		return suspects, errors.New("backup refused to send Merkle trees")
+	}
+	s.antiEntropy.rounds.Inc()
+	var slots []uint32
+	for i, root := range s.kv.MerkleRoots() {
+		if i < len(resp.Roots) && resp.Roots[i] != root {
+			slots = append(slots, uint32(i))
+		}
+	}
+	diverged := make(map[merkleRange]bool)
+	if len(slots) == 0 {
+		return diverged, nil
+	}
+	resp, err = routine.conn.MerkleTree(ctx, &pb.MerkleRequest{Slots: slots})
+	if err != nil {
+		return suspects, err
+	} else if resp.Status != pb.Status_OK {
+		return suspects, errors.New("backup refused to send Merkle trees")
+	}
+	var confirmed []merkleRange
+	for _, leaves := range resp.Leaves {
+		mine := s.kv.MerkleLeaves(int(leaves.Slot))
+		for i, h := range leaves.Hashes {
+			if i < len(mine) && mine[i] != h {
+				r := merkleRange{slot: int(leaves.Slot), leaf: i}
+				if suspects[r] {
+					confirmed = append(confirmed, r)
+				} else {
+					diverged[r] = true
+				}
+			}
+		}
+	}
+	if len(confirmed) == 0 {
+		return diverged, nil
+	}
+	s.antiEntropy.diverged.Add(uint64(len(confirmed)))
+	log.Warnf("%d leaves of Merkle trees diverged on %s, repairing...", len(confirmed), routine.name)
+	repaired, err := s.repair(ctx, routine, confirmed)
+	if err != nil {
+		return diverged, err
+	}
+	s.antiEntropy.repaired.Add(repaired)
+	log.Infof("Repaired %d keys on %s.", repaired, routine.name)
+	return diverged, nil
+}
+
+// Send every key of the primary in `ranges` to the backup, returning the number of keys it has overwritten.
+func (s *WorkerServer) repair(ctx context.Context, routine *SyncRoutine, ranges []merkleRange) (uint64, error) {
+	// keys the primary does not have are deleted unless written after this
+	req := pb.RepairRequest{Version: s.kv.GetVersion(), Epoch: s.epoch.Load()}
+	byRange := make(map[merkleRange]*pb.RepairRange)
+	for _, r := range ranges {
+		rr := &pb.RepairRange{Slot: uint32(r.slot), Leaf: uint32(r.leaf)}
+		byRange[r] = rr
+		req.Ranges = append(req.Ranges, rr)
+	}
+	content := s.kv.Extract(func(key string) bool {
+		slot, leaf := merkleLocation(key)
+		return byRange[merkleRange{slot: slot, leaf: leaf}] != nil
+	}, 0)
+	for k, v := range content {
+		slot, leaf := merkleLocation(k)
+		ent := pb.BackupEntry{Op: pb.Operation_DELETE, Key: k, Version: v.Version, Epoch: req.Epoch}
+		if v.Value != nil {
+			ent.Op = pb.Operation_PUT
+			ent.Value = *v.Value
+		}
+		rr := byRange[merkleRange{slot: slot, leaf: leaf}]
+		rr.Entries = append(rr.Entries, &ent)
+	}
+	reply, err := routine.conn.Repair(ctx, &req)
+	if err != nil {
+		return 0, err
+	}
+	if reply.Status == pb.Status_EINVEPOCH {
+		routine.depose()
+		return 0, EDEPOSED
+	} else if reply.Status != pb.Status_OK {
+		return 0, errors.New("backup failed to repair")
+	}
+	return reply.Repaired, nil
+}
+
+func (s *WorkerServer) MerkleTree(ctx context.Context, req *pb.MerkleRequest) (*pb.MerkleResponse, error) {
+	remoteId, err := remoteWorkerId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if remoteId != s.Id || !s.isReplica() {
+		return &pb.MerkleResponse{Status: pb.Status_EINVSERVER}, nil
+	}
+	resp := pb.MerkleResponse{Status: pb.Status_OK, Roots: s.kv.MerkleRoots()}
+	for _, slot := range req.Slots {
+		resp.Leaves = append(resp.Leaves, &pb.MerkleLeaves{Slot: slot, Hashes: s.kv.MerkleLeaves(int(slot))})
+	}
+	return &resp, nil
+}
+
+func (s *WorkerServer) Repair(ctx context.Context, req *pb.RepairRequest) (*pb.RepairReply, error) {
+	log := common.SugaredLog()
+	remoteId, err := remoteWorkerId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if remoteId != s.Id || !s.isReplica() {
+		return &pb.RepairReply{Status: pb.Status_EINVSERVER}, nil
+	}
+	if !s.checkEpoch(req.Epoch) {
+		log.Warnf("Rejecting repair from a primary of epoch %d.", req.Epoch)
+		return &pb.RepairReply{Status: pb.Status_EINVEPOCH}, nil
+	}
+	var repaired uint64
+	restore := func(key string, v ValueWithVersion) error {
+		ok, err := s.kv.Restore(key, v)
+		if ok {
+			repaired++
+		}
+		return err
+	}
+	for _, r := range req.Ranges {
+		theirs := make(map[string]bool)
+		for _, ent := range r.Entries {
+			theirs[ent.Key] = true
+			v := ValueWithVersion{Version: ent.Version}
+			if ent.Op == pb.Operation_PUT {
+				value := ent.Value
+				v.Value = &value
+			}
+			if err := restore(ent.Key, v); err != nil {
+				log.Error("Failed to repair.", zap.Error(err))
+				return &pb.RepairReply{Status: pb.Status_EFAILED, Repaired: repaired}, nil
+			}
+		}
+		ours := s.kv.Extract(func(key string) bool {
+			slot, leaf := merkleLocation(key)
+			return slot == int(r.Slot) && leaf == int(r.Leaf)
+		}, 0)
+		for k, v := range ours {
+			if theirs[k] || v.Value == nil {
+				continue
+			}
+			if err := restore(k, ValueWithVersion{Version: req.Version}); err != nil {
+				log.Error("Failed to repair.", zap.Error(err))
+				return &pb.RepairReply{Status: pb.Status_EFAILED, Repaired: repaired}, nil
+			}
+		}
+	}
+	if repaired > 0 {
+		log.Warnf("Repaired %d keys diverged from the primary.", repaired)
+	}
+	return &pb.RepairReply{Status: pb.Status_OK, Repaired: repaired}, nil
+}
@@ -18,12 +18,32 @@ import (
 	"path"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// Keys used internally by workers start with this prefix, and are hidden from scans.
+const INTERNAL_KEY_PREFIX = "$"
+
 func GetMigrationVersionKey(id common.WorkerId) string {
-	return fmt.Sprintf("$migration-worker-%d", id)
+	return fmt.Sprintf("%smigration-worker-%d", INTERNAL_KEY_PREFIX, id)
+}
+
+// Get the key a key is routed by in migrations. Internal keys of channels & locks are routed
+// by the channel or lock they belong to, so that they move together with it.
+func routingKey(key string) string {
+	if strings.HasPrefix(key, LOCK_KEY_PREFIX) {
+		return strings.TrimPrefix(key, LOCK_KEY_PREFIX)
+	}
+	if !strings.HasPrefix(key, PUBSUB_KEY_PREFIX) {
+		return key
+	}
+	name := strings.TrimPrefix(key, PUBSUB_KEY_PREFIX)
+	if i := strings.LastIndexByte(name, 0); i >= 0 {
+		return name[:i]
+	}
+	return key
 }
 
 // Transfer bunch of data with transaction
@@ -49,6 +69,7 @@ func (s *WorkerServer) MigrateTransfer(server pb.KVBackup_TransferServer, remote
 	// start a new transaction
 	tid, err := s.kv.StartTransaction()
 	var version uint64 = 0
+	var transferred []*pb.BackupEntry
 	if err != nil {
 		// try again later
 		return server.SendAndClose(&pb.BackupReply{
@@ -69,8 +90,18 @@ func (s *WorkerServer) MigrateTransfer(server pb.KVBackup_TransferServer, remote
 				}
 				os.Exit(-1)
 			}
-			// commit transaction
-			if err := s.kv.Commit(tid); err != nil {
+			// commit transaction, the entries transferred are published to watchers under the version of the commit
+			err := s.applyUnsynced(func() ([]*pb.BackupEntry, error) {
+				if err := s.kv.Commit(tid); err != nil {
+					return nil, err
+				}
+				committed := s.kv.GetVersion()
+				for _, ent := range transferred {
+					ent.Version = committed
+				}
+				return transferred, nil
+			})
+			if err != nil {
 				log.Error("Failed to commit", zap.Error(err))
 				return server.SendAndClose(&pb.BackupReply{
 					Status:  pb.Status_EFAILED,
@@ -91,6 +122,8 @@ func (s *WorkerServer) MigrateTransfer(server pb.KVBackup_TransferServer, remote
 			s.versionCond.L.Lock()
 			s.version = version
 			s.versionCond.L.Unlock()
+			// not sent to backups, those behind have to take a snapshot
+			s.wal.reset(s.kv.GetVersion())
 			return server.SendAndClose(&pb.BackupReply{
 				Status:  pb.Status_OK,
 				Version: version,
@@ -112,6 +145,7 @@ func (s *WorkerServer) MigrateTransfer(server pb.KVBackup_TransferServer, remote
 		default:
 			goto fail
 		}
+		transferred = append(transferred, &pb.BackupEntry{Op: ent.Op, Key: ent.Key, Value: ent.Value})
 		// update version
 		if ent.Version > version {
 			version = ent.Version
@@ -167,8 +201,7 @@ func (s *WorkerServer) MigrateSync(server pb.KVBackup_SyncServer) error {
 		}
 		switch ent.Op {
 		case pb.Operation_PUT:
-			_, err := s.kv.Put(ent.Key, ent.Value, 0)
-			if err != nil {
+			if err := s.applyMigrated(ent); err != nil {
 				if err := server.Send(&pb.BackupReply{
 					Status:  pb.Status_EFAILED,
 					Version: ent.Version,
@@ -195,8 +228,7 @@ func (s *WorkerServer) MigrateSync(server pb.KVBackup_SyncServer) error {
 				return err
 			}
 		case pb.Operation_DELETE:
-			_, err := s.kv.Delete(ent.Key, 0)
-			if err != nil {
+			if err := s.applyMigrated(ent); err != nil {
 				if err := server.Send(&pb.BackupReply{
 					Status:  pb.Status_EFAILED,
 					Version: ent.Version,
@@ -223,111 +255,235 @@ func (s *WorkerServer) MigrateSync(server pb.KVBackup_SyncServer) error {
 				return err
 			}
 		}
+		// not sent to backups, those behind have to take a snapshot
+		s.wal.reset(s.kv.GetVersion())
 	}
 }
 
+// Apply an entry synced in a migration, published to watchers under the version it gets here.
+func (s *WorkerServer) applyMigrated(ent *pb.BackupEntry) error {
+	return s.applyUnsynced(func() ([]*pb.BackupEntry, error) {
+		var version uint64
+		var err error
+		if ent.Op == pb.Operation_DELETE {
+			version, err = s.kv.Delete(ent.Key, 0)
+		} else {
+			version, err = s.kv.Put(ent.Key, ent.Value, 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*pb.BackupEntry{{Op: ent.Op, Key: ent.Key, Value: ent.Value, Version: version}}, nil
+	})
+}
+
 func (s *WorkerServer) RegisterBackup(name string, routine *SyncRoutine) {
 	s.backups[name] = routine
 }
 
-// sync latest entries.
-func (s *WorkerServer) syncEntry(entry *pb.BackupEntry) {
+// Apply a mutation to local KV with `apply` and sync the entry it returns, return when it is synced.
+// Entries are handed over to DoSync in the order of their versions,
+// so that sync routines and watchers see them in order.
+func (s *WorkerServer) syncEntry(apply func() (*pb.BackupEntry, error)) (uint64, error) {
+	s.writeLock.Lock()
+	// writes paused by a switchover find out here that they are too late
+	if s.deposed.Load() || s.mode.Load() != MODE_PRIMARY {
+		s.writeLock.Unlock()
+		return 0, EDEPOSED
+	}
+	entry, err := apply()
+	if err != nil {
+		s.writeLock.Unlock()
+		return 0, err
+	}
+	entry.Epoch = s.epoch.Load()
 	v := entry.Version
+	s.handedVersion = v
 	s.backupCh <- entry
+	s.writeLock.Unlock()
 	s.versionCond.L.Lock()
-	for s.version < v {
+	for !s.committedLocked(v) && !s.deposed.Load() {
 		s.versionCond.Wait()
 	}
+	synced := s.committedLocked(v)
 	s.versionCond.L.Unlock()
+	s.kv.Flush()
+	if !synced {
+		return 0, EDEPOSED
+	}
+	return v, nil
 }
 
+const (
+	// interval of heartbeats to backups, which tell them how far behind they are
+	SYNC_HEARTBEAT_INTERVAL = 500 * time.Millisecond
+	// time after which backups no longer heard from their primary take it as cut off
+	SYNC_LIVENESS_TIMEOUT = 2 * SYNC_HEARTBEAT_INTERVAL
+	// number of entries waiting to be synced before writers block
+	SYNC_QUEUE_SIZE = 1024
+	// number of recent entries retained for backups to catch up from
+	BACKUP_LOG_SIZE         = 65536
+	DEFAULT_SYNC_BATCH_SIZE = 64
+	DEFAULT_SYNC_WINDOW     = 8
+)
+
 // broadcast backup entry to every sync routine, return when all of them are ready.
 // Does not matter if it runs under primary or backup, since backup does not have any sync routine
 func (s *WorkerServer) DoSync() {
 	log := common.SugaredLog()
 	log.Info("Sync goroutine is up and running...")
+	ticker := time.NewTicker(SYNC_HEARTBEAT_INTERVAL)
+	defer ticker.Stop()
 	for {
 		select {
-		case entry := <-s.backupCh:
+		case <-ticker.C:
+			s.versionCond.L.Lock()
+			heartbeat := &pb.BackupEntry{Op: pb.Operation_GET, Version: s.version, Epoch: s.epoch.Load()}
+			s.versionCond.L.Unlock()
 			s.backupLock.RLock()
-			// sync all backups and migrations
 			for _, routine := range s.backups {
-				if routine.GetMask()(entry.Key) {
-					routine.EntryCh <- entry
+				// skip routines busy with entries, they will get the next one
+				select {
+				case routine.EntryCh <- heartbeat:
+				default:
 				}
 			}
-			for _, routine := range s.migrations {
-				if routine.GetMask()(entry.Key) {
-					routine.EntryCh <- entry
+			s.backupLock.RUnlock()
+		case entry := <-s.backupCh:
+			start := time.Now()
+			// entries of concurrent writes are synced & committed together
+			entries := []*pb.BackupEntry{entry}
+		collect:
+			for len(entries) < s.SyncBatchSize {
+				select {
+				case entry := <-s.backupCh:
+					entries = append(entries, entry)
+				default:
+					break collect
+				}
+			}
+			last := entries[len(entries)-1].Version
+			s.backupLock.RLock()
+			// sync all backups and migrations
+			for _, entry := range entries {
+				s.wal.publish(entry)
+				for _, routine := range s.backups {
+					if routine.GetMask()(entry.Key) {
+						routine.offer(entry)
+					}
+				}
+				for _, routine := range s.migrations {
+					if routine.GetMask()(entry.Key) {
+						routine.EntryCh <- entry
+					}
 				}
 			}
 			s.backupLock.RUnlock()
+			s.dispatches.record(last, start)
 
-			// backup use semi-sync transfer, which means
-			// only one of them have to ack before going on.
+			// how many backups have to ack before going on depends on replication mode,
+			// acks are cumulative so the last entry is enough
 			if len(s.backups) > 0 {
 				log.Infof("WAITING FOR BACKUPS")
-				s.backupCond.L.Lock()
-				for {
-					// update s.version
-					for _, routine := range s.backups {
-						if routine.Version > s.backupVersion {
-							s.backupVersion = routine.Version
-						}
-					}
-					if s.backupVersion < entry.Version {
-						s.backupCond.Wait()
-					} else {
-						s.backupCond.L.Unlock()
-						break
-					}
-				}
+				mode := s.waitBackups(last)
+				s.replLatency[mode].observe(time.Since(start))
 			}
 
 			if len(s.migrations) > 0 {
 				log.Infof("WAITING FOR MIGRATIONS")
 				// migration use loss less transfer, which means all of them should complete
 				for _, routine := range s.migrations {
-					if routine.Syncing && routine.GetMask()(entry.Key) {
-						routine.Condition.L.Lock()
-						for routine.Version < entry.Version {
-							routine.Condition.Wait()
+					if !routine.Syncing || s.deposed.Load() {
+						continue
+					}
+					// last entry sent to it
+					var v uint64 = 0
+					for _, entry := range entries {
+						if routine.GetMask()(entry.Key) {
+							v = entry.Version
 						}
-						routine.Condition.L.Unlock()
 					}
+					routine.Condition.L.Lock()
+					for routine.Version < v {
+						routine.Condition.Wait()
+					}
+					routine.Condition.L.Unlock()
 				}
 			}
+			if s.deposed.Load() {
+				// never acknowledged by backups of the new epoch, writers are woken up by depose
+				continue
+			}
 			s.versionCond.L.Lock()
-			s.version = entry.Version
+			s.version = last
 			s.versionCond.L.Unlock()
 			s.versionCond.Broadcast()
+			for _, entry := range entries {
+				s.tail.publish(entry)
+			}
 		case <-s.SyncStopChan:
 			return
 		}
 	}
 }
 
+// Put key into local KV, and replicate it to backups & migration targets.
+func (s *WorkerServer) put(key string, value string) (uint64, error) {
+	if s.raft != nil {
+		return s.proposeEntry(&pb.BackupEntry{Op: pb.Operation_PUT, Key: key, Value: value})
+	}
+	return s.syncEntry(func() (*pb.BackupEntry, error) {
+		version, err := s.kv.Put(key, value, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.BackupEntry{Op: pb.Operation_PUT, Key: key, Value: value, Version: version}, nil
+	})
+}
+
+// Put key into local KV if its version matches, and replicate it.
+func (s *WorkerServer) compareAndPut(key string, value string, version uint64) (uint64, error) {
+	if s.raft != nil {
+		return s.proposeEntry(&pb.BackupEntry{Op: pb.Operation_PUT, Key: key, Value: value, Version: version, Compare: true})
+	}
+	return s.syncEntry(func() (*pb.BackupEntry, error) {
+		newVersion, err := s.kv.CompareAndPut(key, value, version)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.BackupEntry{Op: pb.Operation_PUT, Key: key, Value: value, Version: newVersion}, nil
+	})
+}
+
+// Delete key from local KV, and replicate it.
+func (s *WorkerServer) delete(key string) error {
+	if s.raft != nil {
+		_, err := s.proposeEntry(&pb.BackupEntry{Op: pb.Operation_DELETE, Key: key})
+		return err
+	}
+	_, err := s.syncEntry(func() (*pb.BackupEntry, error) {
+		version, err := s.kv.Delete(key, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.BackupEntry{Op: pb.Operation_DELETE, Key: key, Version: version}, nil
+	})
+	return err
+}
+
 func (s *WorkerServer) Put(_ context.Context, pair *pb.KVPair) (*pb.PutResponse, error) {
 	if pair.SlotVersion != s.SlotTableVersion.Load() {
 		return &pb.PutResponse{Status: pb.Status_EINVVERSION}, nil
 	}
-	if s.mode != MODE_PRIMARY || s.readOnly {
-		return &pb.PutResponse{Status: pb.Status_EINVSERVER}, nil
+	if st := s.writeStatus(); st != pb.Status_OK {
+		return &pb.PutResponse{Status: st}, nil
 	}
-	log := common.SugaredLog()
-	version, err := s.kv.Put(pair.Key, pair.Value, 0)
-	if err != nil {
+	if _, err := s.put(pair.Key, pair.Value); err == EDEPOSED {
+		return &pb.PutResponse{Status: pb.Status_EINVSERVER}, nil
+	} else if err != nil {
 		return &pb.PutResponse{Status: pb.Status_ENOENT}, nil
 	}
-	ent := pb.BackupEntry{
-		Op:      pb.Operation_PUT,
-		Key:     pair.Key,
-		Value:   pair.Value,
-		Version: version,
-	}
-	s.syncEntry(&ent)
-	log.Infof("SYNCED REMOTELY")
-	s.kv.Flush()
+	common.SugaredLog().Infof("SYNCED REMOTELY")
 	return &pb.PutResponse{Status: pb.Status_OK}, nil
 }
 
@@ -335,7 +491,11 @@ func (s *WorkerServer) Get(_ context.Context, key *pb.Key) (*pb.GetResponse, err
 	if key.SlotVersion != s.SlotTableVersion.Load() {
 		return &pb.GetResponse{Status: pb.Status_EINVVERSION}, nil
 	}
-	if s.mode != MODE_PRIMARY {
+	if s.mode.Load() == MODE_PRIMARY {
+		if !s.holdsLease() {
+			return &pb.GetResponse{Status: pb.Status_EINVSERVER}, nil
+		}
+	} else if !s.servesFollowerRead(key) {
 		return &pb.GetResponse{Status: pb.Status_EINVSERVER}, nil
 	}
 
@@ -357,28 +517,46 @@ func (s *WorkerServer) Delete(_ context.Context, key *pb.Key) (*pb.DeleteRespons
 	if key.SlotVersion != s.SlotTableVersion.Load() {
 		return &pb.DeleteResponse{Status: pb.Status_EINVVERSION}, nil
 	}
-	if s.mode != MODE_PRIMARY || s.readOnly {
-		return &pb.DeleteResponse{Status: pb.Status_EINVSERVER}, nil
+	if st := s.writeStatus(); st != pb.Status_OK {
+		return &pb.DeleteResponse{Status: st}, nil
 	}
 	if _, err := s.kv.Get(key.Key, 0); err != nil {
 		return &pb.DeleteResponse{Status: pb.Status_ENOENT}, nil
 	}
-	version, err := s.kv.Delete(key.Key, 0)
-	if err != nil {
+	if err := s.delete(key.Key); err == EDEPOSED {
+		return &pb.DeleteResponse{Status: pb.Status_EINVSERVER}, nil
+	} else if err != nil {
 		return &pb.DeleteResponse{Status: pb.Status_ENOENT}, nil
 	}
-	ent := pb.BackupEntry{
-		Op:      pb.Operation_DELETE,
-		Key:     key.Key,
-		Version: version,
-	}
-	s.syncEntry(&ent)
-	s.kv.Flush()
 	return &pb.DeleteResponse{Status: pb.Status_OK}, nil
 }
 
+func (s *WorkerServer) Scan(_ context.Context, req *pb.ScanRequest) (*pb.ScanResponse, error) {
+	if req.SlotVersion != s.SlotTableVersion.Load() {
+		return &pb.ScanResponse{Status: pb.Status_EINVVERSION}, nil
+	}
+	if s.mode.Load() != MODE_PRIMARY || !s.holdsLease() {
+		return &pb.ScanResponse{Status: pb.Status_EINVSERVER}, nil
+	}
+	start := req.Start
+	resp := pb.ScanResponse{Status: pb.Status_OK}
+	for len(resp.Pairs) < int(req.Limit) {
+		entries := s.kv.Scan(req.Prefix, start, int(req.Limit)-len(resp.Pairs))
+		if len(entries) == 0 {
+			break
+		}
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Key, INTERNAL_KEY_PREFIX) {
+				resp.Pairs = append(resp.Pairs, &pb.KVPair{Key: e.Key, Value: *e.Value})
+			}
+		}
+		start = entries[len(entries)-1].Key
+	}
+	return &resp, nil
+}
+
 func (s *WorkerServer) Checkpoint(_ context.Context, _ *empty.Empty) (*pb.FlushResponse, error) {
-	if s.mode != MODE_PRIMARY {
+	if s.mode.Load() != MODE_PRIMARY {
 		return &pb.FlushResponse{Status: pb.Status_EINVSERVER}, nil
 	}
 	if err := s.kv.Checkpoint(); err != nil {
@@ -403,8 +581,9 @@ func (s *WorkerServer) registerPrimary(weight float32) error {
 		if err != nil {
 			return err
 		}
-		s.config.Weight = weight
-		cBin, err := json.Marshal(s.config)
+		config := s.getConfig()
+		config.Weight = weight
+		cBin, err := json.Marshal(config)
 		if err != nil {
 			return err
 		}
@@ -427,6 +606,7 @@ func (s *WorkerServer) registerPrimary(weight float32) error {
 		if err != nil {
 			return err
 		}
+		s.SetConfig(config)
 		// get node name from last response's response
 		s.NodeName = path.Base(resps[len(resps)-1].String)
 	} else {
@@ -435,7 +615,7 @@ func (s *WorkerServer) registerPrimary(weight float32) error {
 		if err := common.ZkGet(s.conn, path.Join(p, common.ZK_WORKER_CONFIG_NAME), &config); err != nil {
 			return err
 		}
-		s.config = config
+		s.SetConfig(config)
 		// register itself
 		name, err := common.ZkCreate(s.conn, nodePath, node, true, true)
 		if err != nil {
@@ -443,7 +623,8 @@ func (s *WorkerServer) registerPrimary(weight float32) error {
 		}
 		s.NodeName = path.Base(name)
 	}
-	return nil
+	_, err = s.loadEpoch()
+	return err
 }
 
 // Migrate to the "real" primary when I'm the replacement primary.
@@ -482,7 +663,13 @@ func (s *WorkerServer) migrateToNewPrimary(newPrimary string) {
 
 func (s *WorkerServer) primaryWatch(worker common.Worker) {
 	log := common.SugaredLog()
-	// check the backups, make sure backup connections are intact
+	// another primary might have been elected while we were cut off
+	if newer, err := s.loadEpoch(); err != nil {
+		log.Error("Failed to load epoch.", zap.Error(err))
+	} else if newer {
+		s.depose()
+	}
+	// check the backups & learners, make sure their connections are intact
 	for name := range worker.Backups {
 		if _, ok := s.backups[name]; !ok {
 			if err := s.AddBackupRoutine(name); err != nil {
@@ -490,8 +677,17 @@ func (s *WorkerServer) primaryWatch(worker common.Worker) {
 			}
 		}
 	}
+	for name := range worker.Learners {
+		if _, ok := s.backups[name]; !ok {
+			if err := s.AddLearnerRoutine(name); err != nil {
+				log.Error("Failed to connect to learner, retry in the next cycle.", zap.Error(err))
+			}
+		}
+	}
 	for name := range s.backups {
-		if _, ok := worker.Backups[name]; !ok {
+		_, backup := worker.Backups[name]
+		_, learner := worker.Learners[name]
+		if !backup && !learner {
 			// not found, remove this backup routine
 			log.Infof("Removing backup routine with %s...", name)
 			if err := s.RemoveBackupRoutine(name); err != nil {
@@ -499,15 +695,20 @@ func (s *WorkerServer) primaryWatch(worker common.Worker) {
 			}
 		}
 	}
-	// update number of backups to config file
-	s.config.NumBackups = len(s.backups)
-	configPath := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)), common.ZK_WORKER_CONFIG_NAME)
-	bin, _ := json.Marshal(s.config)
-	if _, err := s.conn.Set(configPath, bin, -1); err != nil {
+	// update number of backups to config file, keeping the rest of it
+	numBackups := 0
+	for _, routine := range s.backups {
+		if !routine.learner {
+			numBackups++
+		}
+	}
+	if err := s.updateConfig(func(config *common.WorkerConfig) {
+		config.NumBackups = numBackups
+	}); err != nil {
 		log.Error("Failed to update config file.", zap.Error(err))
 	}
 	// now check additional primaries
-	if len(worker.Primaries) > 1 && s.origMode != MODE_PRIMARY {
+	if len(worker.Primaries) > 1 && s.origMode.Load() != MODE_PRIMARY {
 		// get the second primary, which should be the primary to migrate to
 		log.Info("Another primary detected, stepping down...")
 		var name string
@@ -534,7 +735,7 @@ func (s *WorkerServer) doMigration() error {
 	err := common.ZkGet(s.conn, p, &migration)
 	if err == zk.ErrNoNode {
 		log.Info("Migration complete, nothing to do.")
-		s.SlotTableVersion.Inc()
+		s.IncSlotTableVersion()
 		return nil
 	} else if err != nil {
 		return err
@@ -569,7 +770,7 @@ func (s *WorkerServer) doMigration() error {
 
 				// create mask & register replication strategy
 				mask := func(key string) bool {
-					return migration.GetDestWorkerId(key) == dst
+					return migration.GetDestWorkerId(routingKey(key)) == dst
 				}
 				routine, err = NewSyncRoutine(s, fullName, mask, sync.NewCond(&sync.Mutex{}))
 				if err != nil {
@@ -621,7 +822,7 @@ func (s *WorkerServer) doMigration() error {
 		if v == s.SlotTableVersion.Load()+1 {
 			// ok
 			log.Info("Migration completed, changing local version number and close all migration connections.")
-			s.SlotTableVersion.Inc()
+			s.IncSlotTableVersion()
 			for _, routine := range s.migrations {
 				close(routine.StopCh)
 			}
@@ -637,33 +838,61 @@ func (s *WorkerServer) doMigration() error {
 }
 
 func (s *WorkerServer) AddBackupRoutine(nodeName string) error {
-	log := common.SugaredLog()
 	fullName := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)), nodeName)
 	routine, err := NewSyncRoutine(s, fullName, func(_ string) bool { return true }, s.backupCond)
 	if err != nil {
 		return err
 	}
+	s.startBackupRoutine(nodeName, routine)
+	return nil
+}
+
+// Back up to the backup listening on `addr`, for workers not coordinated through zookeeper.
+func (s *WorkerServer) AttachBackup(name string, addr string) error {
+	routine, err := newSyncRoutine(s, name, addr, func(_ string) bool { return true }, s.backupCond)
+	if err != nil {
+		return err
+	}
+	s.startBackupRoutine(name, routine)
+	return nil
+}
+
+// Register a backup routine, then bring the backup up to date and keep it in sync,
+// starting over whenever syncing is interrupted.
+func (s *WorkerServer) startBackupRoutine(nodeName string, routine *SyncRoutine) {
+	log := common.SugaredLog()
+	routine.wal = s.wal
+	routine.snapshot = s.snapshot
 	s.backupLock.Lock()
 	s.backups[nodeName] = routine
 	s.backupLock.Unlock()
+	go s.lagRoutine(routine)
 	go func() {
-		log.Infof("Backing up with %s", fullName)
-		for {
-			if err := routine.Prepare(); err == nil {
-				break
+		log.Infof("Backing up with %s", routine.name)
+		for started := false; ; started = true {
+			for {
+				if err := routine.Prepare(); err == nil {
+					break
+				}
+				log.Infof("Backing up with %s failed, retrying in 2 seconds...", nodeName)
+				select {
+				case <-time.After(2 * time.Second):
+				case <-routine.StopCh:
+					log.Infof("Stopping...")
+					return
+				}
 			}
-			if len(routine.StopCh) > 0 {
-				_ = <-routine.StopCh
-				log.Infof("Stopping...")
-				break
+			routine.Syncing = true
+			if !started {
+				go s.antiEntropyRoutine(routine)
 			}
-			log.Infof("Backing up with %s failed, retrying in 2 seconds...", nodeName)
-			time.Sleep(2 * time.Second)
+			routine.Sync()
+			if routine.stopped() || s.deposed.Load() {
+				return
+			}
+			log.Infof("Syncing with %s interrupted, preparing again...", nodeName)
 		}
-		routine.Syncing = true
-		routine.Sync()
 	}()
-	return nil
 }
 
 func (s *WorkerServer) RemoveBackupRoutine(backupNodeName string) error {
@@ -675,5 +904,9 @@ func (s *WorkerServer) RemoveBackupRoutine(backupNodeName string) error {
 	s.backupLock.Lock()
 	delete(s.backups, backupNodeName)
 	s.backupLock.Unlock()
+	// writes waiting for it go on without it
+	s.backupCond.L.Lock()
+	s.backupCond.Broadcast()
+	s.backupCond.L.Unlock()
 	return nil
 }
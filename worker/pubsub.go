@@ -0,0 +1,213 @@
+package worker
+
+// publish/subscribe on channels, hosted by the worker owning the slot of the channel
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/zap"
+)
+
+const (
+	// retained messages are kept as $pubsub/<channel>\x00<id in hex>
+	PUBSUB_KEY_PREFIX = INTERNAL_KEY_PREFIX + "pubsub/"
+	// messages buffered for each subscriber, subscribers falling further behind are disconnected
+	PUBSUB_BUFFER_SIZE = 256
+)
+
+type subscriber struct {
+	ch chan *pb.Message
+	// closed once the subscriber is dropped for falling behind
+	dropped chan struct{}
+}
+
+type channelState struct {
+	// held while publishing, so that messages are retained & delivered in the order of their ids
+	lock   sync.Mutex
+	lastId uint64
+	// ids of retained messages in order, loaded from the store once per slot table version & epoch
+	retained    []uint64
+	loaded      bool
+	slotVersion uint32
+	epoch       uint64
+	subscribers map[*subscriber]bool
+}
+
+type pubsub struct {
+	lock     sync.Mutex
+	channels map[string]*channelState
+}
+
+func newPubsub() *pubsub {
+	return &pubsub{channels: make(map[string]*channelState)}
+}
+
+func (p *pubsub) channel(name string) *channelState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	st, ok := p.channels[name]
+	if !ok {
+		st = &channelState{subscribers: make(map[*subscriber]bool)}
+		p.channels[name] = st
+	}
+	return st
+}
+
+func validChannel(channel string) bool {
+	return channel != "" && !strings.ContainsRune(channel, 0)
+}
+
+func messageKeyPrefix(channel string) string {
+	return PUBSUB_KEY_PREFIX + channel + "\x00"
+}
+
+func messageKey(channel string, id uint64) string {
+	// fixed width, so that keys are ordered by id
+	return fmt.Sprintf("%s%016x", messageKeyPrefix(channel), id)
+}
+
+// Get retained messages of a channel in order.
+// Scanning goes through every key of the store, so the prefix is scanned in one go.
+func (s *WorkerServer) retainedMessages(channel string) []*pb.Message {
+	prefix := messageKeyPrefix(channel)
+	var ret []*pb.Message
+	for _, e := range s.kv.Scan(prefix, "", math.MaxInt32) {
+		id, err := strconv.ParseUint(strings.TrimPrefix(e.Key, prefix), 16, 64)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, &pb.Message{Status: pb.Status_OK, Channel: channel, Id: id, Payload: *e.Value})
+	}
+	return ret
+}
+
+func (s *WorkerServer) Publish(_ context.Context, req *pb.PublishRequest) (*pb.PublishResponse, error) {
+	version := s.SlotTableVersion.Load()
+	if req.SlotVersion != version {
+		return &pb.PublishResponse{Status: pb.Status_EINVVERSION}, nil
+	}
+	if st := s.writeStatus(); st != pb.Status_OK {
+		return &pb.PublishResponse{Status: st}, nil
+	}
+	if !validChannel(req.Channel) {
+		return &pb.PublishResponse{Status: pb.Status_EFAILED}, nil
+	}
+	st := s.pubsub.channel(req.Channel)
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	// continue from retained messages, which could have been written by a previous owner or primary
+	if epoch := s.epoch.Load(); !st.loaded || st.slotVersion != version || st.epoch != epoch {
+		st.retained = st.retained[:0]
+		for _, m := range s.retainedMessages(req.Channel) {
+			st.retained = append(st.retained, m.Id)
+		}
+		if n := len(st.retained); n > 0 && st.retained[n-1] > st.lastId {
+			st.lastId = st.retained[n-1]
+		}
+		st.loaded, st.slotVersion, st.epoch = true, version, epoch
+	}
+	msg := &pb.Message{Status: pb.Status_OK, Channel: req.Channel, Id: st.lastId + 1, Payload: req.Payload}
+	if req.Retain > 0 {
+		if _, err := s.put(messageKey(req.Channel, msg.Id), req.Payload); err == EDEPOSED {
+			return &pb.PublishResponse{Status: pb.Status_EINVSERVER}, nil
+		} else if err != nil {
+			common.Log().Error("Failed to retain message.", zap.String("channel", req.Channel), zap.Error(err))
+			return &pb.PublishResponse{Status: pb.Status_EFAILED}, nil
+		}
+		st.retained = append(st.retained, msg.Id)
+		// those failing to be dropped are kept, and dropped on the next publish
+		kept := st.retained[:0]
+		for i, id := range st.retained {
+			if i < len(st.retained)-int(req.Retain) {
+				err := s.delete(messageKey(req.Channel, id))
+				if err == nil {
+					continue
+				}
+				common.Log().Warn("Failed to drop retained message.", zap.String("channel", req.Channel), zap.Error(err))
+			}
+			kept = append(kept, id)
+		}
+		st.retained = kept
+	}
+	st.lastId = msg.Id
+	var receivers uint32 = 0
+	for sub := range st.subscribers {
+		select {
+		case sub.ch <- msg:
+			receivers++
+		default:
+			delete(st.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+	return &pb.PublishResponse{Status: pb.Status_OK, Id: msg.Id, Receivers: receivers}, nil
+}
+
+func (s *WorkerServer) Subscribe(req *pb.SubscribeRequest, server pb.KVWorker_SubscribeServer) error {
+	if req.SlotVersion != s.SlotTableVersion.Load() {
+		return server.Send(&pb.Message{Status: pb.Status_EINVVERSION})
+	}
+	if s.mode.Load() != MODE_PRIMARY {
+		return server.Send(&pb.Message{Status: pb.Status_EINVSERVER})
+	}
+	if !validChannel(req.Channel) {
+		return server.Send(&pb.Message{Status: pb.Status_EFAILED})
+	}
+	st := s.pubsub.channel(req.Channel)
+	sub := &subscriber{ch: make(chan *pb.Message, PUBSUB_BUFFER_SIZE), dropped: make(chan struct{})}
+	// messages published from now on are delivered to sub, and those before are retained
+	st.lock.Lock()
+	st.subscribers[sub] = true
+	var backlog []*pb.Message
+	if req.Replay {
+		backlog = s.retainedMessages(req.Channel)
+	}
+	st.lock.Unlock()
+	defer func() {
+		st.lock.Lock()
+		delete(st.subscribers, sub)
+		st.lock.Unlock()
+	}()
+	for _, m := range backlog {
+		if m.Id <= req.AfterId {
+			continue
+		}
+		if err := server.Send(m); err != nil {
+			return err
+		}
+	}
+	for {
+		// woken up on slot table & mode changes
+		notify := s.tail.wait()
+		if req.SlotVersion != s.SlotTableVersion.Load() {
+			return server.Send(&pb.Message{Status: pb.Status_EINVVERSION})
+		}
+		if s.mode.Load() != MODE_PRIMARY {
+			return server.Send(&pb.Message{Status: pb.Status_EINVSERVER})
+		}
+		select {
+		case m := <-sub.ch:
+			if err := server.Send(m); err != nil {
+				return err
+			}
+		case <-sub.dropped:
+			// deliver what is buffered before telling it
+			for len(sub.ch) > 0 {
+				if err := server.Send(<-sub.ch); err != nil {
+					return err
+				}
+			}
+			return server.Send(&pb.Message{Status: pb.Status_EFAILED})
+		case <-notify:
+		case <-server.Context().Done():
+			return server.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package worker
+
+// replication through a Raft group, in place of backups synced by the primary & elections through zookeeper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/raft"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+)
+
+const (
+	// time given to a write to be committed by the group
+	RAFT_PROPOSE_TIMEOUT = 5 * time.Second
+	// interval of checking whether this replica is in sync with the leader, for bounded staleness reads
+	RAFT_SYNC_CHECK_INTERVAL = 100 * time.Millisecond
+)
+
+var ERAFT = errors.New("replicas of raft groups only take writes through the group")
+
+// Writes are applied once committed, at versions equal to the indices of their entries,
+// so the version of the store is also the index of the last entry applied.
+type raftMachine WorkerServer
+
+func (m *raftMachine) Apply(index uint64, data []byte) (interface{}, error) {
+	s := (*WorkerServer)(m)
+	var entry pb.BackupEntry
+	if err := proto.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	// entries applying nothing to the store take versions too
+	if s.kv.GetVersion() < index-1 {
+		if err := s.kv.SetVersion(index - 1); err != nil {
+			return nil, err
+		}
+	}
+	var version uint64
+	var err error
+	switch {
+	case entry.Op == pb.Operation_PUT && entry.Compare:
+		version, err = s.kv.CompareAndPut(entry.Key, entry.Value, entry.Version)
+	case entry.Op == pb.Operation_PUT:
+		version, err = s.kv.Put(entry.Key, entry.Value, 0)
+	case entry.Op == pb.Operation_DELETE:
+		version, err = s.kv.Delete(entry.Key, 0)
+	default:
+		err = errors.New("invalid operation")
+	}
+	if err != nil {
+		// failed compare-and-puts take their versions all the same
+		if err := s.kv.SetVersion(index); err != nil {
+			return nil, err
+		}
+	} else if version != index {
+		common.SugaredLog().Errorf("Version number mismatch, expecting %x, found %x.", index, version)
+	}
+	s.kv.Flush()
+	s.versionCond.L.Lock()
+	s.version = index
+	s.versionCond.L.Unlock()
+	s.versionCond.Broadcast()
+	if err == nil {
+		s.tail.publish(&pb.BackupEntry{Op: entry.Op, Key: entry.Key, Value: entry.Value, Version: version})
+	}
+	return version, err
+}
+
+func (m *raftMachine) Applied() uint64 {
+	return m.kv.GetVersion()
+}
+
+func (m *raftMachine) LeaderChanged(_ bool) {
+	// the mode is changed by raftRoutine, since it has to go through zookeeper
+	select {
+	case m.raftCh <- struct{}{}:
+	default:
+	}
+}
+
+// Replicate writes of this worker through Raft group member of `config`, which is given its store as state machine.
+// It has to be started before serving, with a store that has only been written by the group.
+// The node is returned for serving messages of the group, and has to be run to tick.
+func (s *WorkerServer) StartRaft(config raft.Config, transport raft.Transport) (*raft.Node, error) {
+	config.Group = s.Id
+	s.raftCh = make(chan struct{}, 1)
+	node, err := raft.NewNode(config, (*raftMachine)(s), transport)
+	if err != nil {
+		return nil, err
+	}
+	s.raft = node
+	go s.raftRoutine(node)
+	return node, nil
+}
+
+// Get the Raft group member of this worker, nil if it replicates to backups.
+func (s *WorkerServer) RaftNode() *raft.Node {
+	return s.raft
+}
+
+// Follow the leadership of the group: its leader serves as the primary, and the rest as backups,
+// re-registered in zookeeper for clients to find. Goes on until the node is stopped.
+func (s *WorkerServer) raftRoutine(node *raft.Node) {
+	log := common.SugaredLog()
+	ticker := time.NewTicker(RAFT_SYNC_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.raftCh:
+		case <-ticker.C:
+		case <-node.Stopped():
+			return
+		}
+		status := node.Status()
+		leader := status.State == raft.STATE_LEADER
+		if status.State == raft.STATE_FOLLOWER && status.Lead != 0 && status.Applied >= status.Commit {
+			s.syncedAt.Store(time.Now().UnixNano())
+		}
+		mode := MODE_BACKUP
+		if leader {
+			mode = MODE_PRIMARY
+		}
+		if s.mode.Load() == mode {
+			continue
+		}
+		log.Infof("Raft member %d is now %s of term %d, serving as %s.", status.Id, status.State, status.Term, mode)
+		if s.conn == nil {
+			// not coordinated through zookeeper, e.g. in-process clusters
+			s.mode.Store(mode)
+		} else if err := s.transformTo(mode); err != nil {
+			log.Error("Failed to follow raft leadership, retrying.", zap.Error(err))
+			continue
+		}
+		s.origMode.Store(mode)
+	}
+}
+
+// Replicate a write through the group as its leader, returning the version it is applied at once committed.
+// The entry carries the command only, versions are taken as it is applied.
+func (s *WorkerServer) proposeEntry(entry *pb.BackupEntry) (uint64, error) {
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	p, err := s.raft.Propose(data)
+	if err != nil {
+		// no longer the leader, clients should go look for it
+		return 0, EDEPOSED
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), RAFT_PROPOSE_TIMEOUT)
+	defer cancel()
+	result, err := p.Wait(ctx)
+	if err == raft.EDROPPED || err == raft.ESTOPPED || err == context.DeadlineExceeded {
+		return 0, EDEPOSED
+	} else if err != nil {
+		return 0, err
+	}
+	return result.(uint64), nil
+}
@@ -0,0 +1,73 @@
+package worker
+
+// read-only mode of primaries promoted with fewer backups than the worker had
+
+import (
+	"context"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+)
+
+// Refuse writes until `backups` backups are in sync, or as many as configured.
+func (s *WorkerServer) EnterReadOnly(backups int) {
+	s.readOnlyQuorum.Store(int32(backups))
+	s.readOnly.Store(true)
+}
+
+// Get the number of backups in sync, i.e. prepared and having got everything, and how many are required.
+func (s *WorkerServer) readOnlyProgress() (int, int) {
+	synced := 0
+	for _, routine := range s.backupRoutines() {
+		if routine.learner || !routine.prepareBegin.Load() || routine.overflown.Load() {
+			continue
+		}
+		if _, versions, _ := s.backupLag(routine); versions == 0 {
+			synced++
+		}
+	}
+	quorum := s.getConfig().ReadOnlyQuorum
+	if quorum <= 0 {
+		quorum = int(s.readOnlyQuorum.Load())
+	}
+	return synced, quorum
+}
+
+// Accept writes again once enough backups are in sync.
+func (s *WorkerServer) checkReadOnly() {
+	if !s.readOnly.Load() {
+		return
+	}
+	synced, quorum := s.readOnlyProgress()
+	if synced >= quorum && s.readOnly.CAS(true, false) {
+		common.SugaredLog().Infof("%d of %d backups in sync, leaving read-only mode.", synced, quorum)
+	}
+}
+
+// Get the status of serving writes, OK if they could be served.
+func (s *WorkerServer) writeStatus() pb.Status {
+	if s.mode.Load() != MODE_PRIMARY {
+		return pb.Status_EINVSERVER
+	}
+	if s.readOnly.Load() {
+		return pb.Status_EREADONLY
+	}
+	return pb.Status_OK
+}
+
+// Accept writes again as an operator confirms, however many backups are in sync.
+func (s *WorkerServer) LeaveReadOnly(_ context.Context, _ *empty.Empty) (*pb.ReadOnlyResponse, error) {
+	if s.mode.Load() != MODE_PRIMARY {
+		return &pb.ReadOnlyResponse{Status: pb.Status_EINVSERVER}, nil
+	}
+	synced, quorum := s.readOnlyProgress()
+	if s.readOnly.CAS(true, false) {
+		common.SugaredLog().Warnf("Leaving read-only mode as confirmed, %d of %d backups in sync.", synced, quorum)
+	}
+	return &pb.ReadOnlyResponse{
+		Status:          pb.Status_OK,
+		SyncedBackups:   uint32(synced),
+		RequiredBackups: uint32(quorum),
+	}, nil
+}
@@ -0,0 +1,50 @@
+package worker_test
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnly_AfterElection(t *testing.T) {
+	conn := connectZk(t)
+	defer conn.Close()
+	const id = common.WorkerId(904)
+	defer cleanUpZkWorker(t, conn, id)
+	w, wConn, stop := startZkWorker(t, id, worker.MODE_PRIMARY)
+	defer stop()
+	// the worker had 2 backups, one of them is promoted while the other is down
+	configPath := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(id)), common.ZK_WORKER_CONFIG_NAME)
+	bin, err := json.Marshal(common.WorkerConfig{Weight: 1, NumBackups: 2})
+	assert.Nil(t, err)
+	_, err = conn.Set(configPath, bin, -1)
+	assert.Nil(t, err)
+	electionPath := path.Join(common.ZK_ELECTION_ROOT, strconv.Itoa(int(id)))
+	assert.Nil(t, common.EnsurePath(conn, electionPath))
+	_, err = conn.Create(path.Join(electionPath, worker.CandidateName(1, w.NodeName)), nil, 0, zk.WorldACL(zk.PermAll))
+	assert.Nil(t, err)
+	assert.Nil(t, w.CloseElection(electionPath))
+
+	put := func() pb.Status {
+		resp, err := pb.NewKVWorkerClient(wConn).Put(context.Background(),
+			&pb.KVPair{Key: "k", Value: "v", SlotVersion: w.SlotTableVersion.Load()})
+		assert.Nil(t, err)
+		return resp.Status
+	}
+	assert.Equal(t, pb.Status_EREADONLY, put())
+	// the one backup left is all it takes once it is back
+	_, _, stopBackup := startZkWorker(t, id, worker.MODE_BACKUP)
+	defer stopBackup()
+	assert.Eventually(t, func() bool {
+		return put() == pb.Status_OK
+	}, 5*time.Second, 50*time.Millisecond)
+}
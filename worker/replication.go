@@ -0,0 +1,220 @@
+package worker
+
+// replication modes of primaries, changeable at runtime through the config znode of the worker
+
+import (
+	"context"
+	"encoding/json"
+	"math/bits"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/samuel/go-zookeeper/zk"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+const (
+	// versions backups may fall behind in async mode if not configured
+	DEFAULT_MAX_LAG = 1024
+	// upper bound of the first latency bucket, each of the following ones doubles it
+	LATENCY_BUCKET_BASE = 64 * time.Microsecond
+	LATENCY_BUCKETS     = 18
+)
+
+var replicationModes = []string{common.REPLICATION_ASYNC, common.REPLICATION_SEMI_SYNC, common.REPLICATION_SYNC}
+
+// Latency histogram with exponential buckets, the last one catches everything above ~8s.
+type histogram struct {
+	counts [LATENCY_BUCKETS]atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := bits.Len64(uint64(d / LATENCY_BUCKET_BASE))
+	if i >= LATENCY_BUCKETS {
+		i = LATENCY_BUCKETS - 1
+	}
+	h.counts[i].Inc()
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) toProto(mode string) *pb.LatencyHistogram {
+	ret := pb.LatencyHistogram{Mode: mode, SumMicros: uint64(h.sum.Load() / int64(time.Microsecond))}
+	for i := range h.counts {
+		if i < LATENCY_BUCKETS-1 {
+			ret.Bounds = append(ret.Bounds, uint64((LATENCY_BUCKET_BASE<<i)/time.Microsecond))
+		}
+		ret.Counts = append(ret.Counts, h.counts[i].Load())
+	}
+	return &ret
+}
+
+func newReplicationLatency() map[string]*histogram {
+	ret := make(map[string]*histogram)
+	for _, mode := range replicationModes {
+		ret[mode] = &histogram{}
+	}
+	return ret
+}
+
+func (s *WorkerServer) getConfig() common.WorkerConfig {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.config
+}
+
+// Replace the local copy of worker config, which workers coordinated through zookeeper keep in sync with its znode.
+func (s *WorkerServer) SetConfig(config common.WorkerConfig) {
+	s.configLock.Lock()
+	s.config = config
+	s.configLock.Unlock()
+	// writes waiting for backups should check against the new mode
+	s.backupCond.L.Lock()
+	s.backupCond.Broadcast()
+	s.backupCond.L.Unlock()
+}
+
+// Get the replication mode in config, falling back to semi-sync for unknown ones.
+func replicationMode(config common.WorkerConfig) string {
+	switch config.Replication {
+	case common.REPLICATION_ASYNC, common.REPLICATION_SYNC:
+		return config.Replication
+	default:
+		return common.REPLICATION_SEMI_SYNC
+	}
+}
+
+func (s *WorkerServer) configPath() string {
+	return path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)), common.ZK_WORKER_CONFIG_NAME)
+}
+
+// Keep the local copy of worker config in sync with zookeeper, until WatchConfigStopChan is closed.
+func (s *WorkerServer) WatchConfig() {
+	log := common.SugaredLog()
+	for {
+		bin, _, eventChan, err := s.conn.GetW(s.configPath())
+		if err != nil {
+			log.Error("Failed to watch config.", zap.Error(err))
+			return
+		}
+		var config common.WorkerConfig
+		if err := json.Unmarshal(bin, &config); err != nil {
+			log.Error("Invalid config.", zap.Error(err))
+		} else {
+			if old := s.getConfig(); replicationMode(old) != replicationMode(config) {
+				log.Infof("Replication mode changed from %s to %s.", replicationMode(old), replicationMode(config))
+			}
+			s.SetConfig(config)
+		}
+		select {
+		case <-eventChan:
+		case <-s.WatchConfigStopChan:
+			return
+		}
+	}
+}
+
+// Change config in zookeeper with `update`, without overwriting changes made in between.
+func (s *WorkerServer) updateConfig(update func(config *common.WorkerConfig)) error {
+	for {
+		bin, stat, err := s.conn.Get(s.configPath())
+		if err != nil {
+			return err
+		}
+		var config common.WorkerConfig
+		if err := json.Unmarshal(bin, &config); err != nil {
+			return err
+		}
+		update(&config)
+		bin, err = json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		if _, err := s.conn.Set(s.configPath(), bin, stat.Version); err == nil {
+			s.SetConfig(config)
+			return nil
+		} else if err != zk.ErrBadVersion {
+			return err
+		}
+	}
+}
+
+// Check whether an entry of `version` is replicated as required by config.
+// `acked` backups out of `n` have got it, and the one furthest ahead is at `highest`.
+func isReplicated(config common.WorkerConfig, version uint64, n int, acked int, highest uint64) bool {
+	if n == 0 {
+		return true
+	}
+	switch replicationMode(config) {
+	case common.REPLICATION_ASYNC:
+		lag := config.MaxLag
+		if lag == 0 {
+			lag = DEFAULT_MAX_LAG
+		}
+		return highest+lag >= version
+	case common.REPLICATION_SYNC:
+		return acked >= n
+	default:
+		k := config.Acks
+		if k < 1 {
+			k = 1
+		} else if k > n {
+			k = n
+		}
+		return acked >= k
+	}
+}
+
+// Wait until backups have got the entry of `version` as the replication mode requires,
+// or this primary is deposed. Ejected backups & learners are not waited for. Returns the mode waited with.
+func (s *WorkerServer) waitBackups(version uint64) string {
+	s.backupCond.L.Lock()
+	defer s.backupCond.L.Unlock()
+	for {
+		// mode could change while waiting
+		config := s.getConfig()
+		n, acked, highest := 0, 0, uint64(0)
+		s.backupLock.RLock()
+		for _, routine := range s.backups {
+			if routine.ejected.Load() || routine.learner {
+				continue
+			}
+			n++
+			if routine.Version >= version {
+				acked++
+			}
+			if routine.Version > highest {
+				highest = routine.Version
+			}
+		}
+		s.backupLock.RUnlock()
+		if s.deposed.Load() || isReplicated(config, version, n, acked, highest) {
+			return replicationMode(config)
+		}
+		s.backupCond.Wait()
+	}
+}
+
+func (s *WorkerServer) ReplicationStats(_ context.Context, _ *empty.Empty) (*pb.ReplicationStatsResponse, error) {
+	if s.mode.Load() != MODE_PRIMARY {
+		return &pb.ReplicationStatsResponse{Status: pb.Status_EINVSERVER}, nil
+	}
+	resp := pb.ReplicationStatsResponse{
+		Status:            pb.Status_OK,
+		Mode:              replicationMode(s.getConfig()),
+		AntiEntropyRounds: s.antiEntropy.rounds.Load(),
+		DivergedRanges:    s.antiEntropy.diverged.Load(),
+		RepairedKeys:      s.antiEntropy.repaired.Load(),
+		Backups:           s.backupLags(),
+		ReadOnly:          s.readOnly.Load(),
+	}
+	for _, mode := range replicationModes {
+		resp.Histograms = append(resp.Histograms, s.replLatency[mode].toProto(mode))
+	}
+	return &resp, nil
+}
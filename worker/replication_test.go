@@ -0,0 +1,99 @@
+package worker_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	"github.com/eyeKill/KV/localcluster"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestIsReplicated(t *testing.T) {
+	semiSyncConfig := common.WorkerConfig{Replication: common.REPLICATION_SEMI_SYNC}
+	syncConfig := common.WorkerConfig{Replication: common.REPLICATION_SYNC}
+	asyncConfig := common.WorkerConfig{Replication: common.REPLICATION_ASYNC, MaxLag: 10}
+	cases := []struct {
+		name    string
+		config  common.WorkerConfig
+		version uint64
+		n       int
+		acked   int
+		highest uint64
+		want    bool
+	}{
+		{"no backups, semi-sync", semiSyncConfig, 5, 0, 0, 0, true},
+		{"no backups, sync", syncConfig, 5, 0, 0, 0, true},
+		{"no backups, async", asyncConfig, 100, 0, 0, 0, true},
+		{"semi-sync, one of two", semiSyncConfig, 5, 2, 1, 5, true},
+		{"semi-sync, none", semiSyncConfig, 5, 2, 0, 4, false},
+		{"semi-sync, unknown mode", common.WorkerConfig{Replication: "nope"}, 5, 2, 0, 4, false},
+		{"semi-sync, two acks of three", common.WorkerConfig{Acks: 2}, 5, 3, 1, 5, false},
+		{"semi-sync, acks clamped to n", common.WorkerConfig{Acks: 5}, 5, 2, 2, 5, true},
+		{"semi-sync, acks clamped but short", common.WorkerConfig{Acks: 5}, 5, 2, 1, 5, false},
+		{"sync, all", syncConfig, 5, 2, 2, 5, true},
+		{"sync, one missing", syncConfig, 5, 2, 1, 5, false},
+		{"async, within lag", asyncConfig, 15, 1, 0, 5, true},
+		{"async, beyond lag", asyncConfig, 16, 1, 0, 5, false},
+		{"async, default lag", common.WorkerConfig{Replication: common.REPLICATION_ASYNC}, worker.DEFAULT_MAX_LAG + 5, 1, 0, 5, true},
+		{"async, beyond default lag", common.WorkerConfig{Replication: common.REPLICATION_ASYNC}, worker.DEFAULT_MAX_LAG + 6, 1, 0, 5, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, worker.IsReplicated(c.config, c.version, c.n, c.acked, c.highest), c.name)
+	}
+}
+
+func TestReplication_ModeChange(t *testing.T) {
+	c, err := localcluster.StartWithBackups(1, 0, func(w *worker.WorkerServer) {
+		w.SetConfig(common.WorkerConfig{Replication: common.REPLICATION_SYNC})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	b := &slowBackup{cond: sync.NewCond(&sync.Mutex{})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterKVBackupServer(s, b)
+	go s.Serve(l)
+	defer s.Stop()
+	defer b.pause(false)
+	assert.Nil(t, c.Workers[0].AttachBackup("slow", l.Addr().String()))
+	kv, err := client.New(client.DefaultOptions(c.MasterAddr))
+	assert.Nil(t, err)
+	defer kv.Close()
+	ctx := context.Background()
+	assert.Nil(t, kv.Put(ctx, "a", "1"))
+
+	// a write waiting for the paused backup in sync mode goes through once the mode turns async
+	b.pause(true)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Nil(t, kv.Put(ctx, "a", "2"))
+	}()
+	select {
+	case <-done:
+		t.Fatal("write did not wait for the backup in sync mode")
+	case <-time.After(200 * time.Millisecond):
+	}
+	c.Workers[0].SetConfig(common.WorkerConfig{Replication: common.REPLICATION_ASYNC})
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("write still waiting after switching to async mode")
+	}
+	if stats, err := kv.ReplicationStats(ctx, 1); assert.Nil(t, err) {
+		assert.Equal(t, common.REPLICATION_ASYNC, stats.Mode)
+	}
+}
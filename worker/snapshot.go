@@ -0,0 +1,243 @@
+package worker
+
+// re-seeding backups with a snapshot of the primary, sent in resumable chunks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// snapshot retained by the primary, and one partly received by a backup, named after their versions
+	SNAPSHOT_FILENAME_PATTERN      = "snapshot-%x.json"
+	SNAPSHOT_PART_FILENAME_PATTERN = "snapshot-%x.part"
+	SNAPSHOT_FILENAME_GLOB         = "snapshot-*.json"
+	SNAPSHOT_PART_FILENAME_GLOB    = "snapshot-*.part"
+	SNAPSHOT_TMP_FILENAME          = "snapshot.tmp"
+	SNAPSHOT_CHUNK_SIZE            = 256 * 1024
+)
+
+// Get a snapshot to send to backups. The retained one is reused if it is of `version`,
+// so an install interrupted could resume, otherwise a new one replaces it.
+// Returns the snapshot file and its version.
+func (s *WorkerServer) snapshot(version uint64) (string, uint64, error) {
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+	if version > 0 {
+		file := path.Join(s.FilePath, fmt.Sprintf(SNAPSHOT_FILENAME_PATTERN, version))
+		if _, err := os.Stat(file); err == nil {
+			return file, version, nil
+		}
+	}
+	old, err := filepath.Glob(path.Join(s.FilePath, SNAPSHOT_FILENAME_GLOB))
+	if err != nil {
+		return "", 0, err
+	}
+	tmp := path.Join(s.FilePath, SNAPSHOT_TMP_FILENAME)
+	version, err = s.kv.Snapshot(tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	file := path.Join(s.FilePath, fmt.Sprintf(SNAPSHOT_FILENAME_PATTERN, version))
+	if err := os.Rename(tmp, file); err != nil {
+		return "", 0, err
+	}
+	for _, f := range old {
+		if f != file {
+			_ = os.Remove(f)
+		}
+	}
+	return file, version, nil
+}
+
+// Find the snapshot partly received, returning its file, version & size, or an empty file name if there is none.
+func (s *WorkerServer) snapshotPart() (string, uint64, uint64) {
+	parts, err := filepath.Glob(path.Join(s.FilePath, SNAPSHOT_PART_FILENAME_GLOB))
+	if err != nil || len(parts) == 0 {
+		return "", 0, 0
+	}
+	var version uint64
+	if _, err := fmt.Sscanf(path.Base(parts[0]), SNAPSHOT_PART_FILENAME_PATTERN, &version); err != nil {
+		return "", 0, 0
+	}
+	info, err := os.Stat(parts[0])
+	if err != nil {
+		return "", 0, 0
+	}
+	return parts[0], version, uint64(info.Size())
+}
+
+// Receive a snapshot from the primary in chunks, and install it once all of them are received.
+// What is received is kept until then, so the primary could resume from there if interrupted.
+func (s *WorkerServer) InstallSnapshot(server pb.KVBackup_InstallSnapshotServer) error {
+	log := common.SugaredLog()
+	remoteId, err := remoteWorkerId(server.Context())
+	if err != nil {
+		return err
+	}
+	if remoteId != s.Id || !s.isReplica() {
+		return errors.New("worker mode invalid")
+	}
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+	part, version, offset := s.snapshotPart()
+	header := metadata.Pairs(HEADER_SNAPSHOT_VERSION, strconv.FormatUint(version, 16),
+		HEADER_SNAPSHOT_OFFSET, strconv.FormatUint(offset, 16))
+	if err := server.SendHeader(header); err != nil {
+		return err
+	}
+	var f *os.File
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+		}
+	}()
+	for {
+		chunk, err := server.Recv()
+		if err == io.EOF {
+			log.Warnf("Snapshot of version %x interrupted at %d bytes.", version, offset)
+			return server.SendAndClose(&pb.BackupReply{Status: pb.Status_EFAILED, Version: version})
+		} else if err != nil {
+			return err
+		}
+		if !s.checkEpoch(chunk.Epoch) {
+			log.Warnf("Rejecting snapshot from a primary of epoch %d.", chunk.Epoch)
+			return server.SendAndClose(&pb.BackupReply{Status: pb.Status_EINVEPOCH, Version: version})
+		}
+		if f == nil {
+			if chunk.Version != version || chunk.Offset == 0 {
+				// another snapshot, what we have got is of no use
+				if part != "" {
+					_ = os.Remove(part)
+				}
+				part, version, offset = path.Join(s.FilePath, fmt.Sprintf(SNAPSHOT_PART_FILENAME_PATTERN, chunk.Version)), chunk.Version, 0
+				f, err = os.OpenFile(part, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+			} else {
+				f, err = os.OpenFile(part, os.O_WRONLY|os.O_APPEND, 0644)
+			}
+			if err != nil {
+				log.Error("Failed to open snapshot file.", zap.Error(err))
+				return server.SendAndClose(&pb.BackupReply{Status: pb.Status_EFAILED, Version: version})
+			}
+		}
+		if chunk.Version != version || chunk.Offset != offset || crc32.ChecksumIEEE(chunk.Data) != chunk.Checksum {
+			log.Warnf("Invalid snapshot chunk of version %x at %d bytes, expecting %x at %d bytes.",
+				chunk.Version, chunk.Offset, version, offset)
+			return server.SendAndClose(&pb.BackupReply{Status: pb.Status_EFAILED, Version: version})
+		}
+		if _, err := f.Write(chunk.Data); err != nil {
+			log.Error("Failed to write snapshot file.", zap.Error(err))
+			return server.SendAndClose(&pb.BackupReply{Status: pb.Status_EFAILED, Version: version})
+		}
+		offset += uint64(len(chunk.Data))
+		if !chunk.Last {
+			continue
+		}
+		err = f.Sync()
+		if err == nil {
+			err = f.Close()
+		}
+		f = nil
+		if err == nil {
+			err = s.kv.InstallSnapshot(part, version)
+		}
+		if err != nil {
+			log.Error("Failed to install snapshot.", zap.Error(err))
+			return server.SendAndClose(&pb.BackupReply{Status: pb.Status_EFAILED, Version: version})
+		}
+		log.Infof("Installed snapshot of version %x, %d bytes.", version, offset)
+		s.versionCond.L.Lock()
+		s.version = version
+		s.versionCond.L.Unlock()
+		// history before the snapshot is unknown
+		s.tail.reset(version)
+		return server.SendAndClose(&pb.BackupReply{Status: pb.Status_OK, Version: version})
+	}
+}
+
+// Send a snapshot of the primary to the backup, resuming the one it has partly received if it is still retained.
+// Returns the version of the snapshot installed.
+func (s *SyncRoutine) installSnapshot(ctx context.Context) (uint64, error) {
+	log := common.Log()
+	stream, err := s.conn.InstallSnapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+	header, err := stream.Header()
+	if err != nil {
+		return 0, err
+	}
+	var partVersion, offset uint64
+	if vs, offsets := header.Get(HEADER_SNAPSHOT_VERSION), header.Get(HEADER_SNAPSHOT_OFFSET); len(vs) == 1 && len(offsets) == 1 {
+		partVersion, _ = strconv.ParseUint(vs[0], 16, 64)
+		offset, _ = strconv.ParseUint(offsets[0], 16, 64)
+	}
+	file, version, err := s.snapshot(partVersion)
+	if err != nil {
+		_ = stream.CloseSend()
+		return 0, err
+	}
+	if version != partVersion {
+		offset = 0
+	} else {
+		log.Sugar().Infof("Resuming snapshot of version %x for %s at %d bytes.", version, s.name, offset)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		_ = stream.CloseSend()
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		_ = stream.CloseSend()
+		return 0, err
+	}
+	buf := make([]byte, SNAPSHOT_CHUNK_SIZE)
+	for {
+		n, err := io.ReadFull(f, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			_ = stream.CloseSend()
+			return 0, err
+		}
+		chunk := pb.SnapshotChunk{
+			Version:  version,
+			Epoch:    s.epoch.Load(),
+			Offset:   offset,
+			Data:     buf[:n],
+			Checksum: crc32.ChecksumIEEE(buf[:n]),
+			Last:     last,
+		}
+		if err := stream.Send(&chunk); err != nil {
+			// the reply tells what went wrong
+			break
+		}
+		offset += uint64(n)
+		if last {
+			break
+		}
+	}
+	repl, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, err
+	}
+	if repl.Status == pb.Status_EINVEPOCH {
+		s.depose()
+		return 0, EDEPOSED
+	} else if repl.Status != pb.Status_OK {
+		return 0, fmt.Errorf("backup failed to install snapshot of version %x", version)
+	}
+	return repl.Version, nil
+}
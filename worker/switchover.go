@@ -0,0 +1,176 @@
+package worker
+
+// planned switchover, handing the primary role over to a backup without stopping anything
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// time given to the backup to catch up if not specified
+	DEFAULT_SWITCHOVER_TIMEOUT = 5 * time.Second
+	// interval of checking whether the backup has caught up
+	SWITCHOVER_POLL_INTERVAL = 5 * time.Millisecond
+)
+
+// Find the backup routine of node name or address `target`. Learners never take over.
+func (s *WorkerServer) findBackup(target string) (string, *SyncRoutine) {
+	for name, routine := range s.backupRoutines() {
+		if (name == target || routine.addr == target) && !routine.learner {
+			return name, routine
+		}
+	}
+	return "", nil
+}
+
+// Wait until the backup has got everything up to `version`, returning false if it has not by `deadline`.
+func (s *WorkerServer) waitCaughtUp(routine *SyncRoutine, version uint64, deadline time.Time) bool {
+	for {
+		routine.Condition.L.Lock()
+		acked := routine.Version
+		routine.Condition.L.Unlock()
+		if acked >= version {
+			return true
+		}
+		if s.deposed.Load() || time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(SWITCHOVER_POLL_INTERVAL)
+	}
+}
+
+// Hand the primary role over to a backup. Writes are paused until it has caught up and is promoted,
+// then this primary steps down to be a backup, and writes paused fail so that clients go to the new one.
+func (s *WorkerServer) Switchover(ctx context.Context, req *pb.SwitchoverRequest) (*pb.SwitchoverResponse, error) {
+	log := common.SugaredLog()
+	if s.mode.Load() != MODE_PRIMARY || s.deposed.Load() {
+		return &pb.SwitchoverResponse{Status: pb.Status_EINVSERVER}, nil
+	}
+	name, routine := s.findBackup(req.Target)
+	if routine == nil {
+		return &pb.SwitchoverResponse{Status: pb.Status_ENOENT}, nil
+	}
+	timeout := DEFAULT_SWITCHOVER_TIMEOUT
+	if req.TimeoutMs > 0 {
+		timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+	// writes wait for writeLock, and entries already taken are synced meanwhile
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	paused := time.Now()
+	if s.mode.Load() != MODE_PRIMARY || s.deposed.Load() {
+		return &pb.SwitchoverResponse{Status: pb.Status_EINVSERVER}, nil
+	}
+	version := s.kv.GetVersion()
+	log.Infof("Switching over to %s, writes paused at version %x.", name, version)
+	if !s.waitCaughtUp(routine, version, paused.Add(timeout)) {
+		log.Warnf("%s did not catch up in %v, switchover aborted.", name, timeout)
+		return &pb.SwitchoverResponse{Status: pb.Status_EFAILED, Version: version}, nil
+	}
+	// reads are not served either, since the backup could take writes once promoted
+	s.deposed.Store(true)
+	ctx = metadata.AppendToOutgoingContext(ctx, HEADER_CLIENT_WORKER_ID, strconv.Itoa(int(s.Id)))
+	repl, err := routine.conn.Promote(ctx, &pb.PromoteRequest{Version: version, Epoch: s.epoch.Load()})
+	if err == nil && repl.Status != pb.Status_OK || err != nil && !s.promotedElsewhere() {
+		log.Warn("Failed to promote backup, switchover aborted.", zap.String("backup", name), zap.Error(err))
+		s.deposed.Store(false)
+		return &pb.SwitchoverResponse{Status: pb.Status_EFAILED, Version: version}, nil
+	}
+	// it registers under a node name of its own once promoted, and with the reply lost,
+	// someone else could as well have been elected meanwhile
+	primary, addr := s.awaitRegisteredPrimary(paused.Add(timeout))
+	status := pb.Status_OK
+	if addr != routine.addr {
+		log.Warnf("%s is not the primary registered, %q is.", name, primary)
+		status = pb.Status_EFAILED
+	}
+	unavailable := time.Since(paused)
+	log.Infof("%s promoted at version %x, writes unavailable for %v, stepping down...", primary, version, unavailable)
+	// no longer the primary to come back to
+	s.origMode.Store(MODE_BACKUP)
+	if err := s.transformTo(MODE_BACKUP); err != nil {
+		log.Error("Failed to step down.", zap.Error(err))
+	}
+	return &pb.SwitchoverResponse{
+		Status:       status,
+		Primary:      primary,
+		Version:      version,
+		PausedMicros: uint64(unavailable / time.Microsecond),
+	}, nil
+}
+
+// Wait for another primary to be registered in zookeeper until `deadline`, returning its node name & address,
+// both empty if there is none by then.
+func (s *WorkerServer) awaitRegisteredPrimary(deadline time.Time) (string, string) {
+	p := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)))
+	for {
+		// see what the new primary has written through another server
+		if _, err := s.conn.Sync(p); err != nil {
+			common.Log().Warn("Failed to sync worker path.", zap.Error(err))
+		} else if worker, err := common.GetAndWatchWorker(s.conn, s.Id); err != nil {
+			common.Log().Warn("Failed to get worker.", zap.Error(err))
+		} else {
+			for name, node := range worker.Primaries {
+				if name != s.NodeName {
+					return name, fmt.Sprintf("%s:%d", node.Host.Hostname, node.Host.Port)
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", ""
+		}
+		time.Sleep(SWITCHOVER_POLL_INTERVAL)
+	}
+}
+
+// Check whether a newer primary has been elected, for when promoting a backup fails half way.
+func (s *WorkerServer) promotedElsewhere() bool {
+	if s.conn == nil {
+		return false
+	}
+	newer, err := s.loadEpoch()
+	// taken as promoted if it could not be told, so that there are never two primaries
+	return newer || err != nil
+}
+
+// Take over the primary role as the primary asks, once everything it has is applied.
+func (s *WorkerServer) Promote(ctx context.Context, req *pb.PromoteRequest) (*pb.BackupReply, error) {
+	log := common.SugaredLog()
+	remoteId, err := remoteWorkerId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if remoteId != s.Id || s.mode.Load() != MODE_BACKUP {
+		return nil, errors.New("worker mode invalid")
+	}
+	if !s.checkEpoch(req.Epoch) {
+		return &pb.BackupReply{Status: pb.Status_EINVEPOCH, Version: s.kv.GetVersion()}, nil
+	}
+	if version := s.kv.GetVersion(); version < req.Version {
+		log.Warnf("Refusing to be promoted at version %x, primary is at %x.", version, req.Version)
+		return &pb.BackupReply{Status: pb.Status_EFAILED, Version: version}, nil
+	}
+	if s.conn == nil {
+		log.Warn("Refusing to be promoted, not coordinated through zookeeper.")
+		return &pb.BackupReply{Status: pb.Status_EFAILED, Version: s.kv.GetVersion()}, nil
+	}
+	// the primary to come back to from now on
+	s.origMode.Store(MODE_PRIMARY)
+	if err := s.transformTo(MODE_PRIMARY); err != nil {
+		log.Error("Failed to be promoted.", zap.Error(err))
+		s.origMode.Store(MODE_BACKUP)
+		return &pb.BackupReply{Status: pb.Status_EFAILED, Version: s.kv.GetVersion()}, nil
+	}
+	log.Infof("Promoted at version %x as the primary asked.", req.Version)
+	return &pb.BackupReply{Status: pb.Status_OK, Version: s.kv.GetVersion()}, nil
+}
@@ -0,0 +1,82 @@
+package worker_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestSwitchover_Promoted(t *testing.T) {
+	conn := connectZk(t)
+	defer conn.Close()
+	const id = common.WorkerId(903)
+	defer cleanUpZkWorker(t, conn, id)
+	primary, primaryConn, stopPrimary := startZkWorker(t, id, worker.MODE_PRIMARY)
+	defer stopPrimary()
+	backup, backupConn, stopBackup := startZkWorker(t, id, worker.MODE_BACKUP)
+	defer stopBackup()
+	put := func(conn *grpc.ClientConn, key string) pb.Status {
+		resp, err := pb.NewKVWorkerClient(conn).Put(context.Background(),
+			&pb.KVPair{Key: key, Value: key, SlotVersion: primary.SlotTableVersion.Load()})
+		assert.Nil(t, err)
+		return resp.Status
+	}
+	assert.Equal(t, pb.Status_OK, put(primaryConn, "a"))
+
+	// writes keep coming during the switchover, those paused fail and go to the new primary
+	done := make(chan struct{})
+	written := make(chan []string)
+	go func() {
+		var keys []string
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				written <- keys
+				return
+			default:
+			}
+			key := fmt.Sprint("k", i)
+			if st := put(primaryConn, key); st == pb.Status_EINVSERVER {
+				assert.Eventually(t, func() bool {
+					return put(backupConn, key) == pb.Status_OK
+				}, 5*time.Second, 10*time.Millisecond)
+			} else {
+				assert.Equal(t, pb.Status_OK, st)
+			}
+			keys = append(keys, key)
+		}
+	}()
+	// until the primary has found the backup through zookeeper
+	var resp *pb.SwitchoverResponse
+	assert.Eventually(t, func() bool {
+		var err error
+		resp, err = pb.NewKVWorkerInternalClient(primaryConn).Switchover(context.Background(),
+			&pb.SwitchoverRequest{Target: backup.NodeName, TimeoutMs: 5000})
+		return assert.Nil(t, err) && resp.Status != pb.Status_ENOENT
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, pb.Status_OK, resp.Status)
+	// reported under the name it is registered as primary with
+	assert.True(t, strings.HasPrefix(resp.Primary, common.ZK_PRIMARY_WORKER_NAME))
+	assert.Equal(t, backup.NodeName, resp.Primary)
+	close(done)
+	keys := <-written
+
+	// the old primary has stepped down, and the new one has every write acknowledged
+	assert.True(t, strings.HasPrefix(primary.NodeName, common.ZK_BACKUP_WORKER_NAME))
+	assert.Equal(t, pb.Status_EINVSERVER, put(primaryConn, "b"))
+	assert.Equal(t, pb.Status_OK, put(backupConn, "b"))
+	for _, key := range append(keys, "a") {
+		resp, err := pb.NewKVWorkerClient(backupConn).Get(context.Background(),
+			&pb.Key{Key: key, SlotVersion: backup.SlotTableVersion.Load()})
+		assert.Nil(t, err)
+		assert.Equal(t, key, resp.Value)
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 type SyncRoutine struct {
 	name         string
+	addr         string
 	conn         pb.KVBackupClient
 	mask         func(string) bool
 	kv           KVStore
@@ -25,6 +26,31 @@ type SyncRoutine struct {
 	Syncing      bool
 	StopCh       chan struct{}
 	prepareBegin atomic.Bool
+	// epoch of the primary, entries are stamped with it
+	epoch *atomic.Uint64
+	// called once the remote turns out to know a newer primary
+	depose func()
+	// max number of entries in a batch, and of batches waiting for ack
+	batchSize int
+	window    int
+	// log of entries sent to backups, for backups to catch up from, nil for migrations.
+	// Entries are dispatched to routines & logged under backupLock.
+	wal        *entryTail
+	backupLock *sync.RWMutex
+	// entries missed by the backup, sent before anything else by Sync
+	backlog []*pb.BackupEntry
+	// get a snapshot for backups too far behind to catch up, nil for migrations
+	snapshot func(version uint64) (string, uint64, error)
+	// not waited for in semi-sync mode for falling too far behind, see lagRoutine
+	ejected   atomic.Bool
+	ejections atomic.Uint64
+	// a learner, never counted toward acks nor taking over as primary
+	learner bool
+	// entries are no longer handed over, Sync takes them from wal once it is done with those in EntryCh
+	overflown atomic.Bool
+	// wake up DoSync blocked handing entries over once the backup is ejected, and Sync once entries overflow
+	ejectCh  chan struct{}
+	resumeCh chan struct{}
 }
 
 func NewSyncRoutine(s *WorkerServer, name string, mask func(string) bool, c *sync.Cond) (*SyncRoutine, error) {
@@ -33,23 +59,41 @@ func NewSyncRoutine(s *WorkerServer, name string, mask func(string) bool, c *syn
 	if err := common.ZkGet(s.conn, name, &node); err != nil {
 		return nil, err
 	}
-	connString := fmt.Sprintf("%s:%d", node.Host.Hostname, node.Host.Port)
+	return newSyncRoutine(s, name, fmt.Sprintf("%s:%d", node.Host.Hostname, node.Host.Port), mask, c)
+}
+
+func newSyncRoutine(s *WorkerServer, name string, connString string, mask func(string) bool, c *sync.Cond) (*SyncRoutine, error) {
 	conn, err := common.ConnectGrpc(connString)
 	if err != nil {
 		return nil, err
 	}
+	batchSize, window := s.SyncBatchSize, s.SyncWindow
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if window < 1 {
+		window = 1
+	}
 	client := pb.NewKVBackupClient(conn)
 	return &SyncRoutine{
-		name:      name,
-		conn:      client,
-		mask:      mask,
-		kv:        s.kv,
-		id:        s.Id,
-		EntryCh:   make(chan *pb.BackupEntry),
-		Version:   0,
-		Condition: c,
-		Syncing:   false,
-		StopCh:    make(chan struct{}),
+		name:       name,
+		addr:       connString,
+		conn:       client,
+		mask:       mask,
+		kv:         s.kv,
+		id:         s.Id,
+		EntryCh:    make(chan *pb.BackupEntry, batchSize),
+		Version:    0,
+		Condition:  c,
+		Syncing:    false,
+		StopCh:     make(chan struct{}),
+		ejectCh:    make(chan struct{}, 1),
+		resumeCh:   make(chan struct{}, 1),
+		epoch:      &s.epoch,
+		depose:     s.depose,
+		batchSize:  batchSize,
+		window:     window,
+		backupLock: &s.backupLock,
 	}, nil
 }
 
@@ -62,37 +106,163 @@ func (s *SyncRoutine) GetMask() func(string) bool {
 	}
 }
 
-// do bulk transfer before the loss less sync process
-func (s *SyncRoutine) Prepare() error {
+// Hand an entry over to Sync. Ejected backups are not waited for, entries they have no room for
+// are dropped from then on, and taken from wal by Sync later.
+func (s *SyncRoutine) offer(ent *pb.BackupEntry) {
+	if s.overflown.Load() {
+		return
+	}
+	if !s.ejected.Load() {
+		select {
+		case s.EntryCh <- ent:
+			return
+		case <-s.ejectCh:
+		case <-s.StopCh:
+			return
+		}
+	}
+	select {
+	case s.EntryCh <- ent:
+	default:
+		s.overflown.Store(true)
+		select {
+		case s.resumeCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *SyncRoutine) eject() {
+	s.ejected.Store(true)
+	s.ejections.Inc()
+	select {
+	case s.ejectCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *SyncRoutine) rejoin() {
+	s.ejected.Store(false)
+	select {
+	case <-s.ejectCh:
+	default:
+	}
+}
+
+func (s *SyncRoutine) stopped() bool {
+	select {
+	case <-s.StopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Start taking entries from DoSync, should be called with backupLock held.
+// Entries left from the last sync are dropped, since they are retained in wal or sent in the transfer.
+func (s *SyncRoutine) beginLocked() {
+	for len(s.EntryCh) > 0 {
+		<-s.EntryCh
+	}
+	s.overflown.Store(false)
+	s.prepareBegin.Store(true)
+}
+
+// Record that the backup has got everything up to `version`.
+func (s *SyncRoutine) acknowledge(version uint64) {
+	s.Condition.L.Lock()
+	if s.Version < version {
+		s.Version = version
+		s.Condition.Broadcast()
+	}
+	s.Condition.L.Unlock()
+}
+
+// Open a bulk transfer stream, returning the version the backup starts from.
+func (s *SyncRoutine) openTransfer(ctx context.Context) (pb.KVBackup_TransferClient, uint64, error) {
 	log := common.Log()
-	ctx := context.Background()
-	strWorkerId := strconv.Itoa(int(s.id))
-	ctx = metadata.AppendToOutgoingContext(ctx, HEADER_CLIENT_WORKER_ID, strWorkerId)
 	client, err := s.conn.Transfer(ctx)
 	if err != nil {
 		log.Error("Failed to get stream.", zap.Error(err))
-		return err
+		return nil, 0, err
 	}
 	// retrieve starting version number from server-side context(header)
 	header, err := client.Header()
 	if err != nil {
 		log.Error("Failed to get header.", zap.Error(err))
-		return err
+		return nil, 0, err
 	}
 	versions := header.Get(HEADER_VERSION_NUMBER)
 	if len(versions) != 1 {
-		return errors.New("server did not send exactly one starting version number")
+		return nil, 0, errors.New("server did not send exactly one starting version number")
 	}
 	version, err := strconv.ParseUint(versions[0], 16, 64)
 	if err != nil {
-		return errors.New("invalid starting version number")
+		return nil, 0, errors.New("invalid starting version number")
+	}
+	return client, version, nil
+}
+
+// Check whether a backup at `version` should be re-seeded with a snapshot,
+// which is when it is fresh, too far behind or ahead of us.
+func (s *SyncRoutine) needsSnapshot(version uint64) bool {
+	if s.snapshot == nil {
+		return false
+	}
+	current := s.kv.GetVersion()
+	return version == 0 && current > 0 || version+BACKUP_LOG_SIZE < current || version > current
+}
+
+// do bulk transfer before the loss less sync process
+func (s *SyncRoutine) Prepare() error {
+	log := common.Log()
+	ctx := context.Background()
+	strWorkerId := strconv.Itoa(int(s.id))
+	ctx = metadata.AppendToOutgoingContext(ctx, HEADER_CLIENT_WORKER_ID, strWorkerId)
+	client, version, err := s.openTransfer(ctx)
+	if err != nil {
+		return err
+	}
+	if s.needsSnapshot(version) {
+		// close the transfer with nothing in it, and start over from a snapshot
+		if _, err := client.CloseAndRecv(); err != nil {
+			log.Error("Close & recv got error.", zap.Error(err))
+			return err
+		}
+		v, err := s.installSnapshot(ctx)
+		if err != nil {
+			log.Warn("Failed to install snapshot.", zap.String("backup", s.name), zap.Error(err))
+			return err
+		}
+		log.Sugar().Infof("%s installed snapshot of version %x, was at %x.", s.name, v, version)
+		if client, version, err = s.openTransfer(ctx); err != nil {
+			return err
+		}
+	}
+	// a backup within the retained log only needs what it missed, in order
+	if backlog, ok := s.catchUp(version); ok {
+		repl, err := client.CloseAndRecv()
+		if err != nil {
+			log.Error("Close & recv got error.", zap.Error(err))
+			return err
+		}
+		if repl.Status != pb.Status_OK {
+			return errors.New("backup failed to start catching up")
+		}
+		log.Sugar().Infof("%s catching up from version %x with %d entries.", s.name, version, len(backlog))
+		s.backlog = backlog
+		s.acknowledge(version)
+		return nil
 	}
 	// register itself, begin extraction
-	s.prepareBegin.Store(true)
+	s.backupLock.Lock()
+	s.beginLocked()
+	s.backupLock.Unlock()
 	content := s.kv.Extract(s.mask, version)
 	for k, v := range content {
 		var ent pb.BackupEntry
 		ent.Version = v.Version
+		ent.Epoch = s.epoch.Load()
 		ent.Key = k
 		if v.Value == nil {
 			ent.Op = pb.Operation_DELETE
@@ -111,6 +281,10 @@ func (s *SyncRoutine) Prepare() error {
 	// ...and those entries during replication
 	for len(s.EntryCh) > 0 {
 		ent := <-s.EntryCh
+		if ent.Op == pb.Operation_GET {
+			// heartbeats are for syncing backups only
+			continue
+		}
 		if err := client.Send(ent); err != nil {
 			log.Warn("Connection interrupted.", zap.Error(err))
 			break
@@ -124,58 +298,163 @@ func (s *SyncRoutine) Prepare() error {
 		log.Error("Close & recv got error.", zap.Error(err))
 		return err
 	}
+	if repl.Status == pb.Status_EINVEPOCH {
+		s.depose()
+		return EDEPOSED
+	}
 	if repl.Version == version {
+		s.acknowledge(version)
 		return nil
 	} else {
 		return errors.New("version does not match")
 	}
 }
 
-// do loss less sync transfer
+// Get entries after `version` from the retained log, and start recording new entries from there on.
+// Returns false if some of them are no longer retained, or the remote is not a backup.
+func (s *SyncRoutine) catchUp(version uint64) ([]*pb.BackupEntry, bool) {
+	if s.wal == nil {
+		return nil, false
+	}
+	// entries are either logged before or sent to EntryCh after
+	s.backupLock.Lock()
+	defer s.backupLock.Unlock()
+	if version > s.kv.GetVersion() {
+		// ahead of us, it could only be a snapshot of something else
+		return nil, false
+	}
+	entries, _, err := s.wal.since(version)
+	if err != nil {
+		return nil, false
+	}
+	s.beginLocked()
+	return entries, true
+}
+
+// Get entries after `version` from wal once those handed over overflow, and take entries from DoSync again.
+func (s *SyncRoutine) refill(version uint64) ([]*pb.BackupEntry, error) {
+	s.backupLock.Lock()
+	defer s.backupLock.Unlock()
+	entries, _, err := s.wal.since(version)
+	if err != nil {
+		return nil, err
+	}
+	s.overflown.Store(false)
+	return entries, nil
+}
+
+// do loss less sync transfer, in batches of entries ready at the same time
 func (s *SyncRoutine) Sync() {
 	log := common.Log()
+	// nothing takes entries from now on, DoSync should not wait for it
+	defer func() {
+		s.overflown.Store(true)
+		select {
+		case s.ejectCh <- struct{}{}:
+		default:
+		}
+	}()
 	// start gRPC bi-directional stream
 	ctx := metadata.AppendToOutgoingContext(context.Background(), HEADER_CLIENT_WORKER_ID, strconv.Itoa(int(s.id)))
-	stream, err := s.conn.Sync(ctx)
+	stream, err := s.conn.SyncBatch(ctx)
 	if err != nil {
 		log.Error("Failed to open gRPC stream.", zap.Error(err))
 		return
 	}
+	defer stream.CloseSend()
 	log.Sugar().Infof("Sync routine for %s started", s.name)
-	stopChan := make(chan struct{})
-	// goroutine to receive reply
+	// a slot for each batch in flight, and their last versions in order
+	slots := make(chan struct{}, s.window)
+	var inflightLock sync.Mutex
+	var inflight []uint64
+	replyDone := make(chan struct{})
+	// goroutine to receive replies, which acknowledge every entry up to their version
 	go func() {
+		defer close(replyDone)
 		for {
-			select {
-			case <-stopChan:
+			reply, err := stream.Recv()
+			if err != nil {
+				log.Warn("Failed to retrieve reply.", zap.Error(err))
 				return
-			default:
-				reply, err := stream.Recv()
-				if err != nil {
-					log.Warn("Failed to retrieve reply.", zap.Error(err))
-					return
-				}
-				s.Condition.L.Lock()
-				if s.Version < reply.Version {
-					s.Version = reply.Version
-					s.Condition.Broadcast()
-					s.Condition.L.Unlock()
-				} else {
-					s.Condition.L.Unlock()
-				}
 			}
+			if reply.Status == pb.Status_EINVEPOCH {
+				log.Sugar().Warnf("%s knows a newer primary.", s.name)
+				s.depose()
+				return
+			}
+			s.acknowledge(reply.Version)
+			inflightLock.Lock()
+			for len(inflight) > 0 && inflight[0] <= reply.Version {
+				inflight = inflight[1:]
+				<-slots
+			}
+			inflightLock.Unlock()
 		}
 	}()
+	backlog := s.backlog
+	s.backlog = nil
+	// last version sent
+	s.Condition.L.Lock()
+	sent := s.Version
+	s.Condition.L.Unlock()
 	for {
-		select {
-		case ent := <-s.EntryCh:
-			if err := stream.Send(ent); err != nil {
-				log.Error("Failed to send backup entry.", zap.Error(err))
-				close(stopChan)
+		var batch pb.BackupBatch
+		if len(backlog) == 0 && s.overflown.Load() && len(s.EntryCh) == 0 {
+			// done with entries handed over, the rest are in wal unless it has been too long
+			var err error
+			if backlog, err = s.refill(sent); err != nil {
+				log.Warn("Backup is too far behind to catch up.", zap.String("backup", s.name), zap.Error(err))
 				return
 			}
-		case <-s.StopCh:
-			close(stopChan)
+		}
+		if len(backlog) > 0 {
+			n := len(backlog)
+			if n > s.batchSize {
+				n = s.batchSize
+			}
+			batch.Entries, backlog = backlog[:n], backlog[n:]
+		} else {
+			select {
+			case ent := <-s.EntryCh:
+				batch.Entries = append(batch.Entries, ent)
+			case <-s.resumeCh:
+				continue
+			case <-s.StopCh:
+				return
+			}
+			// take along whatever else is ready
+		collect:
+			for len(batch.Entries) < s.batchSize {
+				select {
+				case ent := <-s.EntryCh:
+					batch.Entries = append(batch.Entries, ent)
+				default:
+					break collect
+				}
+			}
+		}
+		// heartbeats are not acknowledged, so batches of them only do not wait for a slot
+		var last uint64 = 0
+		for _, ent := range batch.Entries {
+			if ent.Op != pb.Operation_GET {
+				last = ent.Version
+			}
+		}
+		if last > 0 {
+			select {
+			case slots <- struct{}{}:
+			case <-replyDone:
+				return
+			case <-s.StopCh:
+				return
+			}
+			inflightLock.Lock()
+			inflight = append(inflight, last)
+			inflightLock.Unlock()
+			sent = last
+		}
+		if err := stream.Send(&batch); err != nil {
+			log.Error("Failed to send backup entries.", zap.Error(err))
 			return
 		}
 	}
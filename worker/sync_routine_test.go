@@ -0,0 +1,386 @@
+package worker_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/client"
+	"github.com/eyeKill/KV/common"
+	"github.com/eyeKill/KV/localcluster"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func startReplicated(t testing.TB, backups int, batchSize int, window int) (*localcluster.Cluster, *client.Client) {
+	c, err := localcluster.StartWithBackups(1, backups, func(w *worker.WorkerServer) {
+		w.SyncBatchSize = batchSize
+		w.SyncWindow = window
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := client.DefaultOptions(c.MasterAddr)
+	kv, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, kv
+}
+
+func TestSyncRoutine_Batch(t *testing.T) {
+	c, kv := startReplicated(t, 2, 16, 4)
+	defer c.Stop()
+	defer kv.Close()
+	ctx := context.Background()
+
+	// concurrent writes are committed together, and arrive at backups in order
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				assert.Nil(t, kv.Put(ctx, strconv.Itoa(i), strconv.Itoa(j)))
+			}
+		}()
+	}
+	wg.Wait()
+	for _, b := range c.Backups[0] {
+		conn, err := grpc.Dial(fmt.Sprintf("%s:%d", b.Hostname, b.Port), grpc.WithInsecure())
+		assert.Nil(t, err)
+		backup := pb.NewKVWorkerClient(conn)
+		// semi-sync, so the other backup might be a bit behind
+		assert.Eventually(t, func() bool {
+			for i := 0; i < 8; i++ {
+				resp, err := backup.Get(ctx, &pb.Key{Key: strconv.Itoa(i), Consistency: pb.Consistency_ANY})
+				if err != nil || resp.Value != "49" {
+					return false
+				}
+			}
+			return true
+		}, time.Second, 10*time.Millisecond)
+		_ = conn.Close()
+	}
+}
+
+func TestSyncRoutine_CatchUp(t *testing.T) {
+	c, kv := startReplicated(t, 1, worker.DEFAULT_SYNC_BATCH_SIZE, worker.DEFAULT_SYNC_WINDOW)
+	defer c.Stop()
+	defer kv.Close()
+	ctx := context.Background()
+	primary, b := c.Workers[0], c.Backups[0][0]
+	addr := fmt.Sprintf("%s:%d", b.Hostname, b.Port)
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	backup := pb.NewKVWorkerClient(conn)
+	get := func(key string) string {
+		resp, err := backup.Get(ctx, &pb.Key{Key: key, Consistency: pb.Consistency_ANY})
+		if err != nil || resp.Status != pb.Status_OK {
+			return ""
+		}
+		return resp.Value
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, kv.Put(ctx, strconv.Itoa(i), "a"))
+	}
+	assert.Eventually(t, func() bool { return get("9") == "a" }, time.Second, 10*time.Millisecond)
+	// writes missed while the backup is away are replayed in order once it is back
+	assert.Nil(t, primary.RemoveBackupRoutine("backup-1-0"))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, kv.Put(ctx, strconv.Itoa(i), "b"))
+		assert.Nil(t, kv.Put(ctx, strconv.Itoa(i), "c"))
+	}
+	assert.Nil(t, kv.Delete(ctx, "0"))
+	assert.Equal(t, "a", get("5"))
+	assert.Nil(t, primary.AttachBackup("backup-1-0", addr))
+	assert.Eventually(t, func() bool { return get("9") == "c" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "", get("0"))
+	assert.Nil(t, kv.Put(ctx, "10", "d"))
+	assert.Eventually(t, func() bool { return get("10") == "d" }, time.Second, 10*time.Millisecond)
+}
+
+func TestSyncRoutine_Snapshot(t *testing.T) {
+	c, kv := startReplicated(t, 0, worker.DEFAULT_SYNC_BATCH_SIZE, worker.DEFAULT_SYNC_WINDOW)
+	defer c.Stop()
+	defer kv.Close()
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, kv.Put(ctx, strconv.Itoa(i), strings.Repeat("v", 4096)))
+	}
+	assert.Nil(t, kv.Delete(ctx, "0"))
+
+	// a fresh backup is seeded with a snapshot, and synced incrementally from there on
+	b, err := c.AddBackup(1)
+	if !assert.Nil(t, err) {
+		return
+	}
+	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", b.Hostname, b.Port), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	backup := pb.NewKVWorkerClient(conn)
+	get := func(key string) (pb.Status, string) {
+		resp, err := backup.Get(ctx, &pb.Key{Key: key, Consistency: pb.Consistency_ANY})
+		if err != nil {
+			return pb.Status_EFAILED, ""
+		}
+		return resp.Status, resp.Value
+	}
+	// polled in place, testify 1.4 panics if a condition outlives the tick, as gets do under -race
+	waitFor := func(cond func() bool) bool {
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if cond() {
+				return true
+			}
+		}
+		return false
+	}
+	assert.True(t, waitFor(func() bool {
+		status, value := get("99")
+		return status == pb.Status_OK && len(value) == 4096
+	}))
+	status, _ := get("0")
+	assert.Equal(t, pb.Status_ENOENT, status)
+	assert.Nil(t, kv.Put(ctx, "0", "a"))
+	assert.True(t, waitFor(func() bool {
+		status, value := get("0")
+		return status == pb.Status_OK && value == "a"
+	}))
+}
+
+func TestSyncRoutine_AntiEntropy(t *testing.T) {
+	c, err := localcluster.StartWithBackups(1, 1, func(w *worker.WorkerServer) {
+		w.AntiEntropyInterval = 20 * time.Millisecond
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Stop()
+	kv, err := client.New(client.DefaultOptions(c.MasterAddr))
+	assert.Nil(t, err)
+	defer kv.Close()
+	ctx := context.Background()
+	b := c.Backups[0][0]
+	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", b.Hostname, b.Port), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	backup := pb.NewKVWorkerClient(conn)
+	get := func(key string) (pb.Status, string) {
+		resp, err := backup.Get(ctx, &pb.Key{Key: key, Consistency: pb.Consistency_ANY})
+		if err != nil {
+			return pb.Status_EFAILED, ""
+		}
+		return resp.Status, resp.Value
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, kv.Put(ctx, strconv.Itoa(i), "a"))
+	}
+	assert.Eventually(t, func() bool {
+		_, value := get("9")
+		return value == "a"
+	}, time.Second, 10*time.Millisecond)
+	// a stray write takes the version of the next one, which the backup then skips
+	sctx := metadata.AppendToOutgoingContext(ctx, worker.HEADER_CLIENT_WORKER_ID, "1")
+	stream, err := pb.NewKVBackupClient(conn).Sync(sctx)
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "stray", Value: "x", Version: 11}))
+	_, err = stream.Recv()
+	assert.Nil(t, err)
+	assert.Nil(t, kv.Put(ctx, "0", "b"))
+	_, value := get("0")
+	assert.Equal(t, "a", value)
+
+	assert.Eventually(t, func() bool {
+		status, _ := get("stray")
+		_, value := get("0")
+		return status == pb.Status_ENOENT && value == "b"
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		stats, err := kv.ReplicationStats(ctx, 1)
+		return err == nil && stats.DivergedRanges >= 1 && stats.RepairedKeys == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+// A backup applying entries in order, which stops acknowledging them while paused.
+type slowBackup struct {
+	pb.UnimplementedKVBackupServer
+	cond    *sync.Cond
+	paused  bool
+	version uint64
+	// entries arriving out of order
+	gaps int
+}
+
+func (b *slowBackup) apply(ent *pb.BackupEntry) {
+	if ent.Op == pb.Operation_GET || ent.Version <= b.version {
+		return
+	}
+	if ent.Version != b.version+1 {
+		b.gaps++
+	}
+	b.version = ent.Version
+}
+
+func (b *slowBackup) Transfer(server pb.KVBackup_TransferServer) error {
+	b.cond.L.Lock()
+	version := b.version
+	b.cond.L.Unlock()
+	if err := server.SendHeader(metadata.Pairs(worker.HEADER_VERSION_NUMBER, strconv.FormatUint(version, 16))); err != nil {
+		return err
+	}
+	for {
+		ent, err := server.Recv()
+		if err == io.EOF {
+			b.cond.L.Lock()
+			defer b.cond.L.Unlock()
+			return server.SendAndClose(&pb.BackupReply{Status: pb.Status_OK, Version: b.version})
+		} else if err != nil {
+			return err
+		}
+		b.cond.L.Lock()
+		b.apply(ent)
+		b.cond.L.Unlock()
+	}
+}
+
+func (b *slowBackup) SyncBatch(server pb.KVBackup_SyncBatchServer) error {
+	for {
+		batch, err := server.Recv()
+		if err != nil {
+			return err
+		}
+		b.cond.L.Lock()
+		for b.paused {
+			b.cond.Wait()
+		}
+		for _, ent := range batch.Entries {
+			b.apply(ent)
+		}
+		version := b.version
+		b.cond.L.Unlock()
+		if err := server.Send(&pb.BackupReply{Status: pb.Status_OK, Version: version}); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *slowBackup) pause(paused bool) {
+	b.cond.L.Lock()
+	b.paused = paused
+	b.cond.Broadcast()
+	b.cond.L.Unlock()
+}
+
+func TestSyncRoutine_Eject(t *testing.T) {
+	c, err := localcluster.StartWithBackups(1, 0, func(w *worker.WorkerServer) {
+		w.SyncBatchSize = 4
+		w.SyncWindow = 2
+		w.SetConfig(common.WorkerConfig{EjectLagMs: 100})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	b := &slowBackup{cond: sync.NewCond(&sync.Mutex{})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterKVBackupServer(s, b)
+	go s.Serve(l)
+	defer s.Stop()
+	defer b.pause(false)
+	assert.Nil(t, c.Workers[0].AttachBackup("slow", l.Addr().String()))
+	kv, err := client.New(client.DefaultOptions(c.MasterAddr))
+	assert.Nil(t, err)
+	defer kv.Close()
+	ctx := context.Background()
+	lag := func() *pb.BackupLag {
+		stats, err := kv.ReplicationStats(ctx, 1)
+		if err != nil || len(stats.Backups) != 1 {
+			return nil
+		}
+		return stats.Backups[0]
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, kv.Put(ctx, strconv.Itoa(i), "a"))
+	}
+	if l := lag(); assert.NotNil(t, l) {
+		assert.Equal(t, "slow", l.Name)
+		assert.Equal(t, uint64(10), l.Version)
+		assert.False(t, l.Ejected)
+	}
+	// writes go on without the backup once it is ejected, more than it could take meanwhile
+	b.pause(true)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, kv.Put(ctx, strconv.Itoa(i), "b"))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writes blocked by the slow backup")
+	}
+	if l := lag(); assert.NotNil(t, l) {
+		assert.True(t, l.Ejected)
+		assert.Equal(t, uint64(1), l.Ejections)
+		assert.Equal(t, uint64(10), l.Version)
+		assert.Equal(t, uint64(100), l.LagVersions)
+		assert.True(t, l.LagMicros >= 100000)
+	}
+	// and it rejoins once it gets every entry missed
+	b.pause(false)
+	assert.Eventually(t, func() bool {
+		l := lag()
+		return l != nil && !l.Ejected && l.LagVersions == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	b.cond.L.Lock()
+	assert.Equal(t, uint64(110), b.version)
+	assert.Equal(t, 0, b.gaps)
+	b.cond.L.Unlock()
+}
+
+func benchmarkReplication(b *testing.B, batchSize int, window int) {
+	c, kv := startReplicated(b, 1, batchSize, window)
+	defer c.Stop()
+	defer kv.Close()
+	ctx := context.Background()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if err := kv.Put(ctx, strconv.Itoa(i), "v"); err != nil {
+				b.Error(err)
+			}
+			i++
+		}
+	})
+}
+
+// one round trip to the backup for each write, as if there were no batching
+func BenchmarkReplication_Unbatched(b *testing.B) {
+	benchmarkReplication(b, 1, 1)
+}
+
+func BenchmarkReplication_Batched(b *testing.B) {
+	benchmarkReplication(b, worker.DEFAULT_SYNC_BATCH_SIZE, worker.DEFAULT_SYNC_WINDOW)
+}
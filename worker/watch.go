@@ -0,0 +1,313 @@
+package worker
+
+// change notification, built on entries synced by DoSync
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+)
+
+// number of recent entries retained for watchers to resume from
+const WATCH_HISTORY_SIZE = 16384
+
+// In-memory tail of synced entries in the order of their versions.
+// Backups keep their own tail from the entries they receive, so watchers could resume on them after failover.
+type entryTail struct {
+	lock     sync.Mutex
+	capacity int
+	entries  []*pb.BackupEntry
+	// version of the last entry dropped, entries after it are all retained
+	truncated uint64
+	// version of the last entry published
+	last uint64
+	// local version at the time each slot table version is adopted
+	slotVersions map[uint32]uint64
+	// closed & replaced whenever something happens
+	notify chan struct{}
+}
+
+func newEntryTail(capacity int, version uint64) *entryTail {
+	return &entryTail{
+		capacity:     capacity,
+		truncated:    version,
+		last:         version,
+		slotVersions: make(map[uint32]uint64),
+		notify:       make(chan struct{}),
+	}
+}
+
+// wake up all watchers, should be called with lock held
+func (t *entryTail) wakeLocked() {
+	close(t.notify)
+	t.notify = make(chan struct{})
+}
+
+func (t *entryTail) wake() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.wakeLocked()
+}
+
+func (t *entryTail) publish(entry *pb.BackupEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if entry.Version <= t.last {
+		return
+	}
+	t.appendLocked(entry)
+	t.wakeLocked()
+}
+
+// Publish entries committed together in a transaction, which share a version.
+func (t *entryTail) publishAll(entries []*pb.BackupEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	last := t.last
+	for _, entry := range entries {
+		if entry.Version > last {
+			t.appendLocked(entry)
+		}
+	}
+	t.wakeLocked()
+}
+
+func (t *entryTail) appendLocked(entry *pb.BackupEntry) {
+	if len(t.entries) >= t.capacity {
+		// drop the older half at once, so that dropping is amortized
+		n := len(t.entries) / 2
+		t.truncated = t.entries[n-1].Version
+		t.entries = append([]*pb.BackupEntry(nil), t.entries[n:]...)
+	}
+	t.entries = append(t.entries, entry)
+	t.last = entry.Version
+}
+
+// Forget everything before `version`.
+func (t *entryTail) reset(version uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.entries = nil
+	t.truncated = version
+	t.last = version
+	t.wakeLocked()
+}
+
+// Record that slot table version `v` is adopted now.
+func (t *entryTail) markSlotVersion(v uint32) uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.slotVersions[v]; !ok {
+		t.slotVersions[v] = t.last
+	}
+	t.wakeLocked()
+	return t.slotVersions[v]
+}
+
+// Take slot table versions adopted before a restart, as persisted by the kv store.
+func (t *entryTail) restoreSlotVersions(m map[uint32]uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for v, start := range m {
+		if _, ok := t.slotVersions[v]; !ok {
+			t.slotVersions[v] = start
+		}
+	}
+}
+
+// Get local version when slot table version `v` is adopted.
+func (t *entryTail) slotVersionStart(v uint32) (uint64, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	version, ok := t.slotVersions[v]
+	return version, ok
+}
+
+// Get entries after `version` and a channel closed on the next change.
+// Returns ETRUNCATED if some entries after `version` are no longer retained.
+func (t *entryTail) since(version uint64) ([]*pb.BackupEntry, <-chan struct{}, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if version < t.truncated {
+		return nil, t.notify, ETRUNCATED
+	}
+	// binary search for the first entry after version
+	lo, hi := 0, len(t.entries)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if t.entries[mid].Version <= version {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return t.entries[lo:], t.notify, nil
+}
+
+// Get slot table version an entry of `version` is committed under, 0 if unknown.
+func (t *entryTail) epochOf(version uint64) uint32 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var epoch uint32 = 0
+	for v, start := range t.slotVersions {
+		if start < version && v > epoch {
+			epoch = v
+		}
+	}
+	return epoch
+}
+
+// Get a channel closed on the next change.
+func (t *entryTail) wait() <-chan struct{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.notify
+}
+
+// Get version of the last entry dropped.
+func (t *entryTail) truncatedVersion() uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.truncated
+}
+
+func (t *entryTail) lastVersion() uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.last
+}
+
+// Entries for keys last written after version `from` up to `to` that `matches`, taken from the store
+// in the order of their versions. Only the latest version of each key is left there.
+func (s *WorkerServer) storedEntries(from uint64, to uint64, matches func(key string) bool) []*pb.BackupEntry {
+	content := s.kv.Extract(matches, from)
+	entries := make([]*pb.BackupEntry, 0, len(content))
+	for k, v := range content {
+		if v.Version > to {
+			continue
+		}
+		e := &pb.BackupEntry{Op: pb.Operation_DELETE, Key: k, Version: v.Version}
+		if v.Value != nil {
+			e.Op, e.Value = pb.Operation_PUT, *v.Value
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Version < entries[j].Version })
+	return entries
+}
+
+// Apply writes not synced through DoSync with `apply`, e.g. those received in a migration, and publish
+// the entries it returns to watchers. Entries handed over to DoSync before are published first and no
+// other write is applied meanwhile, so that watchers still see entries in the order of their versions.
+func (s *WorkerServer) applyUnsynced(apply func() ([]*pb.BackupEntry, error)) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	for !s.deposed.Load() {
+		notify := s.tail.wait()
+		if s.tail.lastVersion() >= s.handedVersion {
+			break
+		}
+		<-notify
+	}
+	entries, err := apply()
+	s.tail.publishAll(entries)
+	return err
+}
+
+// Advance slot table version, e.g. when a migration is committed.
+func (s *WorkerServer) IncSlotTableVersion() {
+	s.markSlotVersion(s.SlotTableVersion.Inc())
+}
+
+// Record that slot table version `v` is adopted now, in the kv store as well so that
+// records exported after a restart still get their epoch.
+func (s *WorkerServer) markSlotVersion(v uint32) {
+	if err := s.kv.MarkSlotVersion(v, s.tail.markSlotVersion(v)); err != nil {
+		common.SugaredLog().Errorf("Failed to persist slot table version %d: %v", v, err)
+	}
+}
+
+func (s *WorkerServer) WatchKeys(req *pb.WatchRequest, server pb.KVWorker_WatchKeysServer) error {
+	if req.SlotVersion != s.SlotTableVersion.Load() {
+		return server.Send(&pb.WatchEvent{Status: pb.Status_EINVVERSION})
+	}
+	if s.mode.Load() != MODE_PRIMARY {
+		return server.Send(&pb.WatchEvent{Status: pb.Status_EINVSERVER})
+	}
+	matches := func(key string) bool {
+		if req.Prefix {
+			return strings.HasPrefix(key, req.Key) && !strings.HasPrefix(key, INTERNAL_KEY_PREFIX)
+		}
+		return key == req.Key
+	}
+	cursor := req.FromVersion
+	if cursor == 0 && req.SinceSlotVersion != 0 {
+		var ok bool
+		if cursor, ok = s.tail.slotVersionStart(req.SinceSlotVersion); !ok {
+			return server.Send(&pb.WatchEvent{Status: pb.Status_ETRUNCATED})
+		}
+	} else if cursor == 0 {
+		cursor = s.tail.lastVersion()
+	}
+	// tell the watcher where we start from
+	if err := server.Send(&pb.WatchEvent{Status: pb.Status_OK, Op: pb.Operation_GET, Version: cursor}); err != nil {
+		return err
+	}
+	log := common.SugaredLog()
+	log.Infof("Watching %s from version %x.", req.Key, cursor)
+	for {
+		entries, notify, err := s.tail.since(cursor)
+		last := cursor
+		if err == ETRUNCATED {
+			// entries no longer retained one by one, e.g. after a restart, are replayed from the store
+			if cursor > s.kv.GetVersion() {
+				return server.Send(&pb.WatchEvent{Status: pb.Status_ETRUNCATED})
+			}
+			last = s.tail.truncatedVersion()
+			entries = s.storedEntries(cursor, last, matches)
+		}
+		// entries after the next slot table version is adopted belong to the next stream
+		end, changed := s.tail.slotVersionStart(req.SlotVersion + 1)
+		if changed && last > end {
+			last = end
+		}
+		for _, e := range entries {
+			if changed && e.Version > end {
+				break
+			}
+			cursor = e.Version
+			if !matches(e.Key) {
+				continue
+			}
+			if err := server.Send(&pb.WatchEvent{
+				Status:  pb.Status_OK,
+				Op:      e.Op,
+				Key:     e.Key,
+				Value:   e.Value,
+				Version: e.Version,
+			}); err != nil {
+				return err
+			}
+		}
+		if last > cursor {
+			cursor = last
+		}
+		if changed {
+			return server.Send(&pb.WatchEvent{Status: pb.Status_EINVVERSION, Op: pb.Operation_GET, Version: cursor})
+		}
+		if s.mode.Load() != MODE_PRIMARY {
+			return server.Send(&pb.WatchEvent{Status: pb.Status_EINVSERVER, Op: pb.Operation_GET, Version: cursor})
+		}
+		if err == ETRUNCATED {
+			continue
+		}
+		select {
+		case <-notify:
+		case <-server.Context().Done():
+			return server.Context().Err()
+		}
+	}
+}
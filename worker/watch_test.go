@@ -0,0 +1,117 @@
+package worker_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Watch every key on the worker from `version`, returning events as they come.
+func watchAll(t *testing.T, conn *grpc.ClientConn, version uint64) (<-chan *pb.WatchEvent, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewKVWorkerClient(conn).WatchKeys(ctx, &pb.WatchRequest{Prefix: true, FromVersion: version})
+	assert.Nil(t, err)
+	events := make(chan *pb.WatchEvent, 16)
+	go func() {
+		defer close(events)
+		for {
+			e, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			events <- e
+		}
+	}()
+	return events, cancel
+}
+
+func nextEvent(t *testing.T, events <-chan *pb.WatchEvent) *pb.WatchEvent {
+	select {
+	case e := <-events:
+		if e != nil {
+			return e
+		}
+	case <-time.After(time.Second):
+	}
+	t.Fatal("no event")
+	return nil
+}
+
+func TestWatch_ResumeAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	// written before a restart
+	store, err := worker.NewKVStore(dir)
+	assert.Nil(t, err)
+	for _, k := range []string{"a", "b", "c"} {
+		_, err := store.Put(k, k, 0)
+		assert.Nil(t, err)
+	}
+	_, err = store.Delete("a", 0)
+	assert.Nil(t, err)
+	store.Flush()
+	store.Close()
+
+	_, conn, stop := serveWorker(t, worker.MODE_PRIMARY, dir)
+	defer stop()
+	events, cancel := watchAll(t, conn, 1)
+	defer cancel()
+	assert.Equal(t, uint64(1), nextEvent(t, events).Version)
+	// what is written since is replayed from the store, each key at its latest version
+	e := nextEvent(t, events)
+	assert.Equal(t, "b", e.Key)
+	assert.Equal(t, uint64(2), e.Version)
+	assert.Equal(t, "c", nextEvent(t, events).Key)
+	e = nextEvent(t, events)
+	assert.Equal(t, pb.Operation_DELETE, e.Op)
+	assert.Equal(t, "a", e.Key)
+	// and goes on with new writes
+	resp, err := pb.NewKVWorkerClient(conn).Put(context.Background(), &pb.KVPair{Key: "d", Value: "d"})
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, resp.Status)
+	e = nextEvent(t, events)
+	assert.Equal(t, "d", e.Key)
+	assert.Equal(t, uint64(5), e.Version)
+}
+
+func TestWatch_Migrated(t *testing.T) {
+	_, conn, stop := startWorker(t, worker.MODE_PRIMARY)
+	defer stop()
+	events, cancel := watchAll(t, conn, 0)
+	defer cancel()
+	assert.Equal(t, pb.Status_OK, nextEvent(t, events).Status)
+
+	// keys migrated from worker 2 in bulk, then one by one
+	ctx := metadata.AppendToOutgoingContext(context.Background(), worker.HEADER_CLIENT_WORKER_ID, "2")
+	transfer, err := pb.NewKVBackupClient(conn).Transfer(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, transfer.Send(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "a", Value: "1", Version: 1}))
+	assert.Nil(t, transfer.Send(&pb.BackupEntry{Op: pb.Operation_PUT, Key: "b", Value: "2", Version: 2}))
+	reply, err := transfer.CloseAndRecv()
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, reply.Status)
+	first, second := nextEvent(t, events), nextEvent(t, events)
+	assert.Equal(t, "a", first.Key)
+	assert.Equal(t, "2", second.Value)
+	assert.Equal(t, first.Version, second.Version)
+
+	sync, err := pb.NewKVBackupClient(conn).Sync(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, sync.Send(&pb.BackupEntry{Op: pb.Operation_DELETE, Key: "a", Version: 3}))
+	r, err := sync.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, pb.Status_OK, r.Status)
+	e := nextEvent(t, events)
+	assert.Equal(t, pb.Operation_DELETE, e.Op)
+	assert.Equal(t, "a", e.Key)
+	assert.True(t, e.Version > first.Version)
+}
@@ -1,10 +1,13 @@
 package worker
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/eyeKill/KV/common"
 	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/raft"
 	"github.com/samuel/go-zookeeper/zk"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
@@ -18,11 +21,14 @@ import (
 const (
 	MODE_PRIMARY = common.ZK_PRIMARY_WORKER_NAME
 	MODE_BACKUP  = common.ZK_BACKUP_WORKER_NAME
+	MODE_LEARNER = common.ZK_LEARNER_WORKER_NAME
 )
 
 const (
 	HEADER_VERSION_NUMBER   = "versionNumber"
 	HEADER_CLIENT_WORKER_ID = "workerId"
+	HEADER_SNAPSHOT_VERSION = "snapshotVersion"
+	HEADER_SNAPSHOT_OFFSET  = "snapshotOffset"
 )
 
 type WorkerServer struct {
@@ -37,32 +43,91 @@ type WorkerServer struct {
 	kv               KVStore
 	SlotTableVersion atomic.Uint32
 	NodeName         string
+	configLock       sync.RWMutex
 	config           common.WorkerConfig
 
+	// slot table of the current version, used to tell keys owned by other workers apart
+	slotLock sync.RWMutex
+	slots    common.HashSlotRing
+
 	// for backup routine
+	writeLock sync.Mutex // keeps entries sent to backupCh in order
+	// version of the last entry sent to backupCh, guarded by writeLock
+	handedVersion uint64
 	backupCh      chan *pb.BackupEntry
 	backupLock    sync.RWMutex
 	backups       map[string]*SyncRoutine
-	backupVersion uint64
 	backupCond    *sync.Cond
+	// latency of waiting for backups, by replication mode
+	replLatency map[string]*histogram
+	// max number of entries replicated in one batch, and of batches waiting for ack of each backup
+	SyncBatchSize int
+	SyncWindow    int
+	// unix nano time a backup was last known to be in sync with its primary, for bounded staleness reads
+	syncedAt atomic.Int64
+	// latest version the primary is known to have reached, and unix nano time it was last heard from
+	primaryVersion atomic.Uint64
+	heardAt        atomic.Int64
+
+	// recently synced entries, for watchers
+	tail *entryTail
+	// recent entries sent to backups, for backups to catch up from when they reconnect
+	wal *entryTail
+	// when recent entries are sent to backups, for telling how long backups are behind
+	dispatches dispatchLog
+	// guards snapshot files, sent to backups too far behind
+	snapshotLock sync.Mutex
+	// Merkle trees are compared with backups every AntiEntropyInterval, never if it is not positive
+	AntiEntropyInterval time.Duration
+	antiEntropy         antiEntropyStats
+	// channels & their subscribers
+	pubsub *pubsub
 
 	// for migration
 	migrations  map[string]*SyncRoutine
 	version     uint64
 	versionCond *sync.Cond
+	// writes are acknowledged once exported to the change data capture feed as well, while it is exported;
+	// cdcVersion is guarded by versionCond
+	cdcExporting atomic.Bool
+	cdcVersion   uint64
+
+	// epoch of the latest primary known, writes of older primaries are rejected by backups
+	epochLock sync.Mutex
+	epoch     atomic.Uint64
+	deposed   atomic.Bool
+
+	// reads are served by the primary only before lease expires
+	leaseLock   sync.Mutex
+	leaseExpiry time.Time
+	// the lease is held for good by workers not coordinated through zookeeper
+	standalone atomic.Bool
 
 	// for mode switching
-	mode           string
+	mode           atomic.String
 	modeChangeCond *sync.Cond
 	// specified when first being run, help determine whether it should step down as the temporary primary
-	origMode string
-	readOnly bool
+	origMode atomic.String
+	// writes are refused after a promotion with fewer backups than the worker had, until enough of them are in sync,
+	// which is as many as it had unless configured
+	readOnly       atomic.Bool
+	readOnlyQuorum atomic.Int32
+
+	// Raft group writes are replicated through in place of backups, nil if there is none,
+	// and a channel signaled once its leadership changes
+	raft   *raft.Node
+	raftCh chan struct{}
 
 	// three goroutines: watch workers, watch migration, do sync.
 	// watch workers & watch migration should be updated once mode changes
 	WatchWorkerStopChan    chan struct{}
 	WatchMigrationStopChan chan struct{}
 	SyncStopChan           chan struct{}
+	WatchTableStopChan     chan struct{}
+	CDCStopChan            chan struct{}
+	LeaseStopChan          chan struct{}
+	WatchConfigStopChan    chan struct{}
+	EpochStopChan          chan struct{}
 }
 
 // initialize a server
@@ -71,26 +136,40 @@ func NewServer(hostname string, port uint16, filePath string, id common.WorkerId
 	if err != nil {
 		return nil, err
 	}
-	return &WorkerServer{
+	s := &WorkerServer{
 		Hostname:               hostname,
 		Port:                   port,
 		FilePath:               filePath,
 		Id:                     id,
 		kv:                     kv,
-		mode:                   mode,
-		origMode:               mode,
 		backupLock:             sync.RWMutex{},
 		backups:                make(map[string]*SyncRoutine),
-		backupCh:               make(chan *pb.BackupEntry),
+		backupCh:               make(chan *pb.BackupEntry, SYNC_QUEUE_SIZE),
+		SyncBatchSize:          DEFAULT_SYNC_BATCH_SIZE,
+		SyncWindow:             DEFAULT_SYNC_WINDOW,
+		AntiEntropyInterval:    DEFAULT_ANTI_ENTROPY_INTERVAL,
 		migrations:             make(map[string]*SyncRoutine),
+		tail:                   newEntryTail(WATCH_HISTORY_SIZE, kv.GetVersion()),
+		wal:                    newEntryTail(BACKUP_LOG_SIZE, kv.GetVersion()),
+		pubsub:                 newPubsub(),
+		replLatency:            newReplicationLatency(),
 		versionCond:            sync.NewCond(&sync.Mutex{}),
 		modeChangeCond:         sync.NewCond(&sync.Mutex{}),
 		backupCond:             sync.NewCond(&sync.Mutex{}),
 		WatchMigrationStopChan: make(chan struct{}, 4),
 		WatchWorkerStopChan:    make(chan struct{}, 4),
 		SyncStopChan:           make(chan struct{}, 4),
-		readOnly:               false,
-	}, nil
+		WatchTableStopChan:     make(chan struct{}, 4),
+		CDCStopChan:            make(chan struct{}, 4),
+		LeaseStopChan:          make(chan struct{}, 4),
+		WatchConfigStopChan:    make(chan struct{}, 4),
+		EpochStopChan:          make(chan struct{}, 4),
+	}
+	s.mode.Store(mode)
+	s.origMode.Store(mode)
+	s.epoch.Store(kv.GetEpoch())
+	s.tail.restoreSlotVersions(kv.SlotVersions())
+	return s, nil
 }
 
 func NewPrimaryServer(hostname string, port uint16, filePath string, id common.WorkerId) (*WorkerServer, error) {
@@ -111,12 +190,18 @@ func (s *WorkerServer) RegisterToZk(conn *zk.Conn, weight float32) error {
 		return err
 	}
 	s.SlotTableVersion.Store(version)
-	if s.mode == MODE_PRIMARY {
+	s.markSlotVersion(version)
+	var slots common.HashSlotRing
+	if err := common.ZkGet(s.conn, common.ZK_TABLE, &slots); err != nil {
+		return err
+	}
+	s.SetSlotTable(slots)
+	if s.mode.Load() == MODE_PRIMARY {
 		return s.registerPrimary(weight)
-	} else if s.mode == MODE_BACKUP {
+	} else if s.mode.Load() == MODE_BACKUP || s.mode.Load() == MODE_LEARNER {
 		return s.registerBackup()
 	} else {
-		return errors.New(fmt.Sprintf("invalid worker mode %s", s.mode))
+		return errors.New(fmt.Sprintf("invalid worker mode %s", s.mode.Load()))
 	}
 }
 
@@ -130,9 +215,14 @@ func (s *WorkerServer) Watch() {
 		if err != nil {
 			log.Error("Failed to watch worker node.", zap.Error(err))
 		}
-		if s.mode == MODE_PRIMARY {
+		switch {
+		case s.raft != nil:
+			// backups & elections are left to the group
+		case s.mode.Load() == MODE_PRIMARY:
 			s.primaryWatch(worker)
-		} else {
+		case s.mode.Load() == MODE_LEARNER:
+			s.learnerWatch(worker)
+		default:
 			s.backupWatch(worker)
 		}
 		select {
@@ -147,6 +237,54 @@ func (s *WorkerServer) Watch() {
 	}
 }
 
+// Replace the local copy of slot table.
+func (s *WorkerServer) SetSlotTable(slots common.HashSlotRing) {
+	s.slotLock.Lock()
+	s.slots = slots
+	s.slotLock.Unlock()
+}
+
+// Get the worker owning key, and whether it is me.
+// Every key is considered mine when the slot table is unknown.
+func (s *WorkerServer) ownerOf(key string) (common.WorkerId, bool) {
+	s.slotLock.RLock()
+	defer s.slotLock.RUnlock()
+	if len(s.slots) == 0 {
+		return s.Id, true
+	}
+	id := s.slots.GetWorkerIdByKey(key)
+	return id, id == s.Id
+}
+
+// Keep the local copy of slot table up to date
+func (s *WorkerServer) WatchSlotTable() {
+	log := common.SugaredLog()
+	for {
+		bin, _, eventChan, err := s.conn.GetW(common.ZK_TABLE)
+		if err != nil {
+			log.Error("Failed to watch slot table.", zap.Error(err))
+			select {
+			case <-time.After(2 * time.Second):
+				continue
+			case <-s.WatchTableStopChan:
+				return
+			}
+		}
+		var slots common.HashSlotRing
+		if err := json.Unmarshal(bin, &slots); err != nil {
+			log.Error("Failed to parse slot table.", zap.Error(err))
+		} else {
+			s.SetSlotTable(slots)
+		}
+		select {
+		case <-eventChan:
+			continue
+		case <-s.WatchTableStopChan:
+			return
+		}
+	}
+}
+
 // Watch for next migration plan
 func (s *WorkerServer) WatchMigration() {
 	log := common.SugaredLog()
@@ -172,24 +310,24 @@ func (s *WorkerServer) WatchMigration() {
 			}
 		}
 		// only primary workers have to watch migration
-		if s.mode == MODE_PRIMARY {
+		if s.mode.Load() == MODE_PRIMARY {
 			log.Infof("Doing migration #%d...", s.SlotTableVersion)
 			if err := s.doMigration(); err != nil {
 				log.Error("Failed to do migration.", zap.Error(err))
 			}
 		} else {
 			// just commit it
-			s.SlotTableVersion.Inc()
+			s.IncSlotTableVersion()
 		}
 	}
 }
 
 func (s *WorkerServer) transformTo(mode string) error {
-	s.readOnly = false
+	s.readOnly.Store(false)
 	if mode != MODE_PRIMARY && mode != MODE_BACKUP {
 		return errors.New("invalid mode")
 	}
-	if mode == s.mode {
+	if mode == s.mode.Load() {
 		return errors.New("no need for transformation")
 	}
 	// first re-register itself in zookeeper
@@ -198,6 +336,10 @@ func (s *WorkerServer) transformTo(mode string) error {
 	p := path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(s.Id)))
 	var nodePath string
 	if mode == MODE_PRIMARY {
+		// primary of the last epoch is fenced off once backups see the new one
+		if err := s.bumpEpoch(); err != nil {
+			return err
+		}
 		nodePath = path.Join(p, common.ZK_PRIMARY_WORKER_NAME)
 	} else {
 		// transform to backup
@@ -225,27 +367,13 @@ func (s *WorkerServer) transformTo(mode string) error {
 		return err
 	}
 	s.NodeName = path.Base(name)
-	s.mode = mode
-	if s.mode == MODE_PRIMARY {
-		worker, err := common.GetAndWatchWorker(s.conn, s.Id)
-		if err != nil {
-			log.Info("Failed to get worker info.", zap.Error(err))
-		}
-		// get number of actual backups from election path
-		electionPath := path.Join(common.ZK_ELECTION_ROOT, strconv.Itoa(int(s.Id)))
-		children, _, err := s.conn.Children(electionPath)
-		// primary should be responsible for cleaning the election directory
-		if err := common.ZkDeleteRecursive(s.conn, electionPath); err != nil {
-			log.Error("Failed to clear election path", zap.Error(err))
-			return err
-		}
-		// use read-only mode if numBackup in config & actual number of backups does not match
-		if len(children) != worker.NumBackups {
-			log.Infof("Backup number and config number, config num = %d, actual = %d",
-				worker.NumBackups, len(children))
-			s.readOnly = true
-		}
-	}
+	s.mode.Store(mode)
+	s.deposed.Store(false)
+	// only entries sent as primary are logged
+	s.wal.reset(s.kv.GetVersion())
+	// watchers have to leave a backup
+	s.tail.wake()
+	s.renewLease()
 	// now issue stop and restart all goroutines
 	log.Info("Restarting goroutines...")
 	s.WatchMigrationStopChan <- struct{}{}
@@ -255,6 +383,23 @@ func (s *WorkerServer) transformTo(mode string) error {
 
 // backup server implementations
 
+// Get worker id of the remote from header of request.
+func remoteWorkerId(ctx context.Context) (common.WorkerId, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, errors.New("failed to get remote worker id")
+	}
+	ks := md.Get(HEADER_CLIENT_WORKER_ID)
+	if len(ks) != 1 {
+		return 0, errors.New("failed to get remote worker id")
+	}
+	rid, err := strconv.Atoi(ks[0])
+	if err != nil {
+		return 0, errors.New("failed to get remote worker id")
+	}
+	return common.WorkerId(rid), nil
+}
+
 // Transfer bunch of data with transaction
 func (s *WorkerServer) Transfer(server pb.KVBackup_TransferServer) error {
 	// receive client's worker id
@@ -271,8 +416,11 @@ func (s *WorkerServer) Transfer(server pb.KVBackup_TransferServer) error {
 	if err != nil {
 		return errors.New("failed to get remote worker id")
 	}
+	if s.raft != nil {
+		return ERAFT
+	}
 
-	if s.mode == MODE_PRIMARY && remoteId != s.Id {
+	if s.mode.Load() == MODE_PRIMARY && remoteId != s.Id {
 		return s.MigrateTransfer(server, remoteId)
 	} else if remoteId == s.Id {
 		return s.BackupTransfer(server)
@@ -297,8 +445,11 @@ func (s *WorkerServer) Sync(server pb.KVBackup_SyncServer) error {
 	if err != nil {
 		return errors.New("failed to get remote worker id")
 	}
+	if s.raft != nil {
+		return ERAFT
+	}
 
-	if s.mode == MODE_PRIMARY && remoteId != s.Id {
+	if s.mode.Load() == MODE_PRIMARY && remoteId != s.Id {
 		return s.MigrateSync(server)
 	} else if remoteId == s.Id {
 		return s.BackupSync(server)
@@ -306,3 +457,58 @@ func (s *WorkerServer) Sync(server pb.KVBackup_SyncServer) error {
 		return errors.New("worker mode invalid")
 	}
 }
+
+// Sync with entries in batches, served by the same logic as Sync.
+func (s *WorkerServer) SyncBatch(server pb.KVBackup_SyncBatchServer) error {
+	b := &batchSyncServer{KVBackup_SyncBatchServer: server}
+	err := s.Sync(b)
+	if ferr := b.flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Unpack batches into single entries for Sync. OK replies are held back until the batch is done,
+// and only the last one of them is sent, acknowledging the whole batch.
+type batchSyncServer struct {
+	pb.KVBackup_SyncBatchServer
+	entries []*pb.BackupEntry
+	ack     *pb.BackupReply
+}
+
+func (b *batchSyncServer) Recv() (*pb.BackupEntry, error) {
+	for len(b.entries) == 0 {
+		// done with the last batch
+		if err := b.flush(); err != nil {
+			return nil, err
+		}
+		batch, err := b.KVBackup_SyncBatchServer.Recv()
+		if err != nil {
+			return nil, err
+		}
+		b.entries = batch.Entries
+	}
+	ent := b.entries[0]
+	b.entries = b.entries[1:]
+	return ent, nil
+}
+
+func (b *batchSyncServer) Send(reply *pb.BackupReply) error {
+	if reply.Status == pb.Status_OK {
+		b.ack = reply
+		return nil
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+	return b.KVBackup_SyncBatchServer.Send(reply)
+}
+
+func (b *batchSyncServer) flush() error {
+	if b.ack == nil {
+		return nil
+	}
+	ack := b.ack
+	b.ack = nil
+	return b.KVBackup_SyncBatchServer.Send(ack)
+}
@@ -0,0 +1,89 @@
+package worker_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eyeKill/KV/common"
+	pb "github.com/eyeKill/KV/proto"
+	"github.com/eyeKill/KV/worker"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+var ZK_SERVERS = []string{"localhost:2181"}
+
+// Connect to zookeeper, skipping tests of workers coordinated through it where none is running.
+// The slot table is set up the way the master does, unless there is one already.
+func connectZk(t *testing.T) *zk.Conn {
+	c, err := net.DialTimeout("tcp", ZK_SERVERS[0], time.Second)
+	if err != nil {
+		t.Skipf("zookeeper is not running at %s", ZK_SERVERS[0])
+	}
+	c.Close()
+	conn, err := common.ConnectToZk(ZK_SERVERS)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Nil(t, common.EnsurePathRecursive(conn, common.ZK_WORKERS_ROOT))
+	assert.Nil(t, common.EnsurePath(conn, common.ZK_ELECTION_ROOT))
+	for p, v := range map[string]interface{}{common.ZK_TABLE_VERSION: 0, common.ZK_TABLE: common.NewHashSlotRing()} {
+		if _, err := common.ZkCreate(conn, p, v, false, false); err != nil && err != zk.ErrNodeExists {
+			t.Fatal(err)
+		}
+	}
+	return conn
+}
+
+// Serve a worker of `id` registered to zookeeper in `mode`, owning every slot, with a session of its own.
+// Returns it with a connection to it, and a function to stop it as if it crashed.
+func startZkWorker(t *testing.T, id common.WorkerId, mode string) (*worker.WorkerServer, *grpc.ClientConn, func()) {
+	dir, err := ioutil.TempDir("", "worker")
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	w, err := worker.NewServer("127.0.0.1", uint16(l.Addr().(*net.TCPAddr).Port), dir, id, mode)
+	assert.Nil(t, err)
+	zkConn := connectZk(t)
+	if !assert.Nil(t, w.RegisterToZk(zkConn, 1)) {
+		t.FailNow()
+	}
+	slots := *common.NewHashSlotRing()
+	for i := range slots {
+		slots[i] = id
+	}
+	w.SetSlotTable(slots)
+	go w.Watch()
+	go w.DoSync()
+	go w.KeepLease()
+	go w.WatchEpoch()
+	s := common.NewGrpcServer()
+	pb.RegisterKVWorkerServer(s, w)
+	pb.RegisterKVBackupServer(s, w)
+	pb.RegisterKVWorkerInternalServer(s, w)
+	go s.Serve(l)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	return w, conn, func() {
+		conn.Close()
+		s.Stop()
+		close(w.WatchWorkerStopChan)
+		close(w.SyncStopChan)
+		close(w.LeaseStopChan)
+		close(w.EpochStopChan)
+		zkConn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Remove what workers of `id` have left in zookeeper.
+func cleanUpZkWorker(t *testing.T, conn *zk.Conn, id common.WorkerId) {
+	assert.Nil(t, common.ZkDeleteRecursive(conn, path.Join(common.ZK_WORKERS_ROOT, strconv.Itoa(int(id)))))
+	assert.Nil(t, common.ZkDeleteRecursive(conn, path.Join(common.ZK_ELECTION_ROOT, strconv.Itoa(int(id)))))
+}